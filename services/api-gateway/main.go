@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"expvar"
 	"log"
 	"net/http"
 	"os"
@@ -10,22 +11,59 @@ import (
 	"time"
 
 	"ride-sharing/shared/env"
+	"ride-sharing/shared/logging"
+	"ride-sharing/shared/resilience"
 )
 
 var (
 	httpAddr = env.GetString("HTTP_ADDR", ":8081")
+	// routesFile maps path prefixes to the upstream services; it is reloaded
+	// when it changes or on SIGHUP
+	routesFile = env.GetString("GATEWAY_ROUTES_FILE", "services/api-gateway/routes.json")
+	// debugAddr serves /debug/vars, with the breakers and bulkheads of the
+	// services, apart from the public routes; empty to turn it off
+	debugAddr = env.GetString("GATEWAY_DEBUG_ADDR", ":8091")
 )
 
 func main() {
+	defer logging.Setup("api-gateway")()
+
 	log.Println("Starting API Gateway")
 
-	mux := http.NewServeMux()
+	routes, err := NewRoutes(routesFile)
+	if err != nil {
+		log.Fatalf("Error loading routes: %v", err)
+	}
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	stopWatching := make(chan struct{})
+	defer close(stopWatching)
+	go routes.Watch(env.GetDuration("GATEWAY_ROUTES_RELOAD_INTERVAL", 5*time.Second), reload, stopWatching)
+
+	resilience.Publish("resilience")
+	if debugAddr != "" {
+		debug := http.NewServeMux()
+		debug.Handle("GET /debug/vars", expvar.Handler())
+		go func() {
+			if err := http.ListenAndServe(debugAddr, debug); err != nil {
+				log.Printf("Debug server stopped: %v", err)
+			}
+		}()
+	}
 
-	mux.HandleFunc("POST /trip/preview", handleTripPreview)
+	store, err := newStore()
+	if err != nil {
+		log.Fatalf("Error setting up the rate limit store: %v", err)
+	}
+	limiter, err := NewLimiter(store)
+	if err != nil {
+		log.Fatalf("Error setting up rate limiting: %v", err)
+	}
 
 	server := &http.Server{
-		Addr:    httpAddr,
-		Handler: mux,
+		Addr:              httpAddr,
+		Handler:           logging.Middleware(NewGateway(routes, limiter)),
+		ReadHeaderTimeout: 10 * time.Second,
 	}
 
 	serverErrors := make(chan error, 1)
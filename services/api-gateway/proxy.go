@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+	"time"
+
+	"ride-sharing/shared/contracts"
+	"ride-sharing/shared/logging"
+	"ride-sharing/shared/resilience"
+	"ride-sharing/shared/retry"
+)
+
+type routeKey struct{}
+
+// Gateway proxies the requests to the upstream service of their route.
+type Gateway struct {
+	routes  *Routes
+	limiter *Limiter
+	proxy   *httputil.ReverseProxy
+}
+
+// NewGateway returns the gateway of routes, applying their rate limits with
+// limiter unless it is nil.
+func NewGateway(routes *Routes, limiter *Limiter) *Gateway {
+	transport := &retryTransport{
+		base: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           (&net.Dialer{Timeout: 5 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+			MaxIdleConns:          100,
+			MaxIdleConnsPerHost:   20,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   5 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		},
+		backoff: retry.Config{InitialWait: 100 * time.Millisecond, MaxWait: time.Second},
+	}
+
+	g := &Gateway{routes: routes, limiter: limiter}
+	g.proxy = &httputil.ReverseProxy{
+		Rewrite:        g.rewrite,
+		Transport:      transport,
+		ModifyResponse: envelope,
+		ErrorHandler:   proxyError,
+		// stream the answers, server sent events too
+		FlushInterval: -1,
+	}
+	return g
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt := g.routes.Table().match(r)
+	if rt == nil {
+		writeError(w, http.StatusNotFound, "ROUTE_NOT_FOUND", "no route for "+r.Method+" "+r.URL.Path)
+		return
+	}
+	if g.limiter != nil && !g.limiter.Allow(w, r, rt) {
+		return
+	}
+
+	// keep a small body to send it again on retries
+	if rt.retryable(r.Method) && r.Body != nil && r.ContentLength > 0 && r.ContentLength <= maxRetryBody {
+		body, err := io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "BAD_REQUEST", "failed to read the request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), rt.timeout)
+	defer cancel()
+	ctx = context.WithValue(ctx, routeKey{}, rt)
+	g.proxy.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func (g *Gateway) rewrite(pr *httputil.ProxyRequest) {
+	rt := pr.In.Context().Value(routeKey{}).(*route)
+
+	pr.Out.URL.Path = rt.upstreamPath(pr.In.URL.Path)
+	pr.Out.URL.RawPath = ""
+	pr.SetURL(rt.target)
+	pr.SetXForwarded()
+	// API keys are the gateway's, the services don't see them
+	pr.Out.Header.Del(apiKeyHeader)
+	logging.Inject(pr.In.Context(), pr.Out.Header)
+}
+
+// retryTransport sends a request again, after a backoff, when its route
+// allows it and the service couldn't be reached or answered 502, 503 or 504.
+// Every attempt goes through the policy of the service, whose breaker and
+// bulkhead may reject it.
+type retryTransport struct {
+	base    http.RoundTripper
+	backoff retry.Config
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt, _ := req.Context().Value(routeKey{}).(*route)
+	attempts := 1
+	if rt != nil && rt.retryable(req.Method) && (req.Body == nil || req.Body == http.NoBody || req.GetBody != nil) {
+		attempts += rt.Retries
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, err := t.send(rt, req)
+		if attempt == attempts || !retryableResult(req.Context(), resp, err) {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+
+		slog.WarnContext(req.Context(), "retrying upstream request",
+			"route", rt.Prefix, "attempt", attempt, "status", statusOf(resp), "error", err)
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(t.backoff.Delay(attempt)):
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// send makes an attempt, whose 502, 503 and 504 answers count as failures of
+// the service
+func (t *retryTransport) send(rt *route, req *http.Request) (*http.Response, error) {
+	if rt == nil || rt.policy == nil {
+		return t.base.RoundTrip(req)
+	}
+
+	var resp *http.Response
+	err := rt.policy.Attempt(req.Context(), func(ctx context.Context) error {
+		var err error
+		resp, err = t.base.RoundTrip(req)
+		if err == nil && unavailable(resp.StatusCode) {
+			return fmt.Errorf("%s answered %s", rt.Service, resp.Status)
+		}
+		return err
+	})
+	if resp != nil {
+		return resp, nil
+	}
+	return nil, err
+}
+
+func retryableResult(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		// a rejected request would be rejected again
+		return !errors.Is(err, resilience.ErrOpen) && !errors.Is(err, resilience.ErrBulkheadFull)
+	}
+	return unavailable(resp.StatusCode)
+}
+
+func unavailable(status int) bool {
+	switch status {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func statusOf(resp *http.Response) int {
+	if resp == nil {
+		return 0
+	}
+	return resp.StatusCode
+}
+
+// proxyError answers the requests whose service couldn't be reached in time
+func proxyError(w http.ResponseWriter, r *http.Request, err error) {
+	rt, _ := r.Context().Value(routeKey{}).(*route)
+	switch {
+	case errors.Is(r.Context().Err(), context.Canceled):
+		// the client is gone, nobody reads the answer
+		return
+	case errors.Is(err, resilience.ErrOpen):
+		slog.WarnContext(r.Context(), "upstream breaker is open", "route", rt.Prefix, "service", rt.Service)
+		w.Header().Set("Retry-After", strconv.Itoa(int(rt.policy.Config().Breaker.OpenFor.Seconds())))
+		writeError(w, http.StatusServiceUnavailable, "UPSTREAM_UNAVAILABLE", rt.Service+" is failing, try again later")
+	case errors.Is(err, resilience.ErrBulkheadFull):
+		slog.WarnContext(r.Context(), "upstream is busy", "route", rt.Prefix, "service", rt.Service)
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusServiceUnavailable, "UPSTREAM_BUSY", rt.Service+" is busy, try again later")
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(r.Context().Err(), context.DeadlineExceeded):
+		slog.WarnContext(r.Context(), "upstream timed out", "route", rt.Prefix, "service", rt.Service, "timeout", rt.timeout)
+		writeError(w, http.StatusGatewayTimeout, "UPSTREAM_TIMEOUT", rt.Service+" did not answer in time")
+	default:
+		slog.ErrorContext(r.Context(), "upstream request failed", "route", rt.Prefix, "service", rt.Service, "error", err)
+		writeError(w, http.StatusBadGateway, "UPSTREAM_UNAVAILABLE", rt.Service+" is unavailable")
+	}
+}
+
+// envelope wraps the JSON answers of the routes speaking contracts.APIResponse:
+// the data of a success, the APIError of a failure, whatever its format
+func envelope(resp *http.Response) error {
+	rt, _ := resp.Request.Context().Value(routeKey{}).(*route)
+	if rt == nil || !rt.Envelope {
+		return nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	isJSON := mediaType == "application/json"
+	if resp.StatusCode < 400 && !isJSON {
+		return nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	var out contracts.APIResponse
+	if resp.StatusCode >= 400 {
+		out.Error = upstreamError(resp.StatusCode, body, isJSON)
+	} else if len(bytes.TrimSpace(body)) > 0 {
+		out.Data = json.RawMessage(body)
+	}
+	wrapped, err := json.Marshal(out)
+	if err != nil {
+		return err
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(wrapped))
+	resp.ContentLength = int64(len(wrapped))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(wrapped)))
+	resp.Header.Set("Content-Type", "application/json")
+	return nil
+}
+
+// upstreamError reads the error of a failed answer: an APIError already, the
+// message or error field of another JSON body, or plain text
+func upstreamError(status int, body []byte, isJSON bool) *contracts.APIError {
+	apiErr := &contracts.APIError{Code: errorCode(status)}
+	if isJSON {
+		var fields struct {
+			Error   json.RawMessage `json:"error"`
+			Message string          `json:"message"`
+		}
+		if json.Unmarshal(body, &fields) == nil {
+			var nested contracts.APIError
+			var text string
+			switch {
+			case json.Unmarshal(fields.Error, &nested) == nil && nested.Message != "":
+				return &nested
+			case fields.Message != "":
+				apiErr.Message = fields.Message
+			case json.Unmarshal(fields.Error, &text) == nil && text != "":
+				apiErr.Message = text
+			}
+		}
+	} else {
+		apiErr.Message = strings.TrimSpace(string(body))
+	}
+	if apiErr.Message == "" {
+		apiErr.Message = http.StatusText(status)
+	}
+	return apiErr
+}
+
+// errorCode turns a status into an APIError code: 404 is NOT_FOUND
+func errorCode(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return "UPSTREAM_ERROR"
+	}
+	return strings.ToUpper(strings.NewReplacer(" ", "_", "-", "_", "'", "").Replace(text))
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ride-sharing/shared/env"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// the kinds of clients a rate limit tells apart
+const (
+	clientUser   = "user"
+	clientAPIKey = "apiKey"
+	clientIP     = "ip"
+)
+
+// apiKeyHeader carries the API keys of GATEWAY_API_KEYS
+const apiKeyHeader = "X-API-Key"
+
+var rateLimitStats = expvar.NewMap("gateway.ratelimit")
+
+// RateLimitSpec is a token bucket per client: a client may send the requests
+// of a period at once, and gets them back evenly over the period. With a
+// period of a day, it is a daily quota.
+type RateLimitSpec struct {
+	Per string `json:"per"` // e.g. 1s, 1m or 24h
+	// Requests per period of a user of the auth service, of an API key and
+	// of an IP address, for the requests with neither; zero doesn't limit
+	// that kind of client
+	User   int `json:"user,omitempty"`
+	APIKey int `json:"apiKey,omitempty"`
+	IP     int `json:"ip,omitempty"`
+	// Scope names the buckets, shared by the routes of a scope; the prefix
+	// of the route when empty
+	Scope string `json:"scope,omitempty"`
+}
+
+// rateLimit is a RateLimitSpec ready to apply.
+type rateLimit struct {
+	scope  string
+	per    string
+	period time.Duration
+	burst  map[string]int // by kind of client
+}
+
+func (spec RateLimitSpec) build(scope string) (*rateLimit, error) {
+	period, err := time.ParseDuration(spec.Per)
+	if err != nil || period < time.Second {
+		return nil, fmt.Errorf("rate limit: per %q must be a duration of 1s or more", spec.Per)
+	}
+	if spec.User < 0 || spec.APIKey < 0 || spec.IP < 0 {
+		return nil, errors.New("rate limit: negative number of requests")
+	}
+	if spec.Scope != "" {
+		scope = spec.Scope
+	}
+	return &rateLimit{
+		scope:  scope,
+		per:    spec.Per,
+		period: period,
+		burst:  map[string]int{clientUser: spec.User, clientAPIKey: spec.APIKey, clientIP: spec.IP},
+	}, nil
+}
+
+// Store keeps the token buckets of the rate limits, in memory or in Redis
+// for the buckets to be shared by the replicas of the gateway.
+type Store interface {
+	// Take takes a token, when there is one, from the bucket key holding
+	// burst tokens refilled over period, and returns the tokens left.
+	Take(ctx context.Context, key string, burst int, period time.Duration, now time.Time) (tokens float64, allowed bool, err error)
+}
+
+// newStore returns the store of GATEWAY_RATELIMIT_STORE: memory, or a
+// redis:// or rediss:// URL.
+func newStore() (Store, error) {
+	switch raw := env.GetString("GATEWAY_RATELIMIT_STORE", "memory"); {
+	case raw == "memory":
+		return newMemoryStore(), nil
+	case strings.HasPrefix(raw, "redis://"), strings.HasPrefix(raw, "rediss://"):
+		return newRedisStore(raw)
+	default:
+		return nil, fmt.Errorf("GATEWAY_RATELIMIT_STORE: %q is neither memory nor a redis:// URL", raw)
+	}
+}
+
+// client is who sends a request, as its rate limits see it.
+type client struct {
+	kind string
+	id   string
+}
+
+// Limiter applies the rate limits of the routes to their clients: the users
+// of the access tokens of the auth service, the API keys, and the IP
+// addresses of the other requests.
+type Limiter struct {
+	store   Store
+	secret  []byte
+	apiKeys map[[sha256.Size]byte]string // names by hash of the keys
+	// trustProxy takes the IP address of the clients from the
+	// X-Forwarded-For header of the proxy in front of the gateway
+	trustProxy bool
+
+	lastStoreError atomic.Int64
+}
+
+// NewLimiter reads JWT_SECRET, the API keys of GATEWAY_API_KEYS, a comma
+// separated list of name=key, and GATEWAY_TRUST_PROXY. It fails without
+// JWT_SECRET, since a known secret would let anyone make up the users of
+// their tokens, and their rate limits with them.
+func NewLimiter(store Store) (*Limiter, error) {
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		return nil, errors.New("JWT_SECRET is not set")
+	}
+
+	l := &Limiter{
+		store:      store,
+		secret:     []byte(secret),
+		apiKeys:    map[[sha256.Size]byte]string{},
+		trustProxy: env.GetBool("GATEWAY_TRUST_PROXY", false),
+	}
+	for _, entry := range strings.Split(os.Getenv("GATEWAY_API_KEYS"), ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		name, key, ok := strings.Cut(entry, "=")
+		if !ok || name == "" || key == "" {
+			return nil, fmt.Errorf("GATEWAY_API_KEYS: %q is not name=key", entry)
+		}
+		l.apiKeys[sha256.Sum256([]byte(key))] = name
+	}
+	return l, nil
+}
+
+// Allow takes a token from every bucket of the client of r on rt, shortest
+// period first, and sets the RateLimit headers of the one with the fewest
+// requests left. It answers 429 when one is empty, or 401 for an unknown
+// API key, and returns false then. When the store fails, requests are let
+// through rather than the gateway failing with it.
+func (l *Limiter) Allow(w http.ResponseWriter, r *http.Request, rt *route) bool {
+	c, err := l.identify(r)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, "INVALID_API_KEY", err.Error())
+		return false
+	}
+
+	var policies []string
+	for _, limit := range rt.limits {
+		if burst := limit.burst[c.kind]; burst > 0 {
+			policies = append(policies, fmt.Sprintf("%d;w=%d", burst, int(limit.period.Seconds())))
+		}
+	}
+
+	var tightest *bucketState
+	for _, limit := range rt.limits {
+		burst := limit.burst[c.kind]
+		if burst == 0 {
+			continue
+		}
+
+		key := limit.scope + "|" + limit.period.String() + "|" + c.kind + ":" + c.id
+		tokens, allowed, err := l.store.Take(r.Context(), key, burst, limit.period, time.Now())
+		if err != nil {
+			l.storeFailed(r.Context(), err)
+			return true
+		}
+
+		state := newBucketState(burst, limit.period, tokens)
+		if !allowed {
+			rateLimitStats.Add("limited", 1)
+			slog.InfoContext(r.Context(), "rate limited", "route", rt.Prefix, "client", c.kind, "per", limit.per)
+			setRateLimitHeaders(w.Header(), state, policies)
+			retryAfter := int(math.Ceil(state.retryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+			writeError(w, http.StatusTooManyRequests, "RATE_LIMITED",
+				fmt.Sprintf("rate limit of %d requests per %s exceeded", burst, limit.per))
+			return false
+		}
+		if tightest == nil || state.remaining < tightest.remaining ||
+			state.remaining == tightest.remaining && state.reset > tightest.reset {
+			tightest = &state
+		}
+	}
+
+	if tightest != nil {
+		rateLimitStats.Add("allowed", 1)
+		setRateLimitHeaders(w.Header(), *tightest, policies)
+	}
+	return true
+}
+
+// identify returns the client of r: the name of its API key, the user of its
+// access token, or its IP address
+func (l *Limiter) identify(r *http.Request) (client, error) {
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		name, ok := l.apiKey(key)
+		if !ok {
+			return client{}, errors.New("unknown API key")
+		}
+		return client{kind: clientAPIKey, id: name}, nil
+	}
+
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		if userID := l.user(token); userID != "" {
+			return client{kind: clientUser, id: userID}, nil
+		}
+		// an invalid token is the service's to reject, the request counts
+		// as anonymous meanwhile
+	}
+
+	return client{kind: clientIP, id: l.clientIP(r)}, nil
+}
+
+func (l *Limiter) apiKey(key string) (string, bool) {
+	sum := sha256.Sum256([]byte(key))
+	for hash, name := range l.apiKeys {
+		if subtle.ConstantTimeCompare(sum[:], hash[:]) == 1 {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// user returns the user of a valid access token of the auth service, which
+// are HS256 JWTs signed with JWT_SECRET
+func (l *Limiter) user(token string) string {
+	parsed, err := jwt.Parse(token, func(tok *jwt.Token) (interface{}, error) {
+		if _, ok := tok.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", tok.Header["alg"])
+		}
+		return l.secret, nil
+	})
+	if err != nil || !parsed.Valid {
+		return ""
+	}
+	claims, ok := parsed.Claims.(jwt.MapClaims)
+	if !ok {
+		return ""
+	}
+	// refresh tokens only renew access tokens
+	if tokenType, _ := claims["type"].(string); tokenType != "access" {
+		return ""
+	}
+	userID, _ := claims["sub"].(string)
+	return userID
+}
+
+func (l *Limiter) clientIP(r *http.Request) string {
+	if l.trustProxy {
+		// the last address is the one the proxy saw, the others are the
+		// client's to make up
+		hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+		if last := strings.TrimSpace(hops[len(hops)-1]); last != "" {
+			return last
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// storeFailed logs the errors of the store, once every 10 seconds at most
+func (l *Limiter) storeFailed(ctx context.Context, err error) {
+	rateLimitStats.Add("store_errors", 1)
+	now := time.Now().UnixNano()
+	last := l.lastStoreError.Load()
+	if now-last > int64(10*time.Second) && l.lastStoreError.CompareAndSwap(last, now) {
+		slog.ErrorContext(ctx, "rate limit store failed, letting requests through", "error", err)
+	}
+}
+
+// bucketState is what the RateLimit headers say of a bucket.
+type bucketState struct {
+	limit      int
+	remaining  int
+	reset      time.Duration // until the bucket is full again
+	retryAfter time.Duration // until it has a token again
+}
+
+func newBucketState(burst int, period time.Duration, tokens float64) bucketState {
+	perToken := period / time.Duration(burst)
+	s := bucketState{
+		limit:     burst,
+		remaining: int(math.Floor(tokens)),
+		reset:     time.Duration((float64(burst) - tokens) * float64(perToken)),
+	}
+	if tokens < 1 {
+		s.retryAfter = time.Duration((1 - tokens) * float64(perToken))
+	}
+	return s
+}
+
+// setRateLimitHeaders writes the RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers of the IETF draft, with RateLimit-Policy listing
+// every limit of the route
+func setRateLimitHeaders(h http.Header, s bucketState, policies []string) {
+	h.Set("RateLimit-Limit", strconv.Itoa(s.limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(s.remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(s.reset.Seconds()))))
+	h.Set("RateLimit-Policy", strings.Join(policies, ", "))
+}
+
+// memoryStore keeps the buckets of a single gateway.
+type memoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*memoryBucket
+	swept   time.Time
+}
+
+type memoryBucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time // when the bucket is full again, and can be forgotten
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{buckets: map[string]*memoryBucket{}, swept: time.Now()}
+}
+
+func (s *memoryStore) Take(ctx context.Context, key string, burst int, period time.Duration, now time.Time) (float64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &memoryBucket{tokens: float64(burst), updated: now}
+		s.buckets[key] = b
+	}
+
+	tokens, allowed := refill(b.tokens, b.updated, now, burst, period)
+	b.tokens = tokens
+	if now.After(b.updated) {
+		b.updated = now
+	}
+	b.full = now.Add(time.Duration((float64(burst) - tokens) * float64(period) / float64(burst)))
+	return tokens, allowed, nil
+}
+
+// sweep forgets the buckets that are full again, every minute
+func (s *memoryStore) sweep(now time.Time) {
+	if now.Sub(s.swept) < time.Minute {
+		return
+	}
+	s.swept = now
+	for key, b := range s.buckets {
+		if !now.Before(b.full) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// refill adds the tokens earned since updated to a bucket and takes one,
+// returning the tokens left and whether there was one to take. It is the Go
+// twin of rateLimitScript.
+func refill(tokens float64, updated, now time.Time, burst int, period time.Duration) (float64, bool) {
+	if elapsed := now.Sub(updated); elapsed > 0 {
+		tokens = math.Min(float64(burst), tokens+float64(burst)*float64(elapsed)/float64(period))
+	}
+	if tokens < 1 {
+		return tokens, false
+	}
+	return tokens - 1, true
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestRefill(t *testing.T) {
+	start := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		tokens  float64
+		elapsed time.Duration
+		tokens2 float64 // left after the take
+		allowed bool
+	}{
+		{"full", 10, 0, 9, true},
+		{"empty", 0, 0, 0, false},
+		{"almost a token", 0.5, 0, 0.5, false},
+		{"a token earned", 0, 6 * time.Second, 0, true},
+		{"half the period", 0, 30 * time.Second, 4, true},
+		{"more than the period", 3, 5 * time.Minute, 9, true},
+		{"clock gone back", 0, -time.Minute, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, allowed := refill(tt.tokens, start, start.Add(tt.elapsed), 10, time.Minute)
+			if math.Abs(tokens-tt.tokens2) > 1e-9 || allowed != tt.allowed {
+				t.Fatalf("refill = %v, %v; want %v, %v", tokens, allowed, tt.tokens2, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	s := newMemoryStore()
+	ctx := context.Background()
+	now := s.swept
+
+	for i := 0; i < 3; i++ {
+		if _, allowed, _ := s.Take(ctx, "k", 3, time.Minute, now); !allowed {
+			t.Fatalf("take %d refused, want the burst of 3 allowed", i+1)
+		}
+	}
+	if tokens, allowed, _ := s.Take(ctx, "k", 3, time.Minute, now); allowed || tokens != 0 {
+		t.Fatalf("take 4 = %v, %v; want refused with no token", tokens, allowed)
+	}
+	// other keys have their own buckets
+	if _, allowed, _ := s.Take(ctx, "other", 3, time.Minute, now); !allowed {
+		t.Fatal("take of another key refused")
+	}
+
+	// a token every 20s
+	now = now.Add(20 * time.Second)
+	if _, allowed, _ := s.Take(ctx, "k", 3, time.Minute, now); !allowed {
+		t.Fatal("take refused once a token was earned")
+	}
+	if _, allowed, _ := s.Take(ctx, "k", 3, time.Minute, now); allowed {
+		t.Fatal("take allowed past the token earned")
+	}
+
+	// full again a minute later, and forgotten by the next sweep
+	now = now.Add(2 * time.Minute)
+	s.Take(ctx, "new", 3, time.Minute, now)
+	if _, ok := s.buckets["k"]; ok || len(s.buckets) != 1 {
+		t.Fatalf("%d bucket(s) after the sweep, want the new one only", len(s.buckets))
+	}
+}
+
+func TestNewBucketState(t *testing.T) {
+	s := newBucketState(10, time.Minute, 2.5)
+	if s.limit != 10 || s.remaining != 2 || s.reset != 45*time.Second || s.retryAfter != 0 {
+		t.Fatalf("state %+v", s)
+	}
+	s = newBucketState(10, time.Minute, 0.25)
+	if s.remaining != 0 || s.retryAfter != 4500*time.Millisecond {
+		t.Fatalf("state %+v, want a token in 4.5s", s)
+	}
+}
+
+func TestNewLimiterNeedsSecret(t *testing.T) {
+	t.Setenv("JWT_SECRET", "")
+	if _, err := NewLimiter(newMemoryStore()); err == nil {
+		t.Fatal("NewLimiter without JWT_SECRET succeeded")
+	}
+}
+
+func TestNewLimiterRejectsInvalidKeys(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("GATEWAY_API_KEYS", "partner=key-1,broken")
+	if _, err := NewLimiter(newMemoryStore()); err == nil {
+		t.Fatal("NewLimiter with an invalid GATEWAY_API_KEYS entry succeeded")
+	}
+}
+
+func newTestLimiter(t *testing.T, trustProxy string) *Limiter {
+	t.Helper()
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("GATEWAY_API_KEYS", "partner=key-1, mobile=key-2")
+	t.Setenv("GATEWAY_TRUST_PROXY", trustProxy)
+	l, err := NewLimiter(newMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func accessToken(t *testing.T, secret, tokenType string) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": "user-1", "type": tokenType, "exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestIdentify(t *testing.T) {
+	tests := []struct {
+		name       string
+		trustProxy string
+		header     http.Header
+		want       client
+	}{
+		{"address", "false", nil, client{clientIP, "192.0.2.1"}},
+		{"forwarded, untrusted", "false", http.Header{"X-Forwarded-For": {"198.51.100.7"}}, client{clientIP, "192.0.2.1"}},
+		{"forwarded", "true", http.Header{"X-Forwarded-For": {"198.51.100.7"}}, client{clientIP, "198.51.100.7"}},
+		// the client made up the first hops, the proxy appended the last
+		{"forwarded hops", "true", http.Header{"X-Forwarded-For": {"10.0.0.1, 203.0.113.9 , 198.51.100.7"}}, client{clientIP, "198.51.100.7"}},
+		{"forwarded headers", "true", http.Header{"X-Forwarded-For": {"10.0.0.1", "198.51.100.7"}}, client{clientIP, "198.51.100.7"}},
+		{"forwarded, empty", "true", http.Header{"X-Forwarded-For": {""}}, client{clientIP, "192.0.2.1"}},
+		{"user", "false", http.Header{"Authorization": {"Bearer " + accessToken(t, "test-secret", "access")}}, client{clientUser, "user-1"}},
+		{"refresh token", "false", http.Header{"Authorization": {"Bearer " + accessToken(t, "test-secret", "refresh")}}, client{clientIP, "192.0.2.1"}},
+		{"token of another secret", "false", http.Header{"Authorization": {"Bearer " + accessToken(t, "your-secret-key-change-in-production", "access")}}, client{clientIP, "192.0.2.1"}},
+		{"api key", "true", http.Header{"X-Api-Key": {"key-2"}, "Authorization": {"Bearer " + accessToken(t, "test-secret", "access")}}, client{clientAPIKey, "mobile"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newTestLimiter(t, tt.trustProxy)
+			req := httptest.NewRequest("GET", "/images/abc", nil)
+			req.RemoteAddr = "192.0.2.1:51234"
+			for name, values := range tt.header {
+				req.Header[name] = values
+			}
+			got, err := l.identify(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("identify = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("unknown api key", func(t *testing.T) {
+		l := newTestLimiter(t, "false")
+		req := httptest.NewRequest("GET", "/images/abc", nil)
+		req.Header.Set(apiKeyHeader, "key-3")
+		if c, err := l.identify(req); err == nil {
+			t.Fatalf("identify = %+v, want an error", c)
+		}
+	})
+}
+
+func TestAllow(t *testing.T) {
+	l := newTestLimiter(t, "false")
+	perMinute, err := RateLimitSpec{Per: "1m", IP: 2, User: 5}.build("/images/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	daily, err := RateLimitSpec{Per: "24h", IP: 100}.build("/images/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := &route{RouteSpec: RouteSpec{Prefix: "/images/"}, limits: []*rateLimit{perMinute, daily}}
+
+	allow := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/images/abc", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		if l.Allow(rec, req, rt) != (rec.Code == http.StatusOK) {
+			t.Fatalf("Allow answered %d", rec.Code)
+		}
+		return rec
+	}
+
+	rec := allow("192.0.2.1:1")
+	if h := rec.Header(); h.Get("RateLimit-Limit") != "2" || h.Get("RateLimit-Remaining") != "1" ||
+		h.Get("RateLimit-Policy") != "2;w=60, 100;w=86400" {
+		t.Fatalf("headers %v", h)
+	}
+	allow("192.0.2.1:2")
+
+	rec = allow("192.0.2.1:3")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "30" {
+		t.Fatalf("third request: %d, Retry-After %q; want 429 after 30s", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	// another address has its own buckets
+	if rec := allow("192.0.2.2:1"); rec.Code != http.StatusOK {
+		t.Fatalf("other address: %d", rec.Code)
+	}
+}
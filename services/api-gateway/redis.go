@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"ride-sharing/shared/env"
+	"ride-sharing/shared/resilience"
+)
+
+// rateLimitScript is refill run atomically by Redis on the hash of a bucket:
+// its tokens and when they were counted, in milliseconds. The bucket expires
+// once full again, when it is as good as a new one. It returns whether a
+// token was taken and the tokens left, as a string since Redis truncates Lua
+// numbers to integers.
+const rateLimitScript = `
+local burst = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+  tokens = burst
+  ts = now
+end
+if now > ts then
+  tokens = math.min(burst, tokens + burst * (now - ts) / period)
+  ts = now
+end
+
+local allowed = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+end
+
+redis.call('HSET', KEYS[1], 'tokens', string.format('%.6f', tokens), 'ts', string.format('%d', ts))
+redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) * period / burst) + 1000)
+return {allowed, string.format('%.6f', tokens)}
+`
+
+// redisKeyPrefix is prepended to the keys of the buckets
+const redisKeyPrefix = "gateway:ratelimit:"
+
+var rateLimitScriptSHA = func() string {
+	sum := sha1.Sum([]byte(rateLimitScript))
+	return hex.EncodeToString(sum[:])
+}()
+
+// redisStore keeps the buckets in Redis, or any server speaking its protocol
+// and running its Lua scripts, so the replicas of the gateway share them.
+// Calls go through a breaker so that, while Redis is down, requests don't
+// wait for it before being let through.
+type redisStore struct {
+	client *redisClient
+	policy *resilience.Policy
+}
+
+// newRedisStore connects to the server of a redis:// or rediss:// URL, with
+// the password and the database number of the URL when it has them.
+func newRedisStore(raw string) (*redisStore, error) {
+	client, err := newRedisClient(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &redisStore{
+		client: client,
+		policy: resilience.New("gateway ratelimit", resilience.ConfigFromEnv("GATEWAY_RATELIMIT", resilience.Config{
+			Timeout:  100 * time.Millisecond,
+			Bulkhead: resilience.BulkheadConfig{MaxConcurrent: client.size, MaxWait: 50 * time.Millisecond},
+			Breaker:  resilience.BreakerConfig{Failures: 5, OpenFor: 10 * time.Second},
+		})),
+	}, nil
+}
+
+func (s *redisStore) Take(ctx context.Context, key string, burst int, period time.Duration, now time.Time) (float64, bool, error) {
+	args := []string{
+		"1", redisKeyPrefix + key,
+		strconv.Itoa(burst), strconv.FormatInt(period.Milliseconds(), 10), strconv.FormatInt(now.UnixMilli(), 10),
+	}
+
+	var reply any
+	err := s.policy.Do(ctx, func(ctx context.Context) error {
+		var err error
+		reply, err = s.client.Do(ctx, append([]string{"EVALSHA", rateLimitScriptSHA}, args...)...)
+		var redisErr redisError
+		if errors.As(err, &redisErr) && strings.HasPrefix(string(redisErr), "NOSCRIPT") {
+			// first run on this server, EVAL caches the script
+			reply, err = s.client.Do(ctx, append([]string{"EVAL", rateLimitScript}, args...)...)
+		}
+		return err
+	})
+	if err != nil {
+		return 0, false, err
+	}
+
+	values, ok := reply.([]any)
+	if !ok || len(values) != 2 {
+		return 0, false, fmt.Errorf("redis: unexpected rate limit reply %v", reply)
+	}
+	allowed, _ := values[0].(int64)
+	left, _ := values[1].(string)
+	tokens, err := strconv.ParseFloat(left, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("redis: unexpected tokens %q", left)
+	}
+	return tokens, allowed == 1, nil
+}
+
+// redisError is an error reply of the server.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// redisClient is a client of the Redis protocol, RESP, with a pool of
+// connections; it knows the few replies the gateway needs.
+type redisClient struct {
+	addr     string
+	tls      *tls.Config
+	password string
+	db       int
+	size     int
+	conns    chan *redisConn
+}
+
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func newRedisClient(raw string) (*redisClient, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("redis: invalid URL %q", raw)
+	}
+
+	c := &redisClient{
+		addr: u.Host,
+		size: env.GetInt("GATEWAY_RATELIMIT_POOL_SIZE", 16),
+	}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.Scheme == "rediss" {
+		c.tls = &tls.Config{MinVersion: tls.VersionTLS12, ServerName: u.Hostname()}
+	}
+	if u.User != nil {
+		c.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("redis: invalid database %q", db)
+		}
+	}
+	c.conns = make(chan *redisConn, c.size)
+	return c, nil
+}
+
+// Do sends a command and reads its reply: a string, an int64, nil, a []any
+// of those, or a redisError.
+func (c *redisClient) Do(ctx context.Context, args ...string) (any, error) {
+	conn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := conn.do(ctx, args...)
+	var redisErr redisError
+	if err != nil && !errors.As(err, &redisErr) {
+		// the connection may be halfway through a reply
+		conn.Close()
+		return nil, err
+	}
+	c.put(conn)
+	return reply, err
+}
+
+func (c *redisClient) get(ctx context.Context) (*redisConn, error) {
+	select {
+	case conn := <-c.conns:
+		return conn, nil
+	default:
+	}
+
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	var conn net.Conn
+	var err error
+	if c.tls != nil {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: c.tls}).DialContext(ctx, "tcp", c.addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", c.addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis %s: %w", c.addr, err)
+	}
+
+	rc := &redisConn{Conn: conn, r: bufio.NewReader(conn)}
+	if c.password != "" {
+		if _, err := rc.do(ctx, "AUTH", c.password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if c.db != 0 {
+		if _, err := rc.do(ctx, "SELECT", strconv.Itoa(c.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+// put keeps conn for the next commands, closing it when the pool is full
+func (c *redisClient) put(conn *redisConn) {
+	select {
+	case c.conns <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+func (conn *redisConn) do(ctx context.Context, args ...string) (any, error) {
+	deadline := time.Time{}
+	if d, ok := ctx.Deadline(); ok {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(conn, b.String()); err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	return readReply(conn.r)
+}
+
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch kind, rest := line[0], line[1:]; kind {
+	case '+':
+		return rest, nil
+	case '-':
+		return nil, redisError(rest)
+	case ':':
+		n, err := strconv.ParseInt(rest, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid integer %q", rest)
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(rest)
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid length %q", rest)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, fmt.Errorf("redis: %w", err)
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(rest)
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid length %q", rest)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		values := make([]any, n)
+		for i := range values {
+			// an error inside an array is a value, like the others
+			v, err := readReply(r)
+			var redisErr redisError
+			if errors.As(err, &redisErr) {
+				v, err = redisErr, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			values[i] = v
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
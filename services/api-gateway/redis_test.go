@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestRedisClient(t *testing.T) {
+	mr := miniredis.RunT(t)
+	mr.RequireAuth("password")
+	ctx := context.Background()
+
+	c, err := newRedisClient("redis://:password@" + mr.Addr() + "/2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args []string
+		want any
+	}{
+		{[]string{"SET", "a", "line\r\nbreak"}, "OK"},
+		{[]string{"GET", "a"}, "line\r\nbreak"},
+		{[]string{"GET", "missing"}, nil},
+		{[]string{"INCR", "n"}, int64(1)},
+		{[]string{"INCRBY", "n", "-3"}, int64(-2)},
+		{[]string{"MGET", "a", "missing", "n"}, []any{"line\r\nbreak", nil, "-2"}},
+		{[]string{"LRANGE", "missing", "0", "-1"}, []any{}},
+	}
+	for _, tt := range tests {
+		got, err := c.Do(ctx, tt.args...)
+		if err != nil {
+			t.Fatalf("%s: %v", strings.Join(tt.args, " "), err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%s = %#v, want %#v", strings.Join(tt.args, " "), got, tt.want)
+		}
+	}
+
+	// the commands went to the database of the URL
+	mr.Select(2)
+	if v, _ := mr.Get("a"); v != "line\r\nbreak" {
+		t.Fatalf("database 2 holds %q", v)
+	}
+
+	// an error reply leaves the connection usable
+	_, err = c.Do(ctx, "LPUSH", "a", "x")
+	var redisErr redisError
+	if !errors.As(err, &redisErr) || !strings.HasPrefix(string(redisErr), "WRONGTYPE") {
+		t.Fatalf("LPUSH on a string = %v, want a WRONGTYPE error", err)
+	}
+	if len(c.conns) != 1 {
+		t.Fatalf("%d pooled connection(s), want the one used", len(c.conns))
+	}
+	if got, err := c.Do(ctx, "GET", "a"); err != nil || got != "line\r\nbreak" {
+		t.Fatalf("GET after an error = %v, %v", got, err)
+	}
+
+	wrong, err := newRedisClient("redis://:wrong@" + mr.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wrong.Do(ctx, "GET", "a"); !errors.As(err, &redisErr) {
+		t.Fatalf("GET with a wrong password = %v, want an error reply", err)
+	}
+}
+
+func TestNewRedisClient(t *testing.T) {
+	tests := []struct {
+		raw  string
+		addr string
+		db   int
+		tls  bool
+	}{
+		{"redis://redis", "redis:6379", 0, false},
+		{"redis://:secret@redis:6380/3", "redis:6380", 3, false},
+		{"rediss://cache.example.com", "cache.example.com:6379", 0, true},
+	}
+	for _, tt := range tests {
+		c, err := newRedisClient(tt.raw)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.raw, err)
+		}
+		if c.addr != tt.addr || c.db != tt.db || (c.tls != nil) != tt.tls {
+			t.Fatalf("%s: addr %s, db %d, tls %v", tt.raw, c.addr, c.db, c.tls != nil)
+		}
+	}
+
+	for _, raw := range []string{"redis://", "redis://redis/db"} {
+		if _, err := newRedisClient(raw); err == nil {
+			t.Fatalf("%s: no error", raw)
+		}
+	}
+}
+
+func TestRedisStore(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s, err := newRedisStore("redis://" + mr.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	now := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+
+	take := func(at time.Time) (float64, bool) {
+		t.Helper()
+		tokens, allowed, err := s.Take(ctx, "k", 4, time.Minute, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tokens, allowed
+	}
+
+	// the script isn't cached on a new server: EVALSHA fails with NOSCRIPT
+	// and EVAL runs it
+	if tokens, allowed := take(now); !allowed || tokens != 3 {
+		t.Fatalf("first take = %v, %v; want 3 tokens left", tokens, allowed)
+	}
+	exists, err := s.client.Do(ctx, "SCRIPT", "EXISTS", rateLimitScriptSHA)
+	if err != nil || !reflect.DeepEqual(exists, []any{int64(1)}) {
+		t.Fatalf("SCRIPT EXISTS = %v, %v; want the script cached", exists, err)
+	}
+	if ttl := mr.TTL(redisKeyPrefix + "k"); ttl <= 0 || ttl > 16*time.Second {
+		t.Fatalf("bucket expires in %s, want once full again", ttl)
+	}
+
+	take(now)
+	take(now)
+	take(now)
+	if tokens, allowed := take(now); allowed || tokens != 0 {
+		t.Fatalf("fifth take = %v, %v; want refused", tokens, allowed)
+	}
+
+	// a restarted server has lost its scripts, not the buckets
+	if _, err := s.client.Do(ctx, "SCRIPT", "FLUSH"); err != nil {
+		t.Fatal(err)
+	}
+	if tokens, allowed := take(now.Add(15 * time.Second)); !allowed || tokens != 0 {
+		t.Fatalf("take after SCRIPT FLUSH = %v, %v; want the token earned in 15s", tokens, allowed)
+	}
+
+	// the Go twin agrees with the script
+	goTokens, goAllowed := refill(0, now, now.Add(40*time.Second), 4, time.Minute)
+	tokens, allowed := take(now.Add(55 * time.Second))
+	if allowed != goAllowed || tokens-goTokens > 1e-6 || goTokens-tokens > 1e-6 {
+		t.Fatalf("script = %v, %v; refill = %v, %v", tokens, allowed, goTokens, goAllowed)
+	}
+}
+
+func TestRedisStoreDown(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s, err := newRedisStore("redis://" + mr.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr.Close()
+
+	if _, _, err := s.Take(context.Background(), "k", 4, time.Minute, time.Now()); err == nil {
+		t.Fatal("Take with Redis down succeeded")
+	}
+}
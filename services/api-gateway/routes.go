@@ -0,0 +1,374 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"ride-sharing/shared/resilience"
+)
+
+const (
+	defaultRouteTimeout = 15 * time.Second
+	// requests with larger bodies are never retried, since retrying means
+	// keeping the body in memory
+	maxRetryBody = 1 << 20
+
+	defaultMaxConcurrent   = 200
+	defaultMaxWait         = time.Second
+	defaultBreakerFailures = 5
+	defaultBreakerOpen     = 30 * time.Second
+)
+
+// internalPrefixes are the paths of the services never served through the
+// gateway, whatever the routes: their metrics, admin and service to service
+// endpoints
+var internalPrefixes = []string{"/debug/", "/users/", "/admin/"}
+
+// RouteConfig is the file of GATEWAY_ROUTES_FILE: the upstream services and
+// the routes to them.
+type RouteConfig struct {
+	Services map[string]ServiceConfig `json:"services"`
+	Routes   []RouteSpec              `json:"routes"`
+	// RateLimits apply to the routes without their own
+	RateLimits []RateLimitSpec `json:"rateLimits,omitempty"`
+	// Deny adds path prefixes to the internal ones never served
+	Deny []string `json:"deny,omitempty"`
+}
+
+// ServiceConfig is an upstream service of the registry.
+type ServiceConfig struct {
+	URL string `json:"url"`
+	// Env names a variable overriding URL, for the environments where the
+	// service lives elsewhere
+	Env string `json:"env,omitempty"`
+	// MaxConcurrent requests are sent to the service at once, 200 when zero
+	// and no limit when negative; the others wait MaxWait, 1s when empty,
+	// then get a 503
+	MaxConcurrent int    `json:"maxConcurrent,omitempty"`
+	MaxWait       string `json:"maxWait,omitempty"`
+	// BreakerFailures consecutive failures, 5 when zero and never when
+	// negative, make the gateway answer 503 without calling the service for
+	// BreakerOpen, 30s when empty
+	BreakerFailures int    `json:"breakerFailures,omitempty"`
+	BreakerOpen     string `json:"breakerOpen,omitempty"`
+}
+
+// RouteSpec sends the requests whose path starts with Prefix to Service.
+type RouteSpec struct {
+	Prefix  string   `json:"prefix"`
+	Service string   `json:"service"`
+	Methods []string `json:"methods,omitempty"` // all when empty
+	// Rewrite replaces the prefix in the path sent upstream
+	Rewrite *string `json:"rewrite,omitempty"`
+	Timeout string  `json:"timeout,omitempty"` // 15s when empty
+	// Retries are made on connection errors and 502, 503 and 504 answers,
+	// for GET, HEAD, OPTIONS, PUT and DELETE requests only unless the route
+	// is Idempotent
+	Retries    int  `json:"retries,omitempty"`
+	Idempotent bool `json:"idempotent,omitempty"`
+	// Envelope wraps the JSON answers of the service in a
+	// contracts.APIResponse, and its errors in an APIError
+	Envelope bool `json:"envelope,omitempty"`
+	// RateLimits replace the default ones of the config; an empty list
+	// lifts them
+	RateLimits []RateLimitSpec `json:"rateLimits,omitempty"`
+}
+
+// route is a RouteSpec ready to serve.
+type route struct {
+	RouteSpec
+	target  *url.URL
+	methods map[string]bool
+	timeout time.Duration
+	// policy guards the requests to the service
+	policy *resilience.Policy
+	// limits are the rate limits of the route, shortest period first
+	limits []*rateLimit
+}
+
+// routeTable holds the routes longest prefix first.
+type routeTable struct {
+	routes []*route
+	// policies of the services by name, kept by the reloads that don't
+	// change them so their breakers stay as they are
+	policies map[string]*resilience.Policy
+	// deny holds the path prefixes never served
+	deny []string
+}
+
+// match returns the route of r, nil when none has its path and method or
+// when the path, as asked or as sent upstream, is denied
+func (t *routeTable) match(r *http.Request) *route {
+	for _, rt := range t.routes {
+		if !strings.HasPrefix(r.URL.Path, rt.Prefix) {
+			continue
+		}
+		if len(rt.methods) > 0 && !rt.methods[r.Method] {
+			continue
+		}
+		if t.denied(r.URL.Path) || t.denied(rt.upstreamPath(r.URL.Path)) {
+			return nil
+		}
+		return rt
+	}
+	return nil
+}
+
+// denied reports whether p, or p cleaned of its dot segments, is under a
+// denied prefix
+func (t *routeTable) denied(p string) bool {
+	for _, candidate := range []string{p, path.Clean(p) + "/"} {
+		for _, prefix := range t.deny {
+			if strings.HasPrefix(candidate, prefix) || candidate == strings.TrimSuffix(prefix, "/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// retryable reports whether a request of method may be sent again
+func (rt *route) retryable(method string) bool {
+	if rt.Retries == 0 {
+		return false
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return rt.Idempotent
+}
+
+// upstreamPath returns the path of a request to the service
+func (rt *route) upstreamPath(path string) string {
+	if rt.Rewrite == nil {
+		return path
+	}
+	rest := strings.TrimPrefix(path, rt.Prefix)
+	p := *rt.Rewrite + rest
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	return strings.ReplaceAll(p, "//", "/")
+}
+
+// loadRoutes reads and checks the route table of file, keeping the service
+// policies of current, when not nil, whose settings didn't change.
+func loadRoutes(file string, current *routeTable) (*routeTable, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg RouteConfig
+	dec := json.NewDecoder(strings.NewReader(string(b)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	table, err := cfg.build(current)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return table, nil
+}
+
+func (cfg *RouteConfig) build(current *routeTable) (*routeTable, error) {
+	targets := map[string]*url.URL{}
+	policies := map[string]*resilience.Policy{}
+	for name, svc := range cfg.Services {
+		raw := svc.URL
+		if svc.Env != "" && os.Getenv(svc.Env) != "" {
+			raw = os.Getenv(svc.Env)
+		}
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("service %s: %q is not an http(s) URL", name, raw)
+		}
+		targets[name] = u
+
+		guard, err := svc.resilience()
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %w", name, err)
+		}
+		if current != nil && current.policies[name] != nil && current.policies[name].Config() == guard {
+			policies[name] = current.policies[name]
+		} else {
+			policies[name] = resilience.New("gateway "+name, guard)
+		}
+	}
+
+	if len(cfg.Routes) == 0 {
+		return nil, errors.New("no routes")
+	}
+	table := &routeTable{policies: policies, deny: append([]string{}, internalPrefixes...)}
+	for _, prefix := range cfg.Deny {
+		if !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("deny: prefix %q must start with /", prefix)
+		}
+		table.deny = append(table.deny, prefix)
+	}
+	seen := map[string]bool{}
+	for i, spec := range cfg.Routes {
+		if !strings.HasPrefix(spec.Prefix, "/") {
+			return nil, fmt.Errorf("route %d: prefix %q must start with /", i, spec.Prefix)
+		}
+		target, ok := targets[spec.Service]
+		if !ok {
+			return nil, fmt.Errorf("route %s: unknown service %q", spec.Prefix, spec.Service)
+		}
+		if spec.Retries < 0 || spec.Retries > 5 {
+			return nil, fmt.Errorf("route %s: retries must be between 0 and 5", spec.Prefix)
+		}
+
+		rt := &route{RouteSpec: spec, target: target, timeout: defaultRouteTimeout, policy: policies[spec.Service]}
+		if spec.Timeout != "" {
+			d, err := time.ParseDuration(spec.Timeout)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("route %s: invalid timeout %q", spec.Prefix, spec.Timeout)
+			}
+			rt.timeout = d
+		}
+		limits := spec.RateLimits
+		if limits == nil {
+			limits = cfg.RateLimits
+		}
+		for _, limit := range limits {
+			built, err := limit.build(spec.Prefix)
+			if err != nil {
+				return nil, fmt.Errorf("route %s: %w", spec.Prefix, err)
+			}
+			rt.limits = append(rt.limits, built)
+		}
+		sort.SliceStable(rt.limits, func(i, j int) bool { return rt.limits[i].period < rt.limits[j].period })
+		if len(spec.Methods) > 0 {
+			rt.methods = map[string]bool{}
+			for _, m := range spec.Methods {
+				rt.methods[strings.ToUpper(m)] = true
+			}
+		}
+
+		key := spec.Prefix + " " + strings.Join(spec.Methods, ",")
+		if seen[key] {
+			return nil, fmt.Errorf("route %s: defined twice", spec.Prefix)
+		}
+		seen[key] = true
+		table.routes = append(table.routes, rt)
+	}
+
+	// longest prefix first; among equal prefixes, the ones limited to some
+	// methods before the catch-all
+	sort.SliceStable(table.routes, func(i, j int) bool {
+		a, b := table.routes[i], table.routes[j]
+		if len(a.Prefix) != len(b.Prefix) {
+			return len(a.Prefix) > len(b.Prefix)
+		}
+		return len(a.methods) > 0 && len(b.methods) == 0
+	})
+	return table, nil
+}
+
+// resilience returns the bulkhead and breaker of the service; the timeouts
+// and retries are the routes' own.
+func (svc ServiceConfig) resilience() (resilience.Config, error) {
+	cfg := resilience.Config{
+		Bulkhead: resilience.BulkheadConfig{MaxConcurrent: svc.MaxConcurrent, MaxWait: defaultMaxWait},
+		Breaker:  resilience.BreakerConfig{Failures: svc.BreakerFailures, OpenFor: defaultBreakerOpen},
+	}
+	if svc.MaxConcurrent == 0 {
+		cfg.Bulkhead.MaxConcurrent = defaultMaxConcurrent
+	}
+	if svc.BreakerFailures == 0 {
+		cfg.Breaker.Failures = defaultBreakerFailures
+	}
+
+	var err error
+	if svc.MaxWait != "" {
+		if cfg.Bulkhead.MaxWait, err = time.ParseDuration(svc.MaxWait); err != nil || cfg.Bulkhead.MaxWait < 0 {
+			return cfg, fmt.Errorf("invalid maxWait %q", svc.MaxWait)
+		}
+	}
+	if svc.BreakerOpen != "" {
+		if cfg.Breaker.OpenFor, err = time.ParseDuration(svc.BreakerOpen); err != nil || cfg.Breaker.OpenFor <= 0 {
+			return cfg, fmt.Errorf("invalid breakerOpen %q", svc.BreakerOpen)
+		}
+	}
+	return cfg, nil
+}
+
+// Routes serves the route table of a file, reloading it when the file
+// changes. A table that doesn't load leaves the current one in place.
+type Routes struct {
+	file    string
+	table   atomic.Pointer[routeTable]
+	modTime time.Time
+}
+
+// NewRoutes loads file, failing when it can't since the gateway can't route
+// anything without it.
+func NewRoutes(file string) (*Routes, error) {
+	table, err := loadRoutes(file, nil)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Routes{file: file, modTime: info.ModTime()}
+	r.table.Store(table)
+	log.Printf("Loaded %d route(s) from %s", len(table.routes), file)
+	return r, nil
+}
+
+// Table returns the current route table.
+func (r *Routes) Table() *routeTable {
+	return r.table.Load()
+}
+
+// Reload loads the file again.
+func (r *Routes) Reload() error {
+	table, err := loadRoutes(r.file, r.Table())
+	if err != nil {
+		return err
+	}
+	r.table.Store(table)
+	log.Printf("Reloaded %d route(s) from %s", len(table.routes), r.file)
+	return nil
+}
+
+// Watch reloads the file every interval it changed, or when reload fires,
+// until done is closed.
+func (r *Routes) Watch(interval time.Duration, reload <-chan os.Signal, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-reload:
+		case <-ticker.C:
+			info, err := os.Stat(r.file)
+			if err != nil || info.ModTime().Equal(r.modTime) {
+				continue
+			}
+			r.modTime = info.ModTime()
+		}
+
+		if err := r.Reload(); err != nil {
+			log.Printf("Error reloading routes, keeping the current ones: %v", err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRoutesFile(t *testing.T) {
+	table, err := loadRoutes("routes.json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method   string
+		path     string
+		service  string // none when empty
+		upstream string
+	}{
+		{"POST", "/api/v1/auth/login", "auth", "/api/v1/auth/login"},
+		{"POST", "/trip/preview", "trip", "/preview"},
+		{"GET", "/trip/preview", "", ""},
+		{"GET", "/trip/123", "", ""},
+		{"POST", "/trip/start", "", ""},
+		{"GET", "/images/abc", "image", "/images/abc"},
+		{"DELETE", "/images/abc", "", ""},
+		{"GET", "/blobs/owner/abc", "image", "/blobs/owner/abc"},
+		{"POST", "/upload", "image", "/upload"},
+		{"GET", "/logs", "", ""},
+		{"GET", "/logs/tail", "", ""},
+		{"GET", "/debug/vars", "", ""},
+		{"GET", "/users/1/logs", "", ""},
+		{"GET", "/admin/retention", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			rt := table.match(httptest.NewRequest(tt.method, tt.path, nil))
+			if tt.service == "" {
+				if rt != nil {
+					t.Fatalf("routed to %s, want no route", rt.Service)
+				}
+				return
+			}
+			if rt == nil {
+				t.Fatalf("no route, want %s", tt.service)
+			}
+			if rt.Service != tt.service || rt.upstreamPath(tt.path) != tt.upstream {
+				t.Fatalf("routed to %s %s, want %s %s", rt.Service, rt.upstreamPath(tt.path), tt.service, tt.upstream)
+			}
+		})
+	}
+}
+
+func TestInternalPathsDenied(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "routes.json")
+	config := `{
+  "services": { "logger": { "url": "http://logger-service:8082" } },
+  "deny": ["/metrics"],
+  "routes": [
+    { "prefix": "/", "service": "logger" },
+    { "prefix": "/logger/", "service": "logger", "rewrite": "/" }
+  ]
+}`
+	if err := os.WriteFile(file, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	table, err := loadRoutes(file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if table.match(httptest.NewRequest("GET", "/logs", nil)) == nil {
+		t.Fatal("GET /logs not routed")
+	}
+	denied := []string{
+		"/debug/vars",
+		"/debug",
+		"/users/1/logs",
+		"/admin/retention",
+		"/metrics",
+		// denied as sent upstream too
+		"/logger/admin/retention",
+		"/logger/debug/vars",
+		// and once cleaned of dot segments
+		"/logs/../admin/retention",
+		"/logger/x/../../users/1/logs",
+	}
+	for _, path := range denied {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.URL.Path = path
+		if rt := table.match(req); rt != nil {
+			t.Errorf("GET %s routed to %s, want it denied", path, rt.upstreamPath(path))
+		}
+	}
+}
@@ -1,9 +0,0 @@
-package main
-
-import "ride-sharing/shared/types"
-
-type previewTripRequest struct {
-	UserID      string           `json:"userID"`
-	Pickup      types.Coordinate `json:"pickup"`
-	Destination types.Coordinate `json:"destination"`
-}
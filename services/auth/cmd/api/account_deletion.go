@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"ride-sharing/services/auth/data"
+	"ride-sharing/shared/contracts"
+	"ride-sharing/shared/messaging"
+	"ride-sharing/shared/scheduler"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	deletionBatchSize     = 20
+	deletionConfirmQueue  = "auth_user_data_erased"
+	defaultDeletionTick   = time.Minute
+	defaultConfirmTimeout = time.Hour // for the services to confirm an erasure
+	defaultErasureConfirm = "logger-service,trip-service"
+)
+
+// accountDeletionWorker finishes deletion requests whose grace period is over.
+// It anonymises the user in auth, asks every other service to erase its data
+// through a user.event.deleted event and completes the request once all of
+// them confirmed with user.event.data_erased. Requests still unconfirmed after
+// ACCOUNT_DELETION_CONFIRM_TIMEOUT have their event published again, since an
+// event or a confirmation may be lost; erasing twice changes nothing.
+type accountDeletionWorker struct {
+	models         *data.Models
+	rabbitmq       *messaging.RabbitMQ
+	services       []string // services that must confirm the erasure
+	confirmTimeout time.Duration
+}
+
+func startAccountDeletionWorker(models *data.Models, rabbitmq *messaging.RabbitMQ, jobs *scheduler.Scheduler) error {
+	w := &accountDeletionWorker{
+		models:         models,
+		rabbitmq:       rabbitmq,
+		services:       confirmingServices(),
+		confirmTimeout: durationEnv("ACCOUNT_DELETION_CONFIRM_TIMEOUT", defaultConfirmTimeout),
+	}
+
+	if rabbitmq != nil {
+		go func() {
+			err := rabbitmq.ConsumeMessages(deletionConfirmQueue, []string{contracts.UserEventDataErased}, w.handleDataErased)
+			if err != nil {
+				log.Printf("Account deletion confirmation consumer stopped: %v", err)
+			}
+		}()
+	}
+
+	// Only one replica should pick up due requests at a time
+	return jobs.Add(scheduler.Job{
+		Name:      "auth.account-deletions",
+		Schedule:  scheduler.Every(durationEnv("ACCOUNT_DELETION_INTERVAL", defaultDeletionTick)),
+		Run:       w.processDue,
+		Singleton: true,
+	})
+}
+
+// processDue handles every pending request whose grace period has ended, and
+// dispatches again the ones waiting too long for their confirmations
+func (w *accountDeletionWorker) processDue(ctx context.Context) error {
+	if w.rabbitmq == nil {
+		// Without a broker the other services can't be told to erase data, so
+		// leave requests pending until the service restarts with RabbitMQ.
+		return nil
+	}
+
+	due, err := w.models.AccountDeletion.GetDue(ctx, time.Now(), deletionBatchSize)
+	if err != nil {
+		return fmt.Errorf("load due account deletions: %w", err)
+	}
+
+	for _, d := range due {
+		if err := w.process(ctx, d); err != nil {
+			log.Printf("Error processing account deletion %s: %v", d.ID, err)
+		}
+	}
+
+	stale, err := w.models.AccountDeletion.GetStale(ctx, time.Now().Add(-w.confirmTimeout), deletionBatchSize)
+	if err != nil {
+		return fmt.Errorf("load stale account deletions: %w", err)
+	}
+
+	for _, d := range stale {
+		if err := w.redispatch(ctx, d); err != nil {
+			log.Printf("Error dispatching account deletion %s again: %v", d.ID, err)
+		}
+	}
+	return nil
+}
+
+func (w *accountDeletionWorker) process(ctx context.Context, d *data.AccountDeletion) error {
+	if err := w.models.User.Anonymise(ctx, d.UserID); err != nil {
+		return fmt.Errorf("anonymise user: %w", err)
+	}
+	w.audit(ctx, d.ID, "auth", "anonymised", 1, "profile replaced with placeholders and deactivated")
+
+	erased, err := w.models.VerifyToken.DeleteByUserID(ctx, d.UserID)
+	if err != nil {
+		return fmt.Errorf("delete verify tokens: %w", err)
+	}
+	w.audit(ctx, d.ID, "auth", "erased", erased, "verify tokens deleted")
+
+	if err := w.dispatch(ctx, d, "event_published"); err != nil {
+		return err
+	}
+	return w.completeIfConfirmed(ctx, d.ID)
+}
+
+// redispatch publishes again the event of a request whose confirmations are
+// overdue, for the services that haven't confirmed yet
+func (w *accountDeletionWorker) redispatch(ctx context.Context, d *data.AccountDeletion) error {
+	missing, err := w.unconfirmed(ctx, d.ID)
+	if err != nil {
+		return err
+	}
+	if len(missing) == 0 {
+		// every confirmation is in, only completing the request failed
+		return w.completeIfConfirmed(ctx, d.ID)
+	}
+
+	log.Printf("Account deletion %s still unconfirmed by %s, publishing its event again", d.ID, strings.Join(missing, ", "))
+	return w.dispatch(ctx, d, "event_republished")
+}
+
+// dispatch publishes the user.event.deleted event of a request and marks it
+// processing, which starts its confirmation deadline
+func (w *accountDeletionWorker) dispatch(ctx context.Context, d *data.AccountDeletion, action string) error {
+	payload, err := json.Marshal(contracts.UserDeletedEvent{
+		RequestID: d.ID,
+		UserID:    d.UserID,
+		DeletedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	publishCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	err = w.rabbitmq.PublishMessage(publishCtx, contracts.UserEventDeleted, contracts.AmqpMessage{
+		OwnerID: d.UserID,
+		Data:    payload,
+	})
+	if err != nil {
+		return fmt.Errorf("publish %s: %w", contracts.UserEventDeleted, err)
+	}
+	w.audit(ctx, d.ID, "auth", action, 0, contracts.UserEventDeleted)
+
+	if err := w.models.AccountDeletion.MarkProcessing(ctx, d.ID); err != nil {
+		return fmt.Errorf("mark processing: %w", err)
+	}
+	return nil
+}
+
+// handleDataErased records the confirmation of one service
+func (w *accountDeletionWorker) handleDataErased(ctx context.Context, msg amqp.Delivery) error {
+	var message contracts.AmqpMessage
+	if err := json.Unmarshal(msg.Body, &message); err != nil {
+		return fmt.Errorf("failed to unmarshal message: %v", err)
+	}
+
+	var payload contracts.UserDataErasedEvent
+	if err := json.Unmarshal(message.Data, &payload); err != nil {
+		return fmt.Errorf("failed to unmarshal payload: %v", err)
+	}
+
+	err := w.models.AccountDeletion.AddAudit(ctx, data.AccountDeletionAudit{
+		DeletionID:      payload.RequestID,
+		Service:         payload.Service,
+		Action:          "erased",
+		RecordsAffected: payload.Erased,
+		Details:         fmt.Sprintf("confirmed at %s", payload.ErasedAt.Format(time.RFC3339)),
+	})
+	if err != nil {
+		return fmt.Errorf("record erasure of %s: %w", payload.Service, err)
+	}
+
+	return w.completeIfConfirmed(ctx, payload.RequestID)
+}
+
+// completeIfConfirmed marks the request completed once every confirming
+// service has reported its erasure
+func (w *accountDeletionWorker) completeIfConfirmed(ctx context.Context, deletionID string) error {
+	deletion, err := w.models.AccountDeletion.GetOne(ctx, deletionID)
+	if err != nil {
+		return err
+	}
+	if deletion.Status != data.DeletionStatusProcessing {
+		return nil
+	}
+
+	missing, err := w.unconfirmed(ctx, deletionID)
+	if err != nil || len(missing) > 0 {
+		return err
+	}
+
+	if err := w.models.AccountDeletion.MarkCompleted(ctx, deletionID); err != nil {
+		return err
+	}
+	w.audit(ctx, deletionID, "auth", "completed", 0, "all services confirmed erasure: "+strings.Join(w.services, ", "))
+	log.Printf("Account deletion %s completed", deletionID)
+	return nil
+}
+
+// unconfirmed returns the confirming services that haven't reported the
+// erasure of a request yet
+func (w *accountDeletionWorker) unconfirmed(ctx context.Context, deletionID string) ([]string, error) {
+	entries, err := w.models.AccountDeletion.GetAudit(ctx, deletionID)
+	if err != nil {
+		return nil, err
+	}
+
+	confirmed := map[string]bool{}
+	for _, e := range entries {
+		if e.Action == "erased" {
+			confirmed[e.Service] = true
+		}
+	}
+
+	var missing []string
+	for _, service := range w.services {
+		if !confirmed[service] {
+			missing = append(missing, service)
+		}
+	}
+	return missing, nil
+}
+
+func (w *accountDeletionWorker) audit(ctx context.Context, deletionID, service, action string, records int64, details string) {
+	err := w.models.AccountDeletion.AddAudit(ctx, data.AccountDeletionAudit{
+		DeletionID:      deletionID,
+		Service:         service,
+		Action:          action,
+		RecordsAffected: records,
+		Details:         details,
+	})
+	if err != nil {
+		log.Printf("Error writing deletion audit for %s: %v", deletionID, err)
+	}
+}
+
+// confirmingServices reads ACCOUNT_DELETION_SERVICES, the comma separated list
+// of services that must confirm an erasure before a deletion is complete
+func confirmingServices() []string {
+	raw := os.Getenv("ACCOUNT_DELETION_SERVICES")
+	if raw == "" {
+		raw = defaultErasureConfirm
+	}
+
+	var services []string
+	for _, s := range strings.Split(raw, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			services = append(services, s)
+		}
+	}
+	return services
+}
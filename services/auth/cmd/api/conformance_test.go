@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"ride-sharing/services/auth/data"
+	"ride-sharing/services/auth/service"
+	authpb "ride-sharing/shared/generated/auth"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// conformanceEnv is the auth service on the in-memory repositories, served
+// over HTTP and gRPC, with the data the use cases below need.
+type conformanceEnv struct {
+	http *httptest.Server
+	grpc authpb.AuthServiceClient
+
+	refreshToken string
+	accessToken  string
+}
+
+func newConformanceEnv(t *testing.T) *conformanceEnv {
+	t.Helper()
+	ctx := context.Background()
+
+	models := data.NewInMemory()
+	tokens := service.NewTokens("test-secret", time.Hour, 24*time.Hour)
+	auth := service.New(models, tokens)
+	env := &conformanceEnv{}
+
+	app := &Config{Models: models, Auth: auth}
+	env.http = httptest.NewServer(app.routes())
+	t.Cleanup(env.http.Close)
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	authpb.RegisterAuthServiceServer(srv, &authServer{auth: auth})
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	env.grpc = authpb.NewAuthServiceClient(conn)
+
+	user, err := auth.SignUp(ctx, service.SignUpInput{FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", Password: "secret1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inactiveID, err := models.User.Insert(ctx, data.User{FirstName: "Joe", LastName: "Doe", Email: "inactive@example.com", Password: "secret1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inactive, _ := models.User.GetOne(ctx, inactiveID)
+	inactive.Active = false
+	if err := models.User.Update(ctx, *inactive); err != nil {
+		t.Fatal(err)
+	}
+
+	pair, err := tokens.Issue(user.ID, user.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.accessToken, env.refreshToken = pair.AccessToken, pair.RefreshToken
+
+	for _, token := range []struct {
+		token   string
+		expires time.Duration
+		used    bool
+	}{
+		{"valid", time.Hour, false},
+		{"expired", -time.Minute, false},
+		{"used", time.Hour, true},
+	} {
+		id, err := models.VerifyToken.Insert(ctx, data.VerifyToken{
+			UserID:           user.ID,
+			Token:            token.token,
+			OTPCode:          "123456",
+			VerificationType: service.VerificationTypeEmail,
+			ExpiresAt:        time.Now().Add(token.expires),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token.used {
+			models.VerifyToken.MarkAsUsed(ctx, id)
+		}
+	}
+
+	if _, err := models.City.Insert(ctx, data.City{Code: "01", Name: "Hà Nội", Type: "province"}); err != nil {
+		t.Fatal(err)
+	}
+	return env
+}
+
+// conformanceCase is a use case called through both transports; want is the
+// error it ends with, nil when it succeeds. Cases run in order and may change
+// the data of the ones after them.
+type conformanceCase struct {
+	name string
+	path string // of the HTTP API, POSTed body unless it is nil
+	body any
+	grpc func(ctx context.Context, c authpb.AuthServiceClient, env *conformanceEnv) error
+	want *service.Error
+}
+
+func invalid(message string) *service.Error {
+	return &service.Error{Kind: service.KindInvalidArgument, Message: message}
+}
+
+func conformanceCases() []conformanceCase {
+	signUp := func(req *authpb.SignUpRequest) func(context.Context, authpb.AuthServiceClient, *conformanceEnv) error {
+		return func(ctx context.Context, c authpb.AuthServiceClient, _ *conformanceEnv) error {
+			_, err := c.SignUp(ctx, req)
+			return err
+		}
+	}
+	signIn := func(userName, password string) func(context.Context, authpb.AuthServiceClient, *conformanceEnv) error {
+		return func(ctx context.Context, c authpb.AuthServiceClient, _ *conformanceEnv) error {
+			_, err := c.SignIn(ctx, &authpb.SignInRequest{UserName: userName, Password: password})
+			return err
+		}
+	}
+	verifyMail := func(token, otp string) func(context.Context, authpb.AuthServiceClient, *conformanceEnv) error {
+		return func(ctx context.Context, c authpb.AuthServiceClient, _ *conformanceEnv) error {
+			_, err := c.VerifyMail(ctx, &authpb.VerifyMailRequest{VerificationOTPCode: token, Otp: otp})
+			return err
+		}
+	}
+	resendOTP := func(token string) func(context.Context, authpb.AuthServiceClient, *conformanceEnv) error {
+		return func(ctx context.Context, c authpb.AuthServiceClient, _ *conformanceEnv) error {
+			_, err := c.ResendOTP(ctx, &authpb.ResendOTPRequest{VerificationOTPCode: token})
+			return err
+		}
+	}
+	const (
+		signUpPath = "/api/v1/User/sign-up"
+		signInPath = "/api/v1/User/sign-in"
+		renewPath  = "/api/v1/XFWToken/renew-access-token"
+		verifyPath = "/api/v1/User/verify-mail"
+		resendPath = "/api/v1/User/resend-otp"
+	)
+
+	return []conformanceCase{
+		{
+			name: "sign up",
+			path: signUpPath,
+			body: map[string]string{"firstName": "John", "lastName": "Roe", "email": "john@example.com", "password": "secret1"},
+			grpc: signUp(&authpb.SignUpRequest{FirstName: "John", LastName: "Roe", Email: "john@example.com", Password: "secret1"}),
+		},
+		{
+			name: "sign up with a taken email",
+			path: signUpPath,
+			body: map[string]string{"firstName": "Jane", "lastName": "Doe", "email": "jane@example.com", "password": "secret1"},
+			grpc: signUp(&authpb.SignUpRequest{FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", Password: "secret1"}),
+			want: service.ErrUserExists,
+		},
+		{
+			name: "sign up without a last name",
+			path: signUpPath,
+			body: map[string]string{"firstName": "John", "email": "john@example.com", "password": "secret1"},
+			grpc: signUp(&authpb.SignUpRequest{FirstName: "John", Email: "john@example.com", Password: "secret1"}),
+			want: invalid("firstName, lastName, email, and password are required"),
+		},
+		{
+			name: "sign up with a short password",
+			path: signUpPath,
+			body: map[string]string{"firstName": "John", "lastName": "Roe", "email": "john@example.com", "password": "123"},
+			grpc: signUp(&authpb.SignUpRequest{FirstName: "John", LastName: "Roe", Email: "john@example.com", Password: "123"}),
+			want: invalid("Password must be at least 6 characters long"),
+		},
+		{
+			name: "sign up in an unknown city",
+			path: signUpPath,
+			body: map[string]string{"firstName": "John", "lastName": "Roe", "email": "john@example.com", "password": "secret1", "cityId": "99"},
+			grpc: signUp(&authpb.SignUpRequest{FirstName: "John", LastName: "Roe", Email: "john@example.com", Password: "secret1", CityId: "99"}),
+			want: invalid("City with id 99 not found"),
+		},
+		{
+			name: "sign in",
+			path: signInPath,
+			body: map[string]string{"userName": "jane@example.com", "password": "secret1"},
+			grpc: signIn("jane@example.com", "secret1"),
+		},
+		{
+			name: "sign in with a wrong password",
+			path: signInPath,
+			body: map[string]string{"userName": "jane@example.com", "password": "wrong1"},
+			grpc: signIn("jane@example.com", "wrong1"),
+			want: service.ErrInvalidCredentials,
+		},
+		{
+			name: "sign in as an unknown user",
+			path: signInPath,
+			body: map[string]string{"userName": "nobody@example.com", "password": "secret1"},
+			grpc: signIn("nobody@example.com", "secret1"),
+			want: service.ErrInvalidCredentials,
+		},
+		{
+			name: "sign in as an inactive user",
+			path: signInPath,
+			body: map[string]string{"userName": "inactive@example.com", "password": "secret1"},
+			grpc: signIn("inactive@example.com", "secret1"),
+			want: service.ErrInactiveUser,
+		},
+		{
+			name: "sign in without a password",
+			path: signInPath,
+			body: map[string]string{"userName": "jane@example.com"},
+			grpc: signIn("jane@example.com", ""),
+			want: invalid("userName and password are required"),
+		},
+		{
+			name: "renew access token",
+			path: renewPath,
+			body: func(env *conformanceEnv) any { return map[string]string{"vrto": env.refreshToken} },
+			grpc: func(ctx context.Context, c authpb.AuthServiceClient, env *conformanceEnv) error {
+				_, err := c.RenewAccessToken(ctx, &authpb.RenewAccessTokenRequest{Vrto: env.refreshToken})
+				return err
+			},
+		},
+		{
+			name: "renew with an access token",
+			path: renewPath,
+			body: func(env *conformanceEnv) any { return map[string]string{"vrto": env.accessToken} },
+			grpc: func(ctx context.Context, c authpb.AuthServiceClient, env *conformanceEnv) error {
+				_, err := c.RenewAccessToken(ctx, &authpb.RenewAccessTokenRequest{Vrto: env.accessToken})
+				return err
+			},
+			want: service.ErrInvalidToken,
+		},
+		{
+			name: "renew without a token",
+			path: renewPath,
+			body: map[string]string{},
+			grpc: func(ctx context.Context, c authpb.AuthServiceClient, _ *conformanceEnv) error {
+				_, err := c.RenewAccessToken(ctx, &authpb.RenewAccessTokenRequest{})
+				return err
+			},
+			want: invalid("vrto (refresh token) is required"),
+		},
+		{
+			name: "verify mail with a wrong OTP",
+			path: verifyPath,
+			body: map[string]string{"verificationOTPCode": "valid", "otp": "000000"},
+			grpc: verifyMail("valid", "000000"),
+			want: service.ErrInvalidOTP,
+		},
+		{
+			name: "verify mail with an expired token",
+			path: verifyPath,
+			body: map[string]string{"verificationOTPCode": "expired", "otp": "123456"},
+			grpc: verifyMail("expired", "123456"),
+			want: service.ErrVerificationTokenExpired,
+		},
+		{
+			name: "verify mail with a used token",
+			path: verifyPath,
+			body: map[string]string{"verificationOTPCode": "used", "otp": "123456"},
+			grpc: verifyMail("used", "123456"),
+			want: service.ErrVerificationTokenUsed,
+		},
+		{
+			name: "verify mail with an unknown token",
+			path: verifyPath,
+			body: map[string]string{"verificationOTPCode": "unknown", "otp": "123456"},
+			grpc: verifyMail("unknown", "123456"),
+			want: service.ErrInvalidVerificationToken,
+		},
+		{
+			name: "verify mail",
+			path: verifyPath,
+			body: map[string]string{"verificationOTPCode": "valid", "otp": "123456"},
+			grpc: verifyMail("valid", "123456"),
+		},
+		{
+			name: "resend OTP of an unknown token",
+			path: resendPath,
+			body: map[string]string{"verificationOTPCode": "unknown"},
+			grpc: resendOTP("unknown"),
+			want: service.ErrVerificationTokenNotFound,
+		},
+		{
+			name: "resend OTP of an expired token",
+			path: resendPath,
+			body: map[string]string{"verificationOTPCode": "expired"},
+			grpc: resendOTP("expired"),
+			want: service.ErrVerificationTokenExpired,
+		},
+		{
+			name: "resend OTP without a token",
+			path: resendPath,
+			body: map[string]string{},
+			grpc: resendOTP(""),
+			want: invalid("verificationOTPCode is required"),
+		},
+		{
+			name: "list provinces",
+			path: "/api/v1/cities/provinces",
+			grpc: func(ctx context.Context, c authpb.AuthServiceClient, _ *conformanceEnv) error {
+				_, err := c.GetProvinces(ctx, &authpb.GetProvincesRequest{})
+				return err
+			},
+		},
+		{
+			name: "list wards",
+			path: "/api/v1/cities/provinces/01/wards",
+			grpc: func(ctx context.Context, c authpb.AuthServiceClient, _ *conformanceEnv) error {
+				_, err := c.GetWards(ctx, &authpb.GetWardsRequest{ProvinceCode: "01"})
+				return err
+			},
+		},
+	}
+}
+
+// TestTransportConformance runs every use case through the HTTP and the gRPC
+// API, each on its own copy of the data, and checks that both end the same
+// way: with the status and the code the Kind of the error maps to, and its
+// message.
+func TestTransportConformance(t *testing.T) {
+	httpEnv, grpcEnv := newConformanceEnv(t), newConformanceEnv(t)
+
+	for _, tc := range conformanceCases() {
+		t.Run(tc.name, func(t *testing.T) {
+			wantStatus, wantCode, wantMessage := http.StatusOK, codes.OK, ""
+			if tc.want != nil {
+				wantStatus, wantCode, wantMessage = service.HTTPStatus(tc.want), service.GRPCCode(tc.want), tc.want.Message
+			}
+
+			status, message := httpEnv.callHTTP(t, tc.path, tc.body)
+			if tc.want == nil && (status < 200 || status > 299) || tc.want != nil && status != wantStatus {
+				t.Errorf("HTTP: status %d (%q), want %d", status, message, wantStatus)
+			}
+			if message != wantMessage {
+				t.Errorf("HTTP: message %q, want %q", message, wantMessage)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			code, message := grpcEnv.callGRPC(ctx, tc.grpc)
+			if code != wantCode {
+				t.Errorf("gRPC: code %s (%q), want %s", code, message, wantCode)
+			}
+			if message != wantMessage {
+				t.Errorf("gRPC: message %q, want %q", message, wantMessage)
+			}
+		})
+	}
+}
+
+// TestVerifyAccessTokenConformance checks both transports tell valid tokens
+// apart the same way; an invalid token is an answer of gRPC, not an error.
+func TestVerifyAccessTokenConformance(t *testing.T) {
+	env := newConformanceEnv(t)
+	ctx := context.Background()
+
+	for _, tt := range []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"access token", env.accessToken, true},
+		{"refresh token", env.refreshToken, false},
+		{"garbage", "not-a-token", false},
+	} {
+		resp, err := http.Get(env.http.URL + "/api/v1/XFWToken/verify-access-token?token=" + tt.token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var viaHTTP struct{ Valid bool }
+		json.NewDecoder(resp.Body).Decode(&viaHTTP)
+		resp.Body.Close()
+
+		viaGRPC, err := env.grpc.VerifyAccessToken(ctx, &authpb.VerifyAccessTokenRequest{Token: tt.token})
+		if err != nil {
+			t.Fatalf("%s: gRPC: %v", tt.name, err)
+		}
+		if viaHTTP.Valid != tt.valid || viaGRPC.GetValid() != tt.valid {
+			t.Errorf("%s: valid over HTTP %v, over gRPC %v, want %v", tt.name, viaHTTP.Valid, viaGRPC.GetValid(), tt.valid)
+		}
+	}
+}
+
+// callHTTP sends body as JSON, or a GET without it, and returns the status
+// and the error message of the answer
+func (env *conformanceEnv) callHTTP(t *testing.T, path string, body any) (int, string) {
+	t.Helper()
+
+	if fn, ok := body.(func(*conformanceEnv) any); ok {
+		body = fn(env)
+	}
+	var resp *http.Response
+	var err error
+	if body == nil {
+		resp, err = http.Get(env.http.URL + path)
+	} else {
+		payload, _ := json.Marshal(body)
+		resp, err = http.Post(env.http.URL+path, "application/json", bytes.NewReader(payload))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var answer struct {
+		Error string `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&answer)
+	return resp.StatusCode, answer.Error
+}
+
+func (env *conformanceEnv) callGRPC(ctx context.Context, call func(context.Context, authpb.AuthServiceClient, *conformanceEnv) error) (codes.Code, string) {
+	err := call(ctx, env.grpc, env)
+	if err == nil {
+		return codes.OK, ""
+	}
+	s := status.Convert(err)
+	return s.Code(), s.Message()
+}
@@ -128,6 +128,15 @@ const docTemplate = `{
                                 "type": "string"
                             }
                         }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
                     }
                 }
             }
@@ -500,7 +509,12 @@ const docTemplate = `{
         },
         "/api/v1/email/send": {
             "post": {
-                "description": "Sends an email using the mail service via RPC",
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Sends an email using the mail service via gRPC",
                 "consumes": [
                     "application/json"
                 ],
@@ -538,6 +552,15 @@ const docTemplate = `{
                             }
                         }
                     },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -582,12 +605,37 @@ const docTemplate = `{
                 }
             }
         },
+        "handlers.EmailAttachment": {
+            "type": "object",
+            "properties": {
+                "content": {
+                    "type": "string",
+                    "format": "base64"
+                },
+                "contentType": {
+                    "type": "string",
+                    "example": "application/pdf"
+                },
+                "imageRef": {
+                    "type": "string",
+                    "example": "receipts/3fa85f64.png"
+                },
+                "name": {
+                    "type": "string",
+                    "example": "receipt.pdf"
+                }
+            }
+        },
         "handlers.ForgetPasswordSendEmailRequest": {
             "type": "object",
             "properties": {
                 "email": {
                     "type": "string",
                     "example": "user@example.com"
+                },
+                "locale": {
+                    "type": "string",
+                    "example": "vi"
                 }
             }
         },
@@ -633,6 +681,10 @@ const docTemplate = `{
         "handlers.ResendOTPRequest": {
             "type": "object",
             "properties": {
+                "locale": {
+                    "type": "string",
+                    "example": "vi"
+                },
                 "verificationOTPCode": {
                     "type": "string",
                     "example": "3fa85f64-5717-4562-b3fc-2c963f66afa6"
@@ -658,7 +710,7 @@ const docTemplate = `{
                 "attachments": {
                     "type": "array",
                     "items": {
-                        "type": "string"
+                        "$ref": "#/definitions/handlers.EmailAttachment"
                     }
                 },
                 "from": {
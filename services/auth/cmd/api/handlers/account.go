@@ -0,0 +1,406 @@
+package handlers
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"ride-sharing/services/auth/data"
+	"ride-sharing/shared/servicetoken"
+	"strconv"
+	"time"
+)
+
+// defaultDeletionGraceDays is how long a user can cancel a deletion request
+const defaultDeletionGraceDays = 30
+
+// ExportAccountData exports every piece of personal data stored for the user
+// @Summary Export account data
+// @Description Builds an archive with the profile, sessions, trips and log entries of the authenticated user. Returns a ZIP archive by default or a single JSON document with format=json.
+// @Tags Account
+// @Produce application/zip
+// @Produce json
+// @Security BearerAuth
+// @Param format query string false "Export format: zip (default) or json"
+// @Success 200 {object} AccountExport
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/User/account/export [get]
+func (h *Handler) ExportAccountData(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.userIDFromRequest(r)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, "Invalid or missing access token")
+		return
+	}
+
+	export, err := h.buildAccountExport(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error building account export for %s: %v", userID, err)
+		writeError(w, http.StatusInternalServerError, "Failed to export account data")
+		return
+	}
+
+	if r.URL.Query().Get("format") == "json" {
+		writeJSON(w, http.StatusOK, export)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"account-%s.zip\"", userID))
+	w.WriteHeader(http.StatusOK)
+	if err := writeExportArchive(w, export); err != nil {
+		log.Printf("Error writing account export archive for %s: %v", userID, err)
+	}
+}
+
+// RequestAccountDeletion schedules the deletion of the authenticated user's account
+// @Summary Request account deletion
+// @Description Schedules the account for deletion after a grace period. Once the period ends, personal data is anonymised or removed in every service.
+// @Tags Account
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body AccountDeletionRequest false "Deletion request"
+// @Success 202 {object} AccountDeletionResponse
+// @Failure 401 {object} map[string]string
+// @Failure 409 {object} AccountDeletionResponse
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/User/account/delete [post]
+func (h *Handler) RequestAccountDeletion(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.userIDFromRequest(r)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, "Invalid or missing access token")
+		return
+	}
+
+	// The body is optional
+	var req AccountDeletionRequest
+	if r.ContentLength > 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	existing, err := h.Models.AccountDeletion.GetLatestByUserID(r.Context(), userID)
+	if err != nil && !errors.Is(err, data.ErrNotFound) {
+		log.Printf("Error finding deletion request: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to schedule account deletion")
+		return
+	}
+	if existing != nil && (existing.Status == data.DeletionStatusPending || existing.Status == data.DeletionStatusProcessing) {
+		response := newAccountDeletionResponse(existing)
+		response.Message = "Account deletion already requested"
+		writeJSON(w, http.StatusConflict, response)
+		return
+	}
+
+	scheduledFor := time.Now().Add(deletionGracePeriod())
+	id, err := h.Models.AccountDeletion.Insert(r.Context(), data.AccountDeletion{
+		UserID:       userID,
+		Reason:       req.Reason,
+		ScheduledFor: scheduledFor,
+	})
+	if err != nil {
+		log.Printf("Error creating deletion request: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to schedule account deletion")
+		return
+	}
+
+	audit := data.AccountDeletionAudit{
+		DeletionID: id,
+		Service:    "auth",
+		Action:     "requested",
+		Details:    fmt.Sprintf("scheduled for %s", scheduledFor.Format(time.RFC3339)),
+	}
+	if err := h.Models.AccountDeletion.AddAudit(r.Context(), audit); err != nil {
+		log.Printf("Error writing deletion audit: %v", err)
+	}
+
+	deletion, err := h.Models.AccountDeletion.GetOne(r.Context(), id)
+	if err != nil {
+		log.Printf("Error reading deletion request: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to schedule account deletion")
+		return
+	}
+
+	response := newAccountDeletionResponse(deletion)
+	response.Message = "Account scheduled for deletion"
+	writeJSON(w, http.StatusAccepted, response)
+}
+
+// CancelAccountDeletion cancels a deletion request during its grace period
+// @Summary Cancel account deletion
+// @Description Cancels the pending account deletion request of the authenticated user
+// @Tags Account
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} AccountDeletionResponse
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /api/v1/User/account/delete/cancel [post]
+func (h *Handler) CancelAccountDeletion(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.userIDFromRequest(r)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, "Invalid or missing access token")
+		return
+	}
+
+	deletion, err := h.Models.AccountDeletion.GetLatestByUserID(r.Context(), userID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "No account deletion request found")
+		return
+	}
+
+	if deletion.Status != data.DeletionStatusPending {
+		writeError(w, http.StatusConflict, fmt.Sprintf("Account deletion is %s and can no longer be cancelled", deletion.Status))
+		return
+	}
+
+	if err := h.Models.AccountDeletion.Cancel(r.Context(), deletion.ID); err != nil {
+		log.Printf("Error cancelling deletion request: %v", err)
+		writeError(w, http.StatusConflict, "Account deletion can no longer be cancelled")
+		return
+	}
+
+	audit := data.AccountDeletionAudit{DeletionID: deletion.ID, Service: "auth", Action: "cancelled"}
+	if err := h.Models.AccountDeletion.AddAudit(r.Context(), audit); err != nil {
+		log.Printf("Error writing deletion audit: %v", err)
+	}
+
+	deletion, err = h.Models.AccountDeletion.GetOne(r.Context(), deletion.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to read account deletion request")
+		return
+	}
+
+	response := newAccountDeletionResponse(deletion)
+	response.Message = "Account deletion cancelled"
+	writeJSON(w, http.StatusOK, response)
+}
+
+// GetAccountDeletionStatus returns the latest deletion request and its audit trail
+// @Summary Get account deletion status
+// @Description Returns the latest account deletion request of the authenticated user with its audit trail
+// @Tags Account
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} AccountDeletionResponse
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/v1/User/account/delete [get]
+func (h *Handler) GetAccountDeletionStatus(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.userIDFromRequest(r)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, "Invalid or missing access token")
+		return
+	}
+
+	deletion, err := h.Models.AccountDeletion.GetLatestByUserID(r.Context(), userID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "No account deletion request found")
+		return
+	}
+
+	response := newAccountDeletionResponse(deletion)
+	response.Audit, err = h.Models.AccountDeletion.GetAudit(r.Context(), deletion.ID)
+	if err != nil {
+		log.Printf("Error reading deletion audit: %v", err)
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// buildAccountExport collects the user's data from auth, trip-service and logger-service
+func (h *Handler) buildAccountExport(ctx context.Context, userID string) (*AccountExport, error) {
+	user, err := h.Models.User.GetOne(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("read profile: %w", err)
+	}
+
+	tokens, err := h.Models.VerifyToken.GetAllByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("read sessions: %w", err)
+	}
+
+	export := &AccountExport{
+		GeneratedAt: time.Now().UTC(),
+		UserID:      userID,
+		Profile: AccountProfile{
+			ID:                user.ID,
+			Email:             user.Email,
+			FirstName:         user.FirstName,
+			MiddleName:        user.MiddleName,
+			LastName:          user.LastName,
+			Active:            user.Active,
+			CityID:            user.CityID,
+			GenderID:          user.GenderID,
+			DOB:               user.DOB,
+			InvitationPending: user.InvitationToken != nil && *user.InvitationToken != "",
+			CreatedAt:         user.CreatedAt,
+			UpdatedAt:         user.UpdatedAt,
+		},
+		Sessions: []AccountSession{},
+		Trips:    json.RawMessage("[]"),
+		Logs:     json.RawMessage("[]"),
+		Sources:  map[string]string{"auth": "ok"},
+	}
+
+	for _, t := range tokens {
+		export.Sessions = append(export.Sessions, AccountSession{
+			Type:      t.VerificationType,
+			Used:      t.IsUsed,
+			CreatedAt: t.CreatedAt,
+			ExpiresAt: t.ExpiresAt,
+		})
+	}
+
+	// Remote sources are best effort: a missing source is reported in the
+	// export instead of failing the whole request.
+	tripServiceURL := os.Getenv("TRIP_SERVICE_URL")
+	if tripServiceURL == "" {
+		tripServiceURL = "http://trip-service:8083"
+	}
+	if trips, err := fetchExportJSON(fmt.Sprintf("%s/users/%s/trips", tripServiceURL, userID), false); err != nil {
+		log.Printf("Error fetching trips for export: %v", err)
+		export.Sources["trip-service"] = "unavailable: " + err.Error()
+	} else {
+		export.Trips = trips
+		export.Sources["trip-service"] = "ok"
+	}
+
+	loggerServiceURL := os.Getenv("LOGGER_SERVICE_URL")
+	if loggerServiceURL == "" {
+		loggerServiceURL = "http://logger-service"
+	}
+	if logs, err := fetchExportJSON(fmt.Sprintf("%s/users/%s/logs", loggerServiceURL, userID), true); err != nil {
+		log.Printf("Error fetching logs for export: %v", err)
+		export.Sources["logger-service"] = "unavailable: " + err.Error()
+	} else {
+		export.Logs = logs
+		export.Sources["logger-service"] = "ok"
+	}
+
+	return export, nil
+}
+
+// fetchExportJSON fetches a JSON document from another service. When wrapped is
+// true the payload is read from the "data" field of the service's envelope.
+// The services only serve the data of a user with INTERNAL_SERVICE_TOKEN.
+func fetchExportJSON(url string, wrapped bool) (json.RawMessage, error) {
+	token, err := servicetoken.FromEnv("INTERNAL_SERVICE_TOKEN")
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	servicetoken.Set(req, token)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("service returned status code: %d", resp.StatusCode)
+	}
+
+	if !wrapped {
+		var payload json.RawMessage
+		if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+			return nil, err
+		}
+		return payload, nil
+	}
+
+	var envelope struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return nil, err
+	}
+	if len(envelope.Data) == 0 {
+		return json.RawMessage("[]"), nil
+	}
+	return envelope.Data, nil
+}
+
+// writeExportArchive writes the export as a ZIP archive with one JSON file per source
+func writeExportArchive(w http.ResponseWriter, export *AccountExport) error {
+	zw := zip.NewWriter(w)
+
+	files := []struct {
+		name    string
+		content any
+	}{
+		{"profile.json", export.Profile},
+		{"sessions.json", export.Sessions},
+		{"trips.json", export.Trips},
+		{"logs.json", export.Logs},
+		{"manifest.json", map[string]any{
+			"generatedAt": export.GeneratedAt,
+			"userId":      export.UserID,
+			"sources":     export.Sources,
+		}},
+	}
+
+	for _, f := range files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     f.name,
+			Method:   zip.Deflate,
+			Modified: export.GeneratedAt,
+		})
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(f.content); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func newAccountDeletionResponse(d *data.AccountDeletion) AccountDeletionResponse {
+	return AccountDeletionResponse{
+		ID:           d.ID,
+		Status:       d.Status,
+		RequestedAt:  d.RequestedAt,
+		ScheduledFor: d.ScheduledFor,
+		CompletedAt:  d.CompletedAt,
+		CancelledAt:  d.CancelledAt,
+	}
+}
+
+// deletionGracePeriod reads ACCOUNT_DELETION_GRACE_DAYS, defaulting to 30 days
+func deletionGracePeriod() time.Duration {
+	days := defaultDeletionGraceDays
+	if v := os.Getenv("ACCOUNT_DELETION_GRACE_DAYS"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed >= 0 {
+			days = parsed
+		}
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+func writeJSON(w http.ResponseWriter, status int, payload any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(payload)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{
+		"error": message,
+	})
+}
@@ -8,6 +8,8 @@ import (
 	"net/http"
 	"ride-sharing/services/auth/data"
 	"time"
+
+	"github.com/go-chi/chi/v5"
 )
 
 // ProvinceResponse represents the response from 34tinhthanh.com API for provinces
@@ -32,7 +34,7 @@ type WardResponse struct {
 // @Success 200 {object} map[string]interface{}
 // @Failure 500 {object} map[string]string
 // @Router /api/v1/cities/sync [post]
-func SyncCities(w http.ResponseWriter, r *http.Request, models *data.Models) {
+func (h *Handler) SyncCities(w http.ResponseWriter, r *http.Request) {
 	apiBaseURL := "https://34tinhthanh.com/api"
 	
 	log.Println("Starting cities sync from 34tinhthanh.com...")
@@ -59,7 +61,7 @@ func SyncCities(w http.ResponseWriter, r *http.Request, models *data.Models) {
 			ProvinceCode: "",
 			ParentCode:  "",
 		}
-		_, err := models.City.Insert(city)
+		_, err := h.Models.City.Insert(r.Context(), city)
 		if err != nil {
 			log.Printf("Error inserting province %s: %v", province.ProvinceCode, err)
 			continue
@@ -86,7 +88,7 @@ func SyncCities(w http.ResponseWriter, r *http.Request, models *data.Models) {
 				ProvinceCode: ward.ProvinceCode,
 				ParentCode:  ward.ProvinceCode,
 			}
-			_, err := models.City.Insert(city)
+			_, err := h.Models.City.Insert(r.Context(), city)
 			if err != nil {
 				log.Printf("Error inserting ward %s: %v", ward.WardCode, err)
 				continue
@@ -120,8 +122,8 @@ func SyncCities(w http.ResponseWriter, r *http.Request, models *data.Models) {
 // @Produce json
 // @Success 200 {array} data.City
 // @Router /api/v1/cities/provinces [get]
-func GetProvinces(w http.ResponseWriter, r *http.Request, models *data.Models) {
-	provinces, err := models.City.GetAllProvinces()
+func (h *Handler) GetProvinces(w http.ResponseWriter, r *http.Request) {
+	provinces, err := h.Models.City.GetAllProvinces(r.Context())
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
@@ -145,8 +147,9 @@ func GetProvinces(w http.ResponseWriter, r *http.Request, models *data.Models) {
 // @Param province_code path string true "Province Code"
 // @Success 200 {array} data.City
 // @Router /api/v1/cities/provinces/{province_code}/wards [get]
-func GetWards(w http.ResponseWriter, r *http.Request, models *data.Models, provinceCode string) {
-	wards, err := models.City.GetWardsByProvinceCode(provinceCode)
+func (h *Handler) GetWards(w http.ResponseWriter, r *http.Request) {
+	provinceCode := chi.URLParam(r, "province_code")
+	wards, err := h.Models.City.GetWardsByProvinceCode(r.Context(), provinceCode)
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
@@ -165,7 +168,7 @@ func GetWards(w http.ResponseWriter, r *http.Request, models *data.Models, provi
 func fetchProvinces(apiBaseURL string) ([]ProvinceResponse, error) {
 	url := fmt.Sprintf("%s/provinces", apiBaseURL)
 	
-	resp, err := http.Get(url)
+	resp, err := httpClient.Get(url)
 	if err != nil {
 		return nil, err
 	}
@@ -192,7 +195,7 @@ func fetchProvinces(apiBaseURL string) ([]ProvinceResponse, error) {
 func fetchWards(apiBaseURL string, provinceCode string) ([]WardResponse, error) {
 	url := fmt.Sprintf("%s/wards?province_code=%s", apiBaseURL, provinceCode)
 	
-	resp, err := http.Get(url)
+	resp, err := httpClient.Get(url)
 	if err != nil {
 		return nil, err
 	}
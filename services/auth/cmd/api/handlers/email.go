@@ -4,18 +4,28 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
-	"os"
-	"ride-sharing/services/auth/utils"
+
+	"ride-sharing/shared/clients"
+	mailpb "ride-sharing/shared/generated/mail"
 )
 
 // SendEmailRequest represents the request body for sending email
 type SendEmailRequest struct {
-	To          string   `json:"to" example:"user@example.com"`
-	Subject     string   `json:"subject" example:"Welcome to RideShare"`
-	Message     string   `json:"message" example:"Welcome to our platform!"`
-	From        string   `json:"from,omitempty" example:"noreply@rideshare.com"`
-	FromName    string   `json:"fromName,omitempty" example:"RideShare"`
-	Attachments []string `json:"attachments,omitempty"`
+	To          string            `json:"to" example:"user@example.com"`
+	Subject     string            `json:"subject" example:"Welcome to RideShare"`
+	Message     string            `json:"message" example:"Welcome to our platform!"`
+	From        string            `json:"from,omitempty" example:"noreply@rideshare.com"`
+	FromName    string            `json:"fromName,omitempty" example:"RideShare"`
+	Attachments []EmailAttachment `json:"attachments,omitempty"`
+}
+
+// EmailAttachment is a file attached to an email. Set either content (base64)
+// or imageRef, the "folder/file" key of an object stored through image-service.
+type EmailAttachment struct {
+	Name        string `json:"name" example:"receipt.pdf"`
+	ContentType string `json:"contentType,omitempty" example:"application/pdf"`
+	Content     []byte `json:"content,omitempty" swaggertype:"string" format:"base64"`
+	ImageRef    string `json:"imageRef,omitempty" example:"receipts/3fa85f64.png"`
 }
 
 // SendEmailResponse represents the response for sending email
@@ -24,18 +34,27 @@ type SendEmailResponse struct {
 	To      string `json:"to" example:"user@example.com"`
 }
 
-// SendEmail handles sending email via RPC to mail service
+// SendEmail handles sending email via gRPC to mail service. The sender must be
+// signed in: mail-service reads the imageRefs of the attachments with their
+// access token, so they can only attach the images they may read.
 // @Summary Send email
-// @Description Sends an email using the mail service via RPC
+// @Description Sends an email using the mail service via gRPC
 // @Tags Email
 // @Accept json
 // @Produce json
+// @Security BearerAuth
 // @Param request body SendEmailRequest true "Email request"
 // @Success 200 {object} SendEmailResponse
 // @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /api/v1/email/send [post]
-func SendEmail(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) SendEmail(w http.ResponseWriter, r *http.Request) {
+	if _, err := h.userIDFromRequest(r); err != nil {
+		http.Error(w, "Invalid or missing access token", http.StatusUnauthorized)
+		return
+	}
+
 	var req SendEmailRequest
 
 	// Decode request body
@@ -60,42 +79,31 @@ func SendEmail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get mail service URL from environment
-	mailServiceURL := os.Getenv("MAIL_SERVICE_URL")
-	if mailServiceURL == "" {
-		mailServiceURL = "mail-service:5002" // Default to service name in Kubernetes
-	}
-
-	// Create RPC client
-	mailClient, err := utils.NewMailClient(mailServiceURL)
-	if err != nil {
-		log.Printf("Failed to connect to mail service: %v", err)
-		http.Error(w, "Failed to connect to mail service", http.StatusInternalServerError)
-		return
-	}
-	defer mailClient.Close()
-
 	// Set defaults
-	from := req.From
-	if from == "" {
-		from = os.Getenv("FROM_ADDRESS")
-		if from == "" {
-			from = "baobao22.work@gmail.com" // Default from address
-		}
-	}
+	from, fromName := mailSender(req.From, req.FromName)
 
-	fromName := req.FromName
-	if fromName == "" {
-		fromName = os.Getenv("FROM_NAME")
-		if fromName == "" {
-			fromName = "RideShare" // Default from name
-		}
+	// Send email via gRPC
+	attachments := make([]*mailpb.Attachment, 0, len(req.Attachments))
+	for _, a := range req.Attachments {
+		attachments = append(attachments, &mailpb.Attachment{
+			Name:        a.Name,
+			ContentType: a.ContentType,
+			Content:     a.Content,
+			ImageRef:    a.ImageRef,
+		})
 	}
 
-	// Send email via RPC
-	err = mailClient.SendMail(from, fromName, req.To, req.Subject, req.Message, req.Attachments)
+	ctx := clients.WithUserToken(r.Context(), bearerToken(r))
+	_, err := h.Mail.SendMail(ctx, &mailpb.MailRequest{
+		From:     from,
+		FromName: fromName,
+		To:       req.To,
+		Subject:  req.Subject,
+		Message:  req.Message,
+		Files:    attachments,
+	})
 	if err != nil {
-		log.Printf("Failed to send email via RPC: %v", err)
+		log.Printf("Failed to send email via gRPC: %v", err)
 		http.Error(w, "Failed to send email", http.StatusInternalServerError)
 		return
 	}
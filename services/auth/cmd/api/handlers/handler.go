@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"ride-sharing/services/auth/data"
+	"ride-sharing/services/auth/service"
+	"ride-sharing/shared/clients"
+	"ride-sharing/shared/resilience"
+	"ride-sharing/shared/retry"
+)
+
+// httpClient calls the other services and the external APIs over HTTP, with
+// a breaker and a bulkhead per host so a failing one is given up on quickly
+var httpClient = &http.Client{
+	Timeout: time.Minute,
+	Transport: resilience.NewTransport("auth http", resilience.Config{
+		Timeout: 10 * time.Second,
+		Retry: retry.Config{
+			MaxRetries:  2,
+			InitialWait: 200 * time.Millisecond,
+			MaxWait:     2 * time.Second,
+		},
+		Bulkhead: resilience.BulkheadConfig{MaxConcurrent: 20, MaxWait: 5 * time.Second},
+		Breaker:  resilience.BreakerConfig{Failures: 5, OpenFor: 30 * time.Second},
+	}),
+}
+
+// Handler serves the auth HTTP API. Dependencies are injected through New so
+// handlers can run against the Postgres repositories or the in-memory ones.
+type Handler struct {
+	Models data.Models
+	Auth   *service.Service
+	Mail   *clients.MailClient
+}
+
+// New creates a Handler backed by models, the auth use cases and mail-service
+func New(models data.Models, auth *service.Service, mail *clients.MailClient) *Handler {
+	return &Handler{Models: models, Auth: auth, Mail: mail}
+}
+
+// writeServiceError writes an error returned by the auth service with the
+// matching status code. Internal causes are logged, never returned.
+func writeServiceError(w http.ResponseWriter, err error) {
+	status := service.HTTPStatus(err)
+	if status >= http.StatusInternalServerError {
+		log.Printf("Auth service error: %v", err)
+	}
+	writeError(w, status, service.Message(err))
+}
@@ -0,0 +1,356 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"ride-sharing/services/auth/data"
+	"ride-sharing/services/auth/service"
+)
+
+// newTestHandler returns a Handler on the in-memory repositories, without
+// mail-service
+func newTestHandler(t *testing.T) *Handler {
+	t.Helper()
+	models := data.NewInMemory()
+	tokens := service.NewTokens("test-secret", time.Hour, 24*time.Hour)
+	return New(models, service.New(models, tokens), nil)
+}
+
+// serve calls handler with body encoded as JSON and an access token when
+// token isn't empty, and decodes the answer into out when it isn't nil
+func serve(t *testing.T, handler http.HandlerFunc, method, target, token string, body, out any) int {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, target, &buf)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+
+	if out != nil {
+		if err := json.NewDecoder(rec.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: decode answer %q: %v", method, target, rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+// signUp registers a user and signs them in
+func signUp(t *testing.T, h *Handler, email, password string) SignInResponse {
+	t.Helper()
+
+	status := serve(t, h.SignUp, "POST", "/api/v1/User/sign-up", "", SignUpRequest{
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Email:     email,
+		Password:  password,
+	}, nil)
+	if status != http.StatusCreated {
+		t.Fatalf("sign-up: status %d, want %d", status, http.StatusCreated)
+	}
+
+	var signedIn SignInResponse
+	status = serve(t, h.SignIn, "POST", "/api/v1/User/sign-in", "", SignInRequest{UserName: email, Password: password}, &signedIn)
+	if status != http.StatusOK {
+		t.Fatalf("sign-in: status %d, want %d", status, http.StatusOK)
+	}
+	return signedIn
+}
+
+func TestSignUp(t *testing.T) {
+	h := newTestHandler(t)
+	valid := SignUpRequest{FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", Password: "secret1"}
+	city := "1"
+	dob := "15/01/1990"
+
+	tests := []struct {
+		name   string
+		body   any
+		status int
+	}{
+		{"valid", valid, http.StatusCreated},
+		{"same email", valid, http.StatusConflict},
+		{"missing last name", SignUpRequest{FirstName: "Jane", Email: "a@example.com", Password: "secret1"}, http.StatusBadRequest},
+		{"short password", SignUpRequest{FirstName: "Jane", LastName: "Doe", Email: "b@example.com", Password: "123"}, http.StatusBadRequest},
+		{"unknown city", SignUpRequest{FirstName: "Jane", LastName: "Doe", Email: "c@example.com", Password: "secret1", CityID: &city}, http.StatusBadRequest},
+		{"invalid date", SignUpRequest{FirstName: "Jane", LastName: "Doe", Email: "d@example.com", Password: "secret1", DOB: &dob}, http.StatusBadRequest},
+		{"not JSON", "{", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var answer map[string]any
+			if status := serve(t, h.SignUp, "POST", "/api/v1/User/sign-up", "", tt.body, &answer); status != tt.status {
+				t.Fatalf("status %d, want %d: %v", status, tt.status, answer)
+			}
+		})
+	}
+
+	user, err := h.Models.User.GetByEmail(context.Background(), valid.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Password == valid.Password {
+		t.Error("the password is stored in clear")
+	}
+}
+
+func TestSignIn(t *testing.T) {
+	h := newTestHandler(t)
+	signedIn := signUp(t, h, "jane@example.com", "secret1")
+	if signedIn.AccessToken == "" || signedIn.RefreshToken == "" || signedIn.UserName != "jane@example.com" {
+		t.Fatalf("sign-in answer %+v", signedIn)
+	}
+
+	tests := []struct {
+		name   string
+		body   SignInRequest
+		status int
+	}{
+		{"wrong password", SignInRequest{UserName: "jane@example.com", Password: "wrong"}, http.StatusUnauthorized},
+		{"unknown user", SignInRequest{UserName: "john@example.com", Password: "secret1"}, http.StatusUnauthorized},
+		{"missing password", SignInRequest{UserName: "jane@example.com"}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := serve(t, h.SignIn, "POST", "/api/v1/User/sign-in", "", tt.body, nil); status != tt.status {
+				t.Fatalf("status %d, want %d", status, tt.status)
+			}
+		})
+	}
+
+	t.Run("inactive user", func(t *testing.T) {
+		ctx := context.Background()
+		user, _ := h.Models.User.GetByEmail(ctx, "jane@example.com")
+		user.Active = false
+		if err := h.Models.User.Update(ctx, *user); err != nil {
+			t.Fatal(err)
+		}
+		body := SignInRequest{UserName: "jane@example.com", Password: "secret1"}
+		if status := serve(t, h.SignIn, "POST", "/api/v1/User/sign-in", "", body, nil); status != http.StatusForbidden {
+			t.Fatalf("status %d, want %d", status, http.StatusForbidden)
+		}
+	})
+}
+
+func TestTokens(t *testing.T) {
+	h := newTestHandler(t)
+	signedIn := signUp(t, h, "jane@example.com", "secret1")
+
+	var verified VerifyAccessTokenResponse
+	status := serve(t, h.VerifyAccessToken, "GET", "/api/v1/XFWToken/verify-access-token", signedIn.AccessToken, nil, &verified)
+	if status != http.StatusOK || !verified.Valid {
+		t.Fatalf("verify access token: status %d, %+v", status, verified)
+	}
+
+	// a refresh token is no access token
+	status = serve(t, h.VerifyAccessToken, "GET", "/api/v1/XFWToken/verify-access-token?token="+signedIn.RefreshToken, "", nil, &verified)
+	if status != http.StatusUnauthorized || verified.Valid {
+		t.Fatalf("verify refresh token: status %d, %+v", status, verified)
+	}
+	if status := serve(t, h.VerifyAccessToken, "GET", "/api/v1/XFWToken/verify-access-token", "", nil, nil); status != http.StatusBadRequest {
+		t.Fatalf("verify without token: status %d, want %d", status, http.StatusBadRequest)
+	}
+
+	var renewed RenewAccessTokenResponse
+	status = serve(t, h.RenewAccessToken, "POST", "/api/v1/XFWToken/renew-access-token", "", RenewAccessTokenRequest{Vrto: signedIn.RefreshToken}, &renewed)
+	if status != http.StatusOK || renewed.AccessToken == "" {
+		t.Fatalf("renew: status %d, %+v", status, renewed)
+	}
+	status = serve(t, h.RenewAccessToken, "POST", "/api/v1/XFWToken/renew-access-token", "", RenewAccessTokenRequest{Vrto: signedIn.AccessToken}, nil)
+	if status != http.StatusUnauthorized {
+		t.Fatalf("renew with an access token: status %d, want %d", status, http.StatusUnauthorized)
+	}
+}
+
+func TestVerifyMail(t *testing.T) {
+	h := newTestHandler(t)
+	signedIn := signUp(t, h, "jane@example.com", "secret1")
+	ctx := context.Background()
+
+	insert := func(token, otp string, expiresAt time.Time) {
+		_, err := h.Models.VerifyToken.Insert(ctx, data.VerifyToken{
+			UserID:           signedIn.UserID,
+			Token:            token,
+			OTPCode:          otp,
+			VerificationType: service.VerificationTypeEmail,
+			ExpiresAt:        expiresAt,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	insert("valid", "123456", time.Now().Add(time.Hour))
+	insert("expired", "123456", time.Now().Add(-time.Minute))
+
+	tests := []struct {
+		name   string
+		body   VerifyMailRequest
+		status int
+	}{
+		{"wrong OTP", VerifyMailRequest{VerificationOTPCode: "valid", OTP: "000000"}, http.StatusUnauthorized},
+		{"unknown token", VerifyMailRequest{VerificationOTPCode: "unknown", OTP: "123456"}, http.StatusBadRequest},
+		{"expired", VerifyMailRequest{VerificationOTPCode: "expired", OTP: "123456"}, http.StatusBadRequest},
+		{"missing OTP", VerifyMailRequest{VerificationOTPCode: "valid"}, http.StatusBadRequest},
+		{"valid", VerifyMailRequest{VerificationOTPCode: "valid", OTP: "123456"}, http.StatusOK},
+		{"used", VerifyMailRequest{VerificationOTPCode: "valid", OTP: "123456"}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var answer VerifyMailResponse
+			if status := serve(t, h.VerifyMail, "POST", "/api/v1/User/verify-mail", "", tt.body, &answer); status != tt.status {
+				t.Fatalf("status %d, want %d", status, tt.status)
+			}
+			if tt.status == http.StatusOK && answer.UserID != signedIn.UserID {
+				t.Fatalf("verified user %q, want %q", answer.UserID, signedIn.UserID)
+			}
+		})
+	}
+}
+
+func TestAccountDeletion(t *testing.T) {
+	h := newTestHandler(t)
+	signedIn := signUp(t, h, "jane@example.com", "secret1")
+	token := signedIn.AccessToken
+	const path = "/api/v1/User/account/delete"
+
+	if status := serve(t, h.RequestAccountDeletion, "POST", path, "", nil, nil); status != http.StatusUnauthorized {
+		t.Fatalf("request without token: status %d, want %d", status, http.StatusUnauthorized)
+	}
+	if status := serve(t, h.GetAccountDeletionStatus, "GET", path, token, nil, nil); status != http.StatusNotFound {
+		t.Fatalf("status before any request: %d, want %d", status, http.StatusNotFound)
+	}
+
+	var requested AccountDeletionResponse
+	status := serve(t, h.RequestAccountDeletion, "POST", path, token, nil, &requested)
+	if status != http.StatusAccepted || requested.Status != data.DeletionStatusPending {
+		t.Fatalf("request: status %d, %+v", status, requested)
+	}
+	if grace := time.Until(requested.ScheduledFor); grace < 29*24*time.Hour {
+		t.Errorf("scheduled in %s, want the 30 days grace period", grace)
+	}
+
+	var again AccountDeletionResponse
+	status = serve(t, h.RequestAccountDeletion, "POST", path, token, nil, &again)
+	if status != http.StatusConflict || again.ID != requested.ID {
+		t.Fatalf("second request: status %d, %+v", status, again)
+	}
+
+	var cancelled AccountDeletionResponse
+	status = serve(t, h.CancelAccountDeletion, "POST", path+"/cancel", token, nil, &cancelled)
+	if status != http.StatusOK || cancelled.Status != data.DeletionStatusCancelled {
+		t.Fatalf("cancel: status %d, %+v", status, cancelled)
+	}
+	if status := serve(t, h.CancelAccountDeletion, "POST", path+"/cancel", token, nil, nil); status != http.StatusConflict {
+		t.Fatalf("cancel again: status %d, want %d", status, http.StatusConflict)
+	}
+
+	var current AccountDeletionResponse
+	status = serve(t, h.GetAccountDeletionStatus, "GET", path, token, nil, &current)
+	if status != http.StatusOK || current.Status != data.DeletionStatusCancelled {
+		t.Fatalf("status: %d, %+v", status, current)
+	}
+	var actions []string
+	for _, e := range current.Audit {
+		actions = append(actions, e.Action)
+	}
+	if len(actions) != 2 || actions[0] != "requested" || actions[1] != "cancelled" {
+		t.Errorf("audit %v, want [requested cancelled]", actions)
+	}
+
+	// a new request can follow a cancelled one
+	if status := serve(t, h.RequestAccountDeletion, "POST", path, token, nil, nil); status != http.StatusAccepted {
+		t.Fatalf("request after cancelling: status %d, want %d", status, http.StatusAccepted)
+	}
+}
+
+func TestExportAccountData(t *testing.T) {
+	h := newTestHandler(t)
+	signedIn := signUp(t, h, "jane@example.com", "secret1")
+
+	const serviceToken = "test-service-token"
+	t.Setenv("INTERNAL_SERVICE_TOKEN", serviceToken)
+
+	trips := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+serviceToken || r.URL.Path != "/users/"+signedIn.UserID+"/trips" {
+			http.Error(w, "unexpected request", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`[{"id":"trip-1"}]`))
+	}))
+	defer trips.Close()
+	t.Setenv("TRIP_SERVICE_URL", trips.URL)
+	// logger-service is down
+	t.Setenv("LOGGER_SERVICE_URL", "http://127.0.0.1:1")
+
+	var export AccountExport
+	status := serve(t, h.ExportAccountData, "GET", "/api/v1/User/account/export?format=json", signedIn.AccessToken, nil, &export)
+	if status != http.StatusOK {
+		t.Fatalf("export: status %d", status)
+	}
+	if export.UserID != signedIn.UserID || export.Profile.Email != "jane@example.com" {
+		t.Errorf("export of %q, profile %+v", export.UserID, export.Profile)
+	}
+	if string(export.Trips) != `[{"id":"trip-1"}]` || export.Sources["trip-service"] != "ok" {
+		t.Errorf("trips %s from %q", export.Trips, export.Sources["trip-service"])
+	}
+	if export.Sources["logger-service"] == "ok" {
+		t.Error("logger-service is reported ok while down")
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/api/v1/User/account/export", nil)
+	req.Header.Set("Authorization", "Bearer "+signedIn.AccessToken)
+	h.ExportAccountData(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("zip export: status %d, %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
+
+func TestExportLeavesOutInvitationToken(t *testing.T) {
+	h := newTestHandler(t)
+	t.Setenv("INTERNAL_SERVICE_TOKEN", "test-service-token")
+	t.Setenv("TRIP_SERVICE_URL", "http://127.0.0.1:1")
+	t.Setenv("LOGGER_SERVICE_URL", "http://127.0.0.1:1")
+
+	invitation := "invitation-secret"
+	status := serve(t, h.SignUp, "POST", "/api/v1/User/sign-up", "", SignUpRequest{
+		FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", Password: "secret1", InvitationToken: &invitation,
+	}, nil)
+	if status != http.StatusCreated {
+		t.Fatalf("sign-up: status %d", status)
+	}
+	var signedIn SignInResponse
+	serve(t, h.SignIn, "POST", "/api/v1/User/sign-in", "", SignInRequest{UserName: "jane@example.com", Password: "secret1"}, &signedIn)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/api/v1/User/account/export?format=json", nil)
+	req.Header.Set("Authorization", "Bearer "+signedIn.AccessToken)
+	h.ExportAccountData(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("export: status %d", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), invitation) {
+		t.Fatalf("the export holds the invitation token: %s", rec.Body)
+	}
+	var export AccountExport
+	if err := json.NewDecoder(rec.Body).Decode(&export); err != nil {
+		t.Fatal(err)
+	}
+	if !export.Profile.InvitationPending {
+		t.Error("invitation not reported pending")
+	}
+}
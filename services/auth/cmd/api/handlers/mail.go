@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	mailpb "ride-sharing/shared/generated/mail"
+)
+
+// sendTemplateMail asks mail-service to send one of its named templates to.
+// mail-service queues the mail, so this returns before it is delivered.
+func (h *Handler) sendTemplateMail(ctx context.Context, to, template, locale string, variables map[string]string) error {
+	from, fromName := mailSender("", "")
+
+	_, err := h.Mail.SendMail(ctx, &mailpb.MailRequest{
+		From:      from,
+		FromName:  fromName,
+		To:        to,
+		Template:  template,
+		Locale:    locale,
+		Variables: variables,
+	})
+	return err
+}
+
+// mailSender fills in the sender of a mail from FROM_ADDRESS and FROM_NAME
+func mailSender(from, fromName string) (string, string) {
+	if from == "" {
+		from = os.Getenv("FROM_ADDRESS")
+		if from == "" {
+			from = "baobao22.work@gmail.com"
+		}
+	}
+	if fromName == "" {
+		fromName = os.Getenv("FROM_NAME")
+		if fromName == "" {
+			fromName = "RideShare"
+		}
+	}
+	return from, fromName
+}
+
+// requestLocale returns the locale for emails sent on behalf of r: the one in
+// the request body, else the first language of Accept-Language
+func requestLocale(r *http.Request, locale string) string {
+	if locale != "" {
+		return locale
+	}
+	lang, _, _ := strings.Cut(r.Header.Get("Accept-Language"), ",")
+	lang, _, _ = strings.Cut(lang, ";")
+	return strings.TrimSpace(lang)
+}
+
+// expiresInMinutes formats the time left until expiresAt for mail templates
+func expiresInMinutes(expiresAt time.Time) string {
+	return strconv.Itoa(int(time.Until(expiresAt).Round(time.Minute).Minutes()))
+}
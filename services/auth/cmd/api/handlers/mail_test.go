@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"ride-sharing/services/auth/data"
+	"ride-sharing/services/auth/service"
+	"ride-sharing/shared/clients"
+	mailpb "ride-sharing/shared/generated/mail"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// mailbox stands in for mail-service and keeps the requests it is sent
+type mailbox struct {
+	mailpb.MailServiceClient
+
+	mu   sync.Mutex
+	sent []*mailpb.MailRequest
+	// users are the access tokens the mails were sent for, by WithUserToken
+	users []string
+}
+
+func (m *mailbox) SendMail(ctx context.Context, req *mailpb.MailRequest, opts ...grpc.CallOption) (*mailpb.MailResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, req)
+	md, _ := metadata.FromOutgoingContext(ctx)
+	m.users = append(m.users, clients.UserToken(metadata.NewIncomingContext(ctx, md)))
+	return &mailpb.MailResponse{Message: "Email queued for " + req.GetTo(), Id: "1", Status: "queued"}, nil
+}
+
+// last returns the last mail sent to to
+func (m *mailbox) last(t *testing.T, to string) *mailpb.MailRequest {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.sent) - 1; i >= 0; i-- {
+		if m.sent[i].GetTo() == to {
+			return m.sent[i]
+		}
+	}
+	t.Fatalf("no mail sent to %s", to)
+	return nil
+}
+
+func newMailTestHandler(t *testing.T) (*Handler, *mailbox) {
+	t.Helper()
+	h := newTestHandler(t)
+	box := &mailbox{}
+	h.Mail = &clients.MailClient{MailServiceClient: box}
+	return h, box
+}
+
+func TestOTPVerificationFlow(t *testing.T) {
+	h, box := newMailTestHandler(t)
+	signedIn := signUp(t, h, "jane@example.com", "secret1")
+
+	// the token issued at sign-up, whose OTP went to the user's mailbox
+	_, err := h.Models.VerifyToken.Insert(context.Background(), data.VerifyToken{
+		UserID:           signedIn.UserID,
+		Token:            "sign-up",
+		OTPCode:          "111111",
+		VerificationType: service.VerificationTypeEmail,
+		ExpiresAt:        time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resent ResendOTPResponse
+	status := serve(t, h.ResendOTP, "POST", "/api/v1/User/resend-otp", "", ResendOTPRequest{VerificationOTPCode: "sign-up", Locale: "vi"}, &resent)
+	if status != http.StatusOK {
+		t.Fatalf("resend OTP: status %d, want %d", status, http.StatusOK)
+	}
+
+	mail := box.last(t, "jane@example.com")
+	if mail.GetTemplate() != "otp_verification" || mail.GetLocale() != "vi" {
+		t.Fatalf("sent template %q in %q, want otp_verification in vi", mail.GetTemplate(), mail.GetLocale())
+	}
+	vars := mail.GetVariables()
+	if vars["name"] != "Jane" || vars["otp"] == "" || vars["expires_minutes"] == "" {
+		t.Fatalf("OTP mail variables %v", vars)
+	}
+
+	// the OTP of the mail verifies the address with the token of the answer
+	var verified VerifyMailResponse
+	status = serve(t, h.VerifyMail, "POST", "/api/v1/User/verify-mail", "", VerifyMailRequest{
+		VerificationOTPCode: resent.VerificationToken,
+		OTP:                 vars["otp"],
+	}, &verified)
+	if status != http.StatusOK || verified.UserID != signedIn.UserID {
+		t.Fatalf("verify with the mailed OTP: status %d, user %q", status, verified.UserID)
+	}
+}
+
+func TestPasswordResetFlow(t *testing.T) {
+	t.Setenv("RESET_PASSWORD_URL", "https://app.example.com/reset-password")
+	h, box := newMailTestHandler(t)
+	signedIn := signUp(t, h, "jane@example.com", "secret1")
+
+	status := serve(t, h.ForgetPasswordSendEmail, "POST", "/api/v1/User/forget-password-send-email", "",
+		ForgetPasswordSendEmailRequest{Email: "jane@example.com"}, nil)
+	if status != http.StatusOK {
+		t.Fatalf("forget password: status %d, want %d", status, http.StatusOK)
+	}
+
+	mail := box.last(t, "jane@example.com")
+	if mail.GetTemplate() != "password_reset" {
+		t.Fatalf("sent template %q, want password_reset", mail.GetTemplate())
+	}
+	vars := mail.GetVariables()
+	link, err := url.Parse(vars["reset_url"])
+	if err != nil || link.Host != "app.example.com" || link.Path != "/reset-password" {
+		t.Fatalf("reset_url %q", vars["reset_url"])
+	}
+
+	// the link carries a live reset token of the user with the mailed OTP
+	token, err := h.Models.VerifyToken.GetByToken(context.Background(), link.Query().Get("token"))
+	if err != nil {
+		t.Fatalf("reset token of the link: %v", err)
+	}
+	if token.UserID != signedIn.UserID || token.VerificationType != service.VerificationTypePasswordReset || token.IsUsed {
+		t.Fatalf("reset token %+v", token)
+	}
+	if otp := link.Query().Get("otp"); otp != vars["otp"] || otp != token.OTPCode {
+		t.Fatalf("link OTP %q, mailed OTP %q, stored OTP %q", otp, vars["otp"], token.OTPCode)
+	}
+
+	// unknown addresses get the same answer and no mail
+	sent := len(box.sent)
+	status = serve(t, h.ForgetPasswordSendEmail, "POST", "/api/v1/User/forget-password-send-email", "",
+		ForgetPasswordSendEmailRequest{Email: "john@example.com"}, nil)
+	if status != http.StatusOK || len(box.sent) != sent {
+		t.Fatalf("unknown address: status %d, %d mails sent", status, len(box.sent)-sent)
+	}
+}
+
+func TestSendEmail(t *testing.T) {
+	h, box := newMailTestHandler(t)
+	signedIn := signUp(t, h, "jane@example.com", "secret1")
+	req := SendEmailRequest{
+		To:          "john@example.com",
+		Subject:     "Receipt",
+		Message:     "Your receipt",
+		Attachments: []EmailAttachment{{Name: "receipt.png", ImageRef: "receipts/1.png"}},
+	}
+
+	// anybody could attach the images mail-service can read otherwise
+	for _, token := range []string{"", "not-a-token", signedIn.RefreshToken} {
+		if status := serve(t, h.SendEmail, "POST", "/api/v1/email/send", token, req, nil); status != http.StatusUnauthorized {
+			t.Fatalf("send with token %q: status %d, want %d", token, status, http.StatusUnauthorized)
+		}
+	}
+	if len(box.sent) != 0 {
+		t.Fatalf("%d mail(s) sent without a user", len(box.sent))
+	}
+
+	if status := serve(t, h.SendEmail, "POST", "/api/v1/email/send", signedIn.AccessToken, req, nil); status != http.StatusOK {
+		t.Fatalf("send: status %d, want %d", status, http.StatusOK)
+	}
+	mail := box.last(t, "john@example.com")
+	if files := mail.GetFiles(); len(files) != 1 || files[0].GetImageRef() != "receipts/1.png" {
+		t.Fatalf("attachments %v", files)
+	}
+	// mail-service reads the imageRef as the sender
+	if box.users[len(box.users)-1] != signedIn.AccessToken {
+		t.Fatalf("mail sent for token %q, want the sender's", box.users[len(box.users)-1])
+	}
+}
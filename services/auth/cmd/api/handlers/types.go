@@ -1,5 +1,11 @@
 package handlers
 
+import (
+	"encoding/json"
+	"ride-sharing/services/auth/data"
+	"time"
+)
+
 // Token-related types
 
 // VerifyAccessTokenRequest represents the request body for verify access token
@@ -80,6 +86,7 @@ type VerifyMailResponse struct {
 // ResendOTPRequest represents the request body for resending OTP
 type ResendOTPRequest struct {
 	VerificationOTPCode string `json:"verificationOTPCode" example:"3fa85f64-5717-4562-b3fc-2c963f66afa6"`
+	Locale              string `json:"locale,omitempty" example:"vi"`
 }
 
 // ResendOTPResponse represents the response for resending OTP
@@ -90,7 +97,8 @@ type ResendOTPResponse struct {
 
 // ForgetPasswordSendEmailRequest represents the request body for forget password send email
 type ForgetPasswordSendEmailRequest struct {
-	Email string `json:"email" example:"user@example.com"`
+	Email  string `json:"email" example:"user@example.com"`
+	Locale string `json:"locale,omitempty" example:"vi"`
 }
 
 // ForgetPasswordSendEmailResponse represents the response for forget password send email
@@ -99,3 +107,59 @@ type ForgetPasswordSendEmailResponse struct {
 	Email   string `json:"email" example:"user@example.com"`
 }
 
+// Account-related types
+
+// AccountProfile is the user profile included in an account data export
+type AccountProfile struct {
+	ID         string     `json:"id" example:"3fa85f64-5717-4562-b3fc-2c963f66afa6"`
+	Email      string     `json:"email" example:"john.doe@example.com"`
+	FirstName  string     `json:"firstName" example:"John"`
+	MiddleName *string    `json:"middleName,omitempty" example:"Michael"`
+	LastName   string     `json:"lastName" example:"Doe"`
+	Active     bool       `json:"active" example:"true"`
+	CityID     *int       `json:"cityId,omitempty" example:"1"`
+	GenderID   *int       `json:"genderId,omitempty" example:"1"`
+	DOB        *time.Time `json:"dob,omitempty"`
+	// InvitationPending tells whether the user was invited; the invitation
+	// token itself is a credential and is never exported
+	InvitationPending bool      `json:"invitationPending" example:"false"`
+	CreatedAt         time.Time `json:"createdAt"`
+	UpdatedAt         time.Time `json:"updatedAt"`
+}
+
+// AccountSession describes a verification or reset token issued to the user.
+// Secrets (token and OTP) are never exported.
+type AccountSession struct {
+	Type      string    `json:"type" example:"password_reset"`
+	Used      bool      `json:"used" example:"false"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// AccountExport is the full personal data export of a user
+type AccountExport struct {
+	GeneratedAt time.Time         `json:"generatedAt"`
+	UserID      string            `json:"userId" example:"3fa85f64-5717-4562-b3fc-2c963f66afa6"`
+	Profile     AccountProfile    `json:"profile"`
+	Sessions    []AccountSession  `json:"sessions"`
+	Trips       json.RawMessage   `json:"trips" swaggertype:"array,object"`
+	Logs        json.RawMessage   `json:"logs" swaggertype:"array,object"`
+	Sources     map[string]string `json:"sources"` // "ok" or the reason a source is missing
+}
+
+// AccountDeletionRequest represents the request body for requesting account deletion
+type AccountDeletionRequest struct {
+	Reason *string `json:"reason,omitempty" example:"I no longer use the service"`
+}
+
+// AccountDeletionResponse represents the state of an account deletion request
+type AccountDeletionResponse struct {
+	ID           string                       `json:"id" example:"3fa85f64-5717-4562-b3fc-2c963f66afa6"`
+	Status       string                       `json:"status" example:"pending"`
+	RequestedAt  time.Time                    `json:"requestedAt"`
+	ScheduledFor time.Time                    `json:"scheduledFor"`
+	CompletedAt  *time.Time                   `json:"completedAt,omitempty"`
+	CancelledAt  *time.Time                   `json:"cancelledAt,omitempty"`
+	Audit        []*data.AccountDeletionAudit `json:"audit,omitempty"`
+	Message      string                       `json:"message,omitempty" example:"Account scheduled for deletion"`
+}
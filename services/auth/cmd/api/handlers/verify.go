@@ -2,13 +2,8 @@ package handlers
 
 import (
 	"encoding/json"
-	"fmt"
 	"log"
-	"math/rand"
 	"net/http"
-	"ride-sharing/services/auth/data"
-	"ride-sharing/services/auth/utils"
-	"time"
 )
 
 // VerifyMail handles email verification with OTP
@@ -22,103 +17,25 @@ import (
 // @Failure 400 {object} map[string]string
 // @Failure 401 {object} map[string]string
 // @Router /api/v1/User/verify-mail [post]
-func VerifyMail(w http.ResponseWriter, r *http.Request) {
-	if ModelsInstance == nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Models not initialized",
-		})
-		return
-	}
-
+func (h *Handler) VerifyMail(w http.ResponseWriter, r *http.Request) {
 	var req VerifyMailRequest
 
 	// Decode request body
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Invalid request body",
-		})
-		return
-	}
-
-	// Validate required fields
-	if req.VerificationOTPCode == "" || req.OTP == "" {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "verificationOTPCode and otp are required",
-		})
+		writeError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
-	// Get verify token from database
-	verifyToken, err := ModelsInstance.VerifyToken.GetByToken(req.VerificationOTPCode)
+	userID, err := h.Auth.VerifyMail(r.Context(), req.VerificationOTPCode, req.OTP)
 	if err != nil {
-		log.Printf("Error finding verify token: %v", err)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Invalid verification token",
-		})
-		return
-	}
-
-	// Check if token is already used
-	if verifyToken.IsUsed {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Verification token has already been used",
-		})
-		return
-	}
-
-	// Check if token is expired
-	if time.Now().After(verifyToken.ExpiresAt) {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Verification token has expired",
-		})
+		writeServiceError(w, err)
 		return
 	}
 
-	// Verify OTP code
-	if verifyToken.OTPCode != req.OTP {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Invalid OTP code",
-		})
-		return
-	}
-
-	// Mark token as used
-	err = ModelsInstance.VerifyToken.MarkAsUsed(verifyToken.ID)
-	if err != nil {
-		log.Printf("Error marking token as used: %v", err)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Error updating verification status",
-		})
-		return
-	}
-
-	// Update user to mark email as verified (if you have an email_verified field)
-	// For now, we'll just return success
-
-	response := VerifyMailResponse{
+	writeJSON(w, http.StatusOK, VerifyMailResponse{
 		Message: "Email verified successfully",
-		UserID:  verifyToken.UserID,
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
+		UserID:  userID,
+	})
 }
 
 // ResendOTP handles resending OTP for email verification
@@ -131,120 +48,43 @@ func VerifyMail(w http.ResponseWriter, r *http.Request) {
 // @Success 200 {object} ResendOTPResponse
 // @Failure 400 {object} map[string]string
 // @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
 // @Router /api/v1/User/resend-otp [post]
-func ResendOTP(w http.ResponseWriter, r *http.Request) {
-	if ModelsInstance == nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Models not initialized",
-		})
-		return
-	}
-
+func (h *Handler) ResendOTP(w http.ResponseWriter, r *http.Request) {
 	var req ResendOTPRequest
 
 	// Decode request body
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Invalid request body",
-		})
-		return
-	}
-
-	// Validate required fields
-	if req.VerificationOTPCode == "" {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "verificationOTPCode is required",
-		})
+		writeError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
-	// Get verify token from database
-	verifyToken, err := ModelsInstance.VerifyToken.GetByToken(req.VerificationOTPCode)
+	otp, err := h.Auth.ResendOTP(r.Context(), req.VerificationOTPCode)
 	if err != nil {
-		log.Printf("Error finding verify token: %v", err)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Verification token not found",
-		})
+		writeServiceError(w, err)
 		return
 	}
 
-	// Check if token is already used
-	if verifyToken.IsUsed {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Verification token has already been used",
-		})
-		return
-	}
-
-	// Check if token is expired
-	if time.Now().After(verifyToken.ExpiresAt) {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Verification token has expired",
-		})
+	user, err := h.Models.User.GetOne(r.Context(), otp.UserID)
+	if err != nil {
+		log.Printf("Error loading user %s for OTP email: %v", otp.UserID, err)
+		writeError(w, http.StatusInternalServerError, "Failed to send OTP email")
 		return
 	}
 
-	// Generate new OTP code (6 digits)
-	newOTP := generateOTP(6)
-
-	// Generate new token
-	newToken := utils.GenerateUUID()
-
-	// Create new verify token
-	newVerifyToken := data.VerifyToken{
-		UserID:          verifyToken.UserID,
-		Token:           newToken,
-		OTPCode:         newOTP,
-		VerificationType: verifyToken.VerificationType,
-		ExpiresAt:       time.Now().Add(time.Minute * 15), // 15 minutes expiration
-	}
-
-	_, err = ModelsInstance.VerifyToken.Insert(newVerifyToken)
+	err = h.sendTemplateMail(r.Context(), user.Email, "otp_verification", requestLocale(r, req.Locale), map[string]string{
+		"name":            user.FirstName,
+		"otp":             otp.Code,
+		"expires_minutes": expiresInMinutes(otp.ExpiresAt),
+	})
 	if err != nil {
-		log.Printf("Error creating new verify token: %v", err)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Error generating new verification token",
-		})
+		log.Printf("Failed to send OTP email to user %s: %v", otp.UserID, err)
+		writeError(w, http.StatusInternalServerError, "Failed to send OTP email")
 		return
 	}
 
-	// TODO: Send OTP via email/SMS
-	// For now, we'll just log it (in production, send via email service)
-	log.Printf("New OTP for user %s: %s (Token: %s)", verifyToken.UserID, newOTP, newToken)
-
-	response := ResendOTPResponse{
+	writeJSON(w, http.StatusOK, ResendOTPResponse{
 		Message:           "OTP sent successfully",
-		VerificationToken: newToken,
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
+		VerificationToken: otp.Token,
+	})
 }
-
-// generateOTP generates a random OTP code of specified length
-func generateOTP(length int) string {
-	rand.Seed(time.Now().UnixNano())
-	otp := ""
-	for i := 0; i < length; i++ {
-		otp += fmt.Sprintf("%d", rand.Intn(10))
-	}
-	return otp
-}
-
-
-
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"os"
+	"time"
+
+	"ride-sharing/services/auth/data"
+	"ride-sharing/shared/scheduler"
+)
+
+const defaultTokenCleanupInterval = time.Hour
+
+// newScheduler creates the scheduler for the background jobs of the auth
+// service. Singleton jobs are coordinated between replicas through Postgres
+// advisory locks, and job metrics are published on /debug/vars.
+func newScheduler(db *sql.DB, models *data.Models) (*scheduler.Scheduler, error) {
+	jobs := scheduler.New(scheduler.NewPostgresLocker(db))
+	jobs.Publish("scheduler")
+
+	err := jobs.Add(scheduler.Job{
+		Name:      "auth.cleanup-expired-tokens",
+		Schedule:  scheduler.Every(durationEnv("AUTH_TOKEN_CLEANUP_INTERVAL", defaultTokenCleanupInterval)),
+		Jitter:    time.Minute,
+		Timeout:   5 * time.Minute,
+		Singleton: true,
+		Run: func(ctx context.Context) error {
+			n, err := models.VerifyToken.DeleteExpiredTokens(ctx)
+			if err != nil {
+				return err
+			}
+			if n > 0 {
+				log.Printf("Deleted %d expired verification token(s)", n)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+// durationEnv reads a positive duration such as "30s" or "1h" from key
+func durationEnv(key string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("Invalid %s %q, using %s", key, v, fallback)
+	}
+	return fallback
+}
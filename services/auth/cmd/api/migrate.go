@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"ride-sharing/services/auth/migrations"
+)
+
+const migrateUsage = `usage: auth migrate <command>
+
+commands:
+  up          apply all pending migrations
+  down [n]    roll back the last n migrations (default 1)
+  status      list migrations and whether they are applied`
+
+// migrateTimeout bounds a whole migration run, including waiting for the lock
+const migrateTimeout = 5 * time.Minute
+
+// runMigrateCommand handles "auth migrate ..." and returns the exit code
+func runMigrateCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	steps := 1
+	switch args[0] {
+	case "up", "status":
+		if len(args) > 1 {
+			fmt.Fprintln(os.Stderr, migrateUsage)
+			return 2
+		}
+	case "down":
+		if len(args) > 2 {
+			fmt.Fprintln(os.Stderr, migrateUsage)
+			return 2
+		}
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				fmt.Fprintf(os.Stderr, "invalid number of steps %q\n", args[1])
+				return 2
+			}
+			steps = n
+		}
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	db, err := connectToDB()
+	if err != nil {
+		log.Printf("Error connecting to database: %v", err)
+		return 1
+	}
+	defer db.Close()
+
+	migrator, err := migrations.New(db)
+	if err != nil {
+		log.Printf("Error loading migrations: %v", err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), migrateTimeout)
+	defer cancel()
+
+	switch args[0] {
+	case "up":
+		n, err := migrator.Up(ctx)
+		if err != nil {
+			log.Printf("Error applying migrations: %v", err)
+			return 1
+		}
+		log.Printf("Applied %d migration(s)", n)
+	case "down":
+		n, err := migrator.Down(ctx, steps)
+		if err != nil {
+			log.Printf("Error rolling back migrations: %v", err)
+			return 1
+		}
+		log.Printf("Rolled back %d migration(s)", n)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			log.Printf("Error reading migration status: %v", err)
+			return 1
+		}
+		printMigrationStatus(statuses)
+	}
+
+	return 0
+}
+
+func printMigrationStatus(statuses []migrations.Status) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+	for _, s := range statuses {
+		state, appliedAt := "pending", "-"
+		if s.Applied {
+			state, appliedAt = "applied", s.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%03d\t%s\t%s\t%s\n", s.Version, s.Name, state, appliedAt)
+	}
+	w.Flush()
+}
+
+// runMigrations applies the pending embedded migrations on startup
+func runMigrations(db *sql.DB) error {
+	migrator, err := migrations.New(db)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), migrateTimeout)
+	defer cancel()
+
+	log.Println("📦 Running embedded migrations")
+	n, err := migrator.Up(ctx)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("✅ All migrations completed! (%d applied)\n", n)
+	return nil
+}
+
+// migrateOnStartup reports whether the service should migrate before serving.
+// Set MIGRATE_ON_STARTUP=false when migrations run as a separate deploy step.
+func migrateOnStartup() bool {
+	switch strings.ToLower(os.Getenv("MIGRATE_ON_STARTUP")) {
+	case "false", "0", "no":
+		return false
+	}
+	return true
+}
@@ -1,12 +1,14 @@
 package main
 
 import (
+	"expvar"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/cors"
 	httpSwagger "github.com/swaggo/http-swagger"
 	"ride-sharing/services/auth/cmd/api/handlers"
+	"ride-sharing/shared/logging"
 )
 
 // @title Auth Service API
@@ -21,6 +23,7 @@ import (
 // @BasePath /
 func (app *Config) routes() http.Handler {
 	mux := chi.NewRouter()
+	h := handlers.New(app.Models, app.Auth, app.Mail)
 	var allowedOrigins = []string{"https://*", "http://*"}
 
 	// specify who is allowed to connect
@@ -33,6 +36,9 @@ func (app *Config) routes() http.Handler {
 		MaxAge:           300,
 	}))
 
+	// tag the logs of every request with its trace ID
+	mux.Use(logging.Middleware)
+
 	// Health check endpoint
 	// @Summary Health check
 	// @Description Returns OK if service is running
@@ -46,37 +52,38 @@ func (app *Config) routes() http.Handler {
 		w.Write([]byte("OK"))
 	})
 
+	// Scheduler job metrics
+	mux.Handle("/debug/vars", expvar.Handler())
+
 	// API v1 routes
 	mux.Route("/api/v1", func(r chi.Router) {
 		// XFWToken endpoints
 		r.Route("/XFWToken", func(r chi.Router) {
-			r.Get("/verify-access-token", handlers.VerifyAccessToken)
-			r.Post("/renew-access-token", handlers.RenewAccessToken)
+			r.Get("/verify-access-token", h.VerifyAccessToken)
+			r.Post("/renew-access-token", h.RenewAccessToken)
 		})
 		// User endpoints
 		r.Route("/User", func(r chi.Router) {
-			r.Post("/sign-in", handlers.SignIn)
-			r.Post("/sign-up", handlers.SignUp)
-			r.Post("/verify-mail", handlers.VerifyMail)
-			r.Post("/resend-otp", handlers.ResendOTP)
-			r.Post("/forget-password-send-email", handlers.ForgetPasswordSendEmail)
+			r.Post("/sign-in", h.SignIn)
+			r.Post("/sign-up", h.SignUp)
+			r.Post("/verify-mail", h.VerifyMail)
+			r.Post("/resend-otp", h.ResendOTP)
+			r.Post("/forget-password-send-email", h.ForgetPasswordSendEmail)
+			// Account data export and deletion
+			r.Get("/account/export", h.ExportAccountData)
+			r.Get("/account/delete", h.GetAccountDeletionStatus)
+			r.Post("/account/delete", h.RequestAccountDeletion)
+			r.Post("/account/delete/cancel", h.CancelAccountDeletion)
 		})
 		// Email endpoints
 		r.Route("/email", func(r chi.Router) {
-			r.Post("/send", handlers.SendEmail)
+			r.Post("/send", h.SendEmail)
 		})
 		// City endpoints
 		r.Route("/cities", func(r chi.Router) {
-			r.Post("/sync", func(w http.ResponseWriter, r *http.Request) {
-				handlers.SyncCities(w, r, &app.Models)
-			})
-			r.Get("/provinces", func(w http.ResponseWriter, r *http.Request) {
-				handlers.GetProvinces(w, r, &app.Models)
-			})
-			r.Get("/provinces/{province_code}/wards", func(w http.ResponseWriter, r *http.Request) {
-				provinceCode := chi.URLParam(r, "province_code")
-				handlers.GetWards(w, r, &app.Models, provinceCode)
-			})
+			r.Post("/sync", h.SyncCities)
+			r.Get("/provinces", h.GetProvinces)
+			r.Get("/provinces/{province_code}/wards", h.GetWards)
 		})
 	})
 
@@ -0,0 +1,680 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrDuplicate is returned by the in-memory repositories where Postgres would
+// violate a unique constraint.
+var ErrDuplicate = errors.New("duplicate record")
+
+// NewInMemory returns repositories that keep everything in memory. They mirror
+// the behaviour of the Postgres implementation closely enough for handler tests
+// and for running the service without a database.
+func NewInMemory() Models {
+	return Models{
+		User:            NewInMemoryUserRepository(),
+		City:            NewInMemoryCityRepository(),
+		Gender:          NewInMemoryGenderRepository(),
+		VerifyToken:     NewInMemoryVerifyTokenRepository(),
+		AccountDeletion: NewInMemoryAccountDeletionRepository(),
+	}
+}
+
+// InMemoryUserRepository implements UserRepository in memory.
+type InMemoryUserRepository struct {
+	mu    sync.RWMutex
+	users map[string]*User
+}
+
+func NewInMemoryUserRepository() *InMemoryUserRepository {
+	return &InMemoryUserRepository{users: make(map[string]*User)}
+}
+
+func (r *InMemoryUserRepository) GetAll(ctx context.Context) ([]*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	users := make([]*User, 0, len(r.users))
+	for _, u := range r.users {
+		user := *u
+		user.Password = ""
+		users = append(users, &user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].LastName < users[j].LastName })
+	return users, nil
+}
+
+func (r *InMemoryUserRepository) GetOne(ctx context.Context, id string) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	u, ok := r.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	user := *u
+	user.Password = ""
+	return &user, nil
+}
+
+func (r *InMemoryUserRepository) GetByEmail(ctx context.Context, email string) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, u := range r.users {
+		if u.Email == email {
+			user := *u
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *InMemoryUserRepository) Insert(ctx context.Context, user User) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	hashedPassword, err := hashPassword(user.Password)
+	if err != nil {
+		return "", err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, u := range r.users {
+		if u.Email == user.Email {
+			return "", ErrDuplicate
+		}
+	}
+
+	user.ID = uuid.NewString()
+	user.Password = hashedPassword
+	user.CreatedAt = time.Now()
+	user.UpdatedAt = user.CreatedAt
+	r.users[user.ID] = &user
+	return user.ID, nil
+}
+
+func (r *InMemoryUserRepository) Update(ctx context.Context, user User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[user.ID]
+	if !ok {
+		return nil
+	}
+	u.Email = user.Email
+	u.FirstName = user.FirstName
+	u.LastName = user.LastName
+	u.Active = user.Active
+	u.UpdatedAt = time.Now()
+	return nil
+}
+
+func (r *InMemoryUserRepository) DeleteByID(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.users, id)
+	return nil
+}
+
+func (r *InMemoryUserRepository) ResetPassword(ctx context.Context, id, password string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	hashedPassword, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if u, ok := r.users[id]; ok {
+		u.Password = hashedPassword
+		u.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+func (r *InMemoryUserRepository) Anonymise(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	hashedPassword, err := hashPassword(uuid.New().String())
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[id]
+	if !ok {
+		return nil
+	}
+	*u = User{
+		ID:        u.ID,
+		Email:     "deleted-" + u.ID + "@deleted.invalid",
+		FirstName: "Deleted",
+		LastName:  "User",
+		Password:  hashedPassword,
+		CreatedAt: u.CreatedAt,
+		UpdatedAt: time.Now(),
+	}
+	return nil
+}
+
+// InMemoryCityRepository implements CityRepository in memory.
+type InMemoryCityRepository struct {
+	mu     sync.RWMutex
+	nextID int
+	cities map[int]*City
+}
+
+func NewInMemoryCityRepository() *InMemoryCityRepository {
+	return &InMemoryCityRepository{cities: make(map[int]*City)}
+}
+
+func (r *InMemoryCityRepository) Insert(ctx context.Context, city City) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for _, c := range r.cities {
+		if c.Code == city.Code {
+			c.Name = city.Name
+			c.Type = city.Type
+			c.ProvinceCode = city.ProvinceCode
+			c.ParentCode = city.ParentCode
+			c.UpdatedAt = now
+			return c.ID, nil
+		}
+	}
+
+	r.nextID++
+	city.ID = r.nextID
+	city.CreatedAt = now
+	city.UpdatedAt = now
+	r.cities[city.ID] = &city
+	return city.ID, nil
+}
+
+func (r *InMemoryCityRepository) GetAllProvinces(ctx context.Context) ([]*City, error) {
+	return r.filter(ctx, func(c *City) bool { return c.Type == "province" })
+}
+
+func (r *InMemoryCityRepository) GetWardsByProvinceCode(ctx context.Context, provinceCode string) ([]*City, error) {
+	return r.filter(ctx, func(c *City) bool { return c.Type == "ward" && c.ProvinceCode == provinceCode })
+}
+
+func (r *InMemoryCityRepository) filter(ctx context.Context, match func(c *City) bool) ([]*City, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var cities []*City
+	for _, c := range r.cities {
+		if match(c) {
+			city := *c
+			cities = append(cities, &city)
+		}
+	}
+	sort.Slice(cities, func(i, j int) bool { return cities[i].Name < cities[j].Name })
+	return cities, nil
+}
+
+func (r *InMemoryCityRepository) GetOne(ctx context.Context, id int) (*City, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	c, ok := r.cities[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	city := *c
+	return &city, nil
+}
+
+func (r *InMemoryCityRepository) DeleteAll(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cities = make(map[int]*City)
+	return nil
+}
+
+// InMemoryGenderRepository implements GenderRepository in memory. It is seeded
+// with the same values as the genders migration.
+type InMemoryGenderRepository struct {
+	genders []*Gender
+}
+
+func NewInMemoryGenderRepository() *InMemoryGenderRepository {
+	now := time.Now()
+	return &InMemoryGenderRepository{genders: []*Gender{
+		{ID: 1, Name: "Male", Code: "MALE", CreatedAt: now, UpdatedAt: now},
+		{ID: 2, Name: "Female", Code: "FEMALE", CreatedAt: now, UpdatedAt: now},
+		{ID: 3, Name: "Other", Code: "OTHER", CreatedAt: now, UpdatedAt: now},
+	}}
+}
+
+func (r *InMemoryGenderRepository) GetAll(ctx context.Context) ([]*Gender, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	genders := make([]*Gender, 0, len(r.genders))
+	for _, g := range r.genders {
+		gender := *g
+		genders = append(genders, &gender)
+	}
+	sort.Slice(genders, func(i, j int) bool { return genders[i].Name < genders[j].Name })
+	return genders, nil
+}
+
+func (r *InMemoryGenderRepository) GetByID(ctx context.Context, id int) (*Gender, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, g := range r.genders {
+		if g.ID == id {
+			gender := *g
+			return &gender, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// InMemoryVerifyTokenRepository implements VerifyTokenRepository in memory.
+type InMemoryVerifyTokenRepository struct {
+	mu     sync.RWMutex
+	nextID int
+	tokens map[int]*VerifyToken
+}
+
+func NewInMemoryVerifyTokenRepository() *InMemoryVerifyTokenRepository {
+	return &InMemoryVerifyTokenRepository{tokens: make(map[int]*VerifyToken)}
+}
+
+func (r *InMemoryVerifyTokenRepository) Insert(ctx context.Context, token VerifyToken) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, t := range r.tokens {
+		if t.Token == token.Token {
+			return 0, ErrDuplicate
+		}
+	}
+
+	r.nextID++
+	token.ID = r.nextID
+	token.IsUsed = false
+	token.CreatedAt = time.Now()
+	token.UpdatedAt = token.CreatedAt
+	r.tokens[token.ID] = &token
+	return token.ID, nil
+}
+
+func (r *InMemoryVerifyTokenRepository) GetByToken(ctx context.Context, token string) (*VerifyToken, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, t := range r.tokens {
+		if t.Token == token {
+			verifyToken := *t
+			return &verifyToken, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *InMemoryVerifyTokenRepository) GetByUserIDAndType(ctx context.Context, userID, verificationType string) (*VerifyToken, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var latest *VerifyToken
+	now := time.Now()
+	for _, t := range r.tokens {
+		if t.UserID != userID || t.VerificationType != verificationType || t.IsUsed || !t.ExpiresAt.After(now) {
+			continue
+		}
+		if latest == nil || t.CreatedAt.After(latest.CreatedAt) || (t.CreatedAt.Equal(latest.CreatedAt) && t.ID > latest.ID) {
+			latest = t
+		}
+	}
+	if latest == nil {
+		return nil, ErrNotFound
+	}
+	verifyToken := *latest
+	return &verifyToken, nil
+}
+
+func (r *InMemoryVerifyTokenRepository) MarkAsUsed(ctx context.Context, tokenID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if t, ok := r.tokens[tokenID]; ok {
+		t.IsUsed = true
+		t.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+func (r *InMemoryVerifyTokenRepository) GetAllByUserID(ctx context.Context, userID string) ([]*VerifyToken, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var tokens []*VerifyToken
+	for _, t := range r.tokens {
+		if t.UserID == userID {
+			verifyToken := *t
+			tokens = append(tokens, &verifyToken)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].ID > tokens[j].ID })
+	return tokens, nil
+}
+
+func (r *InMemoryVerifyTokenRepository) DeleteByUserID(ctx context.Context, userID string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var n int64
+	for id, t := range r.tokens {
+		if t.UserID == userID {
+			delete(r.tokens, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *InMemoryVerifyTokenRepository) DeleteExpiredTokens(ctx context.Context) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var n int64
+	now := time.Now()
+	for id, t := range r.tokens {
+		if t.ExpiresAt.Before(now) {
+			delete(r.tokens, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// InMemoryAccountDeletionRepository implements AccountDeletionRepository in memory.
+type InMemoryAccountDeletionRepository struct {
+	mu        sync.RWMutex
+	deletions map[string]*AccountDeletion
+	audit     []*AccountDeletionAudit
+}
+
+func NewInMemoryAccountDeletionRepository() *InMemoryAccountDeletionRepository {
+	return &InMemoryAccountDeletionRepository{deletions: make(map[string]*AccountDeletion)}
+}
+
+func (r *InMemoryAccountDeletionRepository) Insert(ctx context.Context, deletion AccountDeletion) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Same rule as the partial unique index: one open request per user
+	for _, d := range r.deletions {
+		if d.UserID == deletion.UserID && (d.Status == DeletionStatusPending || d.Status == DeletionStatusProcessing) {
+			return "", ErrDuplicate
+		}
+	}
+
+	deletion.ID = uuid.NewString()
+	deletion.Status = DeletionStatusPending
+	deletion.RequestedAt = time.Now()
+	r.deletions[deletion.ID] = &deletion
+	return deletion.ID, nil
+}
+
+func (r *InMemoryAccountDeletionRepository) GetOne(ctx context.Context, id string) (*AccountDeletion, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	d, ok := r.deletions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	deletion := *d
+	return &deletion, nil
+}
+
+func (r *InMemoryAccountDeletionRepository) GetLatestByUserID(ctx context.Context, userID string) (*AccountDeletion, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var latest *AccountDeletion
+	for _, d := range r.deletions {
+		if d.UserID == userID && (latest == nil || d.RequestedAt.After(latest.RequestedAt)) {
+			latest = d
+		}
+	}
+	if latest == nil {
+		return nil, ErrNotFound
+	}
+	deletion := *latest
+	return &deletion, nil
+}
+
+func (r *InMemoryAccountDeletionRepository) GetDue(ctx context.Context, now time.Time, limit int) ([]*AccountDeletion, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var due []*AccountDeletion
+	for _, d := range r.deletions {
+		if d.Status == DeletionStatusPending && !d.ScheduledFor.After(now) {
+			deletion := *d
+			due = append(due, &deletion)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].ScheduledFor.Before(due[j].ScheduledFor) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	return due, nil
+}
+
+func (r *InMemoryAccountDeletionRepository) GetStale(ctx context.Context, processedBefore time.Time, limit int) ([]*AccountDeletion, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var stale []*AccountDeletion
+	for _, d := range r.deletions {
+		if d.Status == DeletionStatusProcessing && d.ProcessedAt != nil && d.ProcessedAt.Before(processedBefore) {
+			deletion := *d
+			stale = append(stale, &deletion)
+		}
+	}
+	sort.Slice(stale, func(i, j int) bool { return stale[i].ProcessedAt.Before(*stale[j].ProcessedAt) })
+	if len(stale) > limit {
+		stale = stale[:limit]
+	}
+	return stale, nil
+}
+
+func (r *InMemoryAccountDeletionRepository) Cancel(ctx context.Context, id string) error {
+	return r.transition(ctx, id, DeletionStatusPending, func(d *AccountDeletion, now time.Time) {
+		d.Status = DeletionStatusCancelled
+		d.CancelledAt = &now
+	})
+}
+
+func (r *InMemoryAccountDeletionRepository) MarkProcessing(ctx context.Context, id string) error {
+	err := r.transition(ctx, id, "", func(d *AccountDeletion, now time.Time) {
+		d.Status = DeletionStatusProcessing
+		d.ProcessedAt = &now
+	})
+	if errors.Is(err, ErrNotFound) {
+		// Postgres updates zero rows without an error
+		return nil
+	}
+	return err
+}
+
+func (r *InMemoryAccountDeletionRepository) MarkCompleted(ctx context.Context, id string) error {
+	err := r.transition(ctx, id, DeletionStatusProcessing, func(d *AccountDeletion, now time.Time) {
+		d.Status = DeletionStatusCompleted
+		d.CompletedAt = &now
+	})
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// transition applies update to the request if it is in status from ("" matches any)
+func (r *InMemoryAccountDeletionRepository) transition(ctx context.Context, id, from string, update func(d *AccountDeletion, now time.Time)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	d, ok := r.deletions[id]
+	if !ok || (from != "" && d.Status != from) {
+		return ErrNotFound
+	}
+	update(d, time.Now())
+	return nil
+}
+
+func (r *InMemoryAccountDeletionRepository) AddAudit(ctx context.Context, entry AccountDeletionAudit) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.deletions[entry.DeletionID]; !ok {
+		// Mirrors the foreign key on account_deletion_audit.deletion_id
+		return ErrNotFound
+	}
+
+	entry.ID = len(r.audit) + 1
+	entry.CreatedAt = time.Now()
+	r.audit = append(r.audit, &entry)
+	return nil
+}
+
+func (r *InMemoryAccountDeletionRepository) GetAudit(ctx context.Context, deletionID string) ([]*AccountDeletionAudit, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var entries []*AccountDeletionAudit
+	for _, e := range r.audit {
+		if e.DeletionID == deletionID {
+			entry := *e
+			entries = append(entries, &entry)
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,696 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// New returns the Postgres backed repositories. Every query is bounded by
+// dbTimeout on top of the caller's context.
+func New(db *sql.DB) Models {
+	return Models{
+		User:            &PostgresUserRepository{DB: db},
+		City:            &PostgresCityRepository{DB: db},
+		Gender:          &PostgresGenderRepository{DB: db},
+		VerifyToken:     &PostgresVerifyTokenRepository{DB: db},
+		AccountDeletion: &PostgresAccountDeletionRepository{DB: db},
+	}
+}
+
+// notFound translates sql.ErrNoRows into ErrNotFound
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// PostgresUserRepository implements UserRepository on the users table.
+type PostgresUserRepository struct {
+	DB *sql.DB
+}
+
+// GetAll returns all users from the database.
+func (r *PostgresUserRepository) GetAll(ctx context.Context) ([]*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select uuid_id, email, first_name, last_name, active, created_at, updated_at from users order by last_name`
+
+	rows, err := r.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*User
+
+	for rows.Next() {
+		var user User
+		err := rows.Scan(&user.ID, &user.Email, &user.FirstName, &user.LastName, &user.Active, &user.CreatedAt, &user.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, &user)
+	}
+	return users, rows.Err()
+}
+
+// GetOne returns one user from the database by id (UUID), including the full profile.
+func (r *PostgresUserRepository) GetOne(ctx context.Context, id string) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select uuid_id, email, first_name, middle_name, last_name, active, city_id, gender_id, dob, invitation_token, created_at, updated_at
+	          from users where uuid_id = $1`
+
+	var user User
+	err := r.DB.QueryRowContext(ctx, query, id).Scan(&user.ID, &user.Email, &user.FirstName, &user.MiddleName, &user.LastName, &user.Active,
+		&user.CityID, &user.GenderID, &user.DOB, &user.InvitationToken, &user.CreatedAt, &user.UpdatedAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &user, nil
+}
+
+// GetByEmail returns one user from the database by email.
+func (r *PostgresUserRepository) GetByEmail(ctx context.Context, email string) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select uuid_id, email, first_name, last_name, password, active, created_at, updated_at from users where email = $1`
+
+	var user User
+	err := r.DB.QueryRowContext(ctx, query, email).Scan(&user.ID, &user.Email, &user.FirstName, &user.LastName, &user.Password, &user.Active, &user.CreatedAt, &user.UpdatedAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &user, nil
+}
+
+// Insert inserts a new user into the database.
+func (r *PostgresUserRepository) Insert(ctx context.Context, user User) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	hashedPassword, err := hashPassword(user.Password)
+	if err != nil {
+		return "", err
+	}
+
+	query := `insert into users (uuid_id, email, first_name, middle_name, last_name, password, active, city_id, gender_id, dob, invitation_token, created_at, updated_at)
+	          values (uuid_generate_v4(), $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) returning uuid_id`
+
+	var id string
+	err = r.DB.QueryRowContext(ctx, query,
+		user.Email,
+		user.FirstName,
+		user.MiddleName,
+		user.LastName,
+		hashedPassword,
+		user.Active,
+		user.CityID,
+		user.GenderID,
+		user.DOB,
+		user.InvitationToken,
+		time.Now(),
+		time.Now()).Scan(&id)
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// Update updates the basic fields of a user.
+func (r *PostgresUserRepository) Update(ctx context.Context, user User) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `update users set email = $1, first_name = $2, last_name = $3, active = $4, updated_at = $5 where uuid_id = $6`
+
+	_, err := r.DB.ExecContext(ctx, query, user.Email, user.FirstName, user.LastName, user.Active, time.Now(), user.ID)
+	return err
+}
+
+// DeleteByID deletes one user from the database.
+func (r *PostgresUserRepository) DeleteByID(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `delete from users where uuid_id = $1`
+
+	_, err := r.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+// ResetPassword sets a new password for a user.
+func (r *PostgresUserRepository) ResetPassword(ctx context.Context, id, password string) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	hashedPassword, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	query := `update users set password = $1, updated_at = $2 where uuid_id = $3`
+	_, err = r.DB.ExecContext(ctx, query, hashedPassword, time.Now(), id)
+	return err
+}
+
+// Anonymise replaces the personal data of a user with placeholders.
+func (r *PostgresUserRepository) Anonymise(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	// random password hash nobody knows the plain text of
+	hashedPassword, err := hashPassword(uuid.New().String())
+	if err != nil {
+		return err
+	}
+
+	query := `update users set
+	          email = 'deleted-' || uuid_id || '@deleted.invalid',
+	          first_name = 'Deleted',
+	          middle_name = null,
+	          last_name = 'User',
+	          password = $1,
+	          active = false,
+	          city_id = null,
+	          gender_id = null,
+	          dob = null,
+	          invitation_token = null,
+	          updated_at = $2
+	          where uuid_id = $3`
+
+	_, err = r.DB.ExecContext(ctx, query, hashedPassword, time.Now(), id)
+	return err
+}
+
+// PostgresCityRepository implements CityRepository on the cities table.
+type PostgresCityRepository struct {
+	DB *sql.DB
+}
+
+const cityColumns = `id, code, name, type, province_code, parent_code, created_at, updated_at`
+
+func scanCity(row interface{ Scan(dest ...any) error }) (*City, error) {
+	var city City
+	err := row.Scan(&city.ID, &city.Code, &city.Name, &city.Type,
+		&city.ProvinceCode, &city.ParentCode, &city.CreatedAt, &city.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &city, nil
+}
+
+func (r *PostgresCityRepository) queryCities(ctx context.Context, query string, args ...any) ([]*City, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cities []*City
+	for rows.Next() {
+		city, err := scanCity(rows)
+		if err != nil {
+			return nil, err
+		}
+		cities = append(cities, city)
+	}
+	return cities, rows.Err()
+}
+
+// Insert inserts a new city into the database
+func (r *PostgresCityRepository) Insert(ctx context.Context, city City) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `INSERT INTO cities (code, name, type, province_code, parent_code, created_at, updated_at)
+	          VALUES ($1, $2, $3, $4, $5, $6, $7)
+	          ON CONFLICT (code) DO UPDATE SET
+	          name = EXCLUDED.name,
+	          type = EXCLUDED.type,
+	          province_code = EXCLUDED.province_code,
+	          parent_code = EXCLUDED.parent_code,
+	          updated_at = EXCLUDED.updated_at
+	          RETURNING id`
+
+	var id int
+	err := r.DB.QueryRowContext(ctx, query,
+		city.Code, city.Name, city.Type, city.ProvinceCode, city.ParentCode, time.Now(), time.Now()).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// GetAllProvinces returns all provinces
+func (r *PostgresCityRepository) GetAllProvinces(ctx context.Context) ([]*City, error) {
+	query := `SELECT ` + cityColumns + `
+	          FROM cities
+	          WHERE type = 'province'
+	          ORDER BY name`
+
+	return r.queryCities(ctx, query)
+}
+
+// GetWardsByProvinceCode returns all wards for a province
+func (r *PostgresCityRepository) GetWardsByProvinceCode(ctx context.Context, provinceCode string) ([]*City, error) {
+	query := `SELECT ` + cityColumns + `
+	          FROM cities
+	          WHERE type = 'ward' AND province_code = $1
+	          ORDER BY name`
+
+	return r.queryCities(ctx, query, provinceCode)
+}
+
+// GetOne returns a city by ID
+func (r *PostgresCityRepository) GetOne(ctx context.Context, id int) (*City, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `SELECT ` + cityColumns + ` FROM cities WHERE id = $1`
+
+	city, err := scanCity(r.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return city, nil
+}
+
+// DeleteAll deletes all cities (useful for re-sync)
+func (r *PostgresCityRepository) DeleteAll(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	_, err := r.DB.ExecContext(ctx, `DELETE FROM cities`)
+	return err
+}
+
+// PostgresGenderRepository implements GenderRepository on the genders table.
+type PostgresGenderRepository struct {
+	DB *sql.DB
+}
+
+// GetAll returns all genders
+func (r *PostgresGenderRepository) GetAll(ctx context.Context) ([]*Gender, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `SELECT id, name, code, created_at, updated_at FROM genders ORDER BY name`
+
+	rows, err := r.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var genders []*Gender
+	for rows.Next() {
+		var gender Gender
+		err := rows.Scan(&gender.ID, &gender.Name, &gender.Code, &gender.CreatedAt, &gender.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		genders = append(genders, &gender)
+	}
+	return genders, rows.Err()
+}
+
+// GetByID returns a gender by ID
+func (r *PostgresGenderRepository) GetByID(ctx context.Context, id int) (*Gender, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `SELECT id, name, code, created_at, updated_at FROM genders WHERE id = $1`
+
+	var gender Gender
+	err := r.DB.QueryRowContext(ctx, query, id).Scan(&gender.ID, &gender.Name, &gender.Code, &gender.CreatedAt, &gender.UpdatedAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &gender, nil
+}
+
+// PostgresVerifyTokenRepository implements VerifyTokenRepository on the verify_tokens table.
+type PostgresVerifyTokenRepository struct {
+	DB *sql.DB
+}
+
+const verifyTokenColumns = `id, user_id, token, otp_code, verification_type, expires_at, is_used, created_at, updated_at`
+
+func scanVerifyToken(row interface{ Scan(dest ...any) error }) (*VerifyToken, error) {
+	var verifyToken VerifyToken
+	err := row.Scan(
+		&verifyToken.ID,
+		&verifyToken.UserID,
+		&verifyToken.Token,
+		&verifyToken.OTPCode,
+		&verifyToken.VerificationType,
+		&verifyToken.ExpiresAt,
+		&verifyToken.IsUsed,
+		&verifyToken.CreatedAt,
+		&verifyToken.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &verifyToken, nil
+}
+
+// Insert creates a new verify token
+func (r *PostgresVerifyTokenRepository) Insert(ctx context.Context, token VerifyToken) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `INSERT INTO verify_tokens (user_id, token, otp_code, verification_type, expires_at, created_at, updated_at)
+	          VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`
+
+	var id int
+	err := r.DB.QueryRowContext(ctx, query,
+		token.UserID,
+		token.Token,
+		token.OTPCode,
+		token.VerificationType,
+		token.ExpiresAt,
+		time.Now(),
+		time.Now()).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// GetByToken returns a verify token by token string
+func (r *PostgresVerifyTokenRepository) GetByToken(ctx context.Context, token string) (*VerifyToken, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `SELECT ` + verifyTokenColumns + `
+	          FROM verify_tokens
+	          WHERE token = $1`
+
+	verifyToken, err := scanVerifyToken(r.DB.QueryRowContext(ctx, query, token))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return verifyToken, nil
+}
+
+// GetByUserIDAndType returns the latest verify token for a user and type
+func (r *PostgresVerifyTokenRepository) GetByUserIDAndType(ctx context.Context, userID, verificationType string) (*VerifyToken, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `SELECT ` + verifyTokenColumns + `
+	          FROM verify_tokens
+	          WHERE user_id = $1 AND verification_type = $2 AND is_used = false AND expires_at > NOW()
+	          ORDER BY created_at DESC
+	          LIMIT 1`
+
+	verifyToken, err := scanVerifyToken(r.DB.QueryRowContext(ctx, query, userID, verificationType))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return verifyToken, nil
+}
+
+// MarkAsUsed marks a verify token as used
+func (r *PostgresVerifyTokenRepository) MarkAsUsed(ctx context.Context, tokenID int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `UPDATE verify_tokens SET is_used = true, updated_at = $1 WHERE id = $2`
+	_, err := r.DB.ExecContext(ctx, query, time.Now(), tokenID)
+	return err
+}
+
+// GetAllByUserID returns every verify token issued to a user
+func (r *PostgresVerifyTokenRepository) GetAllByUserID(ctx context.Context, userID string) ([]*VerifyToken, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `SELECT ` + verifyTokenColumns + `
+	          FROM verify_tokens
+	          WHERE user_id = $1
+	          ORDER BY created_at DESC`
+
+	rows, err := r.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*VerifyToken
+	for rows.Next() {
+		verifyToken, err := scanVerifyToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, verifyToken)
+	}
+	return tokens, rows.Err()
+}
+
+// DeleteByUserID deletes every verify token of a user and returns how many were removed
+func (r *PostgresVerifyTokenRepository) DeleteByUserID(ctx context.Context, userID string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `DELETE FROM verify_tokens WHERE user_id = $1`
+	result, err := r.DB.ExecContext(ctx, query, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DeleteExpiredTokens deletes expired tokens (cleanup)
+func (r *PostgresVerifyTokenRepository) DeleteExpiredTokens(ctx context.Context) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `DELETE FROM verify_tokens WHERE expires_at < NOW()`
+	result, err := r.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// PostgresAccountDeletionRepository implements AccountDeletionRepository on the
+// account_deletions and account_deletion_audit tables.
+type PostgresAccountDeletionRepository struct {
+	DB *sql.DB
+}
+
+const accountDeletionColumns = `id, user_id, status, reason, requested_at, scheduled_for, processed_at, completed_at, cancelled_at`
+
+func scanAccountDeletion(row interface{ Scan(dest ...any) error }) (*AccountDeletion, error) {
+	var d AccountDeletion
+	err := row.Scan(&d.ID, &d.UserID, &d.Status, &d.Reason, &d.RequestedAt, &d.ScheduledFor,
+		&d.ProcessedAt, &d.CompletedAt, &d.CancelledAt)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// Insert creates a new pending deletion request
+func (r *PostgresAccountDeletionRepository) Insert(ctx context.Context, deletion AccountDeletion) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `INSERT INTO account_deletions (user_id, status, reason, requested_at, scheduled_for)
+	          VALUES ($1, $2, $3, $4, $5) RETURNING id`
+
+	var id string
+	err := r.DB.QueryRowContext(ctx, query,
+		deletion.UserID,
+		DeletionStatusPending,
+		deletion.Reason,
+		time.Now(),
+		deletion.ScheduledFor).Scan(&id)
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// GetOne returns a deletion request by ID
+func (r *PostgresAccountDeletionRepository) GetOne(ctx context.Context, id string) (*AccountDeletion, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `SELECT ` + accountDeletionColumns + ` FROM account_deletions WHERE id = $1`
+
+	d, err := scanAccountDeletion(r.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return d, nil
+}
+
+// GetLatestByUserID returns the most recent deletion request of a user
+func (r *PostgresAccountDeletionRepository) GetLatestByUserID(ctx context.Context, userID string) (*AccountDeletion, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `SELECT ` + accountDeletionColumns + `
+	          FROM account_deletions
+	          WHERE user_id = $1
+	          ORDER BY requested_at DESC
+	          LIMIT 1`
+
+	d, err := scanAccountDeletion(r.DB.QueryRowContext(ctx, query, userID))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return d, nil
+}
+
+// GetDue returns pending requests whose grace period ended before now
+func (r *PostgresAccountDeletionRepository) GetDue(ctx context.Context, now time.Time, limit int) ([]*AccountDeletion, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `SELECT ` + accountDeletionColumns + `
+	          FROM account_deletions
+	          WHERE status = $1 AND scheduled_for <= $2
+	          ORDER BY scheduled_for
+	          LIMIT $3`
+
+	rows, err := r.DB.QueryContext(ctx, query, DeletionStatusPending, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deletions []*AccountDeletion
+	for rows.Next() {
+		d, err := scanAccountDeletion(rows)
+		if err != nil {
+			return nil, err
+		}
+		deletions = append(deletions, d)
+	}
+	return deletions, rows.Err()
+}
+
+// GetStale returns processing requests dispatched before processedBefore
+func (r *PostgresAccountDeletionRepository) GetStale(ctx context.Context, processedBefore time.Time, limit int) ([]*AccountDeletion, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `SELECT ` + accountDeletionColumns + `
+	          FROM account_deletions
+	          WHERE status = $1 AND processed_at < $2
+	          ORDER BY processed_at
+	          LIMIT $3`
+
+	rows, err := r.DB.QueryContext(ctx, query, DeletionStatusProcessing, processedBefore, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deletions []*AccountDeletion
+	for rows.Next() {
+		d, err := scanAccountDeletion(rows)
+		if err != nil {
+			return nil, err
+		}
+		deletions = append(deletions, d)
+	}
+	return deletions, rows.Err()
+}
+
+// Cancel cancels a request that is still inside its grace period
+func (r *PostgresAccountDeletionRepository) Cancel(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `UPDATE account_deletions SET status = $1, cancelled_at = $2 WHERE id = $3 AND status = $4`
+	result, err := r.DB.ExecContext(ctx, query, DeletionStatusCancelled, time.Now(), id, DeletionStatusPending)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// MarkProcessing marks a request whose erasure event has been published
+func (r *PostgresAccountDeletionRepository) MarkProcessing(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `UPDATE account_deletions SET status = $1, processed_at = $2 WHERE id = $3`
+	_, err := r.DB.ExecContext(ctx, query, DeletionStatusProcessing, time.Now(), id)
+	return err
+}
+
+// MarkCompleted marks a request whose erasure was confirmed by every service
+func (r *PostgresAccountDeletionRepository) MarkCompleted(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `UPDATE account_deletions SET status = $1, completed_at = $2 WHERE id = $3 AND status = $4`
+	_, err := r.DB.ExecContext(ctx, query, DeletionStatusCompleted, time.Now(), id, DeletionStatusProcessing)
+	return err
+}
+
+// AddAudit appends an entry to the audit trail of a deletion request
+func (r *PostgresAccountDeletionRepository) AddAudit(ctx context.Context, entry AccountDeletionAudit) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `INSERT INTO account_deletion_audit (deletion_id, service, action, records_affected, details, created_at)
+	          VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err := r.DB.ExecContext(ctx, query,
+		entry.DeletionID,
+		entry.Service,
+		entry.Action,
+		entry.RecordsAffected,
+		entry.Details,
+		time.Now())
+	return err
+}
+
+// GetAudit returns the audit trail of a deletion request, oldest first
+func (r *PostgresAccountDeletionRepository) GetAudit(ctx context.Context, deletionID string) ([]*AccountDeletionAudit, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `SELECT id, deletion_id, service, action, records_affected, COALESCE(details, ''), created_at
+	          FROM account_deletion_audit
+	          WHERE deletion_id = $1
+	          ORDER BY created_at, id`
+
+	rows, err := r.DB.QueryContext(ctx, query, deletionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*AccountDeletionAudit
+	for rows.Next() {
+		var e AccountDeletionAudit
+		err := rows.Scan(&e.ID, &e.DeletionID, &e.Service, &e.Action, &e.RecordsAffected, &e.Details, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &e)
+	}
+	return entries, rows.Err()
+}
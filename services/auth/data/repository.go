@@ -0,0 +1,72 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// UserRepository stores user accounts.
+type UserRepository interface {
+	GetAll(ctx context.Context) ([]*User, error)
+	// GetOne returns a user by id (UUID), including the full profile.
+	GetOne(ctx context.Context, id string) (*User, error)
+	// GetByEmail returns a user by email, including the password hash.
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	// Insert hashes user.Password and returns the id of the new user.
+	Insert(ctx context.Context, user User) (string, error)
+	Update(ctx context.Context, user User) error
+	DeleteByID(ctx context.Context, id string) error
+	ResetPassword(ctx context.Context, id, password string) error
+	// Anonymise replaces every piece of personal data of a user with placeholders and
+	// deactivates the account. The row is kept so foreign keys and audits stay valid.
+	Anonymise(ctx context.Context, id string) error
+}
+
+// CityRepository stores provinces and wards.
+type CityRepository interface {
+	// Insert creates the city or updates the one with the same code.
+	Insert(ctx context.Context, city City) (int, error)
+	GetAllProvinces(ctx context.Context) ([]*City, error)
+	GetWardsByProvinceCode(ctx context.Context, provinceCode string) ([]*City, error)
+	GetOne(ctx context.Context, id int) (*City, error)
+	DeleteAll(ctx context.Context) error
+}
+
+// GenderRepository reads the gender lookup table.
+type GenderRepository interface {
+	GetAll(ctx context.Context) ([]*Gender, error)
+	GetByID(ctx context.Context, id int) (*Gender, error)
+}
+
+// VerifyTokenRepository stores email verification and password reset tokens.
+type VerifyTokenRepository interface {
+	Insert(ctx context.Context, token VerifyToken) (int, error)
+	GetByToken(ctx context.Context, token string) (*VerifyToken, error)
+	// GetByUserIDAndType returns the latest unused, unexpired token of a user.
+	GetByUserIDAndType(ctx context.Context, userID, verificationType string) (*VerifyToken, error)
+	MarkAsUsed(ctx context.Context, tokenID int) error
+	GetAllByUserID(ctx context.Context, userID string) ([]*VerifyToken, error)
+	// DeleteByUserID returns how many tokens were removed.
+	DeleteByUserID(ctx context.Context, userID string) (int64, error)
+	DeleteExpiredTokens(ctx context.Context) (int64, error)
+}
+
+// AccountDeletionRepository stores account deletion requests and their audit trail.
+type AccountDeletionRepository interface {
+	// Insert creates a new pending request and returns its id.
+	Insert(ctx context.Context, deletion AccountDeletion) (string, error)
+	GetOne(ctx context.Context, id string) (*AccountDeletion, error)
+	GetLatestByUserID(ctx context.Context, userID string) (*AccountDeletion, error)
+	// GetDue returns pending requests whose grace period ended before now.
+	GetDue(ctx context.Context, now time.Time, limit int) ([]*AccountDeletion, error)
+	// GetStale returns processing requests dispatched before the given time,
+	// still waiting for confirmations.
+	GetStale(ctx context.Context, processedBefore time.Time, limit int) ([]*AccountDeletion, error)
+	// Cancel returns ErrNotFound unless the request is still pending.
+	Cancel(ctx context.Context, id string) error
+	MarkProcessing(ctx context.Context, id string) error
+	MarkCompleted(ctx context.Context, id string) error
+	AddAudit(ctx context.Context, entry AccountDeletionAudit) error
+	// GetAudit returns the audit trail of a request, oldest first.
+	GetAudit(ctx context.Context, deletionID string) ([]*AccountDeletionAudit, error)
+}
@@ -0,0 +1,2 @@
+-- Drop the UUID extension, unused once every table is gone
+DROP EXTENSION IF EXISTS "uuid-ossp";
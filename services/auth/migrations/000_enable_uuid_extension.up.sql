@@ -0,0 +1,3 @@
+-- Enable the UUID extension before 001 creates users with uuid_generate_v4()
+-- defaults, so the schema builds on an empty database
+CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
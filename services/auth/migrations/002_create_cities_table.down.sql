@@ -0,0 +1,2 @@
+-- Drop cities table
+DROP TABLE IF EXISTS cities;
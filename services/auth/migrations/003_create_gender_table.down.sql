@@ -0,0 +1,2 @@
+-- Drop gender table
+DROP TABLE IF EXISTS genders;
@@ -0,0 +1,7 @@
+-- Remove sign-up columns from users table (their indexes are dropped with them)
+ALTER TABLE users
+DROP COLUMN IF EXISTS invitation_token,
+DROP COLUMN IF EXISTS dob,
+DROP COLUMN IF EXISTS gender_id,
+DROP COLUMN IF EXISTS middle_name,
+DROP COLUMN IF EXISTS city_id;
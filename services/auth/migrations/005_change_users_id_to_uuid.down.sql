@@ -0,0 +1,2 @@
+-- The uuid_id primary key is kept: 001 creates users with it, and the SERIAL id
+-- column was never removed, so there is nothing to restore.
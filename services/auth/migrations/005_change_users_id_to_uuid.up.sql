@@ -17,11 +17,21 @@ CREATE UNIQUE INDEX IF NOT EXISTS idx_users_uuid_id ON users(uuid_id);
 -- Note: Update city_id and gender_id foreign keys if they reference users.id
 -- This is a placeholder - adjust based on your actual foreign key relationships
 
--- Step 6: Drop old primary key constraint
-ALTER TABLE users DROP CONSTRAINT IF EXISTS users_pkey;
-
--- Step 7: Set uuid_id as new primary key
-ALTER TABLE users ADD PRIMARY KEY (uuid_id);
+-- Step 6 & 7: Replace the old primary key with uuid_id.
+-- Skipped when uuid_id already is the primary key (fresh databases get it from
+-- 001, and verify_tokens depends on it once 006 ran).
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1
+        FROM pg_index i
+        JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+        WHERE i.indrelid = 'users'::regclass AND i.indisprimary AND a.attname = 'uuid_id'
+    ) THEN
+        ALTER TABLE users DROP CONSTRAINT IF EXISTS users_pkey;
+        ALTER TABLE users ADD PRIMARY KEY (uuid_id);
+    END IF;
+END $$;
 
 -- Step 8: Rename uuid_id to id (optional, keeps column name consistent)
 -- ALTER TABLE users RENAME COLUMN uuid_id TO id;
@@ -0,0 +1,2 @@
+-- Drop verifytoken table
+DROP TABLE IF EXISTS verify_tokens;
@@ -0,0 +1,3 @@
+-- Drop account deletion tables
+DROP TABLE IF EXISTS account_deletion_audit;
+DROP TABLE IF EXISTS account_deletions;
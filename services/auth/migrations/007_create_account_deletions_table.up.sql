@@ -0,0 +1,30 @@
+-- Create account deletion requests table (GDPR erasure workflow)
+CREATE TABLE IF NOT EXISTS account_deletions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL, -- no foreign key: the audit must outlive the user row
+    status VARCHAR(20) NOT NULL DEFAULT 'pending', -- 'pending', 'cancelled', 'processing', 'completed'
+    reason TEXT,
+    requested_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    scheduled_for TIMESTAMP NOT NULL,
+    processed_at TIMESTAMP,
+    completed_at TIMESTAMP,
+    cancelled_at TIMESTAMP
+);
+
+-- Only one open request per user
+CREATE UNIQUE INDEX IF NOT EXISTS idx_account_deletions_open_user
+    ON account_deletions(user_id) WHERE status IN ('pending', 'processing');
+CREATE INDEX IF NOT EXISTS idx_account_deletions_status_scheduled ON account_deletions(status, scheduled_for);
+
+-- Audit trail of every step taken for a deletion request
+CREATE TABLE IF NOT EXISTS account_deletion_audit (
+    id SERIAL PRIMARY KEY,
+    deletion_id UUID NOT NULL REFERENCES account_deletions(id) ON DELETE CASCADE,
+    service VARCHAR(50) NOT NULL,
+    action VARCHAR(50) NOT NULL, -- 'requested', 'cancelled', 'anonymised', 'event_published', 'erased', 'completed'
+    records_affected BIGINT NOT NULL DEFAULT 0,
+    details TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_account_deletion_audit_deletion_id ON account_deletion_audit(deletion_id);
@@ -0,0 +1,136 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
+)
+
+func TestLoad(t *testing.T) {
+	migrations, err := load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, m := range migrations {
+		if m.Version != i {
+			t.Errorf("migration %03d_%s: want version %03d, versions must follow each other", m.Version, m.Name, i)
+		}
+		if m.Down == "" {
+			t.Errorf("migration %03d_%s has no down file", m.Version, m.Name)
+		}
+	}
+}
+
+// TestSchemaFromEmptyDatabase builds the schema in a new database of the
+// server of AUTH_TEST_DSN, rolls it all back and builds it again.
+func TestSchemaFromEmptyDatabase(t *testing.T) {
+	dsn := os.Getenv("AUTH_TEST_DSN")
+	if dsn == "" {
+		t.Skip("AUTH_TEST_DSN is not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	db := emptyDatabase(t, ctx, dsn)
+	m, err := New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := m.Up(ctx)
+	if err != nil {
+		t.Fatalf("up from an empty database: %v", err)
+	}
+	if n != len(m.migrations) {
+		t.Fatalf("up applied %d migrations, want %d", n, len(m.migrations))
+	}
+	for _, table := range []string{"users", "cities", "genders", "verify_tokens", "account_deletions", "account_deletion_audit"} {
+		if !tableExists(t, ctx, db, table) {
+			t.Errorf("table %s is missing", table)
+		}
+	}
+
+	// users are referenced by their uuid_id
+	var pk string
+	err = db.QueryRowContext(ctx, `
+		SELECT a.attname
+		FROM pg_index i
+		JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+		WHERE i.indrelid = 'users'::regclass AND i.indisprimary`).Scan(&pk)
+	if err != nil || pk != "uuid_id" {
+		t.Errorf("users primary key = %q (%v), want uuid_id", pk, err)
+	}
+
+	if n, err := m.Up(ctx); err != nil || n != 0 {
+		t.Fatalf("second up = %d, %v; want 0, nil", n, err)
+	}
+
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range statuses {
+		if !s.Applied {
+			t.Errorf("migration %03d_%s is not applied", s.Version, s.Name)
+		}
+	}
+
+	if n, err := m.Down(ctx, len(m.migrations)); err != nil || n != len(m.migrations) {
+		t.Fatalf("down = %d, %v; want %d, nil", n, err, len(m.migrations))
+	}
+	for _, table := range []string{"users", "cities", "genders", "verify_tokens", "account_deletions"} {
+		if tableExists(t, ctx, db, table) {
+			t.Errorf("table %s is still there after rolling everything back", table)
+		}
+	}
+
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatalf("up after rolling everything back: %v", err)
+	}
+}
+
+// emptyDatabase creates a database dropped at the end of the test and
+// returns a connection to it
+func emptyDatabase(t *testing.T, ctx context.Context, dsn string) *sql.DB {
+	t.Helper()
+
+	cfg, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		t.Fatalf("AUTH_TEST_DSN: %v", err)
+	}
+	admin := stdlib.OpenDB(*cfg)
+	t.Cleanup(func() { admin.Close() })
+
+	name := fmt.Sprintf("auth_migrations_test_%d", time.Now().UnixNano())
+	if _, err := admin.ExecContext(ctx, "CREATE DATABASE "+name); err != nil {
+		t.Fatalf("create database: %v", err)
+	}
+
+	cfg = cfg.Copy()
+	cfg.Database = name
+	db := stdlib.OpenDB(*cfg)
+	t.Cleanup(func() {
+		db.Close()
+		if _, err := admin.ExecContext(context.Background(), "DROP DATABASE IF EXISTS "+name); err != nil {
+			t.Logf("drop database %s: %v", name, err)
+		}
+	})
+	return db
+}
+
+func tableExists(t *testing.T, ctx context.Context, db *sql.DB, table string) bool {
+	t.Helper()
+
+	var name sql.NullString
+	if err := db.QueryRowContext(ctx, "SELECT to_regclass($1)::text", table).Scan(&name); err != nil {
+		t.Fatal(err)
+	}
+	return name.Valid
+}
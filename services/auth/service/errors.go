@@ -0,0 +1,130 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+)
+
+// Kind classifies an Error so every transport can map it to its own status.
+type Kind int
+
+const (
+	KindInternal Kind = iota
+	KindInvalidArgument
+	KindUnauthenticated
+	KindPermissionDenied
+	KindNotFound
+	KindAlreadyExists
+)
+
+// Error is a domain error returned by the auth use cases. Message is safe to
+// show to clients; Err holds the underlying cause for logging.
+type Error struct {
+	Kind    Kind
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Is matches errors of the same kind and message, so wrapped copies of the
+// sentinel errors below still compare equal with errors.Is.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Kind == e.Kind && t.Message == e.Message
+}
+
+func newError(kind Kind, message string) *Error {
+	return &Error{Kind: kind, Message: message}
+}
+
+// wrap returns a copy of e carrying cause
+func wrap(e *Error, cause error) *Error {
+	return &Error{Kind: e.Kind, Message: e.Message, Err: cause}
+}
+
+// internal reports an unexpected failure; the cause is never shown to clients
+func internal(message string, cause error) *Error {
+	return &Error{Kind: KindInternal, Message: message, Err: cause}
+}
+
+var (
+	ErrInvalidCredentials        = newError(KindUnauthenticated, "Invalid credentials")
+	ErrInactiveUser              = newError(KindPermissionDenied, "User account is inactive")
+	ErrUserExists                = newError(KindAlreadyExists, "User with this email already exists")
+	ErrUserNotFound              = newError(KindNotFound, "User not found")
+	ErrInvalidToken              = newError(KindUnauthenticated, "Invalid or expired token")
+	ErrInvalidVerificationToken  = newError(KindInvalidArgument, "Invalid verification token")
+	ErrVerificationTokenNotFound = newError(KindNotFound, "Verification token not found")
+	ErrVerificationTokenUsed     = newError(KindInvalidArgument, "Verification token has already been used")
+	ErrVerificationTokenExpired  = newError(KindInvalidArgument, "Verification token has expired")
+	ErrInvalidOTP                = newError(KindUnauthenticated, "Invalid OTP code")
+)
+
+// invalidArgument reports a request that failed validation
+func invalidArgument(message string) *Error {
+	return newError(KindInvalidArgument, message)
+}
+
+// KindOf returns the kind of err, KindInternal for errors not produced here.
+func KindOf(err error) Kind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return KindInternal
+}
+
+// Message returns the client facing message of err.
+func Message(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Message
+	}
+	return "Internal server error"
+}
+
+// HTTPStatus maps err to the HTTP status code returned by the REST API.
+func HTTPStatus(err error) int {
+	switch KindOf(err) {
+	case KindInvalidArgument:
+		return http.StatusBadRequest
+	case KindUnauthenticated:
+		return http.StatusUnauthorized
+	case KindPermissionDenied:
+		return http.StatusForbidden
+	case KindNotFound:
+		return http.StatusNotFound
+	case KindAlreadyExists:
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// GRPCCode maps err to the gRPC status code returned by the gRPC API.
+func GRPCCode(err error) codes.Code {
+	switch KindOf(err) {
+	case KindInvalidArgument:
+		return codes.InvalidArgument
+	case KindUnauthenticated:
+		return codes.Unauthenticated
+	case KindPermissionDenied:
+		return codes.PermissionDenied
+	case KindNotFound:
+		return codes.NotFound
+	case KindAlreadyExists:
+		return codes.AlreadyExists
+	default:
+		return codes.Internal
+	}
+}
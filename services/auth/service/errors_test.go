@@ -0,0 +1,64 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+)
+
+// TestStatusMapping checks that both transports map every Kind the same way:
+// to the HTTP status the gRPC code stands for in the gRPC HTTP mapping.
+func TestStatusMapping(t *testing.T) {
+	tests := []struct {
+		kind Kind
+		http int
+		code codes.Code
+	}{
+		{KindInternal, http.StatusInternalServerError, codes.Internal},
+		{KindInvalidArgument, http.StatusBadRequest, codes.InvalidArgument},
+		{KindUnauthenticated, http.StatusUnauthorized, codes.Unauthenticated},
+		{KindPermissionDenied, http.StatusForbidden, codes.PermissionDenied},
+		{KindNotFound, http.StatusNotFound, codes.NotFound},
+		{KindAlreadyExists, http.StatusConflict, codes.AlreadyExists},
+	}
+	for _, tt := range tests {
+		err := newError(tt.kind, "message")
+		if got := HTTPStatus(err); got != tt.http {
+			t.Errorf("HTTPStatus(kind %d) = %d, want %d", tt.kind, got, tt.http)
+		}
+		if got := GRPCCode(err); got != tt.code {
+			t.Errorf("GRPCCode(kind %d) = %s, want %s", tt.kind, got, tt.code)
+		}
+
+		// wrapping keeps the kind
+		wrapped := fmt.Errorf("context: %w", wrap(err, errors.New("cause")))
+		if HTTPStatus(wrapped) != tt.http || GRPCCode(wrapped) != tt.code {
+			t.Errorf("kind %d is lost once wrapped", tt.kind)
+		}
+	}
+
+	// errors of other packages are internal, and their text isn't shown
+	plain := errors.New("connection refused")
+	if HTTPStatus(plain) != http.StatusInternalServerError || GRPCCode(plain) != codes.Internal {
+		t.Errorf("plain error mapped to %d, %s", HTTPStatus(plain), GRPCCode(plain))
+	}
+	if Message(plain) != "Internal server error" {
+		t.Errorf("Message of a plain error = %q", Message(plain))
+	}
+	if Message(internal("Failed", plain)) != "Failed" {
+		t.Errorf("Message of an internal error shows its cause")
+	}
+}
+
+func TestErrorIs(t *testing.T) {
+	err := fmt.Errorf("sign in: %w", wrap(ErrInvalidToken, errors.New("expired")))
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Error("a wrapped copy of ErrInvalidToken is not ErrInvalidToken")
+	}
+	if errors.Is(err, ErrInvalidCredentials) {
+		t.Error("ErrInvalidToken is ErrInvalidCredentials, which has the same kind")
+	}
+}
@@ -0,0 +1,388 @@
+/*
+Package service holds the auth use cases shared by the HTTP handlers and the
+gRPC server. Transports only decode requests, call a use case and map the
+returned *Error to their own status codes with HTTPStatus or GRPCCode.
+*/
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"time"
+
+	"ride-sharing/services/auth/data"
+
+	"github.com/google/uuid"
+)
+
+const (
+	minPasswordLength = 6
+	otpLength         = 6
+
+	otpExpiry           = 15 * time.Minute
+	passwordResetExpiry = time.Hour
+
+	VerificationTypeEmail         = "email"
+	VerificationTypePasswordReset = "password_reset"
+)
+
+// Service implements the auth use cases on top of the repositories.
+type Service struct {
+	models data.Models
+	tokens *Tokens
+}
+
+func New(models data.Models, tokens *Tokens) *Service {
+	return &Service{
+		models: models,
+		tokens: tokens,
+	}
+}
+
+// SignUpInput is the data needed to register a user. Optional fields are left
+// empty when not provided.
+type SignUpInput struct {
+	FirstName       string
+	MiddleName      string
+	LastName        string
+	Email           string
+	Password        string
+	CityID          string
+	GenderID        string
+	DOB             string // RFC 3339 or YYYY-MM-DD
+	InvitationToken string
+}
+
+// SignUp registers a new active user and returns it.
+func (s *Service) SignUp(ctx context.Context, in SignUpInput) (*data.User, error) {
+	if in.FirstName == "" || in.LastName == "" || in.Email == "" || in.Password == "" {
+		return nil, invalidArgument("firstName, lastName, email, and password are required")
+	}
+	if len(in.Password) < minPasswordLength {
+		return nil, invalidArgument(fmt.Sprintf("Password must be at least %d characters long", minPasswordLength))
+	}
+
+	user := data.User{
+		Email:           in.Email,
+		FirstName:       in.FirstName,
+		MiddleName:      nilIfEmpty(in.MiddleName),
+		LastName:        in.LastName,
+		Password:        in.Password,
+		Active:          true,
+		InvitationToken: nilIfEmpty(in.InvitationToken),
+	}
+
+	if in.CityID != "" {
+		id, err := strconv.Atoi(in.CityID)
+		if err != nil {
+			return nil, invalidArgument("Invalid cityId format")
+		}
+		if _, err := s.models.City.GetOne(ctx, id); err != nil {
+			if errors.Is(err, data.ErrNotFound) {
+				return nil, invalidArgument(fmt.Sprintf("City with id %d not found", id))
+			}
+			return nil, internal("Failed to look up city", err)
+		}
+		user.CityID = &id
+	}
+
+	if in.GenderID != "" {
+		id, err := strconv.Atoi(in.GenderID)
+		if err != nil {
+			return nil, invalidArgument("Invalid genderId format")
+		}
+		if _, err := s.models.Gender.GetByID(ctx, id); err != nil {
+			if errors.Is(err, data.ErrNotFound) {
+				return nil, invalidArgument(fmt.Sprintf("Gender with id %d not found", id))
+			}
+			return nil, internal("Failed to look up gender", err)
+		}
+		user.GenderID = &id
+	}
+
+	if in.DOB != "" {
+		dob, err := time.Parse(time.RFC3339, in.DOB)
+		if err != nil {
+			dob, err = time.Parse("2006-01-02", in.DOB)
+			if err != nil {
+				return nil, invalidArgument("Invalid date format. Use ISO 8601 format (YYYY-MM-DDTHH:mm:ssZ) or YYYY-MM-DD")
+			}
+		}
+		user.DOB = &dob
+	}
+
+	_, err := s.models.User.GetByEmail(ctx, in.Email)
+	switch {
+	case err == nil:
+		return nil, ErrUserExists
+	case !errors.Is(err, data.ErrNotFound):
+		return nil, internal("Failed to create user account", err)
+	}
+
+	id, err := s.models.User.Insert(ctx, user)
+	if err != nil {
+		return nil, internal("Failed to create user account", err)
+	}
+
+	user.ID = id
+	user.Password = ""
+	return &user, nil
+}
+
+// SignInResult is a successful sign-in.
+type SignInResult struct {
+	TokenPair
+	User *data.User
+}
+
+// SignIn checks the credentials of a user and issues a token pair.
+func (s *Service) SignIn(ctx context.Context, userName, password string) (*SignInResult, error) {
+	if userName == "" || password == "" {
+		return nil, invalidArgument("userName and password are required")
+	}
+
+	user, err := s.models.User.GetByEmail(ctx, userName)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, internal("Error finding user", err)
+	}
+
+	matches, err := user.PasswordMatches(password)
+	if err != nil {
+		return nil, internal("Error verifying password", err)
+	}
+	if !matches {
+		return nil, ErrInvalidCredentials
+	}
+
+	// Only reveal the account state once the caller proved they own it
+	if !user.Active {
+		return nil, ErrInactiveUser
+	}
+
+	tokens, err := s.tokens.Issue(user.ID, user.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Password = ""
+	return &SignInResult{TokenPair: *tokens, User: user}, nil
+}
+
+// VerifyAccessToken validates an access token and returns its claims.
+func (s *Service) VerifyAccessToken(ctx context.Context, token string) (*Claims, error) {
+	if token == "" {
+		return nil, invalidArgument("Token is required")
+	}
+	return s.tokens.ParseAccess(token)
+}
+
+// RenewAccessToken issues a new token pair for a valid refresh token, as long
+// as the user still exists and is active.
+func (s *Service) RenewAccessToken(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	if refreshToken == "" {
+		return nil, invalidArgument("vrto (refresh token) is required")
+	}
+
+	claims, err := s.tokens.ParseRefresh(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.models.User.GetOne(ctx, claims.UserID)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			return nil, wrap(ErrInvalidToken, err)
+		}
+		return nil, internal("Error finding user", err)
+	}
+	if !user.Active {
+		return nil, ErrInactiveUser
+	}
+
+	return s.tokens.Issue(user.ID, user.Email)
+}
+
+// VerifyMail consumes a verification token with its OTP and returns the user ID.
+func (s *Service) VerifyMail(ctx context.Context, verificationToken, otp string) (string, error) {
+	if verificationToken == "" || otp == "" {
+		return "", invalidArgument("verificationOTPCode and otp are required")
+	}
+
+	token, err := s.usableToken(ctx, verificationToken, ErrInvalidVerificationToken)
+	if err != nil {
+		return "", err
+	}
+	if token.OTPCode != otp {
+		return "", ErrInvalidOTP
+	}
+
+	if err := s.models.VerifyToken.MarkAsUsed(ctx, token.ID); err != nil {
+		return "", internal("Error updating verification status", err)
+	}
+
+	return token.UserID, nil
+}
+
+// OTP is a freshly issued verification token and its code.
+type OTP struct {
+	UserID    string
+	Token     string
+	Code      string
+	ExpiresAt time.Time
+}
+
+// ResendOTP replaces a still usable verification token with a new one.
+func (s *Service) ResendOTP(ctx context.Context, verificationToken string) (*OTP, error) {
+	if verificationToken == "" {
+		return nil, invalidArgument("verificationOTPCode is required")
+	}
+
+	token, err := s.usableToken(ctx, verificationToken, ErrVerificationTokenNotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	otp, err := s.issueOTP(ctx, token.UserID, token.VerificationType, otpExpiry)
+	if err != nil {
+		return nil, internal("Error generating new verification token", err)
+	}
+	return otp, nil
+}
+
+// PasswordReset is the result of RequestPasswordReset.
+type PasswordReset struct {
+	OTP
+	User *data.User
+}
+
+// RequestPasswordReset issues a password reset token for the user with email.
+// It returns ErrUserNotFound when nobody has that email; callers should not
+// reveal that to clients.
+func (s *Service) RequestPasswordReset(ctx context.Context, email string) (*PasswordReset, error) {
+	if email == "" {
+		return nil, invalidArgument("Email is required")
+	}
+
+	user, err := s.models.User.GetByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			return nil, wrap(ErrUserNotFound, err)
+		}
+		return nil, internal("Error finding user", err)
+	}
+	if !user.Active {
+		return nil, ErrInactiveUser
+	}
+
+	otp, err := s.issueOTP(ctx, user.ID, VerificationTypePasswordReset, passwordResetExpiry)
+	if err != nil {
+		return nil, internal("Failed to create reset token", err)
+	}
+
+	user.Password = ""
+	return &PasswordReset{OTP: *otp, User: user}, nil
+}
+
+// UserIDFromAccessToken returns the user an access token was issued to.
+func (s *Service) UserIDFromAccessToken(token string) (string, error) {
+	claims, err := s.tokens.ParseAccess(token)
+	if err != nil {
+		return "", err
+	}
+	return claims.UserID, nil
+}
+
+// ListProvinces returns every province.
+func (s *Service) ListProvinces(ctx context.Context) ([]*data.City, error) {
+	provinces, err := s.models.City.GetAllProvinces(ctx)
+	if err != nil {
+		return nil, internal("Failed to get provinces", err)
+	}
+	return provinces, nil
+}
+
+// ListWards returns the wards of a province.
+func (s *Service) ListWards(ctx context.Context, provinceCode string) ([]*data.City, error) {
+	if provinceCode == "" {
+		return nil, invalidArgument("provinceCode is required")
+	}
+
+	wards, err := s.models.City.GetWardsByProvinceCode(ctx, provinceCode)
+	if err != nil {
+		return nil, internal("Failed to get wards", err)
+	}
+	return wards, nil
+}
+
+// usableToken loads a verification token that is neither used nor expired.
+// notFound is returned when the token does not exist.
+func (s *Service) usableToken(ctx context.Context, token string, notFound *Error) (*data.VerifyToken, error) {
+	verifyToken, err := s.models.VerifyToken.GetByToken(ctx, token)
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			return nil, wrap(notFound, err)
+		}
+		return nil, internal("Error finding verification token", err)
+	}
+
+	if verifyToken.IsUsed {
+		return nil, ErrVerificationTokenUsed
+	}
+	if time.Now().After(verifyToken.ExpiresAt) {
+		return nil, ErrVerificationTokenExpired
+	}
+	return verifyToken, nil
+}
+
+func (s *Service) issueOTP(ctx context.Context, userID, verificationType string, expiry time.Duration) (*OTP, error) {
+	code, err := generateOTP(otpLength)
+	if err != nil {
+		return nil, err
+	}
+
+	otp := &OTP{
+		UserID:    userID,
+		Token:     uuid.NewString(),
+		Code:      code,
+		ExpiresAt: time.Now().Add(expiry),
+	}
+
+	_, err = s.models.VerifyToken.Insert(ctx, data.VerifyToken{
+		UserID:           userID,
+		Token:            otp.Token,
+		OTPCode:          otp.Code,
+		VerificationType: verificationType,
+		ExpiresAt:        otp.ExpiresAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return otp, nil
+}
+
+// generateOTP returns a random numeric code of length digits
+func generateOTP(length int) (string, error) {
+	code := make([]byte, length)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", err
+		}
+		code[i] = byte('0' + n.Int64())
+	}
+	return string(code), nil
+}
+
+func nilIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	accessTokenType  = "access"
+	refreshTokenType = "refresh"
+
+	defaultAccessTokenExpiry  = time.Hour
+	defaultRefreshTokenExpiry = 7 * 24 * time.Hour
+)
+
+// Tokens issues and validates the HS256 JWTs handed out by the auth service.
+type Tokens struct {
+	secret        []byte
+	accessExpiry  time.Duration
+	refreshExpiry time.Duration
+}
+
+// Claims is the validated content of a token.
+type Claims struct {
+	UserID    string
+	Email     string
+	Type      string
+	ExpiresAt time.Time
+}
+
+// TokenPair is returned by sign-in and token renewal.
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    int // seconds until the access token expires
+}
+
+func NewTokens(secret string, accessExpiry, refreshExpiry time.Duration) *Tokens {
+	return &Tokens{
+		secret:        []byte(secret),
+		accessExpiry:  accessExpiry,
+		refreshExpiry: refreshExpiry,
+	}
+}
+
+// TokensFromEnv reads JWT_SECRET, JWT_ACCESS_TOKEN_EXPIRY and JWT_REFRESH_TOKEN_EXPIRY.
+// It fails without JWT_SECRET: tokens signed with a known secret could be
+// forged by anyone.
+func TokensFromEnv() (*Tokens, error) {
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		return nil, errors.New("JWT_SECRET is not set")
+	}
+
+	return NewTokens(secret,
+		durationFromEnv("JWT_ACCESS_TOKEN_EXPIRY", defaultAccessTokenExpiry),
+		durationFromEnv("JWT_REFRESH_TOKEN_EXPIRY", defaultRefreshTokenExpiry)), nil
+}
+
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+	}
+	return fallback
+}
+
+// Issue generates a new access and refresh token for a user.
+func (t *Tokens) Issue(userID, email string) (*TokenPair, error) {
+	access, err := t.sign(userID, email, accessTokenType, t.accessExpiry)
+	if err != nil {
+		return nil, internal("Error generating access token", err)
+	}
+
+	refresh, err := t.sign(userID, email, refreshTokenType, t.refreshExpiry)
+	if err != nil {
+		return nil, internal("Error generating refresh token", err)
+	}
+
+	return &TokenPair{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		ExpiresIn:    int(t.accessExpiry.Seconds()),
+	}, nil
+}
+
+// ParseAccess validates an access token.
+func (t *Tokens) ParseAccess(token string) (*Claims, error) {
+	return t.parse(token, accessTokenType)
+}
+
+// ParseRefresh validates a refresh token.
+func (t *Tokens) ParseRefresh(token string) (*Claims, error) {
+	return t.parse(token, refreshTokenType)
+}
+
+func (t *Tokens) sign(userID, email, tokenType string, expiry time.Duration) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"sub":  userID,
+		"exp":  now.Add(expiry).Unix(),
+		"iat":  now.Unix(),
+		"type": tokenType,
+	}
+	if email != "" {
+		claims["email"] = email
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(t.secret)
+}
+
+func (t *Tokens) parse(tokenString, expectedType string) (*Claims, error) {
+	if tokenString == "" {
+		return nil, wrap(ErrInvalidToken, errors.New("token is empty"))
+	}
+
+	token, err := jwt.Parse(tokenString, func(tok *jwt.Token) (interface{}, error) {
+		if _, ok := tok.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", tok.Header["alg"])
+		}
+		return t.secret, nil
+	})
+	if err != nil {
+		return nil, wrap(ErrInvalidToken, err)
+	}
+
+	mapClaims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, wrap(ErrInvalidToken, errors.New("invalid claims"))
+	}
+
+	claims := &Claims{}
+	claims.UserID, _ = mapClaims["sub"].(string)
+	claims.Email, _ = mapClaims["email"].(string)
+	claims.Type, _ = mapClaims["type"].(string)
+	if exp, err := mapClaims.GetExpirationTime(); err == nil && exp != nil {
+		claims.ExpiresAt = exp.Time
+	}
+
+	if claims.Type != expectedType {
+		return nil, wrap(ErrInvalidToken, fmt.Errorf("unexpected token type: %s", claims.Type))
+	}
+	if claims.UserID == "" {
+		return nil, wrap(ErrInvalidToken, errors.New("token has no subject"))
+	}
+
+	return claims, nil
+}
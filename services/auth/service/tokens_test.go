@@ -0,0 +1,30 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokensFromEnv(t *testing.T) {
+	t.Setenv("JWT_SECRET", "")
+	if _, err := TokensFromEnv(); err == nil {
+		t.Fatal("TokensFromEnv without JWT_SECRET succeeded")
+	}
+
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("JWT_ACCESS_TOKEN_EXPIRY", "15m")
+	tokens, err := TokensFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair, err := tokens.Issue("user-1", "jane@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pair.ExpiresIn != int((15 * time.Minute).Seconds()) {
+		t.Fatalf("access token expires in %ds, want 15m", pair.ExpiresIn)
+	}
+	if _, err := NewTokens("another-secret", time.Hour, time.Hour).ParseAccess(pair.AccessToken); err == nil {
+		t.Fatal("a token validated with another secret")
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"image-service/data"
+
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc/metadata"
+)
+
+var (
+	errUnauthenticated = errors.New("a valid bearer token is required")
+	errForbidden       = errors.New("not allowed on this image")
+)
+
+// Principal is the caller of a request: a user of the auth service, or
+// another service presenting IMAGE_SERVICE_TOKEN.
+type Principal struct {
+	UserID string
+	// Admin may read, replace, delete and list the images of every user
+	Admin bool
+}
+
+func (p *Principal) String() string {
+	if p.UserID == "" {
+		return "service"
+	}
+	return p.UserID
+}
+
+// owns reports whether p may change the image or session of owner. Images
+// stored before they had an owner belong to the admins only.
+func (p *Principal) owns(owner string) bool {
+	return p != nil && (p.Admin || owner != "" && p.UserID == owner)
+}
+
+// canRead reports whether p may read f; p is nil for anonymous requests
+func (p *Principal) canRead(f *data.File) bool {
+	return f.Visibility == visibilityPublic || p.owns(f.Owner)
+}
+
+// Authenticator verifies the access tokens issued by the auth service, which
+// are HS256 JWTs signed with JWT_SECRET, and the service token.
+type Authenticator struct {
+	secret       []byte
+	serviceToken string
+	admins       map[string]bool
+}
+
+// NewAuthenticator reads JWT_SECRET, IMAGE_SERVICE_TOKEN and the user IDs of
+// IMAGE_ADMINS, a comma separated list. It fails without JWT_SECRET: a
+// default everyone knows would let anyone sign their own tokens.
+func NewAuthenticator() (*Authenticator, error) {
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		return nil, errors.New("JWT_SECRET is not set")
+	}
+
+	a := &Authenticator{
+		secret:       []byte(secret),
+		serviceToken: os.Getenv("IMAGE_SERVICE_TOKEN"),
+		admins:       map[string]bool{},
+	}
+	for _, id := range strings.Split(os.Getenv("IMAGE_ADMINS"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			a.admins[id] = true
+		}
+	}
+	return a, nil
+}
+
+// Authenticate returns the caller presenting token, nil when token is empty.
+func (a *Authenticator) Authenticate(token string) (*Principal, error) {
+	if token == "" {
+		return nil, nil
+	}
+	if a.serviceToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.serviceToken)) == 1 {
+		return &Principal{Admin: true}, nil
+	}
+
+	parsed, err := jwt.Parse(token, func(tok *jwt.Token) (interface{}, error) {
+		if _, ok := tok.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", tok.Header["alg"])
+		}
+		return a.secret, nil
+	})
+	if err != nil {
+		return nil, errUnauthenticated
+	}
+
+	claims, ok := parsed.Claims.(jwt.MapClaims)
+	if !ok || !parsed.Valid {
+		return nil, errUnauthenticated
+	}
+	userID, _ := claims["sub"].(string)
+	// refresh tokens only renew access tokens
+	if tokenType, _ := claims["type"].(string); tokenType != "access" || userID == "" {
+		return nil, errUnauthenticated
+	}
+	return &Principal{UserID: userID, Admin: a.admins[userID]}, nil
+}
+
+// Require is Authenticate for the calls that can't be anonymous.
+func (a *Authenticator) Require(token string) (*Principal, error) {
+	who, err := a.Authenticate(token)
+	if err == nil && who == nil {
+		err = errUnauthenticated
+	}
+	return who, err
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header, if any
+func bearerToken(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// grpcToken returns the token of the "authorization" metadata of a call
+func grpcToken(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		if token, ok := strings.CutPrefix(v, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+	}
+	return ""
+}
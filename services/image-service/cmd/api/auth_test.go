@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"image-service/data"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func signToken(t *testing.T, secret string, claims jwt.MapClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestNewAuthenticatorNeedsSecret(t *testing.T) {
+	t.Setenv("JWT_SECRET", "")
+	if _, err := NewAuthenticator(); err == nil {
+		t.Fatal("NewAuthenticator without JWT_SECRET succeeded")
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("IMAGE_SERVICE_TOKEN", "service-token")
+	t.Setenv("IMAGE_ADMINS", "admin-1, admin-2")
+	auth, err := NewAuthenticator()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := time.Now().Add(time.Hour).Unix()
+	tests := []struct {
+		name  string
+		token string
+		want  *Principal
+	}{
+		{"anonymous", "", nil},
+		{"user", signToken(t, "test-secret", jwt.MapClaims{"sub": "user-1", "type": "access", "exp": exp}), &Principal{UserID: "user-1"}},
+		{"admin", signToken(t, "test-secret", jwt.MapClaims{"sub": "admin-2", "type": "access", "exp": exp}), &Principal{UserID: "admin-2", Admin: true}},
+		{"service", "service-token", &Principal{Admin: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := auth.Authenticate(tt.token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+				t.Fatalf("Authenticate = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	rejected := []struct {
+		name  string
+		token string
+	}{
+		{"the former default secret", signToken(t, "your-secret-key-change-in-production", jwt.MapClaims{"sub": "user-1", "type": "access", "exp": exp})},
+		{"refresh token", signToken(t, "test-secret", jwt.MapClaims{"sub": "user-1", "type": "refresh", "exp": exp})},
+		{"expired", signToken(t, "test-secret", jwt.MapClaims{"sub": "user-1", "type": "access", "exp": time.Now().Add(-time.Minute).Unix()})},
+		{"no subject", signToken(t, "test-secret", jwt.MapClaims{"type": "access", "exp": exp})},
+		{"garbage", "not-a-token"},
+	}
+	for _, tt := range rejected {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := auth.Authenticate(tt.token); !errors.Is(err, errUnauthenticated) {
+				t.Fatalf("Authenticate = %v, want errUnauthenticated", err)
+			}
+		})
+	}
+}
+
+func TestCanRead(t *testing.T) {
+	private := &data.File{Owner: "user-a", Visibility: visibilityPrivate}
+	public := &data.File{Owner: "user-a", Visibility: visibilityPublic}
+
+	tests := []struct {
+		name    string
+		who     *Principal
+		private bool
+	}{
+		{"anonymous", nil, false},
+		{"owner", &Principal{UserID: "user-a"}, true},
+		{"another user", &Principal{UserID: "user-b"}, false},
+		{"admin", &Principal{UserID: "admin-1", Admin: true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.who.canRead(private); got != tt.private {
+				t.Fatalf("canRead(private) = %v, want %v", got, tt.private)
+			}
+			if !tt.who.canRead(public) {
+				t.Fatal("public image not readable")
+			}
+		})
+	}
+}
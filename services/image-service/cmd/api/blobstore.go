@@ -0,0 +1,473 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"ride-sharing/shared/env"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+var (
+	errBlobNotFound = errors.New("blob not found")
+	errInvalidKey   = errors.New("invalid blob key")
+)
+
+// BlobStore keeps the stored files under slash separated keys, "folder/name".
+type BlobStore interface {
+	// Put stores the size bytes of r as key, replacing what was there
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get opens key; the caller closes the reader
+	Get(ctx context.Context, key string) (io.ReadCloser, *BlobInfo, error)
+	Stat(ctx context.Context, key string) (*BlobInfo, error)
+	// Delete removes key, without error when it doesn't exist
+	Delete(ctx context.Context, key string) error
+	// List calls fn for every blob under folder, "" for all of them
+	List(ctx context.Context, folder string, fn func(BlobInfo) error) error
+	// URL returns a URL that reads key until ttl has passed
+	URL(ctx context.Context, key string, ttl time.Duration) (string, error)
+	// Location describes the store for the logs
+	Location() string
+}
+
+// BlobInfo describes a stored blob.
+type BlobInfo struct {
+	Key         string
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+}
+
+// newBlobStore opens the store at IMAGE_STORE_URL: a local directory (a path
+// or file:///path, root when empty) or an S3 compatible bucket
+// (s3://bucket/prefix, with the IMAGE_S3_* settings).
+func newBlobStore(rawURL, root string) (BlobStore, error) {
+	if rawURL == "" {
+		rawURL = root
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IMAGE_STORE_URL: %v", err)
+	}
+
+	switch u.Scheme {
+	case "", "file":
+		dir := u.Path
+		if u.Scheme == "" {
+			dir = rawURL
+		}
+		return newLocalStore(dir)
+	case "s3":
+		return newS3Store(u.Host, strings.Trim(u.Path, "/"))
+	default:
+		return nil, fmt.Errorf("IMAGE_STORE_URL must be a path, file:// or s3:// URL, not %s://", u.Scheme)
+	}
+}
+
+// escapeKey makes key the path of a URL
+func escapeKey(key string) string {
+	return (&url.URL{Path: key}).EscapedPath()
+}
+
+// checkKey rejects the keys that could escape the store
+func checkKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return errInvalidKey
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return errInvalidKey
+		}
+	}
+	return nil
+}
+
+// localStore keeps the blobs below a directory. It can't presign URLs, so it
+// signs them itself and image-service serves them on /blobs/.
+type localStore struct {
+	dir    string
+	signer *urlSigner
+}
+
+func newLocalStore(dir string) (*localStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	signer, err := newURLSigner()
+	if err != nil {
+		return nil, err
+	}
+	return &localStore{dir: filepath.Clean(dir), signer: signer}, nil
+}
+
+func (s *localStore) path(key string) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+func (s *localStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	dest, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+
+	// Write next to the destination and rename, so readers never see a
+	// partial file
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".put-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
+
+func (s *localStore) Get(ctx context.Context, key string) (io.ReadCloser, *BlobInfo, error) {
+	return s.open(key)
+}
+
+// open is Get returning the file, which can seek
+func (s *localStore) open(key string) (*os.File, *BlobInfo, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, errBlobNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if fi.IsDir() {
+		f.Close()
+		return nil, nil, errBlobNotFound
+	}
+	return f, s.info(key, fi), nil
+}
+
+func (s *localStore) Stat(ctx context.Context, key string) (*BlobInfo, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(p)
+	if errors.Is(err, fs.ErrNotExist) || err == nil && fi.IsDir() {
+		return nil, errBlobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.info(key, fi), nil
+}
+
+func (s *localStore) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	// drop the directories left empty, like those of finished sessions
+	for dir := filepath.Dir(p); dir != s.dir && strings.HasPrefix(dir, s.dir); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *localStore) List(ctx context.Context, folder string, fn func(BlobInfo) error) error {
+	root := s.dir
+	if folder != "" {
+		if err := checkKey(folder); err != nil {
+			return err
+		}
+		root = filepath.Join(s.dir, filepath.FromSlash(folder))
+	}
+
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil || d.IsDir() || strings.HasPrefix(d.Name(), ".put-") {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.dir, p)
+		if err != nil {
+			return err
+		}
+		return fn(*s.info(filepath.ToSlash(rel), fi))
+	})
+	return err
+}
+
+func (s *localStore) URL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+	return s.signer.URL(key, time.Now().Add(ttl)), nil
+}
+
+func (s *localStore) Location() string {
+	return s.dir
+}
+
+func (s *localStore) info(key string, fi fs.FileInfo) *BlobInfo {
+	return &BlobInfo{
+		Key:         key,
+		Size:        fi.Size(),
+		ContentType: mime.TypeByExtension(path.Ext(key)),
+		ModTime:     fi.ModTime(),
+	}
+}
+
+// urlSigner makes the time limited URLs of the local store:
+// /blobs/key?expires=unix&signature=hmac
+type urlSigner struct {
+	secret []byte
+	base   string
+}
+
+// newURLSigner reads the key from IMAGE_URL_SECRET and the URL the service
+// is reached at from IMAGE_PUBLIC_URL.
+func newURLSigner() (*urlSigner, error) {
+	secret := []byte(os.Getenv("IMAGE_URL_SECRET"))
+	if len(secret) == 0 {
+		// URLs die with the process, and other replicas reject them
+		log.Println("IMAGE_URL_SECRET is not set, signing URLs with a random key")
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+	}
+	return &urlSigner{
+		secret: secret,
+		base:   strings.TrimRight(os.Getenv("IMAGE_PUBLIC_URL"), "/"),
+	}, nil
+}
+
+func (s *urlSigner) URL(key string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	q := url.Values{"expires": {exp}, "signature": {s.sign(key, exp)}}
+	return s.base + "/blobs/" + escapeKey(key) + "?" + q.Encode()
+}
+
+// Verify checks the expiry and signature of a URL made for key.
+func (s *urlSigner) Verify(key string, q url.Values) bool {
+	exp := q.Get("expires")
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return false
+	}
+	return hmac.Equal([]byte(q.Get("signature")), []byte(s.sign(key, exp)))
+}
+
+func (s *urlSigner) sign(key, expires string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(key + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// s3Store keeps the blobs in a bucket of S3 or a compatible server like
+// MinIO, and hands out presigned URLs.
+type s3Store struct {
+	client  *minio.Client
+	presign *minio.Client // the same bucket through its public endpoint
+	bucket  string
+	prefix  string
+}
+
+// newS3Store reads IMAGE_S3_ENDPOINT, IMAGE_S3_ACCESS_KEY,
+// IMAGE_S3_SECRET_KEY, IMAGE_S3_REGION and IMAGE_S3_SSL, and
+// IMAGE_S3_PUBLIC_ENDPOINT when clients reach the bucket through another
+// host than the service.
+func newS3Store(bucket, prefix string) (*s3Store, error) {
+	if bucket == "" {
+		return nil, errors.New("IMAGE_STORE_URL must name a bucket, s3://bucket/prefix")
+	}
+
+	endpoint := env.GetString("IMAGE_S3_ENDPOINT", "s3.amazonaws.com")
+	opts := func() *minio.Options {
+		return &minio.Options{
+			Creds: credentials.NewStaticV4(
+				os.Getenv("IMAGE_S3_ACCESS_KEY"),
+				os.Getenv("IMAGE_S3_SECRET_KEY"),
+				"",
+			),
+			Secure: env.GetBool("IMAGE_S3_SSL", true),
+			// a known region spares presigning a lookup of the bucket location
+			Region: env.GetString("IMAGE_S3_REGION", "us-east-1"),
+		}
+	}
+
+	client, err := minio.New(endpoint, opts())
+	if err != nil {
+		return nil, err
+	}
+	presign := client
+	if public := os.Getenv("IMAGE_S3_PUBLIC_ENDPOINT"); public != "" {
+		if presign, err = minio.New(public, opts()); err != nil {
+			return nil, err
+		}
+	}
+
+	return &s3Store{client: client, presign: presign, bucket: bucket, prefix: prefix}, nil
+}
+
+func (s *s3Store) object(key string) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+	return path.Join(s.prefix, key), nil
+}
+
+func (s *s3Store) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	name, err := s.object(key)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.PutObject(ctx, s.bucket, name, r, size, minio.PutObjectOptions{ContentType: contentType})
+	return err
+}
+
+func (s *s3Store) Get(ctx context.Context, key string) (io.ReadCloser, *BlobInfo, error) {
+	name, err := s.object(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	obj, err := s.client.GetObject(ctx, s.bucket, name, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, nil, s3Error(err)
+	}
+	// GetObject is lazy, Stat makes the request
+	oi, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		return nil, nil, s3Error(err)
+	}
+	return obj, s.info(key, oi), nil
+}
+
+func (s *s3Store) Stat(ctx context.Context, key string) (*BlobInfo, error) {
+	name, err := s.object(key)
+	if err != nil {
+		return nil, err
+	}
+	oi, err := s.client.StatObject(ctx, s.bucket, name, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, s3Error(err)
+	}
+	return s.info(key, oi), nil
+}
+
+func (s *s3Store) Delete(ctx context.Context, key string) error {
+	name, err := s.object(key)
+	if err != nil {
+		return err
+	}
+	return s.client.RemoveObject(ctx, s.bucket, name, minio.RemoveObjectOptions{})
+}
+
+func (s *s3Store) List(ctx context.Context, folder string, fn func(BlobInfo) error) error {
+	prefix := s.prefix
+	if folder != "" {
+		if err := checkKey(folder); err != nil {
+			return err
+		}
+		prefix = path.Join(prefix, folder)
+	}
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // stops the listing when fn fails
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return obj.Err
+		}
+		key := strings.TrimPrefix(strings.TrimPrefix(obj.Key, s.prefix), "/")
+		if err := fn(*s.info(key, obj)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *s3Store) URL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	name, err := s.object(key)
+	if err != nil {
+		return "", err
+	}
+	u, err := s.presign.PresignedGetObject(ctx, s.bucket, name, ttl, url.Values{})
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+func (s *s3Store) Location() string {
+	return "s3://" + path.Join(s.bucket, s.prefix)
+}
+
+func (s *s3Store) info(key string, oi minio.ObjectInfo) *BlobInfo {
+	return &BlobInfo{Key: key, Size: oi.Size, ContentType: oi.ContentType, ModTime: oi.LastModified}
+}
+
+func s3Error(err error) error {
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NotFound":
+		return errBlobNotFound
+	}
+	return err
+}
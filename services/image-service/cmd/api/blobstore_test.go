@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// s3Stub is an S3 compatible server keeping one bucket in memory, with just
+// the path-style calls of s3Store, like a local MinIO would answer them.
+type s3Stub struct {
+	bucket string
+
+	mu      sync.Mutex
+	objects map[string]stubObject
+	puts    int
+}
+
+type stubObject struct {
+	body        []byte
+	contentType string
+	modTime     time.Time
+}
+
+func newS3Stub(t *testing.T, bucket string) (*s3Stub, *httptest.Server) {
+	t.Helper()
+	stub := &s3Stub{bucket: bucket, objects: map[string]stubObject{}}
+	srv := httptest.NewServer(stub)
+	t.Cleanup(srv.Close)
+	return stub, srv
+}
+
+func (s *s3Stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// signed by a header or, for presigned URLs, in the query
+	if r.Header.Get("Authorization") == "" && r.URL.Query().Get("X-Amz-Signature") == "" {
+		s.fail(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != s.bucket {
+		s.fail(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case key == "" && r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		s.list(w, r.URL.Query().Get("prefix"))
+	case key == "":
+		s.fail(w, http.StatusNotImplemented, "NotImplemented")
+	case r.Method == http.MethodPut:
+		body, err := readPayload(r)
+		if err != nil {
+			s.fail(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		s.objects[key] = stubObject{body: body, contentType: r.Header.Get("Content-Type"), modTime: time.Now().UTC()}
+		s.puts++
+		w.Header().Set("ETag", `"`+strconv.Itoa(s.puts)+`"`)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		obj, ok := s.objects[key]
+		if !ok {
+			s.fail(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Type", obj.contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj.body)))
+		w.Header().Set("Last-Modified", obj.modTime.Format(http.TimeFormat))
+		w.Header().Set("ETag", `"etag"`)
+		if r.Method == http.MethodGet {
+			w.Write(obj.body)
+		}
+	case r.Method == http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.fail(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (s *s3Stub) list(w http.ResponseWriter, prefix string) {
+	type content struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int
+		StorageClass string
+	}
+	result := struct {
+		XMLName     xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+		Name        string
+		Prefix      string
+		KeyCount    int
+		MaxKeys     int
+		IsTruncated bool
+		Contents    []content
+	}{Name: s.bucket, Prefix: prefix, MaxKeys: 1000}
+
+	for key, obj := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			result.Contents = append(result.Contents, content{
+				Key:          key,
+				LastModified: obj.modTime.Format("2006-01-02T15:04:05.000Z"),
+				ETag:         `"etag"`,
+				Size:         len(obj.body),
+				StorageClass: "STANDARD",
+			})
+		}
+	}
+	sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+	result.KeyCount = len(result.Contents)
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func (s *s3Stub) fail(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+// keys returns the stored object names
+func (s *s3Stub) keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.objects))
+	for key := range s.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// readPayload reads the body of a PUT, decoding the aws-chunked encoding the
+// client streams with over plain HTTP
+func readPayload(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return io.ReadAll(r.Body)
+	}
+
+	var body bytes.Buffer
+	br := bufio.NewReader(r.Body)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return body.Bytes(), nil
+		}
+		if _, err := io.CopyN(&body, br, size); err != nil {
+			return nil, err
+		}
+		if _, err := br.Discard(2); err != nil { // \r\n
+			return nil, err
+		}
+	}
+}
+
+// newTestS3Store returns an s3Store on a stub bucket, configured from the
+// environment like in production
+func newTestS3Store(t *testing.T, prefix string) (*s3Store, *s3Stub, *url.URL) {
+	t.Helper()
+	stub, srv := newS3Stub(t, "images")
+	endpoint, _ := url.Parse(srv.URL)
+
+	t.Setenv("IMAGE_S3_ENDPOINT", endpoint.Host)
+	t.Setenv("IMAGE_S3_ACCESS_KEY", "minio")
+	t.Setenv("IMAGE_S3_SECRET_KEY", "minio-secret")
+	t.Setenv("IMAGE_S3_SSL", "false")
+	t.Setenv("IMAGE_S3_PUBLIC_ENDPOINT", "")
+
+	storeURL := "s3://images"
+	if prefix != "" {
+		storeURL += "/" + prefix
+	}
+	store, err := newBlobStore(storeURL, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store.(*s3Store), stub, endpoint
+}
+
+func TestS3Store(t *testing.T) {
+	store, stub, endpoint := newTestS3Store(t, "uploads")
+	ctx := context.Background()
+
+	if got := store.Location(); got != "s3://images/uploads" {
+		t.Errorf("Location = %q", got)
+	}
+
+	put := func(key, body, contentType string) {
+		t.Helper()
+		if err := store.Put(ctx, key, strings.NewReader(body), int64(len(body)), contentType); err != nil {
+			t.Fatalf("Put %s: %v", key, err)
+		}
+	}
+	put("avatars/jane.png", "png bytes", "image/png")
+	put("avatars/john.jpg", "jpeg bytes", "image/jpeg")
+	put("documents/licence.pdf", "pdf bytes", "application/pdf")
+
+	// keys live under the prefix of the store
+	want := []string{"uploads/avatars/jane.png", "uploads/avatars/john.jpg", "uploads/documents/licence.pdf"}
+	if got := stub.keys(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("bucket holds %v, want %v", got, want)
+	}
+
+	t.Run("get", func(t *testing.T) {
+		r, info, err := store.Get(ctx, "avatars/jane.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		body, err := io.ReadAll(r)
+		if err != nil || string(body) != "png bytes" {
+			t.Fatalf("body %q, %v", body, err)
+		}
+		if info.Key != "avatars/jane.png" || info.Size != 9 || info.ContentType != "image/png" || info.ModTime.IsZero() {
+			t.Fatalf("info %+v", info)
+		}
+	})
+
+	t.Run("stat", func(t *testing.T) {
+		info, err := store.Stat(ctx, "documents/licence.pdf")
+		if err != nil || info.Size != 9 || info.ContentType != "application/pdf" {
+			t.Fatalf("Stat = %+v, %v", info, err)
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		if _, _, err := store.Get(ctx, "avatars/nobody.png"); !errors.Is(err, errBlobNotFound) {
+			t.Errorf("Get = %v, want errBlobNotFound", err)
+		}
+		if _, err := store.Stat(ctx, "avatars/nobody.png"); !errors.Is(err, errBlobNotFound) {
+			t.Errorf("Stat = %v, want errBlobNotFound", err)
+		}
+	})
+
+	t.Run("invalid keys", func(t *testing.T) {
+		for _, key := range []string{"", "/etc/passwd", "../secrets", "avatars/../../x", `avatars\jane.png`} {
+			if err := store.Put(ctx, key, strings.NewReader("x"), 1, "text/plain"); !errors.Is(err, errInvalidKey) {
+				t.Errorf("Put %q = %v, want errInvalidKey", key, err)
+			}
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		list := func(folder string) []string {
+			t.Helper()
+			var keys []string
+			err := store.List(ctx, folder, func(b BlobInfo) error {
+				keys = append(keys, fmt.Sprintf("%s:%d", b.Key, b.Size))
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("List %q: %v", folder, err)
+			}
+			return keys
+		}
+		if got := list("avatars"); strings.Join(got, ",") != "avatars/jane.png:9,avatars/john.jpg:10" {
+			t.Errorf("List avatars = %v", got)
+		}
+		if got := list(""); len(got) != 3 {
+			t.Errorf("List all = %v", got)
+		}
+
+		stop := errors.New("stop")
+		calls := 0
+		err := store.List(ctx, "", func(BlobInfo) error { calls++; return stop })
+		if !errors.Is(err, stop) || calls != 1 {
+			t.Errorf("List stopping at the first blob = %v after %d calls", err, calls)
+		}
+	})
+
+	t.Run("url", func(t *testing.T) {
+		raw, err := store.URL(ctx, "avatars/jane.png", 10*time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u, err := url.Parse(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q := u.Query()
+		if u.Host != endpoint.Host || u.Path != "/images/uploads/avatars/jane.png" ||
+			q.Get("X-Amz-Expires") != "600" || q.Get("X-Amz-Signature") == "" {
+			t.Fatalf("presigned URL %s", raw)
+		}
+
+		// the presigned URL reads the object
+		resp, err := http.Get(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(body) != "png bytes" {
+			t.Fatalf("GET presigned URL: %d %q", resp.StatusCode, body)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		if err := store.Delete(ctx, "avatars/john.jpg"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Delete(ctx, "avatars/john.jpg"); err != nil {
+			t.Fatalf("deleting a missing blob: %v", err)
+		}
+		if _, err := store.Stat(ctx, "avatars/john.jpg"); !errors.Is(err, errBlobNotFound) {
+			t.Fatalf("Stat after Delete = %v", err)
+		}
+	})
+}
+
+func TestS3StorePublicEndpoint(t *testing.T) {
+	t.Setenv("IMAGE_S3_ENDPOINT", "minio:9000")
+	t.Setenv("IMAGE_S3_PUBLIC_ENDPOINT", "cdn.example.com")
+	t.Setenv("IMAGE_S3_ACCESS_KEY", "minio")
+	t.Setenv("IMAGE_S3_SECRET_KEY", "minio-secret")
+	store, err := newS3Store("images", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// presigning needs no request, so minio:9000 needn't resolve
+	raw, err := store.URL(context.Background(), "avatars/jane.png", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u, _ := url.Parse(raw); u.Scheme != "https" || u.Host != "cdn.example.com" || u.Path != "/images/avatars/jane.png" {
+		t.Fatalf("presigned URL %s, want one on the public endpoint", raw)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"image-service/data"
+)
+
+// clamdChunkSize is the size of the chunks streamed to clamd, well under its
+// default StreamMaxLength of 25MB
+const clamdChunkSize = 64 << 10
+
+// clamdScanner scans with a ClamAV daemon over TCP, streaming the content
+// with the INSTREAM command.
+type clamdScanner struct {
+	addr    string
+	timeout time.Duration
+}
+
+func (s *clamdScanner) Name() string {
+	return "clamav"
+}
+
+// Scan sends r to clamd in length prefixed chunks ended by an empty one, and
+// reads the reply: "stream: OK", "stream: <signature> FOUND" or
+// "<reason> ERROR".
+func (s *clamdScanner) Scan(ctx context.Context, r io.Reader) (*ScanResult, error) {
+	conn, err := s.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// the z prefix ends commands and replies with a NUL byte
+	_, err = io.WriteString(conn, "zINSTREAM\x00")
+	if err == nil {
+		err = writeChunks(conn, r)
+	}
+	if err != nil {
+		// clamd closes the stream past its size limit, saying why
+		if reply, replyErr := readReply(conn); replyErr == nil && reply != "" {
+			return parseReply(reply)
+		}
+		return nil, fmt.Errorf("clamd: %w", err)
+	}
+
+	reply, err := readReply(conn)
+	if err != nil {
+		return nil, fmt.Errorf("clamd: %w", err)
+	}
+	return parseReply(reply)
+}
+
+// Ping checks that clamd answers.
+func (s *clamdScanner) Ping(ctx context.Context) error {
+	conn, err := s.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := io.WriteString(conn, "zPING\x00"); err != nil {
+		return fmt.Errorf("clamd: %w", err)
+	}
+	reply, err := readReply(conn)
+	if err != nil {
+		return fmt.Errorf("clamd: %w", err)
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("clamd: unexpected reply %q to PING", reply)
+	}
+	return nil
+}
+
+// dial connects to clamd with a deadline of the scanner's timeout, or of
+// ctx when sooner
+func (s *clamdScanner) dial(ctx context.Context) (net.Conn, error) {
+	deadline := time.Now().Add(s.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	dialer := net.Dialer{Deadline: deadline}
+	conn, err := dialer.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return nil, fmt.Errorf("clamd %s: %w", s.addr, err)
+	}
+	conn.SetDeadline(deadline)
+	return conn, nil
+}
+
+func writeChunks(w io.Writer, r io.Reader) error {
+	buf := make([]byte, 4+clamdChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			if _, werr := w.Write(buf[:4+n]); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := w.Write([]byte{0, 0, 0, 0})
+	return err
+}
+
+func readReply(r io.Reader) (string, error) {
+	reply, err := bufio.NewReader(r).ReadString(0)
+	if err != nil && !(errors.Is(err, io.EOF) && reply != "") {
+		return "", err
+	}
+	return strings.TrimSpace(strings.TrimRight(reply, "\x00")), nil
+}
+
+func parseReply(reply string) (*ScanResult, error) {
+	// the stream is named "stream", older versions say "stream(<addr>)"
+	_, result, ok := strings.Cut(reply, ": ")
+	if !ok {
+		result = reply
+	}
+
+	switch {
+	case result == "OK":
+		return &ScanResult{Verdict: data.ScanClean}, nil
+	case strings.HasSuffix(result, " FOUND"):
+		return &ScanResult{Verdict: data.ScanInfected, Signature: strings.TrimSuffix(result, " FOUND")}, nil
+	default:
+		return nil, fmt.Errorf("clamd: %s", reply)
+	}
+}
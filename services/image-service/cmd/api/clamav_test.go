@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"image-service/data"
+)
+
+// fakeClamd is a clamd answering INSTREAM with reply, and with the size
+// limit error once a stream grows past limit when set
+type fakeClamd struct {
+	addr  string
+	reply string
+	limit int
+
+	mu       sync.Mutex
+	received []byte
+}
+
+func newFakeClamd(t *testing.T, reply string) *fakeClamd {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lis.Close() })
+
+	d := &fakeClamd{addr: lis.Addr().String(), reply: reply}
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go d.serve(conn)
+		}
+	}()
+	return d
+}
+
+func (d *fakeClamd) serve(conn net.Conn) {
+	defer conn.Close()
+
+	cmd, err := readCommand(conn)
+	if err != nil {
+		return
+	}
+	switch cmd {
+	case "zPING":
+		io.WriteString(conn, "PONG\x00")
+		return
+	case "zINSTREAM":
+	default:
+		io.WriteString(conn, "UNKNOWN COMMAND\x00")
+		return
+	}
+
+	var stream []byte
+	var size [4]byte
+	for {
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return
+		}
+		n := binary.BigEndian.Uint32(size[:])
+		if n == 0 {
+			break
+		}
+		chunk := make([]byte, n)
+		if _, err := io.ReadFull(conn, chunk); err != nil {
+			return
+		}
+		stream = append(stream, chunk...)
+		if d.limit > 0 && len(stream) > d.limit {
+			io.WriteString(conn, "INSTREAM size limit exceeded. ERROR\x00")
+			// drained so that the scanner reads the reply rather than a reset
+			io.Copy(io.Discard, conn)
+			return
+		}
+	}
+
+	d.mu.Lock()
+	d.received = stream
+	d.mu.Unlock()
+	io.WriteString(conn, d.reply+"\x00")
+}
+
+// readCommand reads up to the NUL ending the command a byte at a time, not
+// to read into the stream after it
+func readCommand(r io.Reader) (string, error) {
+	var cmd []byte
+	b := make([]byte, 1)
+	for {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", err
+		}
+		if b[0] == 0 {
+			return string(cmd), nil
+		}
+		cmd = append(cmd, b[0])
+	}
+}
+
+func (d *fakeClamd) stream() []byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.received
+}
+
+func TestClamdScan(t *testing.T) {
+	// spans several chunks, the last one short
+	content := bytes.Repeat([]byte("0123456789abcdef"), clamdChunkSize/16*2+3)
+
+	tests := []struct {
+		name      string
+		reply     string
+		verdict   string
+		signature string
+		fails     string
+	}{
+		{"clean", "stream: OK", data.ScanClean, "", ""},
+		{"clean, older clamd", "stream(127.0.0.1@51234): OK", data.ScanClean, "", ""},
+		{"infected", "stream: Eicar-Signature FOUND", data.ScanInfected, "Eicar-Signature", ""},
+		{"error", "Can't allocate memory ERROR", "", "", "Can't allocate memory ERROR"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clamd := newFakeClamd(t, tt.reply)
+			s := &clamdScanner{addr: clamd.addr, timeout: 5 * time.Second}
+
+			got, err := s.Scan(context.Background(), bytes.NewReader(content))
+			if tt.fails != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.fails) {
+					t.Fatalf("Scan = %+v, %v; want an error with %q", got, err, tt.fails)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Verdict != tt.verdict || got.Signature != tt.signature {
+				t.Fatalf("Scan = %+v, want %s %q", got, tt.verdict, tt.signature)
+			}
+			if !bytes.Equal(clamd.stream(), content) {
+				t.Fatalf("clamd got %d bytes, want the %d scanned", len(clamd.stream()), len(content))
+			}
+		})
+	}
+}
+
+func TestClamdScanSizeLimit(t *testing.T) {
+	clamd := newFakeClamd(t, "stream: OK")
+	clamd.limit = clamdChunkSize
+	s := &clamdScanner{addr: clamd.addr, timeout: 5 * time.Second}
+
+	content := bytes.Repeat([]byte{'x'}, 4*clamdChunkSize)
+	got, err := s.Scan(context.Background(), bytes.NewReader(content))
+	if err == nil || !strings.Contains(err.Error(), "size limit exceeded") {
+		t.Fatalf("Scan = %+v, %v; want the size limit error", got, err)
+	}
+}
+
+func TestClamdUnreachable(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+
+	s := &clamdScanner{addr: addr, timeout: time.Second}
+	if _, err := s.Scan(context.Background(), strings.NewReader("content")); err == nil {
+		t.Fatal("Scan without clamd succeeded")
+	}
+	if err := s.Ping(context.Background()); err == nil {
+		t.Fatal("Ping without clamd succeeded")
+	}
+}
+
+func TestClamdPing(t *testing.T) {
+	clamd := newFakeClamd(t, "stream: OK")
+	s := &clamdScanner{addr: clamd.addr, timeout: 5 * time.Second}
+	if err := s.Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"ride-sharing/shared/env"
+	"ride-sharing/shared/scheduler"
+	"ride-sharing/shared/scheduler/mongolock"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	gcBatch   = 500
+	gcTimeout = 30 * time.Minute
+)
+
+var errGCRunning = errors.New("garbage collection is already running")
+
+// GCResult counts what a collection deleted, or would have.
+type GCResult struct {
+	Objects int64 // contents no file referred to
+	Bytes   int64
+	Orphans int64 // blobs of the objects folder the index doesn't know
+}
+
+// Collector deletes the contents no file has referred to for the grace
+// period, which lets an upload that shares a content just released take it
+// again. It also deletes the blobs of the objects folder the index doesn't
+// know, once as old as the grace period.
+type Collector struct {
+	uploads *Uploads
+	grace   time.Duration
+
+	// one collection at a time on this replica
+	running sync.Mutex
+}
+
+// NewCollector reads the grace period from IMAGE_GC_GRACE.
+func NewCollector(uploads *Uploads) *Collector {
+	return &Collector{
+		uploads: uploads,
+		grace:   env.GetDuration("IMAGE_GC_GRACE", time.Hour),
+	}
+}
+
+func gcInterval() time.Duration {
+	return env.GetDuration("IMAGE_GC_INTERVAL", time.Hour)
+}
+
+// Schedule adds the collection to jobs as a singleton job running every
+// IMAGE_GC_INTERVAL.
+func (c *Collector) Schedule(jobs *scheduler.Scheduler) error {
+	return jobs.Add(scheduler.Job{
+		Name:      "image.gc",
+		Schedule:  scheduler.Every(gcInterval()),
+		Jitter:    5 * time.Minute,
+		Timeout:   gcTimeout,
+		Singleton: true,
+		Run: func(ctx context.Context) error {
+			result, err := c.Run(ctx, false)
+			if result != nil && (result.Objects > 0 || result.Orphans > 0) {
+				log.Printf("garbage collection deleted %d object(s), %d bytes, and %d orphan blob(s)",
+					result.Objects, result.Bytes, result.Orphans)
+			}
+			return err
+		},
+	})
+}
+
+// Run collects now; a dry run only counts.
+func (c *Collector) Run(ctx context.Context, dryRun bool) (*GCResult, error) {
+	if !c.running.TryLock() {
+		return nil, errGCRunning
+	}
+	defer c.running.Unlock()
+
+	cutoff := time.Now().Add(-c.grace)
+	result := &GCResult{}
+	if err := c.collectObjects(ctx, cutoff, dryRun, result); err != nil {
+		return result, err
+	}
+	err := c.collectOrphans(ctx, cutoff, dryRun, result)
+	return result, err
+}
+
+func (c *Collector) collectObjects(ctx context.Context, cutoff time.Time, dryRun bool, result *GCResult) error {
+	objects := c.uploads.models.Objects
+	limit := int64(gcBatch)
+	if dryRun {
+		limit = 0 // all of them, nothing changes between batches
+	}
+
+	for {
+		batch, err := objects.Unreferenced(ctx, cutoff, limit)
+		if err != nil {
+			return err
+		}
+
+		for _, obj := range batch {
+			if dryRun {
+				result.Objects++
+				result.Bytes += obj.Size
+				continue
+			}
+
+			// Claimed, the object can't be acquired again; an upload
+			// sharing it waits for it to be gone and stores it anew
+			claimed, err := objects.Claim(ctx, obj.SHA256, cutoff)
+			if err != nil {
+				return err
+			}
+			if !claimed {
+				continue
+			}
+			if err := c.uploads.store.Delete(ctx, obj.Blob); err != nil {
+				objects.Unclaim(context.WithoutCancel(ctx), obj.SHA256)
+				return err
+			}
+			if err := objects.Remove(ctx, obj.SHA256); err != nil {
+				return err
+			}
+			result.Objects++
+			result.Bytes += obj.Size
+		}
+
+		if dryRun || len(batch) < gcBatch {
+			return nil
+		}
+	}
+}
+
+func (c *Collector) collectOrphans(ctx context.Context, cutoff time.Time, dryRun bool, result *GCResult) error {
+	var orphans []string
+	err := c.uploads.store.List(ctx, objectsFolder, func(b BlobInfo) error {
+		if !b.ModTime.Before(cutoff) {
+			return nil // maybe still being stored
+		}
+
+		sum := strings.TrimSuffix(path.Base(b.Key), path.Ext(b.Key))
+		known, err := c.uploads.models.Objects.Exists(ctx, sum)
+		if err != nil {
+			return err
+		}
+		if !known {
+			orphans = append(orphans, b.Key)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, key := range orphans {
+		if !dryRun {
+			if err := c.uploads.store.Delete(ctx, key); err != nil {
+				return err
+			}
+		}
+		result.Orphans++
+	}
+	return nil
+}
+
+// newScheduler creates the scheduler of the background jobs of
+// image-service. Replicas elect the one collecting through a Mongo lease that
+// outlives the time between two collections.
+func newScheduler(client *mongo.Client) *scheduler.Scheduler {
+	locks := client.Database("images").Collection("scheduler_locks")
+	jobs := scheduler.New(mongolock.New(locks, 2*gcInterval()+gcTimeout))
+	jobs.Publish("scheduler")
+	return jobs
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"image-service/data"
+)
+
+// unreferenced stores content and releases it, as when its last file goes
+func unreferenced(t *testing.T, u *Uploads, content string) *data.Object {
+	t.Helper()
+	obj, err := u.storeObject(context.Background(), []byte(content), "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.release(context.Background(), obj.SHA256)
+	return obj
+}
+
+func blobExists(t *testing.T, u *Uploads, key string) bool {
+	t.Helper()
+	_, err := u.store.Stat(context.Background(), key)
+	if err != nil && !errors.Is(err, errBlobNotFound) {
+		t.Fatal(err)
+	}
+	return err == nil
+}
+
+func objectExists(t *testing.T, u *Uploads, sum string) bool {
+	t.Helper()
+	ok, err := u.models.Objects.Exists(context.Background(), sum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ok
+}
+
+// age sets the modification time of the blob at key
+func age(t *testing.T, u *Uploads, key string, d time.Duration) {
+	t.Helper()
+	p, err := u.store.(*localStore).path(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Now().Add(-d)
+	if err := os.Chtimes(p, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCollectObjects(t *testing.T) {
+	tests := []struct {
+		name      string
+		grace     time.Duration
+		dryRun    bool
+		collected bool
+	}{
+		// an upload may still take the content again
+		{"within the grace period", time.Hour, false, false},
+		{"past the grace period", -time.Minute, false, true},
+		{"dry run", -time.Minute, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := newTestUploads(t)
+			ctx := context.Background()
+			gone := unreferenced(t, u, "released")
+			kept, err := u.storeObject(ctx, []byte("referenced"), "image/png")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c := &Collector{uploads: u, grace: tt.grace}
+			result, err := c.Run(ctx, tt.dryRun)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var want GCResult
+			if tt.collected || tt.dryRun && tt.grace < 0 {
+				want = GCResult{Objects: 1, Bytes: gone.Size}
+			}
+			if *result != want {
+				t.Fatalf("result %+v, want %+v", *result, want)
+			}
+			if objectExists(t, u, gone.SHA256) == tt.collected || blobExists(t, u, gone.Blob) == tt.collected {
+				t.Fatalf("released object collected %v, want %v", !tt.collected, tt.collected)
+			}
+			if !objectExists(t, u, kept.SHA256) || !blobExists(t, u, kept.Blob) {
+				t.Fatal("referenced object collected")
+			}
+		})
+	}
+}
+
+// failingDeletes is a store failing to delete
+type failingDeletes struct {
+	BlobStore
+}
+
+func (failingDeletes) Delete(context.Context, string) error {
+	return errors.New("store unavailable")
+}
+
+func TestCollectUnclaims(t *testing.T) {
+	u := newTestUploads(t)
+	ctx := context.Background()
+	obj := unreferenced(t, u, "released")
+
+	store := u.store
+	u.store = failingDeletes{store}
+	c := &Collector{uploads: u, grace: -time.Minute}
+	if _, err := c.Run(ctx, false); err == nil {
+		t.Fatal("collected with a failing store")
+	}
+
+	// the object is left as it was, an upload may take it again
+	u.store = store
+	again, err := u.storeObject(ctx, []byte("released"), "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Refs != 1 || again.Collecting || !blobExists(t, u, obj.Blob) {
+		t.Fatalf("object %+v", again)
+	}
+}
+
+func TestCollectOrphans(t *testing.T) {
+	for _, dryRun := range []bool{false, true} {
+		u := newTestUploads(t)
+		ctx := context.Background()
+
+		put := func(content string, d time.Duration) string {
+			key := objectBlob(sha256Hex([]byte(content)), "image/png")
+			if err := u.store.Put(ctx, key, bytes.NewReader([]byte(content)), int64(len(content)), "image/png"); err != nil {
+				t.Fatal(err)
+			}
+			age(t, u, key, d)
+			return key
+		}
+		orphan := put("orphan", 2*time.Hour)
+		// maybe still being stored by an upload
+		recent := put("recent", time.Minute)
+		known, err := u.storeObject(ctx, []byte("known"), "image/png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		age(t, u, known.Blob, 2*time.Hour)
+
+		c := &Collector{uploads: u, grace: time.Hour}
+		result, err := c.Run(ctx, dryRun)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Orphans != 1 || result.Objects != 0 {
+			t.Fatalf("dry run %v: result %+v", dryRun, *result)
+		}
+		if blobExists(t, u, orphan) == !dryRun || !blobExists(t, u, recent) || !blobExists(t, u, known.Blob) {
+			t.Fatalf("dry run %v: blobs %v", dryRun, objectBlobs(t, u))
+		}
+	}
+}
+
+// racingObjects lets an upload run between the steps of a collection
+type racingObjects struct {
+	data.ObjectRepository
+	beforeClaim, afterClaim func()
+	// collecting is signaled when Acquire finds the object being collected
+	collecting chan struct{}
+}
+
+func (r *racingObjects) Claim(ctx context.Context, sha256 string, before time.Time) (bool, error) {
+	if r.beforeClaim != nil {
+		r.beforeClaim()
+	}
+	claimed, err := r.ObjectRepository.Claim(ctx, sha256, before)
+	if claimed && r.afterClaim != nil {
+		r.afterClaim()
+	}
+	return claimed, err
+}
+
+func (r *racingObjects) Acquire(ctx context.Context, obj data.Object) (*data.Object, error) {
+	acquired, err := r.ObjectRepository.Acquire(ctx, obj)
+	if errors.Is(err, data.ErrCollecting) {
+		select {
+		case r.collecting <- struct{}{}:
+		default:
+		}
+	}
+	return acquired, err
+}
+
+func TestCollectRace(t *testing.T) {
+	t.Run("acquired before the claim", func(t *testing.T) {
+		u := newTestUploads(t)
+		ctx := context.Background()
+		obj := unreferenced(t, u, "shared")
+
+		objects := &racingObjects{ObjectRepository: u.models.Objects}
+		u.models.Objects = objects
+		objects.beforeClaim = func() {
+			if _, err := u.storeObject(ctx, []byte("shared"), "image/png"); err != nil {
+				t.Error(err)
+			}
+		}
+
+		c := &Collector{uploads: u, grace: -time.Minute}
+		result, err := c.Run(ctx, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Objects != 0 || !blobExists(t, u, obj.Blob) {
+			t.Fatalf("collected a referenced object: %+v", *result)
+		}
+		if got, err := u.models.Objects.Get(ctx, obj.SHA256); err != nil || got.Refs != 1 || got.Collecting {
+			t.Fatalf("object %+v, %v", got, err)
+		}
+	})
+
+	t.Run("acquired while claimed", func(t *testing.T) {
+		u := newTestUploads(t)
+		ctx := context.Background()
+		obj := unreferenced(t, u, "shared")
+
+		objects := &racingObjects{ObjectRepository: u.models.Objects, collecting: make(chan struct{})}
+		u.models.Objects = objects
+		type stored struct {
+			obj *data.Object
+			err error
+		}
+		upload := make(chan stored, 1)
+		objects.afterClaim = func() {
+			go func() {
+				obj, err := u.storeObject(ctx, []byte("shared"), "image/png")
+				upload <- stored{obj, err}
+			}()
+			// the upload waits for the collection to be over
+			select {
+			case <-objects.collecting:
+			case <-time.After(5 * time.Second):
+				t.Error("upload acquired a claimed object")
+			}
+		}
+
+		c := &Collector{uploads: u, grace: -time.Minute}
+		result, err := c.Run(ctx, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Objects != 1 {
+			t.Fatalf("result %+v", *result)
+		}
+
+		// then stores the content anew
+		got := <-upload
+		if got.err != nil {
+			t.Fatal(got.err)
+		}
+		if got.obj.Refs != 1 || got.obj.Collecting || readBlob(t, u, obj.Blob) != "shared" {
+			t.Fatalf("object %+v", got.obj)
+		}
+	})
+}
@@ -1,35 +1,53 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
-	"os"
-	"path/filepath"
 	"strings"
+	"time"
+
+	"image-service/data"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"ride-sharing/shared/clients"
 	imagepb "ride-sharing/shared/generated/image"
 )
 
-const grpcPort = 50003
+const (
+	grpcPort      = 50003
+	readChunkSize = 256 << 10
+	listPageSize  = 100
+)
 
 type imageGrpcServer struct {
 	imagepb.UnimplementedImageServiceServer
-	uploadRoot string
+	uploads *Uploads
+	auth    *Authenticator
+	gc      *Collector
 }
 
-func startGRPCServer(uploadRoot string) {
+func startGRPCServer(uploads *Uploads, auth *Authenticator, gc *Collector) {
 	addr := fmt.Sprintf(":%d", grpcPort)
 	lis, err := net.Listen("tcp", addr)
 	if err != nil {
 		log.Fatalf("image-service failed to listen: %v", err)
 	}
 
-	s := grpc.NewServer()
-	imagepb.RegisterImageServiceServer(s, &imageGrpcServer{uploadRoot: uploadRoot})
+	serverOpts, err := clients.ServerCredentials()
+	if err != nil {
+		log.Fatalf("gRPC TLS: %v", err)
+	}
+
+	s := grpc.NewServer(serverOpts...)
+	imagepb.RegisterImageServiceServer(s, &imageGrpcServer{uploads: uploads, auth: auth, gc: gc})
 	reflection.Register(s)
 
 	log.Printf("image-service gRPC listening on %s", addr)
@@ -40,24 +58,314 @@ func startGRPCServer(uploadRoot string) {
 	}()
 }
 
+// UploadToFolder stores an image and its variants for the caller as
+// folder/name.ext and folder/name_variant.ext, the extension following the
+// sniffed type rather than the one of fileName.
 func (s *imageGrpcServer) UploadToFolder(ctx context.Context, req *imagepb.UploadRequest) (*imagepb.UploadResponse, error) {
+	who, err := s.auth.Require(grpcToken(ctx))
+	if err != nil {
+		return nil, uploadError(err)
+	}
+
+	info := UploadInfo{
+		Folder:     req.GetFolder(),
+		FileName:   req.GetFileName(),
+		Variants:   req.GetVariants(),
+		Visibility: req.GetVisibility(),
+		Owner:      req.GetOwner(),
+	}
+	if err := s.uploads.Prepare(who, &info); err != nil {
+		return nil, uploadError(err)
+	}
+
+	tmp, err := s.uploads.Receive(ctx, info, bytes.NewReader(req.GetContent()))
+	if err != nil {
+		return nil, uploadError(err)
+	}
+	resp, err := s.uploads.Commit(ctx, who, info, tmp)
+	if err != nil {
+		return nil, uploadError(err)
+	}
+	return resp, nil
+}
+
+// UploadStream is UploadToFolder for images too large for one message. The
+// chunks are written to disk as they arrive.
+func (s *imageGrpcServer) UploadStream(stream imagepb.ImageService_UploadStreamServer) error {
+	ctx := stream.Context()
+	who, err := s.auth.Require(grpcToken(ctx))
+	if err != nil {
+		return uploadError(err)
+	}
+
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if first.GetInfo() == nil {
+		return status.Error(codes.InvalidArgument, "the first message must carry the upload info")
+	}
+
+	in := first.GetInfo()
+	info := UploadInfo{
+		Folder:   in.GetFolder(),
+		FileName: in.GetFileName(),
+		Variants: in.GetVariants(),
+		Size:     in.GetSize(),
+		SHA256:   in.GetSha256(),
+
+		Visibility: in.GetVisibility(),
+		Owner:      in.GetOwner(),
+	}
+	if err := s.uploads.Prepare(who, &info); err != nil {
+		return uploadError(err)
+	}
+
+	tmp, err := s.uploads.Receive(ctx, info, &chunkReader{stream: stream, buf: first.GetData()})
+	if err != nil {
+		return uploadError(err)
+	}
+	resp, err := s.uploads.Commit(ctx, who, info, tmp)
+	if err != nil {
+		return uploadError(err)
+	}
+	return stream.SendAndClose(resp)
+}
+
+// GetURL signs a URL for an image stored earlier, the ones of UploadResponse
+// having expired. A public image has a URL that doesn't expire, given to
+// anyone; a private one is signed for its owner and the admins only. Neither
+// is given for an image in quarantine.
+func (s *imageGrpcServer) GetURL(ctx context.Context, req *imagepb.GetURLRequest) (*imagepb.GetURLResponse, error) {
+	f, err := s.readable(ctx, req.GetKey(), true)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := s.uploads.urlTTL
+	if req.GetTtlSeconds() > 0 {
+		ttl = time.Duration(req.GetTtlSeconds()) * time.Second
+	}
+	url, err := s.uploads.URL(ctx, f, ttl)
+	if err != nil {
+		return nil, uploadError(err)
+	}
+
+	resp := &imagepb.GetURLResponse{Url: url}
+	if f.Visibility != visibilityPublic {
+		resp.ExpiresAt = time.Now().Add(ttl).UTC().Format(time.RFC3339)
+	}
+	return resp, nil
+}
+
+// ReadImage streams a stored image the caller may read in chunks of
+// readChunkSize, the content type and size in the first.
+func (s *imageGrpcServer) ReadImage(req *imagepb.ReadImageRequest, stream imagepb.ImageService_ReadImageServer) error {
+	f, err := s.readable(stream.Context(), req.GetKey(), true)
+	if err != nil {
+		return err
+	}
+
+	r, info, err := s.uploads.store.Get(stream.Context(), f.Blob)
+	if err != nil {
+		return uploadError(err)
+	}
+	defer r.Close()
+
+	chunk := &imagepb.ImageChunk{ContentType: info.ContentType, Size: info.Size}
+	buf := make([]byte, readChunkSize)
+	for first := true; ; first = false {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 || first {
+			chunk.Data = buf[:n]
+			if err := stream.Send(chunk); err != nil {
+				return err
+			}
+			chunk = &imagepb.ImageChunk{}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return uploadError(err)
+		}
+	}
+}
+
+// DeleteImage removes an image and its variants, for its owner or an admin.
+func (s *imageGrpcServer) DeleteImage(ctx context.Context, req *imagepb.DeleteImageRequest) (*imagepb.DeleteImageResponse, error) {
+	who, err := s.auth.Require(grpcToken(ctx))
+	if err != nil {
+		return nil, uploadError(err)
+	}
+
+	deleted, err := s.uploads.Delete(ctx, who, req.GetKey())
+	if err != nil {
+		return nil, uploadError(err)
+	}
+	log.Printf("image %s deleted by %s", req.GetKey(), who)
+	return &imagepb.DeleteImageResponse{Deleted: deleted}, nil
+}
+
+// ListImages pages through the images of the caller, or of anyone for the
+// admins, in key order.
+func (s *imageGrpcServer) ListImages(ctx context.Context, req *imagepb.ListImagesRequest) (*imagepb.ListImagesResponse, error) {
+	who, err := s.auth.Require(grpcToken(ctx))
+	if err != nil {
+		return nil, uploadError(err)
+	}
+
+	limit := int(req.GetPageSize())
+	if limit <= 0 || limit > listPageSize {
+		limit = listPageSize
+	}
 	folder := sanitize(req.GetFolder())
-	fileName := sanitize(req.GetFileName())
-	if folder == "" || fileName == "" {
-		return nil, fmt.Errorf("folder and fileName are required")
+	files, more, err := s.uploads.List(ctx, who, folder, req.GetOwner(), req.GetPageToken(), limit)
+	if err != nil {
+		return nil, uploadError(err)
 	}
 
-	dir := filepath.Join(s.uploadRoot, folder)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return nil, fmt.Errorf("create dir: %w", err)
+	resp := &imagepb.ListImagesResponse{}
+	for _, f := range files {
+		resp.Images = append(resp.Images, imageInfo(f))
+	}
+	if more {
+		resp.NextPageToken = files[len(files)-1].Key
+	}
+	return resp, nil
+}
+
+// GetImage returns the metadata of a file, and how many files share its
+// content.
+func (s *imageGrpcServer) GetImage(ctx context.Context, req *imagepb.GetImageRequest) (*imagepb.ImageInfo, error) {
+	f, err := s.readable(ctx, req.GetKey(), false)
+	if err != nil {
+		return nil, err
+	}
+
+	info := imageInfo(f)
+	obj, err := s.uploads.models.Objects.Get(ctx, f.SHA256)
+	if err != nil {
+		return nil, uploadError(err)
+	}
+	info.References = obj.Refs
+	return info, nil
+}
+
+// CollectGarbage runs the collector now rather than at its next schedule.
+func (s *imageGrpcServer) CollectGarbage(ctx context.Context, req *imagepb.CollectGarbageRequest) (*imagepb.CollectGarbageResponse, error) {
+	who, err := s.auth.Require(grpcToken(ctx))
+	if err != nil {
+		return nil, uploadError(err)
 	}
-	fullPath := filepath.Join(dir, fileName)
-	if err := os.WriteFile(fullPath, req.GetContent(), 0644); err != nil {
-		return nil, fmt.Errorf("write file: %w", err)
+	if !who.Admin {
+		return nil, status.Error(codes.PermissionDenied, "admins only")
 	}
 
-	urlPath := "/uploads/" + folder + "/" + fileName
-	return &imagepb.UploadResponse{Url: urlPath, Path: fullPath, Message: "uploaded"}, nil
+	result, err := s.gc.Run(ctx, req.GetDryRun())
+	if errors.Is(err, errGCRunning) {
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "garbage collection: %v", err)
+	}
+	log.Printf("garbage collection run by %s: %+v", who, *result)
+	return &imagepb.CollectGarbageResponse{
+		Objects: result.Objects,
+		Bytes:   result.Bytes,
+		Orphans: result.Orphans,
+	}, nil
+}
+
+func imageInfo(f *data.File) *imagepb.ImageInfo {
+	info := &imagepb.ImageInfo{
+		Key:          f.Key,
+		Owner:        f.Owner,
+		Visibility:   f.Visibility,
+		ContentType:  f.ContentType,
+		Size:         f.Size,
+		Width:        int32(f.Width),
+		Height:       int32(f.Height),
+		Sha256:       f.SHA256,
+		Variants:     f.Variants,
+		CreatedAt:    f.CreatedAt.UTC().Format(time.RFC3339),
+		Folder:       f.Folder,
+		OriginalName: f.OriginalName,
+		Original:     f.Original,
+	}
+	if f.Scan != nil {
+		info.ScanVerdict, info.ScanSignature = f.Scan.Verdict, f.Scan.Signature
+	}
+	return info
+}
+
+// readable returns the file at key when the caller may read it; its content
+// only once its scan is clean
+func (s *imageGrpcServer) readable(ctx context.Context, key string, content bool) (*data.File, error) {
+	if checkKey(key) != nil || hidden(key) {
+		return nil, status.Error(codes.InvalidArgument, "key must be a folder/file key")
+	}
+
+	who, err := s.auth.Authenticate(grpcToken(ctx))
+	if err != nil {
+		return nil, uploadError(err)
+	}
+	read := s.uploads.Readable
+	if content {
+		read = s.uploads.Content
+	}
+	f, err := read(ctx, who, key)
+	if err != nil {
+		return nil, uploadError(err)
+	}
+	return f, nil
+}
+
+// chunkReader reads the data of the chunks of an UploadStream
+type chunkReader struct {
+	stream imagepb.ImageService_UploadStreamServer
+	buf    []byte
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		chunk, err := r.stream.Recv()
+		if err != nil {
+			return 0, err // io.EOF once the client closed the stream
+		}
+		r.buf = chunk.GetData()
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// uploadError maps the errors of Uploads to gRPC codes
+func uploadError(err error) error {
+	switch {
+	case errors.Is(err, errMissingName), errors.Is(err, errUnsupportedType),
+		errors.Is(err, errTooManyPixels), errors.Is(err, errInvalidImage),
+		errors.Is(err, errUnknownVariant), errors.Is(err, errSizeMismatch),
+		errors.Is(err, errChecksumMismatch), errors.Is(err, errInvalidVisibility),
+		errors.Is(err, errInvalidKey), errors.Is(err, errVariant),
+		errors.Is(err, errReservedFolder):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, errUnauthenticated):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, errForbidden):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, errUploadTooLarge), errors.Is(err, errQuotaExceeded):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, errInfected), errors.Is(err, errQuarantined):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, errBlobNotFound), errors.Is(err, data.ErrNotFound):
+		return status.Error(codes.NotFound, "image not found")
+	case status.Code(err) != codes.Unknown:
+		return err // the stream broke
+	default:
+		return status.Errorf(codes.Internal, "upload: %v", err)
+	}
 }
 
 func sanitize(s string) string {
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"image-service/data"
+
+	imagepb "ride-sharing/shared/generated/image"
+)
+
+// Upload stores the image of a multipart/form-data request for the bearer of
+// its access token. The folder, fileName, variants, sha256, visibility and
+// owner fields must come before the file part, which is streamed to disk
+// rather than parsed in memory. It answers 201, or 202 while the image is
+// quarantined.
+func (app *Config) Upload(w http.ResponseWriter, r *http.Request) {
+	who, ok := app.authenticate(w, r)
+	if !ok {
+		return
+	}
+	app.extendDeadlines(w)
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		app.errorJSON(w, errors.New("expected a multipart/form-data body"))
+		return
+	}
+
+	var info UploadInfo
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			app.errorJSON(w, errors.New("file is required"))
+			return
+		}
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+
+		if part.FormName() != "file" {
+			value, err := io.ReadAll(io.LimitReader(part, 4096))
+			if err != nil {
+				app.errorJSON(w, err)
+				return
+			}
+			switch part.FormName() {
+			case "folder":
+				info.Folder = string(value)
+			case "fileName":
+				info.FileName = string(value)
+			case "variants":
+				for _, name := range strings.Split(string(value), ",") {
+					if name = strings.TrimSpace(name); name != "" {
+						info.Variants = append(info.Variants, name)
+					}
+				}
+			case "sha256":
+				info.SHA256 = string(value)
+			case "visibility":
+				info.Visibility = string(value)
+			case "owner":
+				info.Owner = string(value)
+			}
+			continue
+		}
+
+		if info.FileName == "" {
+			info.FileName = part.FileName()
+		}
+		if err := app.Uploads.Prepare(who, &info); err != nil {
+			app.uploadError(w, err)
+			return
+		}
+
+		tmp, err := app.Uploads.Receive(r.Context(), info, part)
+		if err != nil {
+			app.uploadError(w, err)
+			return
+		}
+		resp, err := app.Uploads.Commit(r.Context(), who, info, tmp)
+		if err != nil {
+			app.uploadError(w, err)
+			return
+		}
+
+		app.writeJSON(w, uploadedStatus(resp), jsonResponse{Message: "uploaded", Data: resp})
+		return
+	}
+}
+
+// CreateUploadSession starts a resumable upload from its JSON info; size is
+// required. The content is then sent with PATCH to the returned Location.
+func (app *Config) CreateUploadSession(w http.ResponseWriter, r *http.Request) {
+	who, ok := app.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	var info UploadInfo
+	if err := app.readJSON(w, r, &info); err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	session, err := app.Sessions.Create(r.Context(), who, info)
+	if err != nil {
+		app.uploadError(w, err)
+		return
+	}
+
+	headers := http.Header{}
+	headers.Set("Location", "/upload/sessions/"+session.ID)
+	headers.Set("Upload-Offset", "0")
+	app.writeJSON(w, http.StatusCreated, jsonResponse{Message: "upload session created", Data: session}, headers)
+}
+
+// UploadSessionOffset tells where to resume in the Upload-Offset header.
+func (app *Config) UploadSessionOffset(w http.ResponseWriter, r *http.Request) {
+	who, err := app.Auth.Require(bearerToken(r))
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	session, err := app.Sessions.Get(r.Context(), who, r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(uploadStatus(err))
+		return
+	}
+
+	setOffsetHeaders(w, session)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (app *Config) GetUploadSession(w http.ResponseWriter, r *http.Request) {
+	who, ok := app.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	session, err := app.Sessions.Get(r.Context(), who, r.PathValue("id"))
+	if err != nil {
+		app.uploadError(w, err)
+		return
+	}
+
+	setOffsetHeaders(w, session)
+	app.writeJSON(w, http.StatusOK, jsonResponse{Message: "upload session", Data: session})
+}
+
+// AppendUploadSession writes the body at the Upload-Offset header, which must
+// match the bytes already received. It answers 204 with the new offset while
+// bytes are missing, and 201 with the stored image once the last ones are in,
+// 202 while it is quarantined.
+func (app *Config) AppendUploadSession(w http.ResponseWriter, r *http.Request) {
+	who, ok := app.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		app.errorJSON(w, errors.New("Upload-Offset header is required"))
+		return
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		if mt, _, _ := mime.ParseMediaType(ct); mt != "application/offset+octet-stream" && mt != "application/octet-stream" {
+			app.errorJSON(w, fmt.Errorf("unexpected Content-Type %s", mt), http.StatusUnsupportedMediaType)
+			return
+		}
+	}
+
+	app.extendDeadlines(w)
+	session, resp, err := app.Sessions.Append(r.Context(), who, r.PathValue("id"), offset, r.Body)
+	if session != nil {
+		setOffsetHeaders(w, session)
+	}
+	if err != nil {
+		if errors.Is(err, errOffsetMismatch) {
+			app.errorJSON(w, err, http.StatusConflict)
+			return
+		}
+		app.uploadError(w, err)
+		return
+	}
+
+	if resp == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	app.writeJSON(w, uploadedStatus(resp), jsonResponse{Message: "uploaded", Data: resp})
+}
+
+func (app *Config) CancelUploadSession(w http.ResponseWriter, r *http.Request) {
+	who, ok := app.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	if err := app.Sessions.Cancel(r.Context(), who, r.PathValue("id")); err != nil {
+		app.uploadError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ServeBlob serves the files of the local store to the holders of a URL it
+// signed.
+func (app *Config) ServeBlob(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	if hidden(key) || !app.Local.signer.Verify(key, r.URL.Query()) {
+		http.Error(w, "invalid or expired URL", http.StatusForbidden)
+		return
+	}
+	app.serveBlob(w, r, key, "private, max-age=300")
+}
+
+// ServeImage serves the public images to everyone, and the private ones to
+// their owner and the admins presenting their token. Other callers get 404,
+// rather than learning that a private image exists, and an image in
+// quarantine is served to no one.
+func (app *Config) ServeImage(w http.ResponseWriter, r *http.Request) {
+	who, err := app.Auth.Authenticate(bearerToken(r))
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	f, err := app.Uploads.Content(r.Context(), who, r.PathValue("key"))
+	if errors.Is(err, errUnauthenticated) || errors.Is(err, errForbidden) || errors.Is(err, errInvalidKey) {
+		err = errBlobNotFound
+	}
+	if err != nil {
+		w.WriteHeader(uploadStatus(err))
+		return
+	}
+
+	cacheControl := "public, max-age=3600"
+	if f.Visibility != visibilityPublic {
+		cacheControl = "private, no-store"
+	}
+	// the content of a key changes, but not the one of a digest
+	w.Header().Set("ETag", `"`+f.SHA256+`"`)
+	app.serveBlob(w, r, f.Blob, cacheControl)
+}
+
+// serveBlob writes the blob at key; from the local store, with support for
+// range and conditional requests
+func (app *Config) serveBlob(w http.ResponseWriter, r *http.Request, key, cacheControl string) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", cacheControl)
+
+	if app.Local != nil {
+		f, info, err := app.Local.open(key)
+		if err != nil {
+			w.WriteHeader(uploadStatus(err))
+			return
+		}
+		defer f.Close()
+
+		if info.ContentType != "" {
+			w.Header().Set("Content-Type", info.ContentType)
+		}
+		http.ServeContent(w, r, path.Base(key), info.ModTime, f)
+		return
+	}
+
+	rc, info, err := app.Uploads.store.Get(r.Context(), key)
+	if err != nil {
+		w.WriteHeader(uploadStatus(err))
+		return
+	}
+	defer rc.Close()
+
+	if info.ContentType != "" {
+		w.Header().Set("Content-Type", info.ContentType)
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	w.Header().Set("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		io.Copy(w, rc)
+	}
+}
+
+// authenticate returns the caller of a request that needs one, answering 401
+// when it has no valid token
+func (app *Config) authenticate(w http.ResponseWriter, r *http.Request) (*Principal, bool) {
+	who, err := app.Auth.Require(bearerToken(r))
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return nil, false
+	}
+	return who, true
+}
+
+// extendDeadlines gives an upload request UploadTimeout to be received and
+// processed, rather than the server timeouts meant for small requests
+func (app *Config) extendDeadlines(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	deadline := time.Now().Add(app.UploadTimeout)
+	if err := rc.SetReadDeadline(deadline); err != nil {
+		log.Printf("upload read deadline: %v", err)
+	}
+	if err := rc.SetWriteDeadline(deadline); err != nil {
+		log.Printf("upload write deadline: %v", err)
+	}
+}
+
+// uploadedStatus is 201 for an upload found clean, 202 for one whose scan
+// isn't done
+func uploadedStatus(resp *imagepb.UploadResponse) int {
+	if resp.ScanVerdict != data.ScanClean {
+		return http.StatusAccepted
+	}
+	return http.StatusCreated
+}
+
+func setOffsetHeaders(w http.ResponseWriter, session *Session) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(session.Info.Size, 10))
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+// uploadError answers with the status of err, logging the unexpected ones
+func (app *Config) uploadError(w http.ResponseWriter, err error) {
+	status := uploadStatus(err)
+	if status == http.StatusInternalServerError {
+		log.Printf("upload: %v", err)
+		err = errors.New("upload failed")
+	}
+	app.errorJSON(w, err, status)
+}
+
+// uploadStatus maps the errors of Uploads and Sessions to HTTP statuses
+func uploadStatus(err error) int {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, errMissingName), errors.Is(err, errUnsupportedType),
+		errors.Is(err, errTooManyPixels), errors.Is(err, errInvalidImage),
+		errors.Is(err, errUnknownVariant), errors.Is(err, errSizeMismatch),
+		errors.Is(err, errChecksumMismatch), errors.Is(err, errSizeRequired),
+		errors.Is(err, errInvalidVisibility), errors.Is(err, errInvalidKey),
+		errors.Is(err, errVariant), errors.Is(err, errReservedFolder):
+		return http.StatusBadRequest
+	case errors.Is(err, errUnauthenticated):
+		return http.StatusUnauthorized
+	case errors.Is(err, errForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, errUploadTooLarge), errors.Is(err, errQuotaExceeded), errors.As(err, &maxBytesErr):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, errSessionNotFound), errors.Is(err, errBlobNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errQuarantined):
+		return http.StatusConflict
+	case errors.Is(err, errInfected):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"image-service/data"
+)
+
+const migrateUsage = `usage: image-service migrate [-from DIR|URL] [-dry-run] [-delete]
+
+Indexes the files of a local upload directory, or of the store at URL, into
+the store of IMAGE_STORE_URL and the index in MongoDB, each content stored
+once under its SHA-256. Files already indexed are skipped, so it can run again
+after an interruption, and -from may be IMAGE_STORE_URL itself.
+
+The owner and visibility of a file come from the record image-service kept
+next to it before the index. Files without one have no owner: they are
+private images only the admins can read until uploaded again. The files
+indexed are quarantined until the service scans them.`
+
+// legacyRecordsFolder holds the records of the files stored before the
+// index, one JSON document per key
+const legacyRecordsFolder = ".objects"
+
+// legacyRecord is what the index knew of a file before it existed.
+type legacyRecord struct {
+	Owner       string    `json:"owner"`
+	Visibility  string    `json:"visibility"`
+	ContentType string    `json:"contentType"`
+	Width       int       `json:"width"`
+	Height      int       `json:"height"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Variants    []string  `json:"variants"`
+	Original    string    `json:"original"`
+}
+
+// runMigrateCommand indexes the files the service kept under their keys,
+// in its local upload root or in a store, and returns the exit code.
+func runMigrateCommand(args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprintln(os.Stderr, migrateUsage) }
+	from := fs.String("from", getUploadRoot(), "local upload directory, or store URL, to index from")
+	dryRun := fs.Bool("dry-run", false, "list what would be indexed")
+	deleteSource := fs.Bool("delete", false, "delete each file, and its record, once indexed")
+	if err := fs.Parse(args); err != nil || fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+
+	dest, err := newBlobStore(os.Getenv("IMAGE_STORE_URL"), getUploadRoot())
+	if err != nil {
+		log.Printf("Error opening the store: %v", err)
+		return 1
+	}
+	var src BlobStore = &localStore{dir: filepath.Clean(*from)}
+	if strings.Contains(*from, "://") {
+		if src, err = newBlobStore(*from, ""); err != nil {
+			log.Printf("Error opening %s: %v", *from, err)
+			return 1
+		}
+	}
+
+	client, err := connectToMongo()
+	if err != nil {
+		return 1
+	}
+	ctx := context.Background()
+	defer client.Disconnect(ctx)
+
+	models := data.New(client)
+	if err := models.EnsureIndexes(ctx); err != nil {
+		log.Printf("Error creating the indexes: %v", err)
+		return 1
+	}
+	// only what storeObject needs, NewUploads would clear the temp dir of
+	// a running service
+	u := &Uploads{store: dest, models: models}
+
+	var indexed, skipped, failed int
+	err = src.List(ctx, "", func(b BlobInfo) error {
+		// records, temp files and sessions, and the contents of the index
+		if hidden(b.Key) || b.Key == objectsFolder || strings.HasPrefix(b.Key, objectsFolder+"/") {
+			return nil
+		}
+
+		f, err := models.Files.Get(ctx, b.Key)
+		if err != nil && !errors.Is(err, data.ErrNotFound) {
+			return err
+		}
+		if f != nil && (!*deleteSource || *dryRun) {
+			skipped++
+			return nil
+		}
+		if *dryRun {
+			log.Printf("would index %s (%d bytes)", b.Key, b.Size)
+			indexed++
+			return nil
+		}
+
+		done, err := migrateFile(ctx, u, src, b, f)
+		if err != nil {
+			log.Printf("Error indexing %s: %v", b.Key, err)
+			failed++
+			return nil
+		}
+		if done {
+			indexed++
+		} else {
+			skipped++
+		}
+
+		if *deleteSource {
+			for _, key := range []string{b.Key, path.Join(legacyRecordsFolder, b.Key+".json")} {
+				if err := src.Delete(ctx, key); err != nil {
+					log.Printf("Error deleting %s: %v", key, err)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error listing %s: %v", *from, err)
+		return 1
+	}
+
+	log.Printf("Indexed %d file(s) from %s into %s, %d already indexed, %d failed", indexed, *from, dest.Location(), skipped, failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// migrateFile stores the content of b and indexes it, unless indexed is the
+// file already at its key, and reports whether it did. A file indexed with
+// another content fails, so that deleting its source loses nothing.
+func migrateFile(ctx context.Context, u *Uploads, src BlobStore, b BlobInfo, indexed *data.File) (bool, error) {
+	r, _, err := src.Get(ctx, b.Key)
+	if err != nil {
+		return false, err
+	}
+	content, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return false, err
+	}
+
+	if indexed != nil {
+		digest := sha256.Sum256(content)
+		if indexed.SHA256 != hex.EncodeToString(digest[:]) {
+			return false, errors.New("indexed already with another content, left in place")
+		}
+		return false, nil
+	}
+
+	f := &data.File{
+		Key:          b.Key,
+		Folder:       path.Dir(b.Key),
+		OriginalName: path.Base(b.Key),
+		Visibility:   visibilityPrivate,
+		ContentType:  b.ContentType,
+		Size:         int64(len(content)),
+		CreatedAt:    b.ModTime.UTC(),
+		// scanned by the running service
+		Scan: &data.Scan{Verdict: data.ScanPending},
+	}
+	rec, err := readLegacyRecord(ctx, src, b.Key)
+	if err != nil {
+		return false, err
+	}
+	if rec != nil {
+		f.Owner, f.Visibility = rec.Owner, rec.Visibility
+		f.Width, f.Height = rec.Width, rec.Height
+		f.Variants, f.Original = rec.Variants, rec.Original
+		if rec.ContentType != "" {
+			f.ContentType = rec.ContentType
+		}
+		if !rec.CreatedAt.IsZero() {
+			f.CreatedAt = rec.CreatedAt.UTC()
+		}
+	} else if cfg, _, err := image.DecodeConfig(bytes.NewReader(content)); err == nil {
+		f.Width, f.Height = cfg.Width, cfg.Height
+	}
+	if f.ContentType == "" || f.ContentType == "application/octet-stream" {
+		f.ContentType = http.DetectContentType(content)
+	}
+
+	obj, err := u.storeObject(ctx, content, f.ContentType)
+	if err != nil {
+		return false, err
+	}
+	f.SHA256, f.Blob = obj.SHA256, obj.Blob
+
+	old, err := u.models.Files.Put(ctx, f)
+	if err != nil {
+		u.release(ctx, obj.SHA256)
+		return false, err
+	}
+	if old != nil {
+		u.release(ctx, old.SHA256)
+	}
+	return true, nil
+}
+
+// readLegacyRecord returns the record of key, nil when it has none
+func readLegacyRecord(ctx context.Context, src BlobStore, key string) (*legacyRecord, error) {
+	r, _, err := src.Get(ctx, path.Join(legacyRecordsFolder, key+".json"))
+	if errors.Is(err, errBlobNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var rec legacyRecord
+	if err := json.NewDecoder(r).Decode(&rec); err != nil {
+		return nil, fmt.Errorf("record of %s: %w", key, err)
+	}
+	if rec.Visibility != visibilityPublic {
+		rec.Visibility = visibilityPrivate
+	}
+	return &rec, nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"time"
+
+	"image-service/data"
+)
+
+// objectsFolder holds the contents in the store, under their SHA-256:
+// objects/ab/ab12...ef.png. Uploads can't use it.
+const objectsFolder = "objects"
+
+const (
+	visibilityPublic  = "public"
+	visibilityPrivate = "private"
+
+	// acquireAttempts bounds the waits for the collector to finish deleting
+	// an object an upload has again
+	acquireAttempts = 5
+)
+
+var (
+	errInvalidVisibility = errors.New("visibility must be public or private")
+	errVariant           = errors.New("a variant goes with its image, delete the image")
+)
+
+// objectBlob returns the key of the content with digest sum
+func objectBlob(sum, contentType string) string {
+	return path.Join(objectsFolder, sum[:2], sum+extensions[contentType])
+}
+
+// storeObject stores content once per digest and references it. The caller
+// releases the reference when the file referring to it isn't stored.
+func (u *Uploads) storeObject(ctx context.Context, content []byte, contentType string) (*data.Object, error) {
+	digest := sha256.Sum256(content)
+	sum := hex.EncodeToString(digest[:])
+	want := data.Object{
+		SHA256:      sum,
+		Blob:        objectBlob(sum, contentType),
+		ContentType: contentType,
+		Size:        int64(len(content)),
+	}
+
+	var obj *data.Object
+	var err error
+	for attempt := 1; ; attempt++ {
+		obj, err = u.models.Objects.Acquire(ctx, want)
+		if !errors.Is(err, data.ErrCollecting) || attempt == acquireAttempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Duration(attempt) * 100 * time.Millisecond):
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The first reference stores the content; later ones only check that
+	// it's still there, since it's put after the object is created
+	if obj.Refs > 1 {
+		_, err = u.store.Stat(ctx, obj.Blob)
+		if err == nil {
+			return obj, nil
+		}
+		if !errors.Is(err, errBlobNotFound) {
+			u.release(ctx, sum)
+			return nil, err
+		}
+	}
+	if err := u.store.Put(ctx, obj.Blob, bytes.NewReader(content), obj.Size, contentType); err != nil {
+		u.release(ctx, sum)
+		return nil, fmt.Errorf("store %s: %w", obj.Blob, err)
+	}
+	return obj, nil
+}
+
+// release drops a reference to an object, logging the failures: the object
+// then outlives its last file, the collector never deletes it
+func (u *Uploads) release(ctx context.Context, sum string) {
+	if err := u.models.Objects.Release(context.WithoutCancel(ctx), sum); err != nil {
+		log.Printf("release object %s: %v", sum, err)
+	}
+}
+
+// file returns the file at key
+func (u *Uploads) file(ctx context.Context, key string) (*data.File, error) {
+	if err := checkKey(key); err != nil || hidden(key) {
+		return nil, errInvalidKey
+	}
+	f, err := u.models.Files.Get(ctx, key)
+	if errors.Is(err, data.ErrNotFound) {
+		return nil, errBlobNotFound
+	}
+	return f, err
+}
+
+// Delete removes the image at key and its variants from the index, and
+// releases their contents. Only the owner and the admins may; a variant goes
+// with its image only.
+func (u *Uploads) Delete(ctx context.Context, who *Principal, key string) ([]string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	f, err := u.file(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !who.owns(f.Owner) {
+		return nil, errForbidden
+	}
+	if f.Original != "" {
+		return nil, errVariant
+	}
+
+	// the original first, so a variant never shows up without it
+	keys := append([]string{key}, f.Variants...)
+	for _, k := range keys {
+		if err := u.remove(ctx, k); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// remove deletes the file at key and releases its content
+func (u *Uploads) remove(ctx context.Context, key string) error {
+	f, err := u.models.Files.Delete(ctx, key)
+	if errors.Is(err, data.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	u.release(ctx, f.SHA256)
+	return nil
+}
+
+// Readable returns the file at key when who may read it, who being nil for
+// anonymous requests, quarantined or not.
+func (u *Uploads) Readable(ctx context.Context, who *Principal, key string) (*data.File, error) {
+	f, err := u.file(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !who.canRead(f) {
+		if who == nil {
+			return nil, errUnauthenticated
+		}
+		return nil, errForbidden
+	}
+	return f, nil
+}
+
+// Content returns the file at key when who may read it and its scan is
+// clean, for serving its content.
+func (u *Uploads) Content(ctx context.Context, who *Principal, key string) (*data.File, error) {
+	f, err := u.Readable(ctx, who, key)
+	if err != nil {
+		return nil, err
+	}
+	if f.Quarantined() {
+		return nil, errQuarantined
+	}
+	return f, nil
+}
+
+// List returns up to limit images, not variants, of folder and its
+// subfolders ("" for every folder) whose keys sort after after, in key
+// order, and whether more follow. Admins see the images of owner, everyone's
+// when empty; other users only their own.
+func (u *Uploads) List(ctx context.Context, who *Principal, folder, owner, after string, limit int) ([]*data.File, bool, error) {
+	if !who.Admin {
+		owner = who.UserID
+	}
+	if folder != "" && (checkKey(folder) != nil || hidden(folder)) {
+		return nil, false, errInvalidKey
+	}
+
+	files, err := u.models.Files.List(ctx, data.FileFilter{
+		Folder: folder,
+		Owner:  owner,
+		After:  after,
+		Limit:  int64(limit) + 1,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if len(files) > limit {
+		return files[:limit], true, nil
+	}
+	return files, false, nil
+}
+
+// URL returns the URL to read f: a plain one when it is public, signed and
+// expiring after ttl otherwise.
+func (u *Uploads) URL(ctx context.Context, f *data.File, ttl time.Duration) (string, error) {
+	if f.Visibility == visibilityPublic {
+		return u.publicURL + "/images/" + escapeKey(f.Key), nil
+	}
+	return u.store.URL(ctx, f.Blob, ttl)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"image-service/data"
+)
+
+// objectBlobs returns the keys of the objects folder
+func objectBlobs(t *testing.T, u *Uploads) []string {
+	t.Helper()
+	var keys []string
+	err := u.store.List(context.Background(), objectsFolder, func(b BlobInfo) error {
+		keys = append(keys, b.Key)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func readBlob(t *testing.T, u *Uploads, key string) string {
+	t.Helper()
+	r, _, err := u.store.Get(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestStoreObjectDedup(t *testing.T) {
+	u := newTestUploads(t)
+	ctx := context.Background()
+	content := []byte("png bytes")
+
+	first, err := u.storeObject(ctx, content, "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.SHA256 != sha256Hex(content) || first.Blob != objectBlob(first.SHA256, "image/png") || first.Refs != 1 {
+		t.Fatalf("first %+v", first)
+	}
+
+	// the same content is referenced again, not stored twice
+	second, err := u.storeObject(ctx, content, "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.SHA256 != first.SHA256 || second.Refs != 2 {
+		t.Fatalf("second %+v", second)
+	}
+	if keys := objectBlobs(t, u); len(keys) != 1 || keys[0] != first.Blob {
+		t.Fatalf("blobs %v", keys)
+	}
+
+	// a blob lost from the store comes back with the next reference
+	if err := u.store.Delete(ctx, first.Blob); err != nil {
+		t.Fatal(err)
+	}
+	if obj, err := u.storeObject(ctx, content, "image/png"); err != nil || obj.Refs != 3 {
+		t.Fatalf("third %+v, %v", obj, err)
+	}
+	if got := readBlob(t, u, first.Blob); got != string(content) {
+		t.Fatalf("blob %q", got)
+	}
+
+	other, err := u.storeObject(ctx, []byte("other png bytes"), "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.SHA256 == first.SHA256 || other.Refs != 1 || len(objectBlobs(t, u)) != 2 {
+		t.Fatalf("other %+v", other)
+	}
+}
+
+func TestDeleteReleases(t *testing.T) {
+	u := newTestUploads(t)
+	ctx := context.Background()
+	alice := &Principal{UserID: "alice"}
+	photo := jpegFixture(t, 1)
+
+	var sums []string
+	for _, name := range []string{"a.jpg", "b.jpg"} {
+		info := UploadInfo{Folder: "trips/1", FileName: name}
+		if err := u.Prepare(alice, &info); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := u.Commit(ctx, alice, info, tempUpload(t, u, photo)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, key := range []string{"trips/1/a.jpg", "trips/1/a_preview.jpg"} {
+		f, err := u.models.Files.Get(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sums = append(sums, f.SHA256)
+	}
+
+	// both images share the contents of the original and the variant
+	refs := func(want int64) {
+		t.Helper()
+		for _, sum := range sums {
+			obj, err := u.models.Objects.Get(ctx, sum)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if obj.Refs != want || (obj.UnreferencedAt == nil) != (want > 0) {
+				t.Fatalf("object %+v, want %d refs", obj, want)
+			}
+		}
+	}
+	refs(2)
+	if keys := objectBlobs(t, u); len(keys) != 2 {
+		t.Fatalf("blobs %v", keys)
+	}
+
+	if _, err := u.Delete(ctx, &Principal{UserID: "bob"}, "trips/1/a.jpg"); !errors.Is(err, errForbidden) {
+		t.Fatalf("delete of another user: %v", err)
+	}
+	if _, err := u.Delete(ctx, alice, "trips/1/a_preview.jpg"); !errors.Is(err, errVariant) {
+		t.Fatalf("delete of a variant: %v", err)
+	}
+
+	keys, err := u.Delete(ctx, alice, "trips/1/a.jpg")
+	if err != nil || len(keys) != 2 {
+		t.Fatalf("deleted %v, %v", keys, err)
+	}
+	refs(1)
+	if _, err := u.Delete(ctx, alice, "trips/1/b.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	refs(0)
+
+	// the contents stay for the collector
+	if keys := objectBlobs(t, u); len(keys) != 2 {
+		t.Fatalf("blobs %v", keys)
+	}
+	if _, err := u.models.Files.Get(ctx, "trips/1/b_preview.jpg"); !errors.Is(err, data.ErrNotFound) {
+		t.Fatalf("variant after delete: %v", err)
+	}
+}
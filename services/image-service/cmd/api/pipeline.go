@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"ride-sharing/shared/env"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // registers the WebP decoder
+)
+
+const (
+	// thumbnail and avatar are cropped to fill their box, preview keeps the
+	// whole page or photo
+	defaultVariants = "thumbnail=200x200:cover,avatar=256x256:cover,preview=1280x1280:contain"
+	// 40 megapixels is more than any phone camera; larger headers are
+	// rejected before decoding so a tiny file can't claim gigabytes of pixels
+	defaultMaxPixels = 40_000_000
+)
+
+var (
+	errUnsupportedType = errors.New("unsupported image type, expected JPEG, PNG or WebP")
+	errTooManyPixels   = errors.New("image dimensions are too large")
+	errInvalidImage    = errors.New("invalid image")
+	errUnknownVariant  = errors.New("unknown variant")
+)
+
+// Image formats the pipeline accepts
+const (
+	typeJPEG = "image/jpeg"
+	typePNG  = "image/png"
+	typeWebP = "image/webp"
+)
+
+var extensions = map[string]string{
+	typeJPEG: ".jpg",
+	typePNG:  ".png",
+	typeWebP: ".webp",
+}
+
+// Variant is a resized copy generated for every upload.
+type Variant struct {
+	Name   string
+	Width  int
+	Height int
+	// Cover crops the image around its center to fill the box exactly,
+	// otherwise the whole image is scaled to fit inside it
+	Cover bool
+}
+
+// Pipeline validates uploaded images, strips their metadata and renders the
+// variants.
+type Pipeline struct {
+	variants    []Variant
+	maxPixels   int
+	jpegQuality int
+}
+
+// Processed is an upload ready to be stored: the original, without metadata,
+// and its variants.
+type Processed struct {
+	ContentType string
+	Width       int
+	Height      int
+	Data        []byte
+	Variants    []ProcessedVariant
+}
+
+type ProcessedVariant struct {
+	Name        string
+	ContentType string
+	Width       int
+	Height      int
+	Data        []byte
+}
+
+// NewPipeline reads the variants from IMAGE_VARIANTS, a comma separated list
+// of name=WIDTHxHEIGHT:cover|contain, and the limits from IMAGE_MAX_PIXELS and
+// IMAGE_JPEG_QUALITY.
+func NewPipeline() (*Pipeline, error) {
+	variants, err := parseVariants(env.GetString("IMAGE_VARIANTS", defaultVariants))
+	if err != nil {
+		return nil, err
+	}
+
+	quality := env.GetInt("IMAGE_JPEG_QUALITY", 90)
+	if quality < 1 || quality > 100 {
+		return nil, fmt.Errorf("IMAGE_JPEG_QUALITY must be between 1 and 100, got %d", quality)
+	}
+
+	return &Pipeline{
+		variants:    variants,
+		maxPixels:   env.GetInt("IMAGE_MAX_PIXELS", defaultMaxPixels),
+		jpegQuality: quality,
+	}, nil
+}
+
+func parseVariants(spec string) ([]Variant, error) {
+	var variants []Variant
+	seen := map[string]bool{}
+
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		name, size, ok := strings.Cut(item, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("IMAGE_VARIANTS: %q is not name=WIDTHxHEIGHT:mode", item)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("IMAGE_VARIANTS: variant %q is defined twice", name)
+		}
+		seen[name] = true
+
+		size, mode, _ := strings.Cut(strings.TrimSpace(size), ":")
+		w, h, ok := strings.Cut(size, "x")
+		width, werr := strconv.Atoi(w)
+		height, herr := strconv.Atoi(h)
+		if !ok || werr != nil || herr != nil || width < 1 || height < 1 {
+			return nil, fmt.Errorf("IMAGE_VARIANTS: invalid size %q for variant %q", size, name)
+		}
+
+		v := Variant{Name: name, Width: width, Height: height}
+		switch mode {
+		case "cover":
+			v.Cover = true
+		case "contain", "":
+		default:
+			return nil, fmt.Errorf("IMAGE_VARIANTS: invalid mode %q for variant %q, expected cover or contain", mode, name)
+		}
+		variants = append(variants, v)
+	}
+
+	return variants, nil
+}
+
+// Variants returns the configured variants named in names, all of them when
+// names is empty.
+func (p *Pipeline) Variants(names []string) ([]Variant, error) {
+	if len(names) == 0 {
+		return p.variants, nil
+	}
+
+	selected := make([]Variant, 0, len(names))
+	for _, name := range names {
+		i := p.variantIndex(name)
+		if i < 0 {
+			return nil, fmt.Errorf("%w %q", errUnknownVariant, name)
+		}
+		selected = append(selected, p.variants[i])
+	}
+	return selected, nil
+}
+
+func (p *Pipeline) variantIndex(name string) int {
+	for i, v := range p.variants {
+		if v.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Process checks that src is a JPEG, PNG or WebP image, whatever the client
+// claimed, and renders it and its variants without their metadata. JPEG and
+// PNG originals are re-encoded, which drops EXIF (GPS position, camera
+// serial...) along with every other metadata; JPEGs are rotated upright
+// first, since their EXIF orientation is lost. WebP can't be re-encoded in
+// pure Go so its metadata chunks are cut out instead.
+func (p *Pipeline) Process(src io.ReadSeeker, variants []Variant) (*Processed, error) {
+	// the marker segments holding EXIF come first and are at most 64KB each
+	head := make([]byte, 128<<10)
+	n, err := io.ReadFull(src, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	head = head[:n]
+
+	contentType := http.DetectContentType(head)
+	if _, ok := extensions[contentType]; !ok {
+		return nil, errUnsupportedType
+	}
+
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	cfg, _, err := image.DecodeConfig(bufio.NewReader(src))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidImage, err)
+	}
+	if cfg.Width < 1 || cfg.Height < 1 || cfg.Width*cfg.Height > p.maxPixels {
+		return nil, errTooManyPixels
+	}
+
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bufio.NewReader(src))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidImage, err)
+	}
+
+	out := &Processed{ContentType: contentType}
+	switch contentType {
+	case typeJPEG:
+		img = orient(img, jpegOrientation(head))
+		out.Data, err = p.encode(img, typeJPEG)
+	case typePNG:
+		out.Data, err = p.encode(img, typePNG)
+	case typeWebP:
+		var content []byte
+		if _, err = src.Seek(0, io.SeekStart); err == nil {
+			content, err = io.ReadAll(src)
+		}
+		if err == nil {
+			out.Data, err = stripWebPMetadata(content)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	out.Width, out.Height = img.Bounds().Dx(), img.Bounds().Dy()
+
+	variantType := contentType
+	if variantType == typeWebP {
+		variantType = typeJPEG
+		if !isOpaque(img) {
+			variantType = typePNG
+		}
+	}
+
+	for _, v := range variants {
+		resized := resize(img, v)
+		data, err := p.encode(resized, variantType)
+		if err != nil {
+			return nil, fmt.Errorf("variant %s: %w", v.Name, err)
+		}
+		out.Variants = append(out.Variants, ProcessedVariant{
+			Name:        v.Name,
+			ContentType: variantType,
+			Width:       resized.Bounds().Dx(),
+			Height:      resized.Bounds().Dy(),
+			Data:        data,
+		})
+	}
+
+	return out, nil
+}
+
+func (p *Pipeline) encode(img image.Image, contentType string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	if contentType == typePNG {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: p.jpegQuality})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("encode %s: %w", contentType, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// resize scales img down to v, never up: an image smaller than the box keeps
+// its size, cropped to the box's aspect ratio when covering.
+func resize(img image.Image, v Variant) image.Image {
+	b := img.Bounds()
+	w, h := float64(b.Dx()), float64(b.Dy())
+	bw, bh := float64(v.Width), float64(v.Height)
+
+	src := b
+	var dw, dh float64
+	if v.Cover {
+		scale := math.Max(bw/w, bh/h)
+		cw, ch := math.Min(w, math.Round(bw/scale)), math.Min(h, math.Round(bh/scale))
+		x0 := b.Min.X + int(w-cw)/2
+		y0 := b.Min.Y + int(h-ch)/2
+		src = image.Rect(x0, y0, x0+int(cw), y0+int(ch))
+		dw, dh = bw, bh
+		if scale > 1 {
+			dw, dh = cw, ch
+		}
+	} else {
+		scale := math.Min(1, math.Min(bw/w, bh/h))
+		dw, dh = math.Max(1, math.Round(w*scale)), math.Max(1, math.Round(h*scale))
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, int(dw), int(dh)))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, src, draw.Src, nil)
+	return dst
+}
+
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
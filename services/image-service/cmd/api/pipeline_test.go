@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+// exifTIFF is the EXIF of a phone photo: the camera make, the orientation and
+// a GPS position
+func exifTIFF(orientation uint16) []byte {
+	be := binary.BigEndian
+	entry := func(tag, typ uint16, count, value uint32) []byte {
+		e := make([]byte, 12)
+		be.PutUint16(e, tag)
+		be.PutUint16(e[2:], typ)
+		be.PutUint32(e[4:], count)
+		be.PutUint32(e[8:], value)
+		return e
+	}
+	// offsets from the start of the TIFF header
+	const gpsIFD, latitude, cameraMake = 50, 80, 104
+
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+
+	// IFD0: make, orientation (a SHORT, in the first bytes of the value) and
+	// the pointer to the GPS IFD
+	tiff = be.AppendUint16(tiff, 3)
+	tiff = append(tiff, entry(0x010F, 2, 10, cameraMake)...)
+	o := entry(0x0112, 3, 1, 0)
+	be.PutUint16(o[8:], orientation)
+	tiff = append(tiff, o...)
+	tiff = append(tiff, entry(0x8825, 4, 1, gpsIFD)...)
+	tiff = be.AppendUint32(tiff, 0)
+
+	// GPS IFD: 48°51'30" N
+	tiff = be.AppendUint16(tiff, 2)
+	ref := entry(0x0001, 2, 2, 0)
+	copy(ref[8:], "N\x00")
+	tiff = append(tiff, ref...)
+	tiff = append(tiff, entry(0x0002, 5, 3, latitude)...)
+	tiff = be.AppendUint32(tiff, 0)
+	for _, v := range []uint32{48, 1, 51, 1, 30, 1} {
+		tiff = be.AppendUint32(tiff, v)
+	}
+
+	return append(tiff, "SecretCam\x00"...)
+}
+
+// jpegFixture is a 32x16 photo, red on the left and blue on the right, with
+// the EXIF of exifTIFF
+func jpegFixture(t *testing.T, orientation uint16) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, 32, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 32; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= 16 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+
+	payload := append([]byte("Exif\x00\x00"), exifTIFF(orientation)...)
+	app1 := binary.BigEndian.AppendUint16([]byte{0xFF, 0xE1}, uint16(len(payload)+2))
+	app1 = append(app1, payload...)
+
+	// right after the start of image marker
+	b := buf.Bytes()
+	out := append([]byte{}, b[:2]...)
+	out = append(out, app1...)
+	return append(out, b[2:]...)
+}
+
+// jpegMarkers lists the marker segments of a JPEG up to its image data
+func jpegMarkers(b []byte) []byte {
+	var markers []byte
+	for i := 2; i+4 <= len(b) && b[i] == 0xFF; {
+		markers = append(markers, b[i+1])
+		if b[i+1] == 0xDA {
+			break
+		}
+		i += 2 + int(binary.BigEndian.Uint16(b[i+2:]))
+	}
+	return markers
+}
+
+// webpChunk is a RIFF chunk, padded to an even size
+func webpChunk(fourCC string, payload []byte) []byte {
+	c := binary.LittleEndian.AppendUint32([]byte(fourCC), uint32(len(payload)))
+	c = append(c, payload...)
+	if len(payload)%2 == 1 {
+		c = append(c, 0)
+	}
+	return c
+}
+
+// webpFlagICC announces a color profile in VP8X
+const webpFlagICC = 0x20
+
+// webpLossless is the VP8L bitstream of a transparent 1x1 image
+var webpLossless = []byte("\x2f\x00\x00\x00\x10\x07\x10\x11\x11\x88\x88\xfe\x07")
+
+// webpFixture is an extended WebP with a color profile, announcing and
+// carrying EXIF and XMP chunks, the XMP one of an odd size
+func webpFixture() []byte {
+	vp8x := make([]byte, 10) // flags, reserved, then a 1x1 canvas
+	vp8x[0] = webpFlagICC | webpFlagEXIF | webpFlagXMP
+
+	body := []byte("WEBP")
+	body = append(body, webpChunk("VP8X", vp8x)...)
+	body = append(body, webpChunk("ICCP", []byte("sRGB profile"))...)
+	body = append(body, webpChunk("VP8L", webpLossless)...)
+	body = append(body, webpChunk("EXIF", exifTIFF(6))...)
+	body = append(body, webpChunk("XMP ", []byte("<x:xmpmeta>SecretCam 48.85N</x:xmpmeta>"))...)
+
+	riff := binary.LittleEndian.AppendUint32([]byte("RIFF"), uint32(len(body)))
+	return append(riff, body...)
+}
+
+// webpChunks lists the chunks of a WebP file
+func webpChunks(t *testing.T, b []byte) []string {
+	t.Helper()
+	if got := int(binary.LittleEndian.Uint32(b[4:])); got != len(b)-8 {
+		t.Fatalf("RIFF size %d for %d bytes", got, len(b))
+	}
+	var chunks []string
+	for i := 12; i+8 <= len(b); {
+		size := int(binary.LittleEndian.Uint32(b[i+4:]))
+		chunks = append(chunks, string(b[i:i+4]))
+		i += 8 + size + size%2
+	}
+	return chunks
+}
+
+// pngFixture is an 8x8 PNG with a tEXt chunk
+func pngFixture(t *testing.T) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+
+	text := []byte("tEXtComment\x00SecretCam")
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(text)-4))
+	chunk = append(chunk, text...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(text))
+
+	// after the signature and IHDR
+	b := buf.Bytes()
+	out := append([]byte{}, b[:33]...)
+	out = append(out, chunk...)
+	return append(out, b[33:]...)
+}
+
+func gifFixture(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := gif.Encode(&buf, image.NewPaletted(image.Rect(0, 0, 4, 4), color.Palette{color.Black}), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func newTestPipeline() *Pipeline {
+	return &Pipeline{
+		variants:    []Variant{{Name: "preview", Width: 16, Height: 16}},
+		maxPixels:   10_000,
+		jpegQuality: 90,
+	}
+}
+
+func TestProcessSniffsType(t *testing.T) {
+	photo := jpegFixture(t, 1)
+	tests := []struct {
+		name        string
+		src         []byte
+		contentType string
+		err         error
+	}{
+		{"jpeg", photo, typeJPEG, nil},
+		{"png", pngFixture(t), typePNG, nil},
+		{"webp", webpFixture(), typeWebP, nil},
+		{"gif", gifFixture(t), "", errUnsupportedType},
+		{"html", []byte("<!DOCTYPE html><html><body><img src=x></body></html>"), "", errUnsupportedType},
+		{"empty", nil, "", errUnsupportedType},
+		{"truncated jpeg", photo[:len(photo)/2], "", errInvalidImage},
+		{"jpeg signature only", photo[:3], "", errInvalidImage},
+	}
+	p := newTestPipeline()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := p.Process(bytes.NewReader(tt.src), p.variants)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("Process = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out.ContentType != tt.contentType {
+				t.Fatalf("content type %s, want %s", out.ContentType, tt.contentType)
+			}
+			// the original and its variants are images of their type
+			for _, data := range [][]byte{out.Data, out.Variants[0].Data} {
+				if _, _, err := image.Decode(bytes.NewReader(data)); err != nil {
+					t.Fatalf("processed image doesn't decode: %v", err)
+				}
+			}
+			if bytes.Contains(out.Data, []byte("SecretCam")) {
+				t.Fatal("metadata kept in the original")
+			}
+		})
+	}
+
+	p.maxPixels = 100
+	if _, err := p.Process(bytes.NewReader(photo), nil); !errors.Is(err, errTooManyPixels) {
+		t.Fatalf("Process of 512 pixels over a limit of 100 = %v", err)
+	}
+}
+
+func TestProcessJPEGOrientation(t *testing.T) {
+	isRed := func(c color.Color) bool {
+		r, g, b, _ := c.RGBA()
+		return r > 0xC000 && g < 0x4000 && b < 0x4000
+	}
+	isBlue := func(c color.Color) bool {
+		r, g, b, _ := c.RGBA()
+		return b > 0xC000 && r < 0x4000 && g < 0x4000
+	}
+
+	tests := []struct {
+		orientation   uint16
+		width, height int
+		red, blue     image.Point // once upright
+		preview       image.Point // size of the 16x16 preview
+	}{
+		{1, 32, 16, image.Pt(4, 8), image.Pt(28, 8), image.Pt(16, 8)},
+		{3, 32, 16, image.Pt(28, 8), image.Pt(4, 8), image.Pt(16, 8)},
+		{6, 16, 32, image.Pt(8, 4), image.Pt(8, 28), image.Pt(8, 16)},
+		{8, 16, 32, image.Pt(8, 28), image.Pt(8, 4), image.Pt(8, 16)},
+	}
+	p := newTestPipeline()
+	for _, tt := range tests {
+		photo := jpegFixture(t, tt.orientation)
+		if jpegOrientation(photo) != int(tt.orientation) || !bytes.Contains(photo, []byte("SecretCam")) {
+			t.Fatalf("fixture of orientation %d has no EXIF", tt.orientation)
+		}
+
+		out, err := p.Process(bytes.NewReader(photo), p.variants)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		img, err := jpeg.Decode(bytes.NewReader(out.Data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := img.Bounds(); out.Width != tt.width || out.Height != tt.height || b.Dx() != tt.width || b.Dy() != tt.height {
+			t.Fatalf("orientation %d: %dx%d, stored as %v; want %dx%d", tt.orientation, out.Width, out.Height, b, tt.width, tt.height)
+		}
+		if !isRed(img.At(tt.red.X, tt.red.Y)) || !isBlue(img.At(tt.blue.X, tt.blue.Y)) {
+			t.Fatalf("orientation %d: not upright, %v at %v and %v at %v", tt.orientation,
+				img.At(tt.red.X, tt.red.Y), tt.red, img.At(tt.blue.X, tt.blue.Y), tt.blue)
+		}
+		if v := out.Variants[0]; v.Width != tt.preview.X || v.Height != tt.preview.Y {
+			t.Fatalf("orientation %d: preview %dx%d, want %v", tt.orientation, v.Width, v.Height, tt.preview)
+		}
+
+		// the upright copy says nothing of its orientation, position or camera
+		if jpegOrientation(out.Data) != 1 {
+			t.Fatalf("orientation %d kept", tt.orientation)
+		}
+		for _, m := range jpegMarkers(out.Data) {
+			if m >= 0xE0 && m <= 0xEF || m == 0xFE {
+				t.Fatalf("orientation %d: application or comment segment %#x kept", tt.orientation, m)
+			}
+		}
+		if bytes.Contains(out.Data, []byte("Exif")) || bytes.Contains(out.Data, []byte("SecretCam")) {
+			t.Fatalf("orientation %d: EXIF kept", tt.orientation)
+		}
+	}
+}
+
+func TestProcessWebPMetadata(t *testing.T) {
+	src := webpFixture()
+	if got := webpChunks(t, src); len(got) != 5 {
+		t.Fatalf("fixture chunks %q", got)
+	}
+
+	p := newTestPipeline()
+	out, err := p.Process(bytes.NewReader(src), p.variants)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.Join(webpChunks(t, out.Data), ","); got != "VP8X,ICCP,VP8L" {
+		t.Fatalf("chunks %s, want VP8X,ICCP,VP8L", got)
+	}
+	if flags := out.Data[20]; flags != webpFlagICC {
+		t.Fatalf("VP8X flags %#x, want only the color profile", flags)
+	}
+	if !bytes.Contains(out.Data, webpChunk("ICCP", []byte("sRGB profile"))) || !bytes.Contains(out.Data, webpChunk("VP8L", webpLossless)) {
+		t.Fatal("color profile or image data changed")
+	}
+	if bytes.Contains(out.Data, []byte("SecretCam")) || bytes.Contains(out.Data, []byte("xmpmeta")) {
+		t.Fatal("metadata kept")
+	}
+	if _, _, err := image.Decode(bytes.NewReader(out.Data)); err != nil {
+		t.Fatalf("stripped WebP doesn't decode: %v", err)
+	}
+
+	// transparent, so its variants are PNG
+	if out.Width != 1 || out.Height != 1 || out.Variants[0].ContentType != typePNG {
+		t.Fatalf("processed %dx%d, variant %s", out.Width, out.Height, out.Variants[0].ContentType)
+	}
+}
+
+func TestStripWebPMetadata(t *testing.T) {
+	src := webpFixture()
+	unpadded := src[:len(src)-1] // the XMP chunk is odd sized
+	binary.LittleEndian.PutUint32(unpadded[4:], uint32(len(unpadded)-8))
+
+	tests := []struct {
+		name   string
+		src    []byte
+		chunks int
+		err    error
+	}{
+		{"extended", src, 3, nil},
+		{"simple", append(binary.LittleEndian.AppendUint32([]byte("RIFF"), 4+8+14), append([]byte("WEBP"), webpChunk("VP8L", webpLossless)...)...), 1, nil},
+		{"last chunk unpadded", unpadded, 3, nil},
+		{"not RIFF", append([]byte("RIFX"), src[4:]...), 0, errInvalidWebP},
+		{"truncated chunk", src[:40], 0, errInvalidWebP},
+		{"truncated header", src[:16], 0, errInvalidWebP},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := stripWebPMetadata(tt.src)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("stripWebPMetadata = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := webpChunks(t, out); len(got) != tt.chunks {
+				t.Fatalf("chunks %q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+
+	"ride-sharing/shared/logging"
+)
+
+func (app *Config) routes() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	})
+
+	// Public images, and private ones to the bearer of their owner's token
+	mux.HandleFunc("GET /images/{key...}", app.ServeImage)
+
+	// The signed URLs of the local store; S3 serves its presigned ones
+	if app.Local != nil {
+		mux.HandleFunc("GET /blobs/{key...}", app.ServeBlob)
+	}
+
+	// Multipart upload, and resumable uploads in several requests
+	mux.HandleFunc("POST /upload", app.Upload)
+	mux.HandleFunc("POST /upload/sessions", app.CreateUploadSession)
+	mux.HandleFunc("HEAD /upload/sessions/{id}", app.UploadSessionOffset)
+	mux.HandleFunc("GET /upload/sessions/{id}", app.GetUploadSession)
+	mux.HandleFunc("PATCH /upload/sessions/{id}", app.AppendUploadSession)
+	mux.HandleFunc("DELETE /upload/sessions/{id}", app.CancelUploadSession)
+
+	return logging.Middleware(mux)
+}
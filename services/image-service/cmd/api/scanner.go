@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"image-service/data"
+	"ride-sharing/shared/env"
+	"ride-sharing/shared/scheduler"
+)
+
+const (
+	// scanBatch bounds the images a rescan goes through
+	scanBatch   = 100
+	scanTimeout = 10 * time.Minute
+)
+
+var (
+	errQuarantined = errors.New("image is quarantined until its scan is clean")
+	errInfected    = errors.New("upload is infected")
+)
+
+// Scanner looks for malware in the content of uploads.
+type Scanner interface {
+	// Scan reads r and returns the verdict, clean or infected; an error is
+	// the error verdict
+	Scan(ctx context.Context, r io.Reader) (*ScanResult, error)
+	// Name identifies the scanner in the scans recorded
+	Name() string
+}
+
+// ScanResult is what a Scanner found.
+type ScanResult struct {
+	Verdict   string // data.ScanClean or data.ScanInfected
+	Signature string // of the malware found, when infected
+}
+
+// newScanner returns the scanner of IMAGE_SCANNER: clamav for a ClamAV
+// daemon at IMAGE_CLAMAV_ADDR, or none.
+func newScanner() (Scanner, error) {
+	switch name := strings.ToLower(os.Getenv("IMAGE_SCANNER")); name {
+	case "", "none":
+		log.Println("IMAGE_SCANNER is not set, uploads are not scanned")
+		return noopScanner{}, nil
+	case "clamav":
+		return &clamdScanner{
+			addr:    env.GetString("IMAGE_CLAMAV_ADDR", "clamav:3310"),
+			timeout: env.GetDuration("IMAGE_CLAMAV_TIMEOUT", time.Minute),
+		}, nil
+	default:
+		return nil, fmt.Errorf("IMAGE_SCANNER: unknown scanner %q, expected clamav or none", name)
+	}
+}
+
+// noopScanner finds every upload clean, for development.
+type noopScanner struct{}
+
+func (noopScanner) Scan(ctx context.Context, r io.Reader) (*ScanResult, error) {
+	return &ScanResult{Verdict: data.ScanClean}, nil
+}
+
+func (noopScanner) Name() string {
+	return "none"
+}
+
+// scan scans the content of the image f and records the verdict on it and
+// its variants, which are rendered from it. A failed scan is the error
+// verdict, retried by Rescan.
+func (u *Uploads) scan(ctx context.Context, f *data.File) *data.Scan {
+	now := time.Now().UTC()
+	scan := &data.Scan{Scanner: u.scanner.Name(), ScannedAt: &now}
+
+	result, err := u.scanBlob(ctx, f.Blob)
+	if err != nil {
+		log.Printf("scan %s: %v", f.Key, err)
+		scan.Verdict, scan.Detail = data.ScanError, err.Error()
+	} else {
+		scan.Verdict, scan.Signature = result.Verdict, result.Signature
+	}
+	if scan.Verdict == data.ScanInfected {
+		log.Printf("quarantined %s of %s: infected with %s", f.Key, f.Owner, scan.Signature)
+	}
+
+	if _, err := u.models.Files.SetScan(context.WithoutCancel(ctx), f.Key, f.SHA256, *scan); err != nil {
+		log.Printf("record scan of %s: %v", f.Key, err)
+	}
+	return scan
+}
+
+func (u *Uploads) scanBlob(ctx context.Context, key string) (*ScanResult, error) {
+	r, _, err := u.store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	result, err := u.scanner.Scan(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	if result.Verdict != data.ScanClean && result.Verdict != data.ScanInfected {
+		return nil, fmt.Errorf("scanner %s: unexpected verdict %q", u.scanner.Name(), result.Verdict)
+	}
+	return result, nil
+}
+
+// awaitScan scans the image just committed, waiting IMAGE_SCAN_WAIT at most
+// for the verdict; the scan goes on after that, and its verdict is pending
+// meanwhile.
+func (u *Uploads) awaitScan(ctx context.Context, f *data.File) *data.Scan {
+	done := make(chan *data.Scan, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), scanTimeout)
+		defer cancel()
+		done <- u.scan(ctx, f)
+	}()
+
+	select {
+	case scan := <-done:
+		return scan
+	case <-time.After(u.scanWait):
+		return &data.Scan{Verdict: data.ScanPending}
+	case <-ctx.Done():
+		return &data.Scan{Verdict: data.ScanPending}
+	}
+}
+
+// Rescan scans the images whose scan never finished, because the replica
+// died or the wait ran out, and retries the failed ones, every
+// IMAGE_SCAN_INTERVAL.
+type Rescan struct {
+	uploads  *Uploads
+	interval time.Duration
+}
+
+func NewRescan(uploads *Uploads) *Rescan {
+	return &Rescan{
+		uploads:  uploads,
+		interval: env.GetDuration("IMAGE_SCAN_INTERVAL", time.Minute),
+	}
+}
+
+// Schedule adds the rescans to jobs as a singleton job.
+func (r *Rescan) Schedule(jobs *scheduler.Scheduler) error {
+	return jobs.Add(scheduler.Job{
+		Name:      "image.rescan",
+		Schedule:  scheduler.Every(r.interval),
+		Timeout:   scanTimeout,
+		Singleton: true,
+		Run:       r.Run,
+	})
+}
+
+// Run scans a batch of the images left unscanned for an interval.
+func (r *Rescan) Run(ctx context.Context) error {
+	files, err := r.uploads.models.Files.Unscanned(ctx, time.Now().Add(-r.interval), scanBatch)
+	if err != nil {
+		return err
+	}
+
+	counts := map[string]int{}
+	for _, f := range files {
+		if ctx.Err() != nil {
+			break
+		}
+		counts[r.uploads.scan(ctx, f).Verdict]++
+	}
+	if len(files) > 0 {
+		log.Printf("rescanned %d image(s): %d clean, %d infected, %d failed", len(files),
+			counts[data.ScanClean], counts[data.ScanInfected], counts[data.ScanError])
+	}
+	return ctx.Err()
+}
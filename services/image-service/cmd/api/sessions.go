@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ride-sharing/shared/env"
+
+	imagepb "ride-sharing/shared/generated/image"
+)
+
+var (
+	errSessionNotFound = errors.New("upload session not found")
+	errOffsetMismatch  = errors.New("Upload-Offset differs from the bytes received")
+	errSizeRequired    = errors.New("size is required for a resumable upload")
+)
+
+// Session is a resumable upload: its info, declared up front, and the bytes
+// received so far.
+type Session struct {
+	ID        string     `json:"id"`
+	Info      UploadInfo `json:"info"`
+	CreatedAt time.Time  `json:"createdAt"`
+	// Offset is the number of bytes received, the size of the parts
+	Offset int64 `json:"offset"`
+}
+
+// Sessions keeps the resumable uploads in the BlobStore, under
+// .sessions/<id>/: info.json and one part per request that sent content,
+// named after its offset. Any replica can resume a session, and so can the
+// same one after a restart.
+type Sessions struct {
+	uploads *Uploads
+	ttl     time.Duration
+
+	// one appender at a time per session, on this replica; across replicas
+	// the parts are named after their offset, so a replayed request
+	// overwrites its own part
+	mu    sync.Mutex
+	locks map[string]*sessionLock
+}
+
+// NewSessions reads how long an unfinished upload is kept from
+// IMAGE_UPLOAD_SESSION_TTL.
+func NewSessions(uploads *Uploads) *Sessions {
+	return &Sessions{
+		uploads: uploads,
+		ttl:     env.GetDuration("IMAGE_UPLOAD_SESSION_TTL", 24*time.Hour),
+		locks:   map[string]*sessionLock{},
+	}
+}
+
+func sessionKey(id, name string) string {
+	return path.Join(sessionsFolder, id, name)
+}
+
+func partName(offset int64) string {
+	return fmt.Sprintf("part-%020d", offset)
+}
+
+// Create starts a resumable upload of who. Its size must be known, so it can
+// be checked against the limits before any byte is sent.
+func (s *Sessions) Create(ctx context.Context, who *Principal, info UploadInfo) (*Session, error) {
+	if err := s.uploads.Prepare(who, &info); err != nil {
+		return nil, err
+	}
+	if info.Size == 0 {
+		return nil, errSizeRequired
+	}
+	if err := s.uploads.checkQuota(ctx, info.Folder, info.Size); err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	session := &Session{ID: hex.EncodeToString(id), Info: info, CreatedAt: time.Now().UTC()}
+
+	meta, err := json.Marshal(session)
+	if err != nil {
+		return nil, err
+	}
+	err = s.uploads.store.Put(ctx, sessionKey(session.ID, "info.json"), bytes.NewReader(meta), int64(len(meta)), "application/json")
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// Get returns the session with id and how much of it was received. Only the
+// owner of the upload and the admins see a session.
+func (s *Sessions) Get(ctx context.Context, who *Principal, id string) (*Session, error) {
+	session, _, err := s.get(ctx, who, id)
+	return session, err
+}
+
+// get also returns the keys of the parts, in order
+func (s *Sessions) get(ctx context.Context, who *Principal, id string) (*Session, []string, error) {
+	if !validSessionID(id) {
+		return nil, nil, errSessionNotFound
+	}
+
+	r, _, err := s.uploads.store.Get(ctx, sessionKey(id, "info.json"))
+	if errors.Is(err, errBlobNotFound) {
+		return nil, nil, errSessionNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer r.Close()
+
+	var session Session
+	if err := json.NewDecoder(r).Decode(&session); err != nil {
+		return nil, nil, err
+	}
+	if !who.owns(session.Info.Owner) {
+		return nil, nil, errSessionNotFound
+	}
+
+	parts := map[string]int64{}
+	err = s.uploads.store.List(ctx, path.Join(sessionsFolder, id), func(b BlobInfo) error {
+		if name := path.Base(b.Key); strings.HasPrefix(name, "part-") {
+			parts[name] = b.Size
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Parts follow each other; a part that doesn't start where the previous
+	// ended was overtaken by a retry and is ignored
+	names := make([]string, 0, len(parts))
+	for name := range parts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var keys []string
+	for _, name := range names {
+		if name == partName(session.Offset) && parts[name] > 0 {
+			keys = append(keys, sessionKey(id, name))
+			session.Offset += parts[name]
+		}
+	}
+	return &session, keys, nil
+}
+
+// Append stores the bytes of r at offset, which must be where the previous
+// request stopped. Once all the declared bytes are in, the upload is
+// committed and its response returned; until then the response is nil.
+// After a failed commit the session is gone and the upload starts over.
+func (s *Sessions) Append(ctx context.Context, who *Principal, id string, offset int64, r io.Reader) (*Session, *imagepb.UploadResponse, error) {
+	unlock := s.lock(id)
+	defer unlock()
+
+	session, parts, err := s.get(ctx, who, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if offset != session.Offset {
+		return session, nil, errOffsetMismatch
+	}
+
+	// Receive into a temp file first, the store needs the size; what a
+	// broken connection delivered before failing is kept, the client
+	// resumes from there
+	tmp, err := os.CreateTemp(s.uploads.tmpDir, "part-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer discard(tmp)
+	n, copyErr := io.Copy(tmp, io.LimitReader(r, session.Info.Size-session.Offset))
+	if n > 0 {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return nil, nil, err
+		}
+		key := sessionKey(id, partName(offset))
+		if err := s.uploads.store.Put(ctx, key, tmp, n, "application/octet-stream"); err != nil {
+			return nil, nil, err
+		}
+		session.Offset += n
+		parts = append(parts, key)
+	}
+	if copyErr != nil {
+		return session, nil, copyErr
+	}
+	if session.Offset < session.Info.Size {
+		return session, nil, nil
+	}
+
+	upload, err := s.assemble(ctx, parts)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer s.remove(context.WithoutCancel(ctx), id)
+	resp, err := s.uploads.Commit(ctx, who, session.Info, upload)
+	if err != nil {
+		return nil, nil, err
+	}
+	return session, resp, nil
+}
+
+// assemble concatenates the parts into a temp file for Commit
+func (s *Sessions) assemble(ctx context.Context, parts []string) (*os.File, error) {
+	f, err := os.CreateTemp(s.uploads.tmpDir, "upload-*")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range parts {
+		r, _, err := s.uploads.store.Get(ctx, key)
+		if err != nil {
+			discard(f)
+			return nil, err
+		}
+		_, err = io.Copy(f, r)
+		r.Close()
+		if err != nil {
+			discard(f)
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// Cancel drops an unfinished upload.
+func (s *Sessions) Cancel(ctx context.Context, who *Principal, id string) error {
+	unlock := s.lock(id)
+	defer unlock()
+
+	if _, _, err := s.get(ctx, who, id); err != nil {
+		return err
+	}
+	return s.remove(ctx, id)
+}
+
+// Expire drops the sessions older than the TTL every interval until done is
+// closed.
+func (s *Sessions) Expire(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			s.expire(context.Background())
+		}
+	}
+}
+
+func (s *Sessions) expire(ctx context.Context) {
+	created := map[string]time.Time{}
+	err := s.uploads.store.List(ctx, sessionsFolder, func(b BlobInfo) error {
+		id := path.Base(path.Dir(b.Key))
+		if t, ok := created[id]; !ok || b.ModTime.Before(t) {
+			created[id] = b.ModTime
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("expire upload sessions: %v", err)
+		return
+	}
+
+	for id, t := range created {
+		if time.Since(t) < s.ttl {
+			continue
+		}
+		unlock := s.lock(id)
+		if err := s.remove(ctx, id); err != nil {
+			log.Printf("expire upload session %s: %v", id, err)
+		}
+		unlock()
+	}
+}
+
+// remove deletes every blob of session id
+func (s *Sessions) remove(ctx context.Context, id string) error {
+	var keys []string
+	err := s.uploads.store.List(ctx, path.Join(sessionsFolder, id), func(b BlobInfo) error {
+		keys = append(keys, b.Key)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, key := range keys {
+		errs = append(errs, s.uploads.store.Delete(ctx, key))
+	}
+	return errors.Join(errs...)
+}
+
+// lock takes the lock of session id and returns its release
+func (s *Sessions) lock(id string) func() {
+	s.mu.Lock()
+	l, ok := s.locks[id]
+	if !ok {
+		l = &sessionLock{}
+		s.locks[id] = l
+	}
+	l.refs++
+	s.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+
+		s.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(s.locks, id)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// sessionLock counts its holders and waiters, so it is dropped with the last
+type sessionLock struct {
+	sync.Mutex
+	refs int
+}
+
+func validSessionID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
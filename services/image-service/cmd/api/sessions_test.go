@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"image-service/data"
+)
+
+func newTestSessions(t *testing.T) *Sessions {
+	t.Helper()
+	return &Sessions{uploads: newTestUploads(t), ttl: time.Hour, locks: map[string]*sessionLock{}}
+}
+
+// sessionBlobs returns the keys stored for session id
+func sessionBlobs(t *testing.T, s *Sessions, id string) []string {
+	t.Helper()
+	var keys []string
+	err := s.uploads.store.List(context.Background(), path.Join(sessionsFolder, id), func(b BlobInfo) error {
+		keys = append(keys, b.Key)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestSessionResume(t *testing.T) {
+	s := newTestSessions(t)
+	ctx := context.Background()
+	alice := &Principal{UserID: "alice"}
+	photo := jpegFixture(t, 6)
+	third := int64(len(photo) / 3)
+
+	session, err := s.Create(ctx, alice, UploadInfo{Folder: "trips/1", FileName: "receipt.jpg", Size: int64(len(photo)), SHA256: sha256Hex(photo)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := session.ID
+
+	session, resp, err := s.Append(ctx, alice, id, 0, bytes.NewReader(photo[:third]))
+	if err != nil || resp != nil || session.Offset != third {
+		t.Fatalf("first chunk: offset %d, response %v, %v", session.Offset, resp, err)
+	}
+
+	// chunks must come in order, without gaps or overlaps
+	for _, offset := range []int64{2 * third, third - 1, 0} {
+		session, _, err := s.Append(ctx, alice, id, offset, bytes.NewReader(photo[offset:]))
+		if !errors.Is(err, errOffsetMismatch) || session.Offset != third {
+			t.Fatalf("chunk at %d: offset %d, %v", offset, session.Offset, err)
+		}
+	}
+
+	// only the owner and the admins see the session
+	bob := &Principal{UserID: "bob"}
+	if _, _, err := s.Append(ctx, bob, id, third, bytes.NewReader(photo[third:])); !errors.Is(err, errSessionNotFound) {
+		t.Fatalf("append of another user: %v", err)
+	}
+	if _, err := s.Get(ctx, bob, id); !errors.Is(err, errSessionNotFound) {
+		t.Fatalf("get of another user: %v", err)
+	}
+	if session, err := s.Get(ctx, &Principal{UserID: "root", Admin: true}, id); err != nil || session.Offset != third {
+		t.Fatalf("get of an admin: %v, %v", session, err)
+	}
+
+	// a broken connection keeps what it delivered
+	broken := io.MultiReader(bytes.NewReader(photo[third:2*third]), iotest.ErrReader(errors.New("connection reset")))
+	if _, _, err := s.Append(ctx, alice, id, third, broken); err == nil || err.Error() != "connection reset" {
+		t.Fatalf("broken chunk: %v", err)
+	}
+	session, err = s.Get(ctx, alice, id)
+	if err != nil || session.Offset != 2*third {
+		t.Fatalf("resumed at %v, %v", session, err)
+	}
+
+	// the last chunk commits the upload and drops the session
+	session, resp, err = s.Append(ctx, alice, id, session.Offset, bytes.NewReader(photo[session.Offset:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Offset != int64(len(photo)) || resp.Path != "trips/1/receipt.jpg" || resp.Sha256 != sha256Hex(photo) ||
+		resp.Width != 16 || resp.Height != 32 {
+		t.Fatalf("committed %+v at offset %d", resp, session.Offset)
+	}
+	if _, err := s.uploads.models.Files.Get(ctx, "trips/1/receipt.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ctx, alice, id); !errors.Is(err, errSessionNotFound) {
+		t.Fatalf("session after commit: %v", err)
+	}
+	if keys := sessionBlobs(t, s, id); len(keys) != 0 {
+		t.Fatalf("blobs left %v", keys)
+	}
+}
+
+func TestSessionChecksumMismatch(t *testing.T) {
+	s := newTestSessions(t)
+	ctx := context.Background()
+	alice := &Principal{UserID: "alice"}
+	photo := jpegFixture(t, 1)
+
+	session, err := s.Create(ctx, alice, UploadInfo{Folder: "trips/1", FileName: "receipt.jpg", Size: int64(len(photo)), SHA256: sha256Hex([]byte("another photo"))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Append(ctx, alice, session.ID, 0, bytes.NewReader(photo)); !errors.Is(err, errChecksumMismatch) {
+		t.Fatalf("Append = %v, want %v", err, errChecksumMismatch)
+	}
+
+	// the upload starts over, nothing of it is kept
+	if _, err := s.Get(ctx, alice, session.ID); !errors.Is(err, errSessionNotFound) {
+		t.Fatalf("session after the mismatch: %v", err)
+	}
+	if keys := sessionBlobs(t, s, session.ID); len(keys) != 0 {
+		t.Fatalf("blobs left %v", keys)
+	}
+	if files, _ := s.uploads.models.Files.List(ctx, data.FileFilter{Variants: true}); len(files) != 0 {
+		t.Fatalf("indexed %d files", len(files))
+	}
+}
+
+func TestSessionCreate(t *testing.T) {
+	s := newTestSessions(t)
+	alice := &Principal{UserID: "alice"}
+
+	tests := []struct {
+		name string
+		info UploadInfo
+		err  error
+	}{
+		{"size known", UploadInfo{Folder: "trips/1", FileName: "a.jpg", Size: 10}, nil},
+		{"size unknown", UploadInfo{Folder: "trips/1", FileName: "a.jpg"}, errSizeRequired},
+		{"too large", UploadInfo{Folder: "trips/1", FileName: "a.jpg", Size: 1<<20 + 1}, errUploadTooLarge},
+		{"for another user", UploadInfo{Folder: "trips/1", FileName: "a.jpg", Size: 10, Owner: "bob"}, errForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.Create(context.Background(), alice, tt.info); !errors.Is(err, tt.err) {
+				t.Fatalf("Create = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	s := newTestSessions(t)
+	ctx := context.Background()
+	alice := &Principal{UserID: "alice"}
+	info := UploadInfo{Folder: "trips/1", FileName: "receipt.jpg", Size: 100}
+
+	stale, err := s.Create(ctx, alice, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Append(ctx, alice, stale.ID, 0, bytes.NewReader(make([]byte, 40))); err != nil {
+		t.Fatal(err)
+	}
+	fresh, err := s.Create(ctx, alice, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the stale session started before the TTL, whatever its last part
+	store := s.uploads.store.(*localStore)
+	meta, err := store.path(sessionKey(stale.ID, "info.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(meta, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	s.expire(ctx)
+
+	if _, err := s.Get(ctx, alice, stale.ID); !errors.Is(err, errSessionNotFound) {
+		t.Fatalf("stale session: %v", err)
+	}
+	if keys := sessionBlobs(t, s, stale.ID); len(keys) != 0 {
+		t.Fatalf("blobs left %v", keys)
+	}
+	if _, err := s.Get(ctx, alice, fresh.ID); err != nil {
+		t.Fatalf("fresh session: %v", err)
+	}
+}
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"image-service/data"
+	"ride-sharing/shared/env"
+
+	imagepb "ride-sharing/shared/generated/image"
+)
+
+// sessionsFolder holds the resumable upload sessions in the store; uploads
+// can't use it since their folder names never start with a dot
+const sessionsFolder = ".sessions"
+
+var (
+	errMissingName      = errors.New("folder and fileName are required")
+	errUploadTooLarge   = errors.New("upload exceeds the maximum size")
+	errQuotaExceeded    = errors.New("folder quota exceeded")
+	errSizeMismatch     = errors.New("received size differs from the declared size")
+	errChecksumMismatch = errors.New("sha256 of the content differs from the declared one")
+	errReservedFolder   = errors.New("the objects folder is reserved")
+)
+
+// UploadInfo describes an upload before its content arrives.
+type UploadInfo struct {
+	Folder   string   `json:"folder"`
+	FileName string   `json:"fileName"`
+	Variants []string `json:"variants,omitempty"`
+	// Visibility is public or private, Owner the user the image is stored
+	// for; Prepare fills them in
+	Visibility string `json:"visibility,omitempty"`
+	Owner      string `json:"owner,omitempty"`
+	// Size and SHA256 are checked against the content when set
+	Size   int64  `json:"size,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// validate sanitizes the names and checks what is known before receiving
+func (info *UploadInfo) validate(maxBytes int64) error {
+	info.Folder = sanitize(info.Folder)
+	info.FileName = sanitize(info.FileName)
+	if info.Folder == "" || info.FileName == "" || hidden(info.Folder) || hidden(info.FileName) {
+		return errMissingName
+	}
+	if info.Folder == objectsFolder || strings.HasPrefix(info.Folder, objectsFolder+"/") {
+		return errReservedFolder
+	}
+	if info.Size < 0 || info.Size > maxBytes {
+		return errUploadTooLarge
+	}
+	info.SHA256 = strings.ToLower(strings.TrimSpace(info.SHA256))
+	return nil
+}
+
+// Prepare validates info before receiving an upload of who, who owns the
+// image unless an admin stores it for another user.
+func (u *Uploads) Prepare(who *Principal, info *UploadInfo) error {
+	if err := info.validate(u.maxBytes); err != nil {
+		return err
+	}
+
+	switch info.Visibility = strings.ToLower(strings.TrimSpace(info.Visibility)); info.Visibility {
+	case "":
+		info.Visibility = u.visibility
+	case visibilityPublic, visibilityPrivate:
+	default:
+		return errInvalidVisibility
+	}
+
+	info.Owner = strings.TrimSpace(info.Owner)
+	if info.Owner == "" {
+		info.Owner = who.UserID
+	} else if !who.Admin && info.Owner != who.UserID {
+		return errForbidden
+	}
+	return nil
+}
+
+// Uploads receives uploads into temporary files and commits them, with
+// their variants, to the BlobStore once processed. The store keeps each
+// content once, the index the files referring to it. A committed upload is
+// quarantined until the scanner finds it clean.
+type Uploads struct {
+	store    BlobStore
+	models   data.Models
+	tmpDir   string
+	pipeline *Pipeline
+	scanner  Scanner
+	scanWait time.Duration
+	maxBytes int64
+	urlTTL   time.Duration
+	quotas   []folderQuota
+	// publicURL is where image-service is reached, for the URLs of the
+	// public images; visibility the one of uploads not choosing
+	publicURL  string
+	visibility string
+
+	// mu serializes the quota checks with the commits of this replica
+	mu sync.Mutex
+}
+
+// folderQuota limits each folder under prefix, "*" being the default
+type folderQuota struct {
+	prefix string
+	bytes  int64
+}
+
+// NewUploads reads IMAGE_TMP_DIR, IMAGE_MAX_UPLOAD_BYTES, IMAGE_URL_TTL,
+// IMAGE_FOLDER_QUOTAS, IMAGE_PUBLIC_URL, IMAGE_DEFAULT_VISIBILITY and
+// IMAGE_SCAN_WAIT.
+func NewUploads(store BlobStore, models data.Models, pipeline *Pipeline, scanner Scanner) (*Uploads, error) {
+	quotas, err := parseQuotas(os.Getenv("IMAGE_FOLDER_QUOTAS"))
+	if err != nil {
+		return nil, err
+	}
+
+	u := &Uploads{
+		store:    store,
+		models:   models,
+		tmpDir:   env.GetString("IMAGE_TMP_DIR", filepath.Join(os.TempDir(), "image-service")),
+		pipeline: pipeline,
+		scanner:  scanner,
+		scanWait: env.GetDuration("IMAGE_SCAN_WAIT", 10*time.Second),
+		maxBytes: int64(env.GetInt("IMAGE_MAX_UPLOAD_BYTES", 25<<20)),
+		urlTTL:   env.GetDuration("IMAGE_URL_TTL", 15*time.Minute),
+		quotas:   quotas,
+
+		publicURL:  strings.TrimRight(os.Getenv("IMAGE_PUBLIC_URL"), "/"),
+		visibility: env.GetString("IMAGE_DEFAULT_VISIBILITY", visibilityPrivate),
+	}
+	if u.visibility != visibilityPublic && u.visibility != visibilityPrivate {
+		return nil, fmt.Errorf("IMAGE_DEFAULT_VISIBILITY: %w", errInvalidVisibility)
+	}
+
+	// whatever is left in the temp dir belongs to a previous process
+	if err := os.RemoveAll(u.tmpDir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(u.tmpDir, 0755); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// parseQuotas reads folder=size pairs, the size in bytes or with a KB, MB or
+// GB suffix. The longest folder prefix matching an upload's folder applies
+// to it, "*" to the folders no other rule matches.
+func parseQuotas(spec string) ([]folderQuota, error) {
+	var quotas []folderQuota
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		folder, size, ok := strings.Cut(item, "=")
+		limit, err := parseBytes(size)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("IMAGE_FOLDER_QUOTAS: %q is not folder=size", item)
+		}
+
+		folder = strings.Trim(strings.TrimSpace(folder), "/")
+		if folder == "*" {
+			folder = ""
+		}
+		quotas = append(quotas, folderQuota{prefix: folder, bytes: limit})
+	}
+	return quotas, nil
+}
+
+func parseBytes(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	unit := int64(1)
+	for suffix, u := range map[string]int64{"KB": 1 << 10, "MB": 1 << 20, "GB": 1 << 30} {
+		if strings.HasSuffix(s, suffix) {
+			s, unit = strings.TrimSpace(strings.TrimSuffix(s, suffix)), u
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * unit, nil
+}
+
+// quota returns the limit of folder, 0 when it has none
+func (u *Uploads) quota(folder string) int64 {
+	var limit int64
+	best := -1
+	for _, q := range u.quotas {
+		if q.prefix != "" && folder != q.prefix && !strings.HasPrefix(folder, q.prefix+"/") {
+			continue
+		}
+		if len(q.prefix) > best {
+			best, limit = len(q.prefix), q.bytes
+		}
+	}
+	return limit
+}
+
+// folderUsage returns the bytes of the files of folder and its subfolders,
+// as indexed, so the uploads of every replica count. A content shared by
+// several files counts for each.
+func (u *Uploads) folderUsage(ctx context.Context, folder string) (int64, error) {
+	return u.models.Files.Usage(ctx, folder)
+}
+
+// checkQuota fails when adding size bytes to folder would exceed its quota
+func (u *Uploads) checkQuota(ctx context.Context, folder string, size int64) error {
+	limit := u.quota(folder)
+	if limit == 0 {
+		return nil
+	}
+
+	used, err := u.folderUsage(ctx, folder)
+	if err != nil {
+		return err
+	}
+	if used+size > limit {
+		return errQuotaExceeded
+	}
+	return nil
+}
+
+// Receive copies the content of an upload into a temporary file, failing as
+// soon as it goes over the maximum size or the folder's quota. The caller
+// passes the file to Commit, which removes it.
+func (u *Uploads) Receive(ctx context.Context, info UploadInfo, r io.Reader) (*os.File, error) {
+	if err := u.checkQuota(ctx, info.Folder, info.Size); err != nil {
+		return nil, err
+	}
+
+	f, err := os.CreateTemp(u.tmpDir, "upload-*")
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := io.Copy(f, io.LimitReader(r, u.maxBytes+1))
+	if err == nil && n > u.maxBytes {
+		err = errUploadTooLarge
+	}
+	if err == nil {
+		err = u.checkQuota(ctx, info.Folder, n)
+	}
+	if err != nil {
+		discard(f)
+		return nil, err
+	}
+	return f, nil
+}
+
+// Commit verifies the received content against info, processes it and
+// stores the image and its variants into the folder, quarantined until
+// scanned. It always removes tmp. An infected upload fails with errInfected;
+// one whose scan is pending or failed has no URLs until the scan is clean.
+// The URLs of a private image are signed and expire after IMAGE_URL_TTL.
+func (u *Uploads) Commit(ctx context.Context, who *Principal, info UploadInfo, tmp *os.File) (*imagepb.UploadResponse, error) {
+	defer discard(tmp)
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(hash, tmp)
+	if err != nil {
+		return nil, err
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if info.Size > 0 && size != info.Size {
+		return nil, errSizeMismatch
+	}
+	if info.SHA256 != "" && sum != info.SHA256 {
+		return nil, errChecksumMismatch
+	}
+
+	variants, err := u.pipeline.Variants(info.Variants)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img, err := u.pipeline.Process(tmp, variants)
+	if err != nil {
+		return nil, err
+	}
+
+	stem := strings.TrimSuffix(info.FileName, filepath.Ext(info.FileName))
+	key := info.Folder + "/" + stem + extensions[img.ContentType]
+	original := &data.File{
+		Key:          key,
+		Folder:       info.Folder,
+		OriginalName: info.FileName,
+		Owner:        info.Owner,
+		Visibility:   info.Visibility,
+		ContentType:  img.ContentType,
+		Size:         int64(len(img.Data)),
+		Width:        img.Width,
+		Height:       img.Height,
+		Scan:         &data.Scan{Verdict: data.ScanPending},
+	}
+
+	// the variants first, so the original never shows up without them
+	var pieces []piece
+	for _, v := range img.Variants {
+		variant := &data.File{
+			Key:          info.Folder + "/" + stem + "_" + v.Name + extensions[v.ContentType],
+			Folder:       info.Folder,
+			OriginalName: info.FileName,
+			Owner:        info.Owner,
+			Visibility:   info.Visibility,
+			ContentType:  v.ContentType,
+			Size:         int64(len(v.Data)),
+			Width:        v.Width,
+			Height:       v.Height,
+			Original:     key,
+			Scan:         &data.Scan{Verdict: data.ScanPending},
+		}
+		original.Variants = append(original.Variants, variant.Key)
+		pieces = append(pieces, piece{file: variant, content: v.Data})
+	}
+	pieces = append(pieces, piece{file: original, content: img.Data})
+
+	if err := u.put(ctx, who, info.Folder, pieces); err != nil {
+		return nil, err
+	}
+
+	resp := &imagepb.UploadResponse{
+		Path:        key,
+		Message:     "uploaded",
+		ContentType: img.ContentType,
+		Width:       int32(img.Width),
+		Height:      int32(img.Height),
+		Size:        size,
+		Sha256:      sum,
+		Owner:       info.Owner,
+		Visibility:  info.Visibility,
+	}
+
+	scan := u.awaitScan(ctx, original)
+	resp.ScanVerdict = scan.Verdict
+	if scan.Verdict == data.ScanInfected {
+		return nil, fmt.Errorf("%w with %s", errInfected, scan.Signature)
+	}
+	clean := scan.Verdict == data.ScanClean
+	if clean {
+		if resp.Url, err = u.URL(ctx, original, u.urlTTL); err != nil {
+			return nil, err
+		}
+	}
+	for i, v := range img.Variants {
+		variant := &imagepb.ImageVariant{
+			Name:        v.Name,
+			Width:       int32(v.Width),
+			Height:      int32(v.Height),
+			ContentType: v.ContentType,
+		}
+		if clean {
+			if variant.Url, err = u.URL(ctx, pieces[i].file, u.urlTTL); err != nil {
+				return nil, err
+			}
+		}
+		resp.Variants = append(resp.Variants, variant)
+	}
+	return resp, nil
+}
+
+// piece is a file of an upload and its content
+type piece struct {
+	file    *data.File
+	content []byte
+}
+
+// put stores the contents of pieces, once per digest, then indexes their
+// files after checking that who may replace the files at their keys and the
+// folder's quota. The files replaced count for their new size only, and
+// release their contents. When one fails, those already indexed are removed
+// again.
+func (u *Uploads) put(ctx context.Context, who *Principal, folder string, pieces []piece) error {
+	var acquired []string
+	releaseFrom := func(i int) {
+		for _, sum := range acquired[i:] {
+			u.release(ctx, sum)
+		}
+	}
+	for _, p := range pieces {
+		obj, err := u.storeObject(ctx, p.content, p.file.ContentType)
+		if err != nil {
+			releaseFrom(0)
+			return err
+		}
+		acquired = append(acquired, obj.SHA256)
+		p.file.SHA256, p.file.Blob = obj.SHA256, obj.Blob
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	// the variants of the images replaced, dropped unless rendered again
+	stale := map[string]bool{}
+	var replaced int64
+	for _, p := range pieces {
+		old, err := u.models.Files.Get(ctx, p.file.Key)
+		if errors.Is(err, data.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			releaseFrom(0)
+			return err
+		}
+		if !who.owns(old.Owner) {
+			releaseFrom(0)
+			return errForbidden
+		}
+		replaced += old.Size
+		for _, key := range old.Variants {
+			stale[key] = true
+		}
+	}
+	for _, p := range pieces {
+		delete(stale, p.file.Key)
+	}
+
+	if limit := u.quota(folder); limit > 0 {
+		used, err := u.folderUsage(ctx, folder)
+		if err != nil {
+			releaseFrom(0)
+			return err
+		}
+		added := -replaced
+		for _, p := range pieces {
+			added += p.file.Size
+		}
+		if used+added > limit {
+			releaseFrom(0)
+			return errQuotaExceeded
+		}
+	}
+
+	for i, p := range pieces {
+		old, err := u.models.Files.Put(ctx, p.file)
+		if err != nil {
+			for _, put := range pieces[:i] {
+				u.remove(context.WithoutCancel(ctx), put.file.Key)
+			}
+			releaseFrom(i)
+			return fmt.Errorf("index %s: %w", p.file.Key, err)
+		}
+		if old != nil {
+			u.release(ctx, old.SHA256)
+		}
+	}
+
+	for key := range stale {
+		if err := u.remove(ctx, key); err != nil {
+			log.Printf("remove replaced variant %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// discard closes and removes a temporary file
+func discard(f *os.File) {
+	f.Close()
+	os.Remove(f.Name())
+}
+
+// hidden reports whether a path has a segment starting with a dot, like the
+// sessions folder
+func hidden(path string) bool {
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, ".") {
+			return true
+		}
+	}
+	return false
+}
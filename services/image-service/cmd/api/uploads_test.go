@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"image-service/data"
+)
+
+// newTestUploads stores in a temp dir and indexes in memory, finding every
+// upload clean
+func newTestUploads(t *testing.T) *Uploads {
+	t.Helper()
+	t.Setenv("IMAGE_URL_SECRET", "test-secret")
+
+	store, err := newLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Uploads{
+		store:      store,
+		models:     data.NewInMemory(),
+		tmpDir:     t.TempDir(),
+		pipeline:   newTestPipeline(),
+		scanner:    noopScanner{},
+		scanWait:   5 * time.Second,
+		maxBytes:   1 << 20,
+		urlTTL:     time.Minute,
+		visibility: visibilityPrivate,
+	}
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// tempUpload is a received upload of content, for Commit
+func tempUpload(t *testing.T, u *Uploads, content []byte) *os.File {
+	t.Helper()
+	f, err := u.Receive(context.Background(), UploadInfo{}, bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestCommitVerifiesContent(t *testing.T) {
+	photo := jpegFixture(t, 1)
+	tests := []struct {
+		name    string
+		content []byte
+		size    int64
+		sha256  string
+		err     error
+	}{
+		{"declared", photo, int64(len(photo)), sha256Hex(photo), nil},
+		{"undeclared", photo, 0, "", nil},
+		{"size mismatch", photo, int64(len(photo)) + 1, "", errSizeMismatch},
+		{"checksum mismatch", photo, 0, sha256Hex([]byte("another photo")), errChecksumMismatch},
+		{"truncated", photo[:len(photo)-10], int64(len(photo)), sha256Hex(photo), errSizeMismatch},
+		{"not an image", []byte("%PDF-1.4"), 0, "", errUnsupportedType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := newTestUploads(t)
+			ctx := context.Background()
+			alice := &Principal{UserID: "alice"}
+
+			info := UploadInfo{Folder: "trips/1", FileName: "receipt.jpg", Size: tt.size, SHA256: tt.sha256}
+			if err := u.Prepare(alice, &info); err != nil {
+				t.Fatal(err)
+			}
+			tmp := tempUpload(t, u, tt.content)
+			resp, err := u.Commit(ctx, alice, info, tmp)
+
+			if _, statErr := os.Stat(tmp.Name()); !os.IsNotExist(statErr) {
+				t.Fatalf("temp file left behind: %v", statErr)
+			}
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("Commit = %v, want %v", err, tt.err)
+				}
+				if _, err := u.models.Files.Get(ctx, "trips/1/receipt.jpg"); !errors.Is(err, data.ErrNotFound) {
+					t.Fatalf("rejected upload indexed: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Path != "trips/1/receipt.jpg" || resp.Sha256 != sha256Hex(photo) || resp.Owner != "alice" ||
+				resp.ScanVerdict != data.ScanClean || resp.Url == "" || len(resp.Variants) != 1 {
+				t.Fatalf("response %+v", resp)
+			}
+		})
+	}
+}
+
+func TestQuota(t *testing.T) {
+	u := newTestUploads(t)
+	var err error
+	if u.quotas, err = parseQuotas("trips=2KB, trips/archive=1MB"); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	alice := &Principal{UserID: "alice"}
+	if _, err := u.models.Files.Put(ctx, &data.File{Key: "trips/1/old.jpg", Folder: "trips/1", Owner: "alice", Size: 1500}); err != nil {
+		t.Fatal(err)
+	}
+	s := &Sessions{uploads: u, ttl: time.Hour, locks: map[string]*sessionLock{}}
+
+	tests := []struct {
+		name   string
+		folder string
+		size   int
+		err    error
+	}{
+		{"fits", "trips/1", 500, nil},
+		{"over the quota", "trips/1", 600, errQuotaExceeded},
+		{"subfolder counted", "trips", 600, errQuotaExceeded},
+		{"another folder", "trips/2", 600, nil},
+		{"larger quota", "trips/archive", 5000, nil},
+		{"no quota", "avatars", 5000, nil},
+		{"over the maximum size", "avatars", 1<<20 + 1, errUploadTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// declared up front, for a resumable upload
+			_, err := s.Create(ctx, alice, UploadInfo{Folder: tt.folder, FileName: "a.jpg", Size: int64(tt.size)})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Create = %v, want %v", err, tt.err)
+			}
+			if tt.err == errUploadTooLarge {
+				return
+			}
+
+			// or found out while receiving
+			f, err := u.Receive(ctx, UploadInfo{Folder: tt.folder}, strings.NewReader(strings.Repeat("x", tt.size)))
+			if f != nil {
+				discard(f)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Receive = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCommitQuota(t *testing.T) {
+	u := newTestUploads(t)
+	ctx := context.Background()
+	alice := &Principal{UserID: "alice"}
+	photo := jpegFixture(t, 1)
+
+	commit := func(fileName string) error {
+		info := UploadInfo{Folder: "trips/1", FileName: fileName}
+		if err := u.Prepare(alice, &info); err != nil {
+			t.Fatal(err)
+		}
+		_, err := u.Commit(ctx, alice, info, tempUpload(t, u, photo))
+		return err
+	}
+	if err := commit("a.jpg"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the image and its variant, as processed, count
+	used, err := u.models.Files.Usage(ctx, "trips/1")
+	if err != nil || used == 0 {
+		t.Fatalf("usage %d, %v", used, err)
+	}
+	u.quotas = []folderQuota{{prefix: "trips", bytes: used + used/2}}
+
+	if err := commit("b.jpg"); !errors.Is(err, errQuotaExceeded) {
+		t.Fatalf("second image over the quota: %v", err)
+	}
+	// its references were dropped again, the first image keeps its own
+	obj, err := u.models.Objects.Get(ctx, sha256Hex(mustProcess(t, u, photo)))
+	if err != nil || obj.Refs != 1 {
+		t.Fatalf("object %+v, %v", obj, err)
+	}
+
+	// replacing an image counts for its new size only
+	if err := commit("a.jpg"); err != nil {
+		t.Fatalf("replacing the image: %v", err)
+	}
+	if after, _ := u.models.Files.Usage(ctx, "trips/1"); after != used {
+		t.Fatalf("usage %d after replacing, want %d", after, used)
+	}
+}
+
+// mustProcess returns the original stored for content
+func mustProcess(t *testing.T, u *Uploads, content []byte) []byte {
+	t.Helper()
+	img, err := u.pipeline.Process(bytes.NewReader(content), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img.Data
+}
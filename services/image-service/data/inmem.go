@@ -0,0 +1,358 @@
+package data
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NewInMemory returns stores that keep everything in memory. They mirror the
+// Mongo implementation closely enough for the tests of uploads, sessions and
+// the collector.
+func NewInMemory() Models {
+	return Models{
+		Files:   NewInMemoryFileRepository(),
+		Objects: NewInMemoryObjectRepository(),
+	}
+}
+
+// InMemoryFileRepository implements FileRepository in memory.
+type InMemoryFileRepository struct {
+	mu    sync.RWMutex
+	files map[string]*File
+}
+
+func NewInMemoryFileRepository() *InMemoryFileRepository {
+	return &InMemoryFileRepository{files: map[string]*File{}}
+}
+
+// copyFile returns a copy of f sharing nothing with it
+func copyFile(f *File) *File {
+	c := *f
+	c.Variants = append([]string(nil), f.Variants...)
+	if f.Scan != nil {
+		scan := *f.Scan
+		c.Scan = &scan
+	}
+	return &c
+}
+
+func (r *InMemoryFileRepository) Get(ctx context.Context, key string) (*File, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	f, ok := r.files[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyFile(f), nil
+}
+
+func (r *InMemoryFileRepository) Put(ctx context.Context, f *File) (*File, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now().UTC()
+	f.UpdatedAt = now
+	if f.CreatedAt.IsZero() {
+		f.CreatedAt = now
+	}
+
+	old, ok := r.files[f.Key]
+	if ok {
+		f.CreatedAt = old.CreatedAt
+	}
+	r.files[f.Key] = copyFile(f)
+	if !ok {
+		return nil, nil
+	}
+	return old, nil
+}
+
+func (r *InMemoryFileRepository) Delete(ctx context.Context, key string) (*File, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f, ok := r.files[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	delete(r.files, key)
+	return f, nil
+}
+
+func (r *InMemoryFileRepository) List(ctx context.Context, filter FileFilter) ([]*File, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	files := []*File{}
+	for _, f := range r.files {
+		if filter.matches(f) {
+			files = append(files, copyFile(f))
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Key < files[j].Key })
+	if filter.Limit > 0 && int64(len(files)) > filter.Limit {
+		files = files[:filter.Limit]
+	}
+	return files, nil
+}
+
+func (r *InMemoryFileRepository) Usage(ctx context.Context, folder string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	filter := FileFilter{Folder: folder, Variants: true}
+	var bytes int64
+	for _, f := range r.files {
+		if filter.matches(f) {
+			bytes += f.Size
+		}
+	}
+	return bytes, nil
+}
+
+func (r *InMemoryFileRepository) Unscanned(ctx context.Context, before time.Time, limit int64) ([]*File, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	files := []*File{}
+	for _, f := range r.files {
+		if f.Original != "" || f.Scan == nil {
+			continue
+		}
+		pending := f.Scan.Verdict == ScanPending && f.UpdatedAt.Before(before)
+		failed := f.Scan.Verdict == ScanError && f.Scan.ScannedAt != nil && f.Scan.ScannedAt.Before(before)
+		if pending || failed {
+			files = append(files, copyFile(f))
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].UpdatedAt.Before(files[j].UpdatedAt) })
+	if limit > 0 && int64(len(files)) > limit {
+		files = files[:limit]
+	}
+	return files, nil
+}
+
+func (r *InMemoryFileRepository) SetScan(ctx context.Context, key, sha256 string, scan Scan) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	unscanned := func(f *File) bool {
+		return f.Scan != nil && (f.Scan.Verdict == ScanPending || f.Scan.Verdict == ScanError)
+	}
+	f, ok := r.files[key]
+	if !ok || f.SHA256 != sha256 || !unscanned(f) {
+		return false, nil
+	}
+
+	for _, v := range r.files {
+		if v == f || v.Original == key && unscanned(v) {
+			s := scan
+			v.Scan = &s
+		}
+	}
+	return true, nil
+}
+
+// matches is match for the files in memory
+func (f FileFilter) matches(file *File) bool {
+	if f.Folder != "" && file.Folder != f.Folder && !strings.HasPrefix(file.Folder, f.Folder+"/") {
+		return false
+	}
+	if f.Owner != "" && file.Owner != f.Owner {
+		return false
+	}
+	if f.After != "" && file.Key <= f.After {
+		return false
+	}
+	return f.Variants || file.Original == ""
+}
+
+// InMemoryObjectRepository implements ObjectRepository in memory.
+type InMemoryObjectRepository struct {
+	mu      sync.Mutex
+	objects map[string]*Object
+}
+
+func NewInMemoryObjectRepository() *InMemoryObjectRepository {
+	return &InMemoryObjectRepository{objects: map[string]*Object{}}
+}
+
+func copyObject(obj *Object) *Object {
+	c := *obj
+	if obj.UnreferencedAt != nil {
+		t := *obj.UnreferencedAt
+		c.UnreferencedAt = &t
+	}
+	return &c
+}
+
+func (r *InMemoryObjectRepository) Acquire(ctx context.Context, obj Object) (*Object, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.objects[obj.SHA256]
+	if ok && stored.Collecting {
+		return nil, ErrCollecting
+	}
+	if !ok {
+		stored = &Object{
+			SHA256:      obj.SHA256,
+			Blob:        obj.Blob,
+			ContentType: obj.ContentType,
+			Size:        obj.Size,
+			CreatedAt:   time.Now().UTC(),
+		}
+		r.objects[obj.SHA256] = stored
+	}
+	stored.Refs++
+	stored.UnreferencedAt = nil
+	return copyObject(stored), nil
+}
+
+func (r *InMemoryObjectRepository) Release(ctx context.Context, sha256 string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	obj, ok := r.objects[sha256]
+	if !ok {
+		return nil
+	}
+	obj.Refs--
+	if obj.Refs <= 0 && obj.UnreferencedAt == nil {
+		now := time.Now().UTC()
+		obj.UnreferencedAt = &now
+	}
+	return nil
+}
+
+func (r *InMemoryObjectRepository) Get(ctx context.Context, sha256 string) (*Object, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	obj, ok := r.objects[sha256]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyObject(obj), nil
+}
+
+func (r *InMemoryObjectRepository) Exists(ctx context.Context, sha256 string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.objects[sha256]
+	return ok, nil
+}
+
+func (r *InMemoryObjectRepository) Unreferenced(ctx context.Context, before time.Time, limit int64) ([]*Object, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	objects := []*Object{}
+	for _, obj := range r.objects {
+		if obj.Refs <= 0 && obj.UnreferencedAt != nil && obj.UnreferencedAt.Before(before) {
+			objects = append(objects, copyObject(obj))
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].UnreferencedAt.Before(*objects[j].UnreferencedAt) })
+	if limit > 0 && int64(len(objects)) > limit {
+		objects = objects[:limit]
+	}
+	return objects, nil
+}
+
+func (r *InMemoryObjectRepository) Claim(ctx context.Context, sha256 string, before time.Time) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	obj, ok := r.objects[sha256]
+	if !ok || obj.Refs > 0 || obj.UnreferencedAt == nil || !obj.UnreferencedAt.Before(before) {
+		return false, nil
+	}
+	obj.Collecting = true
+	return true, nil
+}
+
+func (r *InMemoryObjectRepository) Unclaim(ctx context.Context, sha256 string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if obj, ok := r.objects[sha256]; ok {
+		obj.Collecting = false
+	}
+	return nil
+}
+
+func (r *InMemoryObjectRepository) Remove(ctx context.Context, sha256 string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if obj, ok := r.objects[sha256]; ok && obj.Collecting {
+		delete(r.objects, sha256)
+	}
+	return nil
+}
@@ -0,0 +1,422 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const database = "images"
+
+// Verdicts of the scan of a file. A file is quarantined, never served,
+// until its verdict is clean.
+const (
+	ScanPending  = "pending"
+	ScanClean    = "clean"
+	ScanInfected = "infected"
+	ScanError    = "error"
+)
+
+var (
+	// ErrNotFound is returned when a file doesn't exist
+	ErrNotFound = errors.New("image not found")
+	// ErrCollecting is returned when acquiring an object the garbage
+	// collector is deleting; acquire it again once it's gone
+	ErrCollecting = errors.New("object is being garbage collected")
+)
+
+func New(client *mongo.Client) Models {
+	db := client.Database(database)
+
+	return Models{
+		Files:   FileStore{files: db.Collection("files")},
+		Objects: ObjectStore{objects: db.Collection("objects")},
+		db:      db,
+	}
+}
+
+// Models groups the stores of image-service. Use New for the Mongo
+// implementation and NewInMemory for tests.
+type Models struct {
+	Files   FileRepository
+	Objects ObjectRepository
+
+	db *mongo.Database // nil in memory
+}
+
+// EnsureIndexes creates the indexes the listings, the scans and the collector
+// rely on.
+func (m Models) EnsureIndexes(ctx context.Context) error {
+	if m.db == nil {
+		return nil
+	}
+
+	_, err := m.db.Collection("files").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "owner", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "folder", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "sha256", Value: 1}}},
+		{Keys: bson.D{{Key: "scan.verdict", Value: 1}, {Key: "updated_at", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = m.db.Collection("objects").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "unreferenced_at", Value: 1}},
+		Options: options.Index().SetPartialFilterExpression(bson.M{"unreferenced_at": bson.M{"$exists": true}}),
+	})
+	return err
+}
+
+// File is a stored image or variant as its users know it: a key, an owner
+// and the metadata of its content, which is the Object with its SHA256.
+type File struct {
+	Key          string `bson:"_id" json:"key"`
+	Folder       string `bson:"folder" json:"folder"`
+	OriginalName string `bson:"original_name" json:"originalName"`
+	Owner        string `bson:"owner,omitempty" json:"owner,omitempty"`
+	Visibility   string `bson:"visibility" json:"visibility"`
+
+	ContentType string `bson:"content_type" json:"contentType"`
+	Size        int64  `bson:"size" json:"size"`
+	Width       int    `bson:"width" json:"width"`
+	Height      int    `bson:"height" json:"height"`
+	SHA256      string `bson:"sha256" json:"sha256"`
+	// Blob is the key of the content in the BlobStore
+	Blob string `bson:"blob" json:"-"`
+
+	// Variants are the keys of the variants of an image, Original the key of
+	// the image a variant was rendered from
+	Variants []string `bson:"variants,omitempty" json:"variants,omitempty"`
+	Original string   `bson:"original,omitempty" json:"original,omitempty"`
+
+	// Scan is nil for the files indexed before uploads were scanned
+	Scan *Scan `bson:"scan,omitempty" json:"scan,omitempty"`
+
+	CreatedAt time.Time `bson:"created_at" json:"createdAt"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updatedAt"`
+}
+
+// Quarantined reports whether f may not be served yet, or ever.
+func (f *File) Quarantined() bool {
+	return f.Scan != nil && f.Scan.Verdict != ScanClean
+}
+
+// Scan is the outcome of scanning the content of a file. The variants of an
+// image get the verdict of its scan.
+type Scan struct {
+	Verdict string `bson:"verdict" json:"verdict"`
+	// Signature is what the scanner found in an infected file, Detail why
+	// a scan failed
+	Signature string     `bson:"signature,omitempty" json:"signature,omitempty"`
+	Detail    string     `bson:"detail,omitempty" json:"detail,omitempty"`
+	Scanner   string     `bson:"scanner,omitempty" json:"scanner,omitempty"`
+	ScannedAt *time.Time `bson:"scanned_at,omitempty" json:"scannedAt,omitempty"`
+}
+
+// FileFilter selects files for List. Zero values don't filter.
+type FileFilter struct {
+	Folder string // and its subfolders
+	Owner  string
+	After  string // key the previous page ended with
+	Limit  int64
+	// Variants lists the variants too, not only the images
+	Variants bool
+}
+
+// FileStore indexes the files by key in the files collection.
+type FileStore struct {
+	files *mongo.Collection
+}
+
+// Get returns the file at key.
+func (s FileStore) Get(ctx context.Context, key string) (*File, error) {
+	var f File
+	err := s.files.FindOne(ctx, bson.M{"_id": key}).Decode(&f)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// Put stores f, replacing the file at its key, and returns the replaced
+// file, nil when there was none. A replaced file keeps its creation time.
+func (s FileStore) Put(ctx context.Context, f *File) (*File, error) {
+	now := time.Now().UTC()
+	f.UpdatedAt = now
+	if f.CreatedAt.IsZero() {
+		f.CreatedAt = now
+	}
+
+	var old File
+	err := s.files.FindOneAndReplace(ctx, bson.M{"_id": f.Key}, f,
+		options.FindOneAndReplace().SetUpsert(true).SetReturnDocument(options.Before),
+	).Decode(&old)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !old.CreatedAt.Equal(f.CreatedAt) {
+		f.CreatedAt = old.CreatedAt
+		_, err = s.files.UpdateByID(ctx, f.Key, bson.M{"$set": bson.M{"created_at": old.CreatedAt}})
+	}
+	return &old, err
+}
+
+// Delete removes the file at key and returns it.
+func (s FileStore) Delete(ctx context.Context, key string) (*File, error) {
+	var f File
+	err := s.files.FindOneAndDelete(ctx, bson.M{"_id": key}).Decode(&f)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// List returns the files matching filter in key order.
+func (s FileStore) List(ctx context.Context, filter FileFilter) ([]*File, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	if filter.Limit > 0 {
+		opts.SetLimit(filter.Limit)
+	}
+
+	cursor, err := s.files.Find(ctx, filter.match(), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	files := []*File{}
+	if err := cursor.All(ctx, &files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// Usage returns the bytes of the files of folder and its subfolders.
+func (s FileStore) Usage(ctx context.Context, folder string) (int64, error) {
+	cursor, err := s.files.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: FileFilter{Folder: folder, Variants: true}.match()}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "bytes": bson.M{"$sum": "$size"}}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var result []struct {
+		Bytes int64 `bson:"bytes"`
+	}
+	if err := cursor.All(ctx, &result); err != nil || len(result) == 0 {
+		return 0, err
+	}
+	return result[0].Bytes, nil
+}
+
+// Unscanned returns up to limit images, not variants, waiting for a scan
+// since before, or whose last scan failed before before.
+func (s FileStore) Unscanned(ctx context.Context, before time.Time, limit int64) ([]*File, error) {
+	cursor, err := s.files.Find(ctx,
+		bson.M{
+			"original": bson.M{"$exists": false},
+			"$or": bson.A{
+				bson.M{"scan.verdict": ScanPending, "updated_at": bson.M{"$lt": before}},
+				bson.M{"scan.verdict": ScanError, "scan.scanned_at": bson.M{"$lt": before}},
+			},
+		},
+		options.Find().SetSort(bson.D{{Key: "updated_at", Value: 1}}).SetLimit(limit),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	files := []*File{}
+	if err := cursor.All(ctx, &files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// SetScan records the scan of the image at key and its variants, as long as
+// the image still has the content sha256 scanned and no final verdict. It
+// reports whether it did.
+func (s FileStore) SetScan(ctx context.Context, key, sha256 string, scan Scan) (bool, error) {
+	unscanned := bson.M{"$in": bson.A{ScanPending, ScanError}}
+	res, err := s.files.UpdateOne(ctx,
+		bson.M{"_id": key, "sha256": sha256, "scan.verdict": unscanned},
+		bson.M{"$set": bson.M{"scan": scan}},
+	)
+	if err != nil || res.MatchedCount == 0 {
+		return false, err
+	}
+
+	_, err = s.files.UpdateMany(ctx,
+		bson.M{"original": key, "scan.verdict": unscanned},
+		bson.M{"$set": bson.M{"scan": scan}},
+	)
+	return err == nil, err
+}
+
+func (f FileFilter) match() bson.M {
+	m := bson.M{}
+	if f.Folder != "" {
+		m["folder"] = bson.M{"$regex": "^" + regexp.QuoteMeta(f.Folder) + "(/|$)"}
+	}
+	if f.Owner != "" {
+		m["owner"] = f.Owner
+	}
+	if f.After != "" {
+		m["_id"] = bson.M{"$gt": f.After}
+	}
+	if !f.Variants {
+		m["original"] = bson.M{"$exists": false}
+	}
+	return m
+}
+
+// Object is a content stored once in the BlobStore, under the SHA-256 of its
+// bytes, however many files have it. Refs counts those files; an object no
+// file refers to any more is deleted by the garbage collector.
+type Object struct {
+	SHA256      string    `bson:"_id" json:"sha256"`
+	Blob        string    `bson:"blob" json:"blob"`
+	ContentType string    `bson:"content_type" json:"contentType"`
+	Size        int64     `bson:"size" json:"size"`
+	Refs        int64     `bson:"refs" json:"refs"`
+	CreatedAt   time.Time `bson:"created_at" json:"createdAt"`
+	// UnreferencedAt is when Refs dropped to 0
+	UnreferencedAt *time.Time `bson:"unreferenced_at,omitempty" json:"unreferencedAt,omitempty"`
+	// Collecting is set while the collector deletes the object
+	Collecting bool `bson:"collecting,omitempty" json:"-"`
+}
+
+// ObjectStore counts the references to the objects in the objects
+// collection.
+type ObjectStore struct {
+	objects *mongo.Collection
+}
+
+// Acquire adds a reference to the object with obj's SHA256, creating it when
+// it doesn't exist, and returns it as stored.
+func (s ObjectStore) Acquire(ctx context.Context, obj Object) (*Object, error) {
+	update := bson.M{
+		"$inc":   bson.M{"refs": 1},
+		"$unset": bson.M{"unreferenced_at": ""},
+		"$setOnInsert": bson.M{
+			"blob":         obj.Blob,
+			"content_type": obj.ContentType,
+			"size":         obj.Size,
+			"created_at":   time.Now().UTC(),
+		},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var stored Object
+	// the filter doesn't match an object being collected, so the upsert
+	// collides with it on _id
+	err := s.objects.FindOneAndUpdate(ctx,
+		bson.M{"_id": obj.SHA256, "collecting": bson.M{"$ne": true}}, update, opts,
+	).Decode(&stored)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrCollecting
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &stored, nil
+}
+
+// Release drops a reference to the object sha256, marking it unreferenced
+// when it was the last one.
+func (s ObjectStore) Release(ctx context.Context, sha256 string) error {
+	_, err := s.objects.UpdateByID(ctx, sha256, bson.M{"$inc": bson.M{"refs": -1}})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.objects.UpdateOne(ctx,
+		bson.M{"_id": sha256, "refs": bson.M{"$lte": 0}, "unreferenced_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"unreferenced_at": time.Now().UTC()}},
+	)
+	return err
+}
+
+// Get returns the object sha256.
+func (s ObjectStore) Get(ctx context.Context, sha256 string) (*Object, error) {
+	var obj Object
+	err := s.objects.FindOne(ctx, bson.M{"_id": sha256}).Decode(&obj)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &obj, nil
+}
+
+// Exists reports whether the object sha256 is known.
+func (s ObjectStore) Exists(ctx context.Context, sha256 string) (bool, error) {
+	n, err := s.objects.CountDocuments(ctx, bson.M{"_id": sha256}, options.Count().SetLimit(1))
+	return n > 0, err
+}
+
+// Unreferenced returns up to limit objects without references since before,
+// all of them when limit is 0.
+func (s ObjectStore) Unreferenced(ctx context.Context, before time.Time, limit int64) ([]*Object, error) {
+	cursor, err := s.objects.Find(ctx,
+		bson.M{"refs": bson.M{"$lte": 0}, "unreferenced_at": bson.M{"$lt": before}},
+		options.Find().SetSort(bson.D{{Key: "unreferenced_at", Value: 1}}).SetLimit(limit),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	objects := []*Object{}
+	if err := cursor.All(ctx, &objects); err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// Claim marks the object sha256 as being collected, unless it was referenced
+// again or unreferenced after before. It returns false when it can't be.
+func (s ObjectStore) Claim(ctx context.Context, sha256 string, before time.Time) (bool, error) {
+	res, err := s.objects.UpdateOne(ctx,
+		bson.M{"_id": sha256, "refs": bson.M{"$lte": 0}, "unreferenced_at": bson.M{"$lt": before}},
+		bson.M{"$set": bson.M{"collecting": true}},
+	)
+	if err != nil {
+		return false, err
+	}
+	// matched rather than modified, so a claim left by a collector that
+	// died is taken over
+	return res.MatchedCount == 1, nil
+}
+
+// Unclaim gives a claimed object back when its content couldn't be deleted.
+func (s ObjectStore) Unclaim(ctx context.Context, sha256 string) error {
+	_, err := s.objects.UpdateByID(ctx, sha256, bson.M{"$unset": bson.M{"collecting": ""}})
+	return err
+}
+
+// Remove deletes a claimed object once its content is gone.
+func (s ObjectStore) Remove(ctx context.Context, sha256 string) error {
+	_, err := s.objects.DeleteOne(ctx, bson.M{"_id": sha256, "collecting": true})
+	return err
+}
@@ -0,0 +1,113 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestFileFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter FileFilter
+		match  bson.M
+		files  map[string]bool // folder/key of a file, whether it matches
+	}{
+		{
+			name:   "folder and subfolders",
+			filter: FileFilter{Folder: "trips/1"},
+			match: bson.M{
+				"folder":   bson.M{"$regex": `^trips/1(/|$)`},
+				"original": bson.M{"$exists": false},
+			},
+			files: map[string]bool{"trips/1": true, "trips/1/receipts": true, "trips/10": false, "trips": false},
+		},
+		{
+			name:   "folder quoted",
+			filter: FileFilter{Folder: "a.b", Variants: true},
+			match:  bson.M{"folder": bson.M{"$regex": `^a\.b(/|$)`}},
+			files:  map[string]bool{"a.b": true, "axb": false},
+		},
+		{
+			name:   "owner after key",
+			filter: FileFilter{Owner: "alice", After: "trips/1/b.jpg", Variants: true},
+			match:  bson.M{"owner": "alice", "_id": bson.M{"$gt": "trips/1/b.jpg"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match := tt.filter.match()
+			if !reflect.DeepEqual(match, tt.match) {
+				t.Fatalf("match %v, want %v", match, tt.match)
+			}
+
+			// the in-memory stores select the same files
+			for folder, want := range tt.files {
+				re := regexp.MustCompile(match["folder"].(bson.M)["$regex"].(string))
+				if re.MatchString(folder) != want {
+					t.Fatalf("regex on %s: %v, want %v", folder, !want, want)
+				}
+				if got := tt.filter.matches(&File{Key: folder + "/a.jpg", Folder: folder}); got != want {
+					t.Fatalf("matches %s: %v, want %v", folder, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestObjectRefs(t *testing.T) {
+	ctx := context.Background()
+	objects := NewInMemoryObjectRepository()
+	want := Object{SHA256: "ab12", Blob: "objects/ab/ab12.png", ContentType: "image/png", Size: 9}
+
+	for i := int64(1); i <= 2; i++ {
+		obj, err := objects.Acquire(ctx, want)
+		if err != nil || obj.Refs != i || obj.Blob != want.Blob {
+			t.Fatalf("acquire %d: %+v, %v", i, obj, err)
+		}
+	}
+
+	// referenced, or released too recently, it can't be claimed
+	before := time.Now().Add(time.Minute)
+	if claimed, _ := objects.Claim(ctx, want.SHA256, before); claimed {
+		t.Fatal("claimed a referenced object")
+	}
+	objects.Release(ctx, want.SHA256)
+	objects.Release(ctx, want.SHA256)
+	obj, _ := objects.Get(ctx, want.SHA256)
+	if obj.Refs != 0 || obj.UnreferencedAt == nil {
+		t.Fatalf("released %+v", obj)
+	}
+	if claimed, _ := objects.Claim(ctx, want.SHA256, obj.UnreferencedAt.Add(-time.Second)); claimed {
+		t.Fatal("claimed within the grace period")
+	}
+
+	// claimed, it isn't acquired again until given back
+	if claimed, _ := objects.Claim(ctx, want.SHA256, before); !claimed {
+		t.Fatal("unreferenced object not claimed")
+	}
+	if _, err := objects.Acquire(ctx, want); !errors.Is(err, ErrCollecting) {
+		t.Fatalf("acquire of a claimed object: %v", err)
+	}
+	objects.Unclaim(ctx, want.SHA256)
+	if obj, err := objects.Acquire(ctx, want); err != nil || obj.Refs != 1 || obj.UnreferencedAt != nil {
+		t.Fatalf("acquire after unclaim: %+v, %v", obj, err)
+	}
+
+	// only a claimed object is removed
+	objects.Remove(ctx, want.SHA256)
+	if ok, _ := objects.Exists(ctx, want.SHA256); !ok {
+		t.Fatal("removed an unclaimed object")
+	}
+	objects.Release(ctx, want.SHA256)
+	objects.Claim(ctx, want.SHA256, before)
+	objects.Remove(ctx, want.SHA256)
+	if ok, _ := objects.Exists(ctx, want.SHA256); ok {
+		t.Fatal("claimed object not removed")
+	}
+}
@@ -0,0 +1,34 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// FileRepository indexes the stored files by key.
+type FileRepository interface {
+	Get(ctx context.Context, key string) (*File, error)
+	// Put returns the file it replaced, nil when there was none.
+	Put(ctx context.Context, f *File) (*File, error)
+	Delete(ctx context.Context, key string) (*File, error)
+	List(ctx context.Context, filter FileFilter) ([]*File, error)
+	// Usage returns the bytes of the files of folder and its subfolders.
+	Usage(ctx context.Context, folder string) (int64, error)
+	Unscanned(ctx context.Context, before time.Time, limit int64) ([]*File, error)
+	SetScan(ctx context.Context, key, sha256 string, scan Scan) (bool, error)
+}
+
+// ObjectRepository counts the references to the contents stored once per
+// SHA-256.
+type ObjectRepository interface {
+	// Acquire fails with ErrCollecting while the object is being collected.
+	Acquire(ctx context.Context, obj Object) (*Object, error)
+	Release(ctx context.Context, sha256 string) error
+	Get(ctx context.Context, sha256 string) (*Object, error)
+	Exists(ctx context.Context, sha256 string) (bool, error)
+	Unreferenced(ctx context.Context, before time.Time, limit int64) ([]*Object, error)
+	// Claim, Unclaim and Remove are the steps of collecting an object.
+	Claim(ctx context.Context, sha256 string, before time.Time) (bool, error)
+	Unclaim(ctx context.Context, sha256 string) error
+	Remove(ctx context.Context, sha256 string) error
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"ride-sharing/shared/env"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// Archive keeps the gzipped NDJSON files of log entries removed by retention.
+type Archive interface {
+	// Put stores the size bytes of r as name, a slash separated relative path
+	Put(ctx context.Context, name string, r io.Reader, size int64) error
+	Usage(ctx context.Context) (*ArchiveUsage, error)
+}
+
+// ArchiveUsage is what the archive holds.
+type ArchiveUsage struct {
+	Location string `json:"location"`
+	Files    int64  `json:"files"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// newArchive opens the archive at LOG_ARCHIVE_URL: a local directory (a path
+// or file:///path) or an S3 compatible bucket (s3://bucket/prefix, with the
+// LOG_ARCHIVE_S3_* settings). It returns nil when rawURL is empty, in which
+// case expired entries are deleted without a copy.
+func newArchive(rawURL string) (Archive, error) {
+	if rawURL == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LOG_ARCHIVE_URL: %v", err)
+	}
+
+	switch u.Scheme {
+	case "", "file":
+		dir := u.Path
+		if u.Scheme == "" {
+			dir = rawURL
+		}
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+		return &localArchive{dir: dir}, nil
+	case "s3":
+		client, err := minio.New(env.GetString("LOG_ARCHIVE_S3_ENDPOINT", "s3.amazonaws.com"), &minio.Options{
+			Creds: credentials.NewStaticV4(
+				os.Getenv("LOG_ARCHIVE_S3_ACCESS_KEY"),
+				os.Getenv("LOG_ARCHIVE_S3_SECRET_KEY"),
+				"",
+			),
+			Secure: env.GetBool("LOG_ARCHIVE_S3_SSL", true),
+			Region: os.Getenv("LOG_ARCHIVE_S3_REGION"),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &s3Archive{client: client, bucket: u.Host, prefix: strings.Trim(u.Path, "/")}, nil
+	default:
+		return nil, fmt.Errorf("LOG_ARCHIVE_URL must be a path, file:// or s3:// URL, not %s://", u.Scheme)
+	}
+}
+
+// localArchive writes the files below a directory
+type localArchive struct {
+	dir string
+}
+
+func (a *localArchive) Put(ctx context.Context, name string, r io.Reader, size int64) error {
+	dest := filepath.Join(a.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+
+	// Write next to the destination and rename, so a crash never leaves a
+	// truncated archive behind
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".archive-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
+
+func (a *localArchive) Usage(ctx context.Context) (*ArchiveUsage, error) {
+	usage := &ArchiveUsage{Location: a.dir}
+	err := filepath.WalkDir(a.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(p, ".ndjson.gz") {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		usage.Files++
+		usage.Bytes += info.Size()
+		return nil
+	})
+	return usage, err
+}
+
+// s3Archive uploads the files to a bucket
+type s3Archive struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+func (a *s3Archive) Put(ctx context.Context, name string, r io.Reader, size int64) error {
+	_, err := a.client.PutObject(ctx, a.bucket, path.Join(a.prefix, name), r, size, minio.PutObjectOptions{
+		ContentType: "application/gzip",
+	})
+	return err
+}
+
+func (a *s3Archive) Usage(ctx context.Context) (*ArchiveUsage, error) {
+	usage := &ArchiveUsage{Location: "s3://" + path.Join(a.bucket, a.prefix)}
+
+	prefix := a.prefix
+	if prefix != "" {
+		prefix += "/"
+	}
+	for obj := range a.client.ListObjects(ctx, a.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		usage.Files++
+		usage.Bytes += obj.Size
+	}
+	return usage, nil
+}
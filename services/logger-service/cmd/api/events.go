@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"ride-sharing/shared/contracts"
+	"ride-sharing/shared/messaging"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	serviceName      = "logger-service"
+	userErasureQueue = "logger_user_erasure"
+)
+
+// listenForUserEvents erases the log entries of deleted users and confirms the
+// erasure back to the auth service.
+func (app *Config) listenForUserEvents(rabbitmq *messaging.RabbitMQ) {
+	handler := func(ctx context.Context, msg amqp.Delivery) error {
+		var message contracts.AmqpMessage
+		if err := json.Unmarshal(msg.Body, &message); err != nil {
+			return fmt.Errorf("failed to unmarshal message: %v", err)
+		}
+
+		var payload contracts.UserDeletedEvent
+		if err := json.Unmarshal(message.Data, &payload); err != nil {
+			return fmt.Errorf("failed to unmarshal payload: %v", err)
+		}
+
+		erased, err := app.Models.LogEntry.DeleteByUserID(payload.UserID)
+		if err != nil {
+			return fmt.Errorf("failed to erase logs for user %s: %v", payload.UserID, err)
+		}
+		log.Printf("Erased %d log entries for deleted user %s", erased, payload.UserID)
+
+		confirmation, err := json.Marshal(contracts.UserDataErasedEvent{
+			RequestID: payload.RequestID,
+			UserID:    payload.UserID,
+			Service:   serviceName,
+			Erased:    erased,
+			ErasedAt:  time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+
+		return rabbitmq.PublishMessage(ctx, contracts.UserEventDataErased, contracts.AmqpMessage{
+			OwnerID: payload.UserID,
+			Data:    confirmation,
+		})
+	}
+
+	if err := rabbitmq.ConsumeMessages(userErasureQueue, []string{contracts.UserEventDeleted}, handler); err != nil {
+		log.Printf("User event consumer stopped: %v", err)
+	}
+}
@@ -1,37 +1,60 @@
-//go:build grpc && !nogrpc
-// +build grpc,!nogrpc
-
 package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"time"
 
 	"log-service/data"
+	"ride-sharing/shared/clients"
 	loggerpb "ride-sharing/shared/generated/logger"
+	"ride-sharing/shared/servicetoken"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
 )
 
 const grpcPort = 50001
 
+// internalMethods read the entries of every user, stored or live; they need
+// the INTERNAL_SERVICE_TOKEN like the HTTP endpoints serving them
+var internalMethods = []string{
+	loggerpb.LoggerService_QueryLogs_FullMethodName,
+	loggerpb.LoggerService_TailLogs_FullMethodName,
+}
+
 type loggerGrpcServer struct {
 	loggerpb.UnimplementedLoggerServiceServer
-	models data.Models
+	models   data.Models
+	ingester *Ingester
+	tail     *Tail
 }
 
-func startGRPCServer(models data.Models) {
+func startGRPCServer(models data.Models, ingester *Ingester, tail *Tail, serviceToken string) {
 	addr := fmt.Sprintf(":%d", grpcPort)
 	lis, err := net.Listen("tcp", addr)
 	if err != nil {
 		log.Fatalf("logger-service failed to listen: %v", err)
 	}
 
-	s := grpc.NewServer()
-	loggerpb.RegisterLoggerServiceServer(s, &loggerGrpcServer{models: models})
+	serverOpts, err := clients.ServerCredentials()
+	if err != nil {
+		log.Fatalf("gRPC TLS: %v", err)
+	}
+
+	serverOpts = append(serverOpts,
+		grpc.ChainUnaryInterceptor(servicetoken.UnaryServerInterceptor(serviceToken, internalMethods...)),
+		grpc.ChainStreamInterceptor(servicetoken.StreamServerInterceptor(serviceToken, internalMethods...)),
+	)
+	s := grpc.NewServer(serverOpts...)
+	loggerpb.RegisterLoggerServiceServer(s, &loggerGrpcServer{models: models, ingester: ingester, tail: tail})
 	reflection.Register(s)
 
 	log.Printf("logger-service gRPC listening on %s", addr)
@@ -43,10 +66,161 @@ func startGRPCServer(models data.Models) {
 }
 
 func (s *loggerGrpcServer) LogInfo(ctx context.Context, req *loggerpb.LogRequest) (*loggerpb.LogResponse, error) {
-	e := data.LogEntry{Name: req.GetName(), Data: req.GetData()}
-	if err := s.models.LogEntry.Insert(e); err != nil {
+	e, err := entryFromRequest(req)
+	if err != nil {
 		return nil, err
 	}
+
+	err = s.ingester.Submit(ctx, e)
+	if errors.Is(err, ErrDropped) {
+		return &loggerpb.LogResponse{Message: "dropped"}, nil
+	}
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
 	return &loggerpb.LogResponse{Message: "logged"}, nil
 }
 
+// StreamLogs takes a stream of entries from one producer and answers once it
+// closes the stream with how many were accepted.
+func (s *loggerGrpcServer) StreamLogs(stream loggerpb.LoggerService_StreamLogsServer) error {
+	var accepted, dropped int64
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&loggerpb.StreamLogsResponse{Accepted: accepted, Dropped: dropped})
+		}
+		if err != nil {
+			return err
+		}
+
+		e, err := entryFromRequest(req)
+		if err != nil {
+			return err
+		}
+
+		err = s.ingester.Submit(stream.Context(), e)
+		switch {
+		case err == nil:
+			accepted++
+		case errors.Is(err, ErrDropped):
+			dropped++
+		default:
+			return status.Error(codes.Unavailable, err.Error())
+		}
+	}
+}
+
+func entryFromRequest(req *loggerpb.LogRequest) (data.LogEntry, error) {
+	e := data.LogEntry{
+		Service: req.GetService(),
+		Level:   req.GetLevel(),
+		Name:    req.GetName(),
+		Data:    req.GetData(),
+		TraceID: req.GetTraceId(),
+		UserID:  req.GetUserId(),
+		Fields:  stringFields(req.GetFields()),
+	}
+	if ts := req.GetTimestamp(); ts != "" {
+		t, err := time.Parse(time.RFC3339Nano, ts)
+		if err != nil {
+			return e, status.Error(codes.InvalidArgument, "timestamp must be an RFC 3339 time")
+		}
+		e.Timestamp = t
+	}
+	return e, nil
+}
+
+func (s *loggerGrpcServer) QueryLogs(ctx context.Context, req *loggerpb.LogQueryRequest) (*loggerpb.LogQueryResponse, error) {
+	query := data.LogQuery{
+		Service: req.GetService(),
+		Levels:  req.GetLevels(),
+		TraceID: req.GetTraceId(),
+		UserID:  req.GetUserId(),
+		Search:  req.GetSearch(),
+		Cursor:  req.GetCursor(),
+		Limit:   int(req.GetLimit()),
+	}
+
+	var err error
+	if v := req.GetFrom(); v != "" {
+		if query.From, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "from must be an RFC 3339 time")
+		}
+	}
+	if v := req.GetTo(); v != "" {
+		if query.To, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "to must be an RFC 3339 time")
+		}
+	}
+
+	page, err := s.models.LogEntry.Query(ctx, query)
+	if err != nil {
+		if errors.Is(err, data.ErrInvalidCursor) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		return nil, err
+	}
+
+	resp := &loggerpb.LogQueryResponse{NextCursor: page.NextCursor}
+	for _, e := range page.Entries {
+		resp.Entries = append(resp.Entries, toProtoEntry(e))
+	}
+	return resp, nil
+}
+
+// TailLogs sends the entries matching the filters as they are ingested, until
+// the client cancels or the service shuts down.
+func (s *loggerGrpcServer) TailLogs(req *loggerpb.TailLogsRequest, stream loggerpb.LoggerService_TailLogsServer) error {
+	sub, err := s.tail.Subscribe(data.LogQuery{
+		Service: req.GetService(),
+		Levels:  req.GetLevels(),
+		TraceID: req.GetTraceId(),
+		UserID:  req.GetUserId(),
+		Search:  req.GetSearch(),
+	})
+	if err != nil {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	defer s.tail.Unsubscribe(sub)
+
+	send := func(event tailEvent) error {
+		if event.Entry != nil {
+			return stream.Send(&loggerpb.TailEvent{Entry: toProtoEntry(event.Entry)})
+		}
+		return stream.Send(&loggerpb.TailEvent{Dropped: int64(event.Dropped)})
+	}
+	// gRPC keepalives take care of idle streams
+	heartbeat := func() error { return nil }
+
+	err = s.tail.stream(stream.Context(), sub, send, heartbeat)
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+func toProtoEntry(e *data.LogEntry) *loggerpb.LogEntry {
+	fields := make(map[string]string, len(e.Fields))
+	for k, v := range e.Fields {
+		if str, ok := v.(string); ok {
+			fields[k] = str
+			continue
+		}
+		encoded, _ := json.Marshal(v)
+		fields[k] = string(encoded)
+	}
+
+	return &loggerpb.LogEntry{
+		Id:        e.ID,
+		Service:   e.Service,
+		Level:     e.Level,
+		Name:      e.Name,
+		Data:      e.Data,
+		TraceId:   e.TraceID,
+		UserId:    e.UserID,
+		Timestamp: e.Timestamp.Format(time.RFC3339Nano),
+		Fields:    fields,
+	}
+}
+
@@ -1,36 +1,257 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
 	"log-service/data"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type JSONPayload struct {
-	Name string `json:"name"`
-	Data string `json:"data"`
+	Service   string         `json:"service,omitempty"`
+	Level     string         `json:"level,omitempty"`
+	Name      string         `json:"name"`
+	Data      string         `json:"data"`
+	TraceID   string         `json:"trace_id,omitempty"`
+	UserID    string         `json:"user_id,omitempty"`
+	Fields    map[string]any `json:"fields,omitempty"`
+	Timestamp time.Time      `json:"timestamp,omitempty"`
 }
 
 func (app *Config) WriteLog(w http.ResponseWriter, r *http.Request) {
 	// read json into var
 	var requestPayload JSONPayload
-	_ = app.readJSON(w, r, &requestPayload)
+	if err := app.readJSON(w, r, &requestPayload); err != nil {
+		app.errorJSON(w, err)
+		return
+	}
 
 	// insert data
 	event := data.LogEntry{
-		Name: requestPayload.Name,
-		Data: requestPayload.Data,
+		Service:   requestPayload.Service,
+		Level:     requestPayload.Level,
+		Name:      requestPayload.Name,
+		Data:      requestPayload.Data,
+		TraceID:   requestPayload.TraceID,
+		UserID:    requestPayload.UserID,
+		Fields:    requestPayload.Fields,
+		Timestamp: requestPayload.Timestamp,
+	}
+
+	message := "logged"
+	err := app.Ingester.Submit(r.Context(), event)
+	switch {
+	case errors.Is(err, ErrDropped):
+		message = "dropped: " + err.Error()
+	case err != nil:
+		app.errorJSON(w, err, http.StatusServiceUnavailable)
+		return
+	}
+
+	resp := jsonResponse{
+		Error: false,
+		Message: message,
 	}
 
-	err := app.Models.LogEntry.Insert(event)
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// QueryLogs searches the log entries, newest first. Filters: from and to
+// (RFC 3339), service, level (comma separated), trace_id, user_id and q for
+// full-text search. Pass the returned next_cursor as cursor for the next page.
+func (app *Config) QueryLogs(w http.ResponseWriter, r *http.Request) {
+	query, err := parseLogQuery(r)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
+	page, err := app.Models.LogEntry.Query(r.Context(), query)
+	if err != nil {
+		if errors.Is(err, data.ErrInvalidCursor) {
+			app.errorJSON(w, err)
+		} else {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
 	resp := jsonResponse{
-		Error: false,
-		Message: "logged",
+		Error:   false,
+		Message: fmt.Sprintf("%d log entries", len(page.Entries)),
+		Data:    page,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// TailLogs streams the entries as they are ingested, with the filters of
+// QueryLogs except cursor and limit. It answers with server-sent events, or
+// over a WebSocket when the request asks for an upgrade.
+func (app *Config) TailLogs(w http.ResponseWriter, r *http.Request) {
+	query, err := parseLogQuery(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	sub, err := app.Tail.Subscribe(query)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusServiceUnavailable)
+		return
+	}
+	defer app.Tail.Unsubscribe(sub)
+
+	if websocket.IsWebSocketUpgrade(r) {
+		app.Tail.streamWebSocket(w, r, sub)
+		return
+	}
+	if err := app.Tail.streamSSE(w, r, sub); err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+	}
+}
+
+// GetLog returns one log entry
+func (app *Config) GetLog(w http.ResponseWriter, r *http.Request) {
+	entry, err := app.Models.LogEntry.GetOne(chi.URLParam(r, "id"))
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			app.errorJSON(w, errors.New("log entry not found"), http.StatusNotFound)
+		} else {
+			app.errorJSON(w, err)
+		}
+		return
+	}
+
+	resp := jsonResponse{
+		Error:   false,
+		Message: "log entry " + entry.ID,
+		Data:    entry,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+func parseLogQuery(r *http.Request) (data.LogQuery, error) {
+	params := r.URL.Query()
+
+	query := data.LogQuery{
+		Service: params.Get("service"),
+		TraceID: params.Get("trace_id"),
+		UserID:  params.Get("user_id"),
+		Search:  params.Get("q"),
+		Cursor:  params.Get("cursor"),
+	}
+
+	for _, level := range strings.Split(params.Get("level"), ",") {
+		if level = strings.TrimSpace(level); level != "" {
+			query.Levels = append(query.Levels, level)
+		}
+	}
+
+	var err error
+	if v := params.Get("from"); v != "" {
+		if query.From, err = time.Parse(time.RFC3339, v); err != nil {
+			return query, fmt.Errorf("from must be an RFC 3339 time: %v", err)
+		}
+	}
+	if v := params.Get("to"); v != "" {
+		if query.To, err = time.Parse(time.RFC3339, v); err != nil {
+			return query, fmt.Errorf("to must be an RFC 3339 time: %v", err)
+		}
+	}
+	if v := params.Get("limit"); v != "" {
+		if query.Limit, err = strconv.Atoi(v); err != nil || query.Limit < 1 {
+			return query, errors.New("limit must be a positive number")
+		}
+	}
+
+	return query, nil
+}
+
+// RetentionStatus shows the retention policy, the last purge and how much
+// the logs and their archive take up
+func (app *Config) RetentionStatus(w http.ResponseWriter, r *http.Request) {
+	storage, err := app.Models.LogEntry.Storage(r.Context())
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	status := map[string]any{
+		"policy":   app.Retention.Policy(),
+		"last_run": app.Retention.LastRun(),
+		"storage":  storage,
+		"archive":  nil,
+	}
+	if app.Retention.archive != nil {
+		usage, err := app.Retention.archive.Usage(r.Context())
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+		status["archive"] = usage
+	}
+
+	resp := jsonResponse{
+		Error:   false,
+		Message: "retention",
+		Data:    status,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// RunRetention starts a purge now instead of waiting for the schedule. The
+// lock taken here is handed to the purge, so no other run can start between
+// the answer and the purge.
+func (app *Config) RunRetention(w http.ResponseWriter, r *http.Request) {
+	if !app.Retention.running.TryLock() {
+		app.errorJSON(w, errRetentionRunning, http.StatusConflict)
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), retentionTimeout)
+		defer cancel()
+		if err := app.Retention.runLocked(ctx); err != nil {
+			log.Printf("Log retention run failed: %v", err)
+		}
+	}()
+
+	resp := jsonResponse{
+		Error:   false,
+		Message: "retention run started",
 	}
 
 	app.writeJSON(w, http.StatusAccepted, resp)
-}
\ No newline at end of file
+}
+
+// GetUserLogs returns every log entry stored for a user. It backs the account
+// data export in the auth service.
+func (app *Config) GetUserLogs(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userID")
+
+	logs, err := app.Models.LogEntry.AllByUserID(userID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	resp := jsonResponse{
+		Error:   false,
+		Message: "logs for user " + userID,
+		Data:    logs,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"log-service/data"
+	"ride-sharing/shared/env"
+	"ride-sharing/shared/logging"
+	"ride-sharing/shared/retry"
+)
+
+// Overflow policies, applied when the buffer is full
+const (
+	// OverflowBlock makes producers wait for room, pushing back on them
+	OverflowBlock = "block"
+	// OverflowDropOldest drops the oldest buffered entry to make room
+	OverflowDropOldest = "drop-oldest"
+	// OverflowSample keeps one in LOG_SAMPLE_RATE debug and info entries once
+	// the buffer is 80% full, and every warning and error while there's room
+	OverflowSample = "sample"
+)
+
+var (
+	// ErrDropped is returned for an entry the overflow policy threw away
+	ErrDropped = errors.New("log buffer is full, entry dropped")
+	// ErrIngesterClosed is returned once the service is shutting down
+	ErrIngesterClosed = errors.New("log ingestion is shut down")
+)
+
+// IngestStats counts what happened to the entries handed to the Ingester.
+type IngestStats struct {
+	Policy   string `json:"policy"`
+	Buffered int    `json:"buffered"`
+	Capacity int    `json:"capacity"`
+	Accepted uint64 `json:"accepted"`
+	Dropped  uint64 `json:"dropped"`
+	Written  uint64 `json:"written"`
+	Failed   uint64 `json:"failed"`
+}
+
+// batchWriter stores the batches of the Ingester, a *data.LogEntry outside
+// of the tests. InsertMany returns how many entries were rejected.
+type batchWriter interface {
+	InsertMany(ctx context.Context, entries []data.LogEntry) (int, error)
+}
+
+// Ingester buffers log entries in memory and writes them to Mongo in batches,
+// when a batch is full or every flush interval, whichever comes first. Every
+// transport submits through it, so producers never wait on Mongo unless the
+// buffer is full and the policy is to block.
+type Ingester struct {
+	store      batchWriter
+	tail       *Tail
+	entries    chan data.LogEntry
+	batchSize  int
+	interval   time.Duration
+	policy     string
+	sampleRate uint64
+	retry      retry.Config
+
+	// Submit holds the read lock while it sends, Stop the write lock to close
+	// entries, so nothing is ever sent on a closed channel
+	mu     sync.RWMutex
+	closed bool
+	done   chan struct{}
+
+	pressure atomic.Uint64 // entries seen while sampling, to keep one in sampleRate
+	accepted atomic.Uint64
+	dropped  atomic.Uint64
+	written  atomic.Uint64
+	failed   atomic.Uint64
+}
+
+// NewIngester reads its settings from LOG_BUFFER_SIZE, LOG_BATCH_SIZE,
+// LOG_FLUSH_INTERVAL, LOG_OVERFLOW_POLICY and LOG_SAMPLE_RATE. Accepted
+// entries are also broadcast to tail, when it isn't nil.
+func NewIngester(store *data.LogEntry, tail *Tail) (*Ingester, error) {
+	policy := env.GetString("LOG_OVERFLOW_POLICY", OverflowBlock)
+	switch policy {
+	case OverflowBlock, OverflowDropOldest, OverflowSample:
+	default:
+		return nil, fmt.Errorf("unknown LOG_OVERFLOW_POLICY %q, use block, drop-oldest or sample", policy)
+	}
+
+	capacity := env.GetInt("LOG_BUFFER_SIZE", 10000)
+	batchSize := env.GetInt("LOG_BATCH_SIZE", 500)
+	sampleRate := env.GetInt("LOG_SAMPLE_RATE", 10)
+	if capacity < 1 || batchSize < 1 || sampleRate < 1 {
+		return nil, errors.New("LOG_BUFFER_SIZE, LOG_BATCH_SIZE and LOG_SAMPLE_RATE must be positive")
+	}
+
+	return &Ingester{
+		store:      store,
+		tail:       tail,
+		entries:    make(chan data.LogEntry, capacity),
+		batchSize:  batchSize,
+		interval:   env.GetDuration("LOG_FLUSH_INTERVAL", time.Second),
+		policy:     policy,
+		sampleRate: uint64(sampleRate),
+		retry: retry.Config{
+			MaxRetries:  3,
+			InitialWait: 500 * time.Millisecond,
+			MaxWait:     5 * time.Second,
+		},
+		done: make(chan struct{}),
+	}, nil
+}
+
+// Start runs the flusher until Stop is called.
+func (in *Ingester) Start() {
+	go in.run()
+	log.Printf("Log ingestion running: buffer %d, batches of %d every %v, %s on overflow",
+		cap(in.entries), in.batchSize, in.interval, in.policy)
+}
+
+// Submit buffers an entry for the next batch. It returns ErrDropped when the
+// overflow policy discarded the entry, or discarded an older one to make room
+// when the policy is drop-oldest, and only blocks under the block policy.
+func (in *Ingester) Submit(ctx context.Context, entry data.LogEntry) error {
+	in.mu.RLock()
+	defer in.mu.RUnlock()
+
+	if in.closed {
+		return ErrIngesterClosed
+	}
+
+	// Stamp entries now, not when the batch is written
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now()
+	}
+	entry.Level = data.NormalizeLevel(entry.Level)
+
+	switch in.policy {
+	case OverflowDropOldest:
+		return in.submitDropOldest(entry)
+	case OverflowSample:
+		return in.submitSampled(entry)
+	default:
+		select {
+		case in.entries <- entry:
+			in.accept(entry)
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (in *Ingester) submitDropOldest(entry data.LogEntry) error {
+	var err error
+	for {
+		select {
+		case in.entries <- entry:
+			in.accept(entry)
+			return err
+		default:
+		}
+
+		select {
+		case <-in.entries:
+			in.dropped.Add(1)
+			err = ErrDropped
+		default:
+		}
+	}
+}
+
+func (in *Ingester) submitSampled(entry data.LogEntry) error {
+	underPressure := len(in.entries) >= cap(in.entries)*8/10
+
+	if underPressure && entry.Level != data.LevelWarn && entry.Level != data.LevelError {
+		if in.pressure.Add(1)%in.sampleRate != 0 {
+			in.dropped.Add(1)
+			return ErrDropped
+		}
+	}
+
+	select {
+	case in.entries <- entry:
+		in.accept(entry)
+		return nil
+	default:
+		in.dropped.Add(1)
+		return ErrDropped
+	}
+}
+
+// accept counts an entry that made it into the buffer and shows it to the
+// clients tailing the logs right away, before it is written
+func (in *Ingester) accept(entry data.LogEntry) {
+	in.accepted.Add(1)
+	if in.tail != nil {
+		in.tail.Broadcast(&entry)
+	}
+}
+
+// Stop refuses new entries, writes the buffered ones and waits for the last
+// batch, or until ctx is done.
+func (in *Ingester) Stop(ctx context.Context) error {
+	in.mu.Lock()
+	if !in.closed {
+		in.closed = true
+		close(in.entries)
+	}
+	in.mu.Unlock()
+
+	select {
+	case <-in.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%d log entries not flushed: %w", len(in.entries), ctx.Err())
+	}
+}
+
+// Stats returns the ingestion counters.
+func (in *Ingester) Stats() IngestStats {
+	return IngestStats{
+		Policy:   in.policy,
+		Buffered: len(in.entries),
+		Capacity: cap(in.entries),
+		Accepted: in.accepted.Load(),
+		Dropped:  in.dropped.Load(),
+		Written:  in.written.Load(),
+		Failed:   in.failed.Load(),
+	}
+}
+
+// Publish exposes Stats on /debug/vars under name.
+func (in *Ingester) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() any { return in.Stats() }))
+}
+
+func (in *Ingester) run() {
+	defer close(in.done)
+
+	ticker := time.NewTicker(in.interval)
+	defer ticker.Stop()
+
+	batch := make([]data.LogEntry, 0, in.batchSize)
+	for {
+		select {
+		case entry, ok := <-in.entries:
+			if !ok {
+				in.flush(batch)
+				return
+			}
+			batch = append(batch, entry)
+			if len(batch) >= in.batchSize {
+				in.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				in.flush(batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+// flush writes a batch, retrying a few times before giving up on it. The
+// buffer keeps filling meanwhile, which is what applies the overflow policy
+// when Mongo is slow.
+func (in *Ingester) flush(batch []data.LogEntry) {
+	if len(batch) == 0 {
+		return
+	}
+
+	// IDs are set once, so entries a failed attempt did store are skipped as
+	// duplicates by the next one
+	data.AssignIDs(batch)
+
+	var rejected int
+	err := retry.WithBackoff(context.Background(), in.retry, func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var err error
+		rejected, err = in.store.InsertMany(ctx, batch)
+		return err
+	})
+	if err != nil {
+		in.failed.Add(uint64(len(batch)))
+		log.Printf("Error writing %d log entries, dropping them: %v", len(batch), err)
+		return
+	}
+
+	if rejected > 0 {
+		log.Printf("Mongo rejected %d of %d log entries", rejected, len(batch))
+	}
+	in.failed.Add(uint64(rejected))
+	in.written.Add(uint64(len(batch) - rejected))
+}
+
+// ingestSink hands the logs of the logger service itself to its Ingester
+// instead of sending them to itself over the network. It never logs, so an
+// entry it can't submit doesn't produce another one.
+type ingestSink struct {
+	ingester *Ingester
+}
+
+func (s ingestSink) Send(ctx context.Context, entries []logging.Entry) error {
+	for _, e := range entries {
+		err := s.ingester.Submit(ctx, data.LogEntry{
+			Service:   e.Service,
+			Level:     e.Level,
+			Name:      e.Message,
+			TraceID:   e.TraceID,
+			UserID:    e.UserID,
+			Fields:    stringFields(e.Fields),
+			Timestamp: e.Timestamp,
+		})
+		if err != nil && !errors.Is(err, ErrDropped) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s ingestSink) Close() error {
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"log-service/data"
+	"ride-sharing/shared/retry"
+)
+
+// fakeWriter stores entries by ID like the logs collection: an entry whose ID
+// is already stored is a duplicate, which isn't a rejection
+type fakeWriter struct {
+	mu       sync.Mutex
+	stored   map[string]data.LogEntry
+	attempts [][]string // IDs of every InsertMany
+	failures int        // attempts left that store half the batch, then fail
+	reject   string     // name of the entries rejected, as invalid documents
+}
+
+func (w *fakeWriter) InsertMany(_ context.Context, entries []data.LogEntry) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	ids := make([]string, len(entries))
+	for i, e := range entries {
+		ids[i] = e.ID
+	}
+	w.attempts = append(w.attempts, ids)
+
+	n := len(entries)
+	if w.failures > 0 {
+		w.failures--
+		n = len(entries) / 2
+	}
+
+	rejected := 0
+	for _, e := range entries[:n] {
+		if _, dup := w.stored[e.ID]; dup {
+			continue
+		}
+		if e.Name == w.reject {
+			rejected++
+			continue
+		}
+		w.stored[e.ID] = e
+	}
+	if n < len(entries) {
+		return 0, errors.New("connection reset")
+	}
+	return rejected, nil
+}
+
+func newTestIngester(store batchWriter, capacity int) *Ingester {
+	return &Ingester{
+		store:      store,
+		entries:    make(chan data.LogEntry, capacity),
+		batchSize:  capacity,
+		interval:   time.Hour,
+		policy:     OverflowBlock,
+		sampleRate: 1,
+		retry:      retry.Config{MaxRetries: 2, InitialWait: time.Millisecond, MaxWait: time.Millisecond},
+		done:       make(chan struct{}),
+	}
+}
+
+func TestFlushRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		reject   string
+		attempts int
+		stored   int
+		written  uint64
+		failed   uint64
+	}{
+		{"first attempt", 0, "", 1, 4, 4, 0},
+		{"half stored, then retried", 1, "", 2, 4, 4, 0},
+		{"retried twice", 2, "", 3, 4, 4, 0},
+		{"one rejected", 1, "invalid", 2, 3, 3, 1},
+		{"given up", 3, "", 3, 2, 0, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &fakeWriter{stored: map[string]data.LogEntry{}, failures: tt.failures, reject: tt.reject}
+			in := newTestIngester(store, 4)
+
+			preset := "65f000000000000000000001"
+			batch := []data.LogEntry{{ID: preset, Name: "preset"}, {Name: "a"}, {Name: "b"}, {Name: "invalid"}}
+			in.flush(batch)
+
+			if len(store.attempts) != tt.attempts {
+				t.Fatalf("%d attempts, want %d", len(store.attempts), tt.attempts)
+			}
+			// every attempt writes the batch with the same IDs, so the entries
+			// an attempt stored before failing aren't stored twice
+			for i, ids := range store.attempts {
+				if ids[0] != preset {
+					t.Fatalf("attempt %d replaced ID %s by %s", i+1, preset, ids[0])
+				}
+				for j, id := range ids {
+					if id == "" || id != store.attempts[0][j] {
+						t.Fatalf("attempt %d wrote entry %d as %q, the first one as %q", i+1, j, id, store.attempts[0][j])
+					}
+				}
+			}
+
+			stats := in.Stats()
+			if len(store.stored) != tt.stored || stats.Written != tt.written || stats.Failed != tt.failed {
+				t.Fatalf("stored %d, written %d, failed %d; want %d, %d, %d",
+					len(store.stored), stats.Written, stats.Failed, tt.stored, tt.written, tt.failed)
+			}
+		})
+	}
+}
+
+func TestStopFlushes(t *testing.T) {
+	store := &fakeWriter{stored: map[string]data.LogEntry{}}
+	in := newTestIngester(store, 10)
+	in.Start()
+
+	ctx := context.Background()
+	for _, name := range []string{"a", "b", "c"} {
+		if err := in.Submit(ctx, data.LogEntry{Name: name, Level: "WARNING"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stopCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := in.Stop(stopCtx); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(store.stored) != 3 || in.Stats().Written != 3 {
+		t.Fatalf("stored %d, stats %+v", len(store.stored), in.Stats())
+	}
+	for _, e := range store.stored {
+		if e.Level != data.LevelWarn || e.Timestamp.IsZero() {
+			t.Fatalf("entry stored as %+v", e)
+		}
+	}
+	if err := in.Submit(ctx, data.LogEntry{Name: "late"}); !errors.Is(err, ErrIngesterClosed) {
+		t.Fatalf("Submit after Stop = %v", err)
+	}
+}
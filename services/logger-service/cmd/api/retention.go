@@ -0,0 +1,330 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"log-service/data"
+	"ride-sharing/shared/env"
+	"ride-sharing/shared/scheduler"
+	"ride-sharing/shared/scheduler/mongolock"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultRetention = "*=30d,*:error=90d"
+	retentionTimeout = 30 * time.Minute
+)
+
+var errRetentionRunning = errors.New("a retention run is already in progress")
+
+// Retention purges the log entries older than their retention rule allows,
+// archiving them first when an archive is configured.
+type Retention struct {
+	store   *data.LogEntry
+	rules   []data.RetentionRule
+	archive Archive
+	batch   int64 // entries per archive file
+
+	running sync.Mutex
+	mu      sync.Mutex
+	lastRun *RetentionRun
+}
+
+// RetentionRun is the outcome of one purge.
+type RetentionRun struct {
+	StartedAt time.Time `json:"started_at"`
+	Duration  string    `json:"duration"`
+	Deleted   int64     `json:"deleted"`
+	Archived  int64     `json:"archived"`
+	Files     int       `json:"files"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// RetentionPolicy describes one rule for the admin endpoint.
+type RetentionPolicy struct {
+	Service string `json:"service"`
+	Level   string `json:"level"`
+	KeepFor string `json:"keep_for"`
+}
+
+// newRetention reads the rules from LOG_RETENTION and the archive from
+// LOG_ARCHIVE_URL.
+func newRetention(store *data.LogEntry) (*Retention, error) {
+	rules, err := parseRetention(env.GetString("LOG_RETENTION", defaultRetention))
+	if err != nil {
+		return nil, err
+	}
+
+	archive, err := newArchive(os.Getenv("LOG_ARCHIVE_URL"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Retention{
+		store:   store,
+		rules:   rules,
+		archive: archive,
+		batch:   int64(env.GetInt("LOG_ARCHIVE_BATCH", 10000)),
+	}, nil
+}
+
+// parseRetention reads rules such as "*=30d,auth=90d,*:error=180d,trip:debug=3d".
+// A key is a service, "service:level", "*:level", or "*" for the default; a
+// value is a number of days ("30d"), a Go duration ("12h") or "forever". The
+// most specific rule matching an entry applies, and entries no rule matches
+// are kept forever.
+func parseRetention(spec string) ([]data.RetentionRule, error) {
+	var rules []data.RetentionRule
+	seen := map[string]bool{}
+
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		key, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("retention rule %q must look like service:level=30d", item)
+		}
+
+		service, level, _ := strings.Cut(strings.TrimSpace(key), ":")
+		if service == "" || service == "default" {
+			service = data.Any
+		}
+		if level == "" {
+			level = data.Any
+		}
+		level = strings.ToLower(level)
+		switch level {
+		case data.Any, data.LevelDebug, data.LevelInfo, data.LevelWarn, data.LevelError:
+		default:
+			return nil, fmt.Errorf("retention rule %q: unknown level %s", item, level)
+		}
+
+		maxAge, err := parseAge(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("retention rule %q: %v", item, err)
+		}
+
+		rule := data.RetentionRule{Service: service, Level: level, MaxAge: maxAge}
+		if seen[service+":"+level] {
+			return nil, fmt.Errorf("retention rule for %s:%s is set twice", service, level)
+		}
+		seen[service+":"+level] = true
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+func parseAge(value string) (time.Duration, error) {
+	switch {
+	case value == "forever" || value == "0":
+		return 0, nil
+	case strings.HasSuffix(value, "d"):
+		days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		if err != nil || days < 1 {
+			return 0, fmt.Errorf("invalid number of days %q", value)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	default:
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return 0, fmt.Errorf("invalid age %q", value)
+		}
+		return d, nil
+	}
+}
+
+func formatAge(d time.Duration) string {
+	switch {
+	case d == 0:
+		return "forever"
+	case d%(24*time.Hour) == 0:
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	default:
+		return d.String()
+	}
+}
+
+func retentionInterval() time.Duration {
+	return env.GetDuration("LOG_RETENTION_INTERVAL", time.Hour)
+}
+
+// Schedule adds the purge to jobs as a singleton job running every
+// LOG_RETENTION_INTERVAL.
+func (r *Retention) Schedule(jobs *scheduler.Scheduler) error {
+	return jobs.Add(scheduler.Job{
+		Name:      "logger.retention",
+		Schedule:  scheduler.Every(retentionInterval()),
+		Jitter:    5 * time.Minute,
+		Timeout:   retentionTimeout,
+		Singleton: true,
+		Run:       r.Run,
+	})
+}
+
+// Run purges, and archives, the expired entries of every rule.
+func (r *Retention) Run(ctx context.Context) error {
+	if !r.running.TryLock() {
+		return errRetentionRunning
+	}
+	return r.runLocked(ctx)
+}
+
+// runLocked is Run for a caller that already holds r.running, which it
+// releases once the purge is over.
+func (r *Retention) runLocked(ctx context.Context) error {
+	defer r.running.Unlock()
+
+	now := time.Now()
+	run := &RetentionRun{StartedAt: now}
+
+	err := r.purge(ctx, now, run)
+	run.Duration = time.Since(now).Round(time.Millisecond).String()
+	if err != nil {
+		run.Error = err.Error()
+	}
+
+	r.mu.Lock()
+	r.lastRun = run
+	r.mu.Unlock()
+
+	if run.Deleted > 0 || err != nil {
+		log.Printf("Log retention: deleted %d entries, archived %d in %d files", run.Deleted, run.Archived, run.Files)
+	}
+	return err
+}
+
+func (r *Retention) purge(ctx context.Context, now time.Time, run *RetentionRun) error {
+	for _, rule := range r.rules {
+		if rule.MaxAge == 0 {
+			continue
+		}
+
+		filter := data.ExpiredFilter(rule, r.rules, now)
+		if r.archive == nil {
+			deleted, err := r.store.DeleteMatching(ctx, filter)
+			run.Deleted += deleted
+			if err != nil {
+				return fmt.Errorf("purging %s: %w", rule, err)
+			}
+			continue
+		}
+
+		for part := 1; ; part++ {
+			n, err := r.archiveBatch(ctx, rule, filter, now, part, run)
+			if err != nil {
+				return fmt.Errorf("archiving %s: %w", rule, err)
+			}
+			if n < r.batch {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// archiveBatch writes the oldest batch of expired entries to one archive
+// file, deletes them once the file is stored and returns how many there were.
+// Only archived entries are deleted, whatever expired meanwhile waits for the
+// next batch.
+func (r *Retention) archiveBatch(ctx context.Context, rule data.RetentionRule, filter bson.M, now time.Time, part int, run *RetentionRun) (int64, error) {
+	tmp, err := os.CreateTemp("", "logs-*.ndjson.gz")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	gz := gzip.NewWriter(tmp)
+	enc := json.NewEncoder(gz)
+
+	var ids []string
+	err = r.store.Iterate(ctx, filter, r.batch, func(e *data.LogEntry) error {
+		ids = append(ids, e.ID)
+		return enc.Encode(e)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err := gz.Close(); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	name := fmt.Sprintf("%s/logs-%s-%s-%s-%d.ndjson.gz", now.UTC().Format("2006/01/02"),
+		fileSafe(rule.Service), fileSafe(rule.Level), now.UTC().Format("20060102T150405Z"), part)
+	if err := r.archive.Put(ctx, name, tmp, size); err != nil {
+		return 0, err
+	}
+	run.Files++
+	run.Archived += int64(len(ids))
+
+	deleted, err := r.store.DeleteIDs(ctx, ids)
+	run.Deleted += deleted
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(ids)), nil
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+func fileSafe(s string) string {
+	if s == data.Any {
+		return "all"
+	}
+	return unsafeFileChars.ReplaceAllString(s, "_")
+}
+
+// Policy lists the rules as the admin endpoint shows them.
+func (r *Retention) Policy() []RetentionPolicy {
+	policy := make([]RetentionPolicy, 0, len(r.rules))
+	for _, rule := range r.rules {
+		policy = append(policy, RetentionPolicy{Service: rule.Service, Level: rule.Level, KeepFor: formatAge(rule.MaxAge)})
+	}
+	return policy
+}
+
+// LastRun returns the outcome of the last purge, nil before the first one.
+func (r *Retention) LastRun() *RetentionRun {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lastRun
+}
+
+// newScheduler creates the scheduler of the background jobs of the logger
+// service. Replicas elect the leader of singleton jobs through a Mongo lease
+// that outlives the time between two purges.
+func newScheduler(client *mongo.Client) *scheduler.Scheduler {
+	locks := client.Database("logs").Collection("scheduler_locks")
+	jobs := scheduler.New(mongolock.New(locks, 2*retentionInterval()+retentionTimeout))
+	jobs.Publish("scheduler")
+	return jobs
+}
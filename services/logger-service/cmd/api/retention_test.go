@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRunRetention(t *testing.T) {
+	// Without rules a purge has nothing to do, so no store is needed
+	app := &Config{Retention: &Retention{}}
+
+	// a run in progress: 409, and the lock stays with that run
+	app.Retention.running.Lock()
+	rec := httptest.NewRecorder()
+	app.RunRetention(rec, httptest.NewRequest(http.MethodPost, "/admin/retention/run", nil))
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("status %d while a run is in progress, want %d", rec.Code, http.StatusConflict)
+	}
+	if err := app.Retention.Run(context.Background()); !errors.Is(err, errRetentionRunning) {
+		t.Fatalf("Run = %v while a run is in progress", err)
+	}
+	if app.Retention.LastRun() != nil {
+		t.Fatal("a purge ran while another was in progress")
+	}
+	app.Retention.running.Unlock()
+
+	// the purge started gets the lock of the request, and releases it
+	rec = httptest.NewRecorder()
+	app.RunRetention(rec, httptest.NewRequest(http.MethodPost, "/admin/retention/run", nil))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status %d, want %d", rec.Code, http.StatusAccepted)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for app.Retention.LastRun() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("the purge started never ran")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for !app.Retention.running.TryLock() {
+		if time.Now().After(deadline) {
+			t.Fatal("the purge started kept the lock")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	app.Retention.running.Unlock()
+
+	if err := app.Retention.Run(context.Background()); err != nil {
+		t.Fatalf("Run = %v", err)
+	}
+}
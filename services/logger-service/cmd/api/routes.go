@@ -1,8 +1,12 @@
 package main
 
 import (
+	"expvar"
 	"net/http"
 
+	"ride-sharing/shared/logging"
+	"ride-sharing/shared/servicetoken"
+
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
@@ -22,8 +26,27 @@ func (app *Config) routes() http.Handler {
 	}))
 
 	mux.Use(middleware.Heartbeat("/ping"))
+	mux.Use(logging.Middleware)
 
 	mux.Post("/log", app.WriteLog)
 
+	// ingestion and tail counters, published by the Ingester and the Tail
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	mux.Group(func(mux chi.Router) {
+		mux.Use(servicetoken.Middleware(app.AdminToken))
+		mux.Get("/admin/retention", app.RetentionStatus)
+		mux.Post("/admin/retention/run", app.RunRetention)
+	})
+
+	mux.Group(func(mux chi.Router) {
+		mux.Use(servicetoken.Middleware(app.ServiceToken))
+		// the entries of every user, user_id being one of the filters
+		mux.Get("/logs", app.QueryLogs)
+		mux.Get("/logs/tail", app.TailLogs)
+		mux.Get("/logs/{id}", app.GetLog)
+		mux.Get("/users/{userID}/logs", app.GetUserLogs)
+	})
+
 	return mux
 }
\ No newline at end of file
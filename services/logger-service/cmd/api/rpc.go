@@ -1,35 +1,74 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"expvar"
 	"log"
 	"log-service/data"
+	"sync"
 	"time"
 )
 
+var (
+	legacyCalls      = expvar.NewMap("rpc.legacy_calls")
+	legacyCallWarned sync.Once
+)
+
+// legacyCall counts a net/rpc call and warns about the first one
+func legacyCall(method string) {
+	legacyCalls.Add(method, 1)
+	legacyCallWarned.Do(func() {
+		log.Printf("Deprecated net/rpc call RPCServer.%s, move the caller to gRPC", method)
+	})
+}
+
 // RPCServer is the type for our RPC Server. Methods that take this as a receiver are available
 // over RPC, as long as they are exported.
+//
+// Deprecated: call the gRPC LoggerService instead, through shared/clients.
+// Calls are counted in rpc.legacy_calls on /debug/vars to tell when the last
+// caller has moved.
 type RPCServer struct {
-	Models data.Models
+	Models   data.Models
+	Ingester *Ingester
 }
 
 // RPCPayload is the type for data we receive from RPC
 type RPCPayload struct {
-	Name string
-	Data string
+	Name    string
+	Data    string
+	UserID  string
+	Service string
+	Level   string
+	TraceID string
+	// Timestamp defaults to the time the entry is received
+	Timestamp time.Time
+	Fields    map[string]string
 }
 
 // LogInfo writes our payload to mongo
 func (r *RPCServer) LogInfo(payload RPCPayload, resp *string) error {
+	legacyCall("LogInfo")
+
 	event := data.LogEntry{
+		Service:   payload.Service,
+		Level:     payload.Level,
 		Name:      payload.Name,
 		Data:      payload.Data,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		TraceID:   payload.TraceID,
+		UserID:    payload.UserID,
+		Fields:    stringFields(payload.Fields),
+		Timestamp: payload.Timestamp,
 	}
 
-	err := r.Models.LogEntry.Insert(event)
+	err := r.Ingester.Submit(context.Background(), event)
+	if errors.Is(err, ErrDropped) {
+		*resp = "Dropped payload via RPC: " + payload.Name
+		return nil
+	}
 	if err != nil {
-		log.Println("error writing to mongo via RPC", err)
+		log.Println("error queueing log entry via RPC", err)
 		return err
 	}
 
@@ -37,3 +76,16 @@ func (r *RPCServer) LogInfo(payload RPCPayload, resp *string) error {
 	*resp = "Processed payload via RPC: " + payload.Name
 	return nil
 }
+
+// stringFields widens string fields to the map stored with an entry
+func stringFields(fields map[string]string) map[string]any {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	out := make(map[string]any, len(fields))
+	for k, v := range fields {
+		out[k] = v
+	}
+	return out
+}
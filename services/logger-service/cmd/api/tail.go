@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"log-service/data"
+	"ride-sharing/shared/env"
+
+	"github.com/gorilla/websocket"
+)
+
+// tailHeartbeat keeps idle tail connections from being closed by proxies
+const tailHeartbeat = 15 * time.Second
+
+// ErrTooManySubscribers is returned once LOG_TAIL_MAX_SUBSCRIBERS clients tail
+var ErrTooManySubscribers = errors.New("too many clients are tailing the logs")
+
+// TailStats counts the live tail subscribers and what they were sent.
+type TailStats struct {
+	Subscribers int    `json:"subscribers"`
+	Delivered   uint64 `json:"delivered"`
+	Dropped     uint64 `json:"dropped"`
+}
+
+// Tail fans the entries the Ingester accepts out to the clients tailing the
+// logs. Every subscriber has its own buffer; when a slow client lets it fill
+// up, its new entries are dropped and counted instead of holding up ingestion,
+// and the client is told how many it missed once it catches up.
+type Tail struct {
+	buffer         int
+	maxSubscribers int
+	upgrader       websocket.Upgrader
+
+	mu     sync.RWMutex
+	subs   map[*Subscriber]struct{}
+	closed bool
+	done   chan struct{}
+
+	delivered atomic.Uint64
+	dropped   atomic.Uint64
+}
+
+// Subscriber receives the entries matching its query.
+type Subscriber struct {
+	query   data.LogQuery
+	entries chan *data.LogEntry
+	dropped atomic.Uint64 // since the client was last told
+}
+
+// NewTail reads its settings from LOG_TAIL_BUFFER, LOG_TAIL_MAX_SUBSCRIBERS
+// and LOG_TAIL_ALLOWED_ORIGINS.
+func NewTail() (*Tail, error) {
+	buffer := env.GetInt("LOG_TAIL_BUFFER", 256)
+	maxSubscribers := env.GetInt("LOG_TAIL_MAX_SUBSCRIBERS", 100)
+	if buffer < 1 || maxSubscribers < 1 {
+		return nil, errors.New("LOG_TAIL_BUFFER and LOG_TAIL_MAX_SUBSCRIBERS must be positive")
+	}
+
+	return &Tail{
+		buffer:         buffer,
+		maxSubscribers: maxSubscribers,
+		subs:           map[*Subscriber]struct{}{},
+		done:           make(chan struct{}),
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 4096,
+			CheckOrigin:     tailOrigins(env.GetString("LOG_TAIL_ALLOWED_ORIGINS", "")),
+		},
+	}, nil
+}
+
+// Subscribe starts sending the entries matching query to a new subscriber.
+// Call Unsubscribe once the client is gone.
+func (t *Tail) Subscribe(query data.LogQuery) (*Subscriber, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return nil, ErrIngesterClosed
+	}
+	if len(t.subs) >= t.maxSubscribers {
+		return nil, ErrTooManySubscribers
+	}
+
+	s := &Subscriber{
+		query:   query,
+		entries: make(chan *data.LogEntry, t.buffer),
+	}
+	t.subs[s] = struct{}{}
+	return s, nil
+}
+
+func (t *Tail) Unsubscribe(s *Subscriber) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.subs, s)
+}
+
+// Broadcast hands entry to every subscriber whose query it matches, without
+// ever waiting on one.
+func (t *Tail) Broadcast(entry *data.LogEntry) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for s := range t.subs {
+		if !s.query.Matches(entry) {
+			continue
+		}
+		select {
+		case s.entries <- entry:
+			t.delivered.Add(1)
+		default:
+			s.dropped.Add(1)
+			t.dropped.Add(1)
+		}
+	}
+}
+
+// Done is closed when the service shuts down, for the streams to end.
+func (t *Tail) Done() <-chan struct{} {
+	return t.done
+}
+
+// Close ends every stream and refuses new subscribers.
+func (t *Tail) Close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.closed {
+		t.closed = true
+		close(t.done)
+	}
+}
+
+// Stats returns the tail counters.
+func (t *Tail) Stats() TailStats {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return TailStats{
+		Subscribers: len(t.subs),
+		Delivered:   t.delivered.Load(),
+		Dropped:     t.dropped.Load(),
+	}
+}
+
+// Publish exposes Stats on /debug/vars under name.
+func (t *Tail) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() any { return t.Stats() }))
+}
+
+// Entries is where the matching entries arrive.
+func (s *Subscriber) Entries() <-chan *data.LogEntry {
+	return s.entries
+}
+
+// Dropped returns how many entries were dropped since the last call. Streams
+// check it whenever they've caught up, so the notice follows the entries that
+// were sent before the gap.
+func (s *Subscriber) Dropped() uint64 {
+	if len(s.entries) > 0 {
+		return 0
+	}
+	return s.dropped.Swap(0)
+}
+
+// tailEvent is what tail streams send: a log entry, or how many entries were
+// dropped because the client was too slow.
+type tailEvent struct {
+	Type    string         `json:"type"`
+	Entry   *data.LogEntry `json:"entry,omitempty"`
+	Dropped uint64         `json:"dropped,omitempty"`
+}
+
+// stream sends sub's entries through send until ctx is done or the service
+// shuts down, telling the client about dropped entries whenever it caught up
+// and calling heartbeat when nothing was sent for a while.
+func (t *Tail) stream(ctx context.Context, sub *Subscriber, send func(tailEvent) error, heartbeat func() error) error {
+	ticker := time.NewTicker(tailHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case entry := <-sub.Entries():
+			if err := send(tailEvent{Type: "log", Entry: entry}); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			if err := heartbeat(); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.done:
+			return nil
+		}
+
+		if n := sub.Dropped(); n > 0 {
+			if err := send(tailEvent{Type: "dropped", Dropped: n}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// streamSSE sends the tail as server-sent events: "log" events whose data
+// is an entry and "dropped" events whose data is {"type":"dropped","dropped":n}.
+// It only fails when the response can't be streamed.
+func (t *Tail) streamSSE(w http.ResponseWriter, r *http.Request, sub *Subscriber) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return errors.New("streaming is not supported")
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": tailing logs\n\n")
+	flusher.Flush()
+
+	send := func(event tailEvent) error {
+		var payload any = event.Entry
+		if event.Type != "log" {
+			payload = event
+		}
+		out, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, out); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+	heartbeat := func() error {
+		if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	t.stream(r.Context(), sub, send, heartbeat)
+	return nil
+}
+
+// tailOrigins returns the origin check of the WebSocket tail for a comma
+// separated list of origins such as "https://admin.example.com". Without any,
+// it is the same-origin check of websocket.Upgrader. Either way, clients
+// sending no Origin are let in; browsers always send one.
+func tailOrigins(list string) func(r *http.Request) bool {
+	allowed := map[string]bool{}
+	for _, origin := range strings.Split(list, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			allowed[strings.ToLower(strings.TrimSuffix(origin, "/"))] = true
+		}
+	}
+	if len(allowed) == 0 {
+		return nil
+	}
+
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		return origin == "" || allowed[strings.ToLower(origin)]
+	}
+}
+
+// streamWebSocket sends the tail as JSON text messages, each a tailEvent.
+// Anything the client sends is ignored; closing the socket ends the tail.
+func (t *Tail) streamWebSocket(w http.ResponseWriter, r *http.Request, sub *Subscriber) {
+	conn, err := t.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade already answered the request
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	// Reading handles the pings and close frames of the client
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(event tailEvent) error {
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		return conn.WriteJSON(event)
+	}
+	heartbeat := func() error {
+		return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second))
+	}
+
+	if err := t.stream(ctx, sub, send, heartbeat); err == nil {
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, "logger service shutting down"),
+			time.Now().Add(time.Second))
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"log-service/data"
+	loggerpb "ride-sharing/shared/generated/logger"
+	"ride-sharing/shared/servicetoken"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestTailOrigins(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed string
+		origin  string // "self" for the origin of the server
+		token   string
+		status  int
+	}{
+		{"without token", "", "", "", http.StatusUnauthorized},
+		{"no origin", "", "", "service-token", http.StatusSwitchingProtocols},
+		{"same origin", "", "self", "service-token", http.StatusSwitchingProtocols},
+		{"other origin", "", "https://evil.example.com", "service-token", http.StatusForbidden},
+		{"allowed origin", "https://admin.example.com, https://ops.example.com/", "https://ops.example.com", "service-token", http.StatusSwitchingProtocols},
+		{"origin not allowed", "https://admin.example.com", "https://evil.example.com", "service-token", http.StatusForbidden},
+		{"own origin not allowed", "https://admin.example.com", "self", "service-token", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("LOG_TAIL_ALLOWED_ORIGINS", tt.allowed)
+			tail, err := NewTail()
+			if err != nil {
+				t.Fatal(err)
+			}
+			app := &Config{Tail: tail, ServiceToken: "service-token", AdminToken: "admin-token"}
+			srv := httptest.NewServer(app.routes())
+			defer srv.Close()
+			defer tail.Close()
+
+			header := http.Header{}
+			if tt.token != "" {
+				header.Set("Authorization", "Bearer "+tt.token)
+			}
+			switch tt.origin {
+			case "":
+			case "self":
+				header.Set("Origin", srv.URL)
+			default:
+				header.Set("Origin", tt.origin)
+			}
+
+			conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/logs/tail", header)
+			if conn != nil {
+				conn.Close()
+			}
+			if resp == nil {
+				t.Fatalf("no response: %v", err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status %d, want %d", resp.StatusCode, tt.status)
+			}
+		})
+	}
+}
+
+func TestTailLogsGuard(t *testing.T) {
+	tail, err := NewTail()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tail.Close()
+
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(servicetoken.UnaryServerInterceptor("service-token", internalMethods...)),
+		grpc.ChainStreamInterceptor(servicetoken.StreamServerInterceptor("service-token", internalMethods...)),
+	)
+	loggerpb.RegisterLoggerServiceServer(s, &loggerGrpcServer{tail: tail})
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := loggerpb.NewLoggerServiceClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, token := range []string{"", "wrong"} {
+		callCtx := ctx
+		if token != "" {
+			callCtx = servicetoken.Outgoing(ctx, token)
+		}
+		stream, err := client.TailLogs(callCtx, &loggerpb.TailLogsRequest{})
+		if err == nil {
+			_, err = stream.Recv()
+		}
+		if status.Code(err) != codes.Unauthenticated {
+			t.Fatalf("TailLogs with token %q: %v", token, err)
+		}
+	}
+
+	stream, err := client.TailLogs(servicetoken.Outgoing(ctx, "service-token"), &loggerpb.TailLogsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the entry is sent once the stream has subscribed
+	go func() {
+		for tail.Stats().Subscribers == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		tail.Broadcast(&data.LogEntry{ID: "65f000000000000000000001", Name: "trip created", Level: data.LevelInfo})
+	}()
+	event, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.GetEntry().GetName() != "trip created" {
+		t.Fatalf("event %v", event)
+	}
+}
@@ -2,7 +2,10 @@ package data
 
 import (
 	"context"
+	"errors"
 	"log"
+	"regexp"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -25,23 +28,50 @@ type Models struct {
 	LogEntry LogEntry
 }
 
+// Log levels, from least to most severe
+const (
+	LevelDebug = "debug"
+	LevelInfo  = "info"
+	LevelWarn  = "warn"
+	LevelError = "error"
+)
+
+// LogEntry is one structured log record. Name is the event or message, Data
+// free-form text, and Fields any extra key/values the producer attached.
+// Timestamp is when the event happened, CreatedAt when it was stored.
 type LogEntry struct {
-	ID        string    `bson:"_id,omitempty" json:"id,omitempty"`
-	Name      string    `bson:"name" json:"name"`
-	Data      string    `bson:"data" json:"data"`
-	CreatedAt time.Time `bson:"created_at" json:"created_at"`
-	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+	ID        string         `bson:"_id,omitempty" json:"id,omitempty"`
+	Service   string         `bson:"service,omitempty" json:"service,omitempty"`
+	Level     string         `bson:"level,omitempty" json:"level,omitempty"`
+	Name      string         `bson:"name" json:"name"`
+	Data      string         `bson:"data" json:"data"`
+	TraceID   string         `bson:"trace_id,omitempty" json:"trace_id,omitempty"`
+	UserID    string         `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	Fields    map[string]any `bson:"fields,omitempty" json:"fields,omitempty"`
+	Timestamp time.Time      `bson:"timestamp" json:"timestamp"`
+	CreatedAt time.Time      `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time      `bson:"updated_at" json:"updated_at"`
+}
+
+// NormalizeLevel maps a level name to one of the Level constants, "info" when
+// it is empty or unknown.
+func NormalizeLevel(level string) string {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "debug", "trace":
+		return LevelDebug
+	case "warn", "warning":
+		return LevelWarn
+	case "error", "err", "fatal", "panic":
+		return LevelError
+	default:
+		return LevelInfo
+	}
 }
 
 func (l *LogEntry) Insert(entry LogEntry) error {
 	collection := client.Database("logs").Collection("logs")
 
-	_, err := collection.InsertOne(context.TODO(), LogEntry{
-		Name: entry.Name,
-		Data: entry.Data,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-	})
+	_, err := collection.InsertOne(context.TODO(), prepare(entry, time.Now()))
 	if err != nil {
 		log.Println("Error inserting into logs:", err)
 		return err
@@ -50,6 +80,92 @@ func (l *LogEntry) Insert(entry LogEntry) error {
 	return nil
 }
 
+// InsertMany writes a batch of entries in one round trip. The batch is
+// unordered, so one bad entry doesn't stop the others: it returns how many
+// entries Mongo rejected, and no error when the rest were written. Entries
+// keep the ID AssignIDs gave them, so writing a batch again after a failure
+// doesn't duplicate the ones a previous attempt stored.
+func (l *LogEntry) InsertMany(ctx context.Context, entries []LogEntry) (int, error) {
+	if len(entries) == 0 {
+		return 0, nil
+	}
+
+	collection := client.Database("logs").Collection("logs")
+
+	now := time.Now()
+	docs := make([]any, len(entries))
+	for i, entry := range entries {
+		docs[i] = prepare(entry, now)
+	}
+
+	_, err := collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	return rejectedWrites(err)
+}
+
+// rejectedWrites counts the entries a batch insert failed on. A duplicate key
+// is an entry an earlier attempt at the batch already stored, so it counts as
+// written; any error but per-entry ones fails the whole batch.
+func rejectedWrites(err error) (int, error) {
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil {
+		return 0, err
+	}
+
+	rejected := 0
+	for _, writeErr := range bulkErr.WriteErrors {
+		if !mongo.IsDuplicateKeyError(writeErr.WriteError) {
+			rejected++
+		}
+	}
+	return rejected, nil
+}
+
+// AssignIDs gives every entry without an ID a new one. Writers that retry a
+// batch call it once before the first attempt.
+func AssignIDs(entries []LogEntry) {
+	for i := range entries {
+		if entries[i].ID == "" {
+			entries[i].ID = primitive.NewObjectID().Hex()
+		}
+	}
+}
+
+// document is how an entry is stored, its ID being the ObjectID the queries
+// sort and page on
+type document struct {
+	ID       primitive.ObjectID `bson:"_id"`
+	LogEntry `bson:",inline"`
+}
+
+// prepare returns the document stored for entry, received at now. It keeps
+// the ID of the entry and only makes one up for an entry without.
+func prepare(entry LogEntry, now time.Time) document {
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = now
+	}
+
+	id, err := primitive.ObjectIDFromHex(entry.ID)
+	if err != nil {
+		id = primitive.NewObjectID()
+	}
+
+	return document{
+		ID: id,
+		LogEntry: LogEntry{
+			Service:   entry.Service,
+			Level:     NormalizeLevel(entry.Level),
+			Name:      entry.Name,
+			Data:      entry.Data,
+			TraceID:   entry.TraceID,
+			UserID:    entry.UserID,
+			Fields:    entry.Fields,
+			Timestamp: entry.Timestamp,
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+	}
+}
+
 func (l *LogEntry) All() ([]*LogEntry, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
@@ -57,7 +173,7 @@ func (l *LogEntry) All() ([]*LogEntry, error) {
 	collection := client.Database("logs").Collection("logs")
 
 	opts := options.Find()
-	opts.SetSort(bson.D{{"created_at", -1}})
+	opts.SetSort(bson.D{{Key: "created_at", Value: -1}})
 
 	cursor, err := collection.Find(context.TODO(), bson.D{}, opts)
 	if err != nil {
@@ -83,6 +199,65 @@ func (l *LogEntry) All() ([]*LogEntry, error) {
 	return logs, nil
 }
 
+// AllByUserID returns every log entry that belongs to or mentions the user.
+func (l *LogEntry) AllByUserID(userID string) ([]*LogEntry, error) {
+	if userID == "" {
+		return nil, errors.New("user id is required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	collection := client.Database("logs").Collection("logs")
+
+	opts := options.Find()
+	opts.SetSort(bson.D{{Key: "created_at", Value: -1}})
+
+	cursor, err := collection.Find(ctx, userFilter(userID), opts)
+	if err != nil {
+		log.Println("Finding user docs error:", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	logs := []*LogEntry{}
+	if err := cursor.All(ctx, &logs); err != nil {
+		log.Print("Error decoding user logs:", err)
+		return nil, err
+	}
+
+	return logs, nil
+}
+
+// DeleteByUserID removes every log entry that belongs to or mentions the user
+// and returns the number of deleted entries.
+func (l *LogEntry) DeleteByUserID(userID string) (int64, error) {
+	if userID == "" {
+		return 0, errors.New("user id is required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	collection := client.Database("logs").Collection("logs")
+
+	result, err := collection.DeleteMany(ctx, userFilter(userID))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.DeletedCount, nil
+}
+
+// userFilter matches entries tagged with the user ID as well as older entries
+// that only carry the ID inside their free-form data.
+func userFilter(userID string) bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{"user_id": userID},
+		bson.M{"data": primitive.Regex{Pattern: regexp.QuoteMeta(userID)}},
+	}}
+}
+
 func (l *LogEntry) GetOne(id string) (*LogEntry, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
@@ -131,10 +306,10 @@ func (l *LogEntry) Update() (*mongo.UpdateResult, error) {
 		ctx,
 		bson.M{"_id": docID},
 		bson.D{
-			{"$set", bson.D{
-				{"name", l.Name},
-				{"data", l.Data},
-				{"updated_at", time.Now()},
+			{Key: "$set", Value: bson.D{
+				{Key: "name", Value: l.Name},
+				{Key: "data", Value: l.Data},
+				{Key: "updated_at", Value: time.Now()},
 			}},
 		},
 	)
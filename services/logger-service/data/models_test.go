@@ -0,0 +1,93 @@
+package data
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestAssignIDs(t *testing.T) {
+	entries := []LogEntry{{Name: "a"}, {Name: "b"}, {ID: "65f000000000000000000001", Name: "c"}}
+	AssignIDs(entries)
+
+	if entries[0].ID == "" || entries[0].ID == entries[1].ID {
+		t.Fatalf("IDs %q and %q, want two new ones", entries[0].ID, entries[1].ID)
+	}
+	if entries[2].ID != "65f000000000000000000001" {
+		t.Fatalf("existing ID replaced by %q", entries[2].ID)
+	}
+
+	// a retried batch is written with the same IDs
+	first := entries[0].ID
+	AssignIDs(entries)
+	if entries[0].ID != first {
+		t.Fatalf("ID changed from %q to %q", first, entries[0].ID)
+	}
+}
+
+func TestPrepareKeepsID(t *testing.T) {
+	now := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	entry := LogEntry{ID: primitive.NewObjectID().Hex(), Name: "trip created", Level: "WARNING"}
+
+	first, second := prepare(entry, now), prepare(entry, now.Add(time.Second))
+	if first.ID.Hex() != entry.ID || second.ID != first.ID {
+		t.Fatalf("documents %s and %s of entry %s", first.ID.Hex(), second.ID.Hex(), entry.ID)
+	}
+	if first.Level != LevelWarn || !first.Timestamp.Equal(now) {
+		t.Fatalf("document %+v", first)
+	}
+
+	// stored with one _id, an ObjectID
+	raw, err := bson.Marshal(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, ok := bson.Raw(raw).Lookup("_id").ObjectIDOK(); !ok || id.Hex() != entry.ID {
+		t.Fatalf("stored _id %v", bson.Raw(raw).Lookup("_id"))
+	}
+
+	if doc := prepare(LogEntry{Name: "no ID"}, now); doc.ID.IsZero() {
+		t.Fatal("entry without an ID stored without one")
+	}
+}
+
+func TestRejectedWrites(t *testing.T) {
+	writeErr := func(code int) mongo.BulkWriteError {
+		return mongo.BulkWriteError{WriteError: mongo.WriteError{Code: code, Message: "write error"}}
+	}
+
+	tests := []struct {
+		name     string
+		err      error
+		rejected int
+		fails    bool
+	}{
+		{"written", nil, 0, false},
+		{"stored by an earlier attempt", mongo.BulkWriteException{
+			WriteErrors: []mongo.BulkWriteError{writeErr(11000), writeErr(11000)},
+		}, 0, false},
+		{"some rejected", mongo.BulkWriteException{
+			WriteErrors: []mongo.BulkWriteError{writeErr(11000), writeErr(2), writeErr(121)},
+		}, 2, false},
+		{"write concern", mongo.BulkWriteException{
+			WriteConcernError: &mongo.WriteConcernError{Code: 64, Message: "waiting for replication timed out"},
+		}, 0, true},
+		{"stored, but not replicated", mongo.BulkWriteException{
+			WriteErrors:       []mongo.BulkWriteError{writeErr(11000)},
+			WriteConcernError: &mongo.WriteConcernError{Code: 64, Message: "waiting for replication timed out"},
+		}, 0, true},
+		{"network", errors.New("connection reset"), 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rejected, err := rejectedWrites(tt.err)
+			if rejected != tt.rejected || (err != nil) != tt.fails {
+				t.Fatalf("rejectedWrites = %d, %v; want %d, failing %v", rejected, err, tt.rejected, tt.fails)
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package data
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	DefaultQueryLimit = 50
+	MaxQueryLimit     = 500
+)
+
+// ErrInvalidCursor is returned for a cursor that wasn't issued by Query
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// LogQuery filters log entries. Zero values don't filter.
+type LogQuery struct {
+	From    time.Time // inclusive
+	To      time.Time // exclusive
+	Service string
+	Levels  []string
+	TraceID string
+	UserID  string
+	Search  string // full-text search over the text of the entry
+	Cursor  string // NextCursor of the previous page
+	Limit   int
+}
+
+// LogPage is one page of query results, newest first. NextCursor is empty on
+// the last page.
+type LogPage struct {
+	Entries    []*LogEntry `json:"entries"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// EnsureIndexes creates the indexes the query API relies on and gives entries
+// written before the structured schema a timestamp.
+func (l *LogEntry) EnsureIndexes(ctx context.Context) error {
+	collection := client.Database("logs").Collection("logs")
+
+	_, err := collection.UpdateMany(ctx,
+		bson.M{"timestamp": bson.M{"$exists": false}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{"timestamp": "$created_at"}}}},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "timestamp", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "service", Value: 1}, {Key: "level", Value: 1}, {Key: "timestamp", Value: -1}}},
+		{Keys: bson.D{{Key: "level", Value: 1}, {Key: "timestamp", Value: -1}}},
+		{
+			Keys:    bson.D{{Key: "trace_id", Value: 1}, {Key: "timestamp", Value: -1}},
+			Options: options.Index().SetPartialFilterExpression(bson.M{"trace_id": bson.M{"$exists": true}}),
+		},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "timestamp", Value: -1}}},
+		// Every string in the entry, fields included, is searchable
+		{Keys: bson.D{{Key: "$**", Value: "text"}}, Options: options.Index().SetName("text_search")},
+	})
+	return err
+}
+
+// Query returns the entries matching q, newest first, a page at a time.
+func (l *LogEntry) Query(ctx context.Context, q LogQuery) (*LogPage, error) {
+	collection := client.Database("logs").Collection("logs")
+
+	filter, err := q.filter()
+	if err != nil {
+		return nil, err
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultQueryLimit
+	}
+	if limit > MaxQueryLimit {
+		limit = MaxQueryLimit
+	}
+
+	// Fetch one more than asked to know whether there's a next page
+	opts := options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(int64(limit + 1))
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	entries := []*LogEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+
+	page := &LogPage{Entries: entries}
+	if len(entries) > limit {
+		page.Entries = entries[:limit]
+		last := page.Entries[limit-1]
+		page.NextCursor = encodeCursor(last.Timestamp, last.ID)
+	}
+	return page, nil
+}
+
+func (q LogQuery) filter() (bson.M, error) {
+	and := bson.A{}
+
+	timestamp := bson.M{}
+	if !q.From.IsZero() {
+		timestamp["$gte"] = q.From
+	}
+	if !q.To.IsZero() {
+		timestamp["$lt"] = q.To
+	}
+	if len(timestamp) > 0 {
+		and = append(and, bson.M{"timestamp": timestamp})
+	}
+
+	if q.Service != "" {
+		and = append(and, bson.M{"service": q.Service})
+	}
+	if len(q.Levels) > 0 {
+		levels := make(bson.A, 0, len(q.Levels))
+		for _, level := range q.Levels {
+			levels = append(levels, NormalizeLevel(level))
+		}
+		and = append(and, bson.M{"level": bson.M{"$in": levels}})
+	}
+	if q.TraceID != "" {
+		and = append(and, bson.M{"trace_id": q.TraceID})
+	}
+	if q.UserID != "" {
+		and = append(and, bson.M{"user_id": q.UserID})
+	}
+	if q.Search != "" {
+		and = append(and, bson.M{"$text": bson.M{"$search": q.Search}})
+	}
+
+	if q.Cursor != "" {
+		ts, id, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		// Entries after the last one of the previous page in (timestamp, _id) order
+		and = append(and, bson.M{"$or": bson.A{
+			bson.M{"timestamp": bson.M{"$lt": ts}},
+			bson.M{"timestamp": ts, "_id": bson.M{"$lt": id}},
+		}})
+	}
+
+	if len(and) == 0 {
+		return bson.M{}, nil
+	}
+	return bson.M{"$and": and}, nil
+}
+
+// Matches reports whether e passes the filters of q, for entries that are not
+// in Mongo yet such as the live tail. Search approximates the text index: an
+// entry matches when any of the words appears in its name, data or string
+// fields, ignoring case. Cursor and Limit don't apply.
+func (q LogQuery) Matches(e *LogEntry) bool {
+	if !q.From.IsZero() && e.Timestamp.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !e.Timestamp.Before(q.To) {
+		return false
+	}
+	if q.Service != "" && e.Service != q.Service {
+		return false
+	}
+	if q.TraceID != "" && e.TraceID != q.TraceID {
+		return false
+	}
+	if q.UserID != "" && e.UserID != q.UserID {
+		return false
+	}
+
+	if len(q.Levels) > 0 {
+		level := NormalizeLevel(e.Level)
+		found := false
+		for _, l := range q.Levels {
+			if NormalizeLevel(l) == level {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if q.Search != "" {
+		text := []string{strings.ToLower(e.Name), strings.ToLower(e.Data)}
+		for _, v := range e.Fields {
+			if str, ok := v.(string); ok {
+				text = append(text, strings.ToLower(str))
+			}
+		}
+		for _, word := range strings.Fields(strings.ToLower(q.Search)) {
+			for _, t := range text {
+				if strings.Contains(t, word) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+// A cursor is the timestamp and ID of the last entry of a page
+func encodeCursor(ts time.Time, id string) string {
+	raw := strconv.FormatInt(ts.UnixMilli(), 10) + ":" + id
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (time.Time, primitive.ObjectID, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, primitive.NilObjectID, ErrInvalidCursor
+	}
+
+	millis, hexID, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return time.Time{}, primitive.NilObjectID, ErrInvalidCursor
+	}
+
+	ms, err := strconv.ParseInt(millis, 10, 64)
+	if err != nil {
+		return time.Time{}, primitive.NilObjectID, ErrInvalidCursor
+	}
+	id, err := primitive.ObjectIDFromHex(hexID)
+	if err != nil {
+		return time.Time{}, primitive.NilObjectID, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+
+	return time.UnixMilli(ms).UTC(), id, nil
+}
@@ -0,0 +1,172 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Any matches every service or every level in a RetentionRule
+const Any = "*"
+
+// RetentionRule keeps the entries of a service and level for MaxAge. Zero
+// MaxAge keeps them forever.
+type RetentionRule struct {
+	Service string        `json:"service"`
+	Level   string        `json:"level"`
+	MaxAge  time.Duration `json:"-"`
+}
+
+func (r RetentionRule) String() string {
+	if r.MaxAge == 0 {
+		return fmt.Sprintf("%s:%s=forever", r.Service, r.Level)
+	}
+	return fmt.Sprintf("%s:%s=%s", r.Service, r.Level, r.MaxAge)
+}
+
+// specificity ranks rules so the most specific one applies to an entry: a
+// service and level beat a service, which beats a level, which beats the default.
+func (r RetentionRule) specificity() int {
+	n := 0
+	if r.Service != Any {
+		n += 2
+	}
+	if r.Level != Any {
+		n++
+	}
+	return n
+}
+
+// overlaps reports whether some entries match both rules
+func (r RetentionRule) overlaps(o RetentionRule) bool {
+	return (r.Service == Any || o.Service == Any || r.Service == o.Service) &&
+		(r.Level == Any || o.Level == Any || r.Level == o.Level)
+}
+
+func (r RetentionRule) match() bson.M {
+	m := bson.M{}
+	if r.Service != Any {
+		m["service"] = r.Service
+	}
+	if r.Level != Any {
+		m["level"] = r.Level
+	}
+	return m
+}
+
+// ExpiredFilter matches the entries rule applies to that are older than its
+// MaxAge at now. Entries a more specific rule of rules covers are left to
+// that rule.
+func ExpiredFilter(rule RetentionRule, rules []RetentionRule, now time.Time) bson.M {
+	and := bson.A{
+		rule.match(),
+		bson.M{"timestamp": bson.M{"$lt": now.Add(-rule.MaxAge)}},
+	}
+
+	for _, other := range rules {
+		if other.specificity() > rule.specificity() && rule.overlaps(other) {
+			and = append(and, bson.M{"$nor": bson.A{other.match()}})
+		}
+	}
+
+	return bson.M{"$and": and}
+}
+
+// Iterate calls fn with up to limit entries matching filter, oldest first.
+func (l *LogEntry) Iterate(ctx context.Context, filter bson.M, limit int64, fn func(*LogEntry) error) error {
+	collection := client.Database("logs").Collection("logs")
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: 1}, {Key: "_id", Value: 1}}).
+		SetLimit(limit)
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var entry LogEntry
+		if err := cursor.Decode(&entry); err != nil {
+			return err
+		}
+		if err := fn(&entry); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// DeleteIDs removes the entries with the given IDs.
+func (l *LogEntry) DeleteIDs(ctx context.Context, ids []string) (int64, error) {
+	collection := client.Database("logs").Collection("logs")
+
+	docIDs := make(bson.A, 0, len(ids))
+	for _, id := range ids {
+		docID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return 0, err
+		}
+		docIDs = append(docIDs, docID)
+	}
+
+	result, err := collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": docIDs}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// DeleteMatching removes every entry matching filter.
+func (l *LogEntry) DeleteMatching(ctx context.Context, filter bson.M) (int64, error) {
+	collection := client.Database("logs").Collection("logs")
+
+	result, err := collection.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// StorageStats is the size of the logs collection.
+type StorageStats struct {
+	Entries      int64 `json:"entries"`
+	DataBytes    int64 `json:"data_bytes"`
+	StorageBytes int64 `json:"storage_bytes"`
+	IndexBytes   int64 `json:"index_bytes"`
+}
+
+// Storage returns the size of the logs collection.
+func (l *LogEntry) Storage(ctx context.Context) (*StorageStats, error) {
+	var stats bson.M
+	err := client.Database("logs").RunCommand(ctx, bson.D{{Key: "collStats", Value: "logs"}}).Decode(&stats)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StorageStats{
+		Entries:      toInt64(stats["count"]),
+		DataBytes:    toInt64(stats["size"]),
+		StorageBytes: toInt64(stats["storageSize"]),
+		IndexBytes:   toInt64(stats["totalIndexSize"]),
+	}, nil
+}
+
+// toInt64 reads a number Mongo may return as int32, int64 or double
+func toInt64(v any) int64 {
+	switch n := v.(type) {
+	case int32:
+		return int64(n)
+	case int64:
+		return n
+	case float64:
+		return int64(n)
+	default:
+		return 0
+	}
+}
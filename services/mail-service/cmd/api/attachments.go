@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+
+	"mailer-service/data"
+	"ride-sharing/shared/clients"
+	"ride-sharing/shared/env"
+	imagepb "ride-sharing/shared/generated/image"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// imageFetchTimeout bounds reading an imageRef from image-service
+const imageFetchTimeout = 15 * time.Second
+
+// ErrInvalidAttachment is returned for an attachment that breaks the policy
+var ErrInvalidAttachment = errors.New("invalid attachment")
+
+// errPathAttachments rejects the old file path attachments
+var errPathAttachments = fmt.Errorf("%w: file paths can't be attached, send the content or an imageRef", ErrInvalidAttachment)
+
+// Attachment is a file to attach to a mail: either the bytes themselves, or a
+// reference ("folder/file") to an object stored through image-service. Files
+// on the mail-service host can't be attached.
+type Attachment struct {
+	Name        string `json:"name"`
+	ContentType string `json:"contentType,omitempty"`
+	Content     []byte `json:"content,omitempty"` // base64 in JSON
+	ImageRef    string `json:"imageRef,omitempty"`
+}
+
+// AttachmentPolicy decides which attachments a mail may carry and loads the
+// ones stored in image-service.
+type AttachmentPolicy struct {
+	MaxAttachmentBytes int64 // per attachment
+	MaxMessageBytes    int64 // body and attachments together
+	AllowedTypes       map[string]bool
+	// Images reads the imageRefs with the access token of the user the mail
+	// is sent for, so image-service lets them attach only the images that
+	// user may read; without one, only public images
+	Images imagepb.ImageServiceClient
+}
+
+func newAttachmentPolicy() AttachmentPolicy {
+	allowed := map[string]bool{}
+	types := env.GetString("MAIL_ATTACHMENT_TYPES",
+		"application/pdf,image/jpeg,image/png,image/gif,image/webp,text/plain,text/csv,text/calendar")
+	for _, t := range strings.Split(types, ",") {
+		if t = strings.TrimSpace(strings.ToLower(t)); t != "" {
+			allowed[t] = true
+		}
+	}
+
+	images, err := clients.NewImageClient(clients.Default())
+	if err != nil {
+		log.Fatalf("Error setting up the image-service client: %v", err)
+	}
+
+	return AttachmentPolicy{
+		MaxAttachmentBytes: int64(env.GetInt("MAIL_MAX_ATTACHMENT_BYTES", 5<<20)),
+		MaxMessageBytes:    int64(env.GetInt("MAIL_MAX_MESSAGE_BYTES", 10<<20)),
+		AllowedTypes:       allowed,
+		Images:             images,
+	}
+}
+
+// Resolve checks every attachment against the policy, loads image references
+// with userToken, the access token of the user the mail is sent for, and
+// returns what should be stored with the mail. bodyBytes is the size of the
+// rendered bodies, which counts towards MaxMessageBytes.
+func (p AttachmentPolicy) Resolve(ctx context.Context, attachments []Attachment, userToken string, bodyBytes int) ([]data.Attachment, error) {
+	total := int64(bodyBytes)
+	resolved := make([]data.Attachment, 0, len(attachments))
+
+	for i, a := range attachments {
+		name, err := attachmentName(a.Name)
+		if err != nil {
+			return nil, fmt.Errorf("%w %d: %v", ErrInvalidAttachment, i+1, err)
+		}
+
+		content := a.Content
+		contentType := a.ContentType
+		switch {
+		case a.ImageRef != "" && len(a.Content) > 0:
+			return nil, fmt.Errorf("%w %s: set either content or imageRef, not both", ErrInvalidAttachment, name)
+		case a.ImageRef != "":
+			content, contentType, err = p.fetchImage(ctx, a.ImageRef, contentType, userToken)
+			if err != nil {
+				return nil, fmt.Errorf("%w %s: %v", ErrInvalidAttachment, name, err)
+			}
+		case len(a.Content) == 0:
+			return nil, fmt.Errorf("%w %s: content or imageRef is required", ErrInvalidAttachment, name)
+		}
+
+		if int64(len(content)) > p.MaxAttachmentBytes {
+			return nil, fmt.Errorf("%w %s: larger than %d bytes", ErrInvalidAttachment, name, p.MaxAttachmentBytes)
+		}
+
+		contentType, err = p.contentType(contentType, content)
+		if err != nil {
+			return nil, fmt.Errorf("%w %s: %v", ErrInvalidAttachment, name, err)
+		}
+
+		total += int64(len(content))
+		if total > p.MaxMessageBytes {
+			return nil, fmt.Errorf("%w: message is larger than %d bytes", ErrInvalidAttachment, p.MaxMessageBytes)
+		}
+
+		resolved = append(resolved, data.Attachment{
+			Name:        name,
+			ContentType: contentType,
+			Content:     content,
+			ImageRef:    a.ImageRef,
+		})
+	}
+
+	return resolved, nil
+}
+
+// contentType returns the media type of an attachment, sniffed when not given,
+// if the policy allows it
+func (p AttachmentPolicy) contentType(declared string, content []byte) (string, error) {
+	if declared == "" {
+		declared = http.DetectContentType(content)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(declared)
+	if err != nil {
+		return "", fmt.Errorf("bad content type %q", declared)
+	}
+	if !p.AllowedTypes[mediaType] {
+		return "", fmt.Errorf("content type %s is not allowed", mediaType)
+	}
+	return mediaType, nil
+}
+
+// fetchImage reads an object stored through image-service as the user of
+// userToken, anonymously when it is empty
+func (p AttachmentPolicy) fetchImage(ctx context.Context, ref, contentType, userToken string) ([]byte, string, error) {
+	if err := checkImageRef(ref); err != nil {
+		return nil, "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, imageFetchTimeout)
+	defer cancel()
+	if userToken != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+userToken)
+	}
+
+	stream, err := p.Images.ReadImage(ctx, &imagepb.ReadImageRequest{Key: ref})
+	if err != nil {
+		return nil, "", fmt.Errorf("fetch from image-service: %v", err)
+	}
+
+	var content []byte
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if status.Code(err) == codes.NotFound {
+			return nil, "", fmt.Errorf("imageRef %q not found", ref)
+		}
+		if code := status.Code(err); code == codes.Unauthenticated || code == codes.PermissionDenied {
+			return nil, "", fmt.Errorf("imageRef %q is not readable by the sender", ref)
+		}
+		if status.Code(err) == codes.FailedPrecondition {
+			return nil, "", fmt.Errorf("imageRef %q is quarantined until its malware scan is clean", ref)
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("fetch from image-service: %v", err)
+		}
+
+		if contentType == "" && chunk.GetContentType() != "" {
+			contentType = chunk.GetContentType()
+		}
+		content = append(content, chunk.GetData()...)
+		// Stop one byte past the limit, Resolve rejects oversized objects
+		if int64(len(content)) > p.MaxAttachmentBytes {
+			break
+		}
+	}
+	return content, contentType, nil
+}
+
+// checkImageRef validates an image-service reference
+func checkImageRef(ref string) error {
+	if strings.Contains(ref, "://") || strings.HasPrefix(ref, "/") || strings.Contains(ref, "\\") {
+		return fmt.Errorf("imageRef must be a folder/file key, not a path or URL")
+	}
+
+	for _, part := range strings.Split(ref, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("imageRef %q is not a valid key", ref)
+		}
+	}
+	return nil
+}
+
+// attachmentName returns the file name shown to the recipient
+func attachmentName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("name is required")
+	}
+	if strings.ContainsAny(name, "/\\") || name != path.Base(name) {
+		return "", fmt.Errorf("name %q must be a file name, not a path", name)
+	}
+	if strings.IndexFunc(name, unicode.IsControl) >= 0 || name == "." || name == ".." {
+		return "", fmt.Errorf("name %q is not a valid file name", name)
+	}
+	return name, nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	imagepb "ride-sharing/shared/generated/image"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// storedImage is an object of the fake image-service
+type storedImage struct {
+	owner       string
+	public      bool
+	quarantined bool
+	contentType string
+	content     string
+}
+
+// fakeImages is an image-service that, like the real one, lets users read
+// the public images and their own, the users being those of users by token
+type fakeImages struct {
+	imagepb.UnimplementedImageServiceServer
+	users  map[string]string
+	images map[string]storedImage
+
+	mu     sync.Mutex
+	tokens []string // presented by the reads, "" for anonymous ones
+}
+
+func (s *fakeImages) ReadImage(req *imagepb.ReadImageRequest, stream grpc.ServerStreamingServer[imagepb.ImageChunk]) error {
+	var token string
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	if v := md.Get("authorization"); len(v) > 0 {
+		token = strings.TrimPrefix(v[0], "Bearer ")
+	}
+	s.mu.Lock()
+	s.tokens = append(s.tokens, token)
+	s.mu.Unlock()
+
+	img, ok := s.images[req.GetKey()]
+	if !ok {
+		return status.Error(codes.NotFound, "image not found")
+	}
+	user, known := s.users[token]
+	if token != "" && !known {
+		return status.Error(codes.Unauthenticated, "invalid token")
+	}
+	if !img.public && (user == "" || user != img.owner) {
+		return status.Error(codes.PermissionDenied, "not allowed on this image")
+	}
+	if img.quarantined {
+		return status.Error(codes.FailedPrecondition, "quarantined")
+	}
+
+	// in two chunks, the first one typed
+	half := len(img.content) / 2
+	if err := stream.Send(&imagepb.ImageChunk{ContentType: img.contentType, Size: int64(len(img.content)), Data: []byte(img.content[:half])}); err != nil {
+		return err
+	}
+	return stream.Send(&imagepb.ImageChunk{Data: []byte(img.content[half:])})
+}
+
+func (s *fakeImages) presented() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.tokens...)
+}
+
+// newTestPolicy returns a policy reading the images of server
+func newTestPolicy(t *testing.T, server imagepb.ImageServiceServer) AttachmentPolicy {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	imagepb.RegisterImageServiceServer(s, server)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return AttachmentPolicy{
+		MaxAttachmentBytes: 64,
+		MaxMessageBytes:    100,
+		AllowedTypes:       map[string]bool{"image/png": true, "text/plain": true, "application/pdf": true},
+		Images:             imagepb.NewImageServiceClient(conn),
+	}
+}
+
+func newFakeImages() *fakeImages {
+	return &fakeImages{
+		users: map[string]string{"token-a": "user-a", "token-b": "user-b"},
+		images: map[string]storedImage{
+			"user-a/private.png": {owner: "user-a", contentType: "image/png", content: "\x89PNG private of A"},
+			"user-a/public.png":  {owner: "user-a", public: true, contentType: "image/png", content: "\x89PNG public of A"},
+		},
+	}
+}
+
+func TestPrivateImageOfAnotherUser(t *testing.T) {
+	images := newFakeImages()
+	p := newTestPolicy(t, images)
+	ctx := context.Background()
+	private := []Attachment{{Name: "photo.png", ImageRef: "user-a/private.png"}}
+
+	for _, token := range []string{"token-b", ""} {
+		_, err := p.Resolve(ctx, private, token, 0)
+		if !errors.Is(err, ErrInvalidAttachment) || !strings.Contains(err.Error(), "not readable") {
+			t.Fatalf("user-a/private.png attached with token %q: %v", token, err)
+		}
+	}
+
+	resolved, err := p.Resolve(ctx, private, "token-a", 0)
+	if err != nil {
+		t.Fatalf("user-a/private.png attached by its owner: %v", err)
+	}
+	if string(resolved[0].Content) != "\x89PNG private of A" {
+		t.Fatalf("content %q", resolved[0].Content)
+	}
+
+	// image-service was only ever asked with the tokens of the senders
+	if got := images.presented(); strings.Join(got, ",") != "token-b,,token-a" {
+		t.Fatalf("image-service was read with %q", got)
+	}
+}
+
+func TestResolveAttachments(t *testing.T) {
+	images := newFakeImages()
+	images.images["user-a/big.png"] = storedImage{owner: "user-a", public: true, content: "\x89PNG" + strings.Repeat("x", 80)}
+	images.images["user-a/held.png"] = storedImage{owner: "user-a", public: true, quarantined: true, content: "\x89PNG held"}
+	images.images["user-a/notes.txt"] = storedImage{owner: "user-a", public: true, content: "plain notes"}
+	p := newTestPolicy(t, images)
+	ctx := context.Background()
+
+	pdf := []byte("%PDF-1.4 receipt")
+	tests := []struct {
+		name        string
+		attachments []Attachment
+		bodyBytes   int
+		contentType string // of the first attachment
+		fails       string
+	}{
+		{"content, declared type", []Attachment{{Name: "r.pdf", ContentType: "application/pdf; name=r.pdf", Content: pdf}}, 0, "application/pdf", ""},
+		{"content, sniffed type", []Attachment{{Name: "r.pdf", Content: pdf}}, 0, "application/pdf", ""},
+		{"imageRef, type of image-service", []Attachment{{Name: "a.png", ImageRef: "user-a/public.png"}}, 0, "image/png", ""},
+		{"imageRef, sniffed type", []Attachment{{Name: "n.txt", ImageRef: "user-a/notes.txt"}}, 0, "text/plain", ""},
+		{"type not allowed", []Attachment{{Name: "a.html", ContentType: "text/html", Content: []byte("<p>hi</p>")}}, 0, "", "not allowed"},
+		{"sniffed type not allowed", []Attachment{{Name: "a.zip", Content: []byte("PK\x03\x04zip")}}, 0, "", "not allowed"},
+		{"bad type", []Attachment{{Name: "a.pdf", ContentType: "pdf;;", Content: pdf}}, 0, "", "bad content type"},
+		{"attachment too large", []Attachment{{Name: "a.txt", ContentType: "text/plain", Content: []byte(strings.Repeat("x", 65))}}, 0, "", "larger than 64 bytes"},
+		{"image too large", []Attachment{{Name: "big.png", ImageRef: "user-a/big.png"}}, 0, "", "larger than 64 bytes"},
+		{"message too large", []Attachment{
+			{Name: "a.txt", ContentType: "text/plain", Content: []byte(strings.Repeat("x", 40))},
+			{Name: "b.txt", ContentType: "text/plain", Content: []byte(strings.Repeat("x", 40))},
+		}, 30, "", "message is larger than 100 bytes"},
+		{"content and imageRef", []Attachment{{Name: "a.png", Content: pdf, ImageRef: "user-a/public.png"}}, 0, "", "not both"},
+		{"neither", []Attachment{{Name: "a.png"}}, 0, "", "content or imageRef is required"},
+		{"path as name", []Attachment{{Name: "../a.pdf", Content: pdf}}, 0, "", "file name"},
+		{"unknown imageRef", []Attachment{{Name: "a.png", ImageRef: "user-a/missing.png"}}, 0, "", "not found"},
+		{"quarantined imageRef", []Attachment{{Name: "a.png", ImageRef: "user-a/held.png"}}, 0, "", "quarantined"},
+		{"imageRef as a path", []Attachment{{Name: "a.png", ImageRef: "/etc/passwd"}}, 0, "", "not a path or URL"},
+		{"imageRef as a URL", []Attachment{{Name: "a.png", ImageRef: "http://example.com/a.png"}}, 0, "", "not a path or URL"},
+		{"imageRef leaving its folder", []Attachment{{Name: "a.png", ImageRef: "user-a/../user-b/a.png"}}, 0, "", "not a valid key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolved, err := p.Resolve(ctx, tt.attachments, "token-a", tt.bodyBytes)
+			if tt.fails != "" {
+				if !errors.Is(err, ErrInvalidAttachment) || !strings.Contains(err.Error(), tt.fails) {
+					t.Fatalf("Resolve = %v, want an invalid attachment error with %q", err, tt.fails)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(resolved) != len(tt.attachments) || resolved[0].ContentType != tt.contentType {
+				t.Fatalf("resolved %+v, want %s", resolved, tt.contentType)
+			}
+			if ref := tt.attachments[0].ImageRef; ref != "" {
+				if resolved[0].ImageRef != ref || string(resolved[0].Content) != images.images[ref].content {
+					t.Fatalf("resolved %s to %q", ref, resolved[0].Content)
+				}
+			}
+		})
+	}
+}
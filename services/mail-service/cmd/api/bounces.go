@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+
+	"mailer-service/data"
+)
+
+var errUnknownBounceFormat = errors.New("unrecognised bounce payload")
+
+// bounceEvent is a suppression learned from a bounce or complaint report
+type bounceEvent struct {
+	Email  string
+	Reason string
+	Source string
+	Detail string
+}
+
+// parseBounces reads the bounce and complaint reports in a webhook body. It
+// understands delivery status notifications (RFC 3464), as a whole bounce mail
+// or as the message/delivery-status part alone, Amazon SES events, raw or
+// wrapped in an SNS notification, and a plain JSON list of
+// {"email", "type", "permanent", "detail"} objects. Soft bounces are dropped,
+// the queue retries those by itself.
+func parseBounces(contentType string, body []byte) ([]bounceEvent, error) {
+	mediaType, params, _ := mime.ParseMediaType(contentType)
+
+	switch {
+	case mediaType == "message/delivery-status":
+		return parseDeliveryStatus(body)
+	case mediaType == "multipart/report":
+		return parseReport(params["boundary"], bytes.NewReader(body))
+	}
+
+	// SNS posts its JSON as text/plain, so look at the body itself
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		return parseJSONBounces(trimmed)
+	}
+
+	// Anything else should be a bounce mail
+	msg, err := mail.ReadMessage(bytes.NewReader(body))
+	if err != nil {
+		return nil, errUnknownBounceFormat
+	}
+	mediaType, params, _ = mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if mediaType != "multipart/report" {
+		return nil, errUnknownBounceFormat
+	}
+	return parseReport(params["boundary"], msg.Body)
+}
+
+// parseReport finds the delivery status part of a multipart/report
+func parseReport(boundary string, body io.Reader) ([]bounceEvent, error) {
+	if boundary == "" {
+		return nil, fmt.Errorf("%w: multipart/report without boundary", errUnknownBounceFormat)
+	}
+
+	mr := multipart.NewReader(body, boundary)
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%w: no message/delivery-status part", errUnknownBounceFormat)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		mediaType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if mediaType != "message/delivery-status" {
+			continue
+		}
+
+		status, err := io.ReadAll(part)
+		if err != nil {
+			return nil, err
+		}
+		return parseDeliveryStatus(status)
+	}
+}
+
+// parseDeliveryStatus reads the header blocks of a message/delivery-status
+// part: one about the message, then one per recipient
+func parseDeliveryStatus(status []byte) ([]bounceEvent, error) {
+	r := textproto.NewReader(bufio.NewReader(bytes.NewReader(status)))
+
+	var events []bounceEvent
+	for {
+		block, err := r.ReadMIMEHeader()
+		if recipient := dsnRecipient(block); recipient != "" {
+			action := strings.ToLower(strings.TrimSpace(block.Get("Action")))
+			code := dsnStatusCode(block.Get("Status"))
+			if action == "failed" && hardBounceStatus(code) {
+				events = append(events, bounceEvent{
+					Email:  recipient,
+					Reason: data.ReasonHardBounce,
+					Source: "dsn",
+					Detail: strings.TrimSpace(code + " " + block.Get("Diagnostic-Code")),
+				})
+			}
+		}
+
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// dsnRecipient returns the address of a per-recipient block, "" for others
+func dsnRecipient(block textproto.MIMEHeader) string {
+	recipient := block.Get("Final-Recipient")
+	if recipient == "" {
+		recipient = block.Get("Original-Recipient")
+	}
+
+	// "rfc822; jane@example.com"
+	if _, addr, ok := strings.Cut(recipient, ";"); ok {
+		recipient = addr
+	}
+	return strings.TrimSpace(recipient)
+}
+
+// dsnStatusCode returns the "5.1.1" part of a Status field such as "5.1.1 (bad mailbox)"
+func dsnStatusCode(status string) string {
+	fields := strings.Fields(status)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// hardBounceStatus reports whether an enhanced status code (RFC 3463) says the
+// address can't receive mail at all, rather than that this message failed
+func hardBounceStatus(code string) bool {
+	return strings.HasPrefix(code, "5.1.") || code == "5.2.1"
+}
+
+type snsEnvelope struct {
+	Type         string `json:"Type"`
+	Message      string `json:"Message"`
+	SubscribeURL string `json:"SubscribeURL"`
+}
+
+type sesEvent struct {
+	NotificationType string `json:"notificationType"`
+	EventType        string `json:"eventType"`
+	Bounce           struct {
+		BounceType        string `json:"bounceType"`
+		BouncedRecipients []struct {
+			EmailAddress   string `json:"emailAddress"`
+			Status         string `json:"status"`
+			DiagnosticCode string `json:"diagnosticCode"`
+		} `json:"bouncedRecipients"`
+	} `json:"bounce"`
+	Complaint struct {
+		ComplaintFeedbackType string `json:"complaintFeedbackType"`
+		ComplainedRecipients  []struct {
+			EmailAddress string `json:"emailAddress"`
+		} `json:"complainedRecipients"`
+	} `json:"complaint"`
+}
+
+type genericBounce struct {
+	Email     string `json:"email"`
+	Type      string `json:"type"` // bounce, complaint or unsubscribe
+	Permanent bool   `json:"permanent"`
+	Detail    string `json:"detail"`
+}
+
+func parseJSONBounces(body []byte) ([]bounceEvent, error) {
+	if body[0] == '[' {
+		var list []genericBounce
+		if err := json.Unmarshal(body, &list); err != nil {
+			return nil, err
+		}
+		return genericBounces(list)
+	}
+
+	var probe struct {
+		snsEnvelope
+		sesEvent
+		genericBounce
+	}
+	if err := json.Unmarshal(body, &probe); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case probe.snsEnvelope.Type == "SubscriptionConfirmation":
+		// Confirming means fetching a URL AWS gives us; leave that to a human
+		log.Printf("SNS subscription to the bounce endpoint needs confirming: %s", probe.SubscribeURL)
+		return nil, nil
+	case probe.snsEnvelope.Type == "Notification":
+		return parseJSONBounces(bytes.TrimSpace([]byte(probe.Message)))
+	case probe.NotificationType != "" || probe.EventType != "":
+		return sesBounces(probe.sesEvent), nil
+	case probe.Email != "":
+		return genericBounces([]genericBounce{probe.genericBounce})
+	default:
+		return nil, errUnknownBounceFormat
+	}
+}
+
+func sesBounces(e sesEvent) []bounceEvent {
+	kind := e.NotificationType
+	if kind == "" {
+		kind = e.EventType
+	}
+
+	var events []bounceEvent
+	switch kind {
+	case "Bounce":
+		if e.Bounce.BounceType != "Permanent" {
+			return nil
+		}
+		for _, r := range e.Bounce.BouncedRecipients {
+			events = append(events, bounceEvent{
+				Email:  r.EmailAddress,
+				Reason: data.ReasonHardBounce,
+				Source: "webhook",
+				Detail: strings.TrimSpace(r.Status + " " + r.DiagnosticCode),
+			})
+		}
+	case "Complaint":
+		for _, r := range e.Complaint.ComplainedRecipients {
+			events = append(events, bounceEvent{
+				Email:  r.EmailAddress,
+				Reason: data.ReasonComplaint,
+				Source: "webhook",
+				Detail: e.Complaint.ComplaintFeedbackType,
+			})
+		}
+	}
+	return events
+}
+
+func genericBounces(list []genericBounce) ([]bounceEvent, error) {
+	var events []bounceEvent
+	for _, b := range list {
+		if b.Email == "" {
+			return nil, fmt.Errorf("%w: email is required", errUnknownBounceFormat)
+		}
+
+		var reason string
+		switch b.Type {
+		case "bounce":
+			if !b.Permanent {
+				continue
+			}
+			reason = data.ReasonHardBounce
+		case "complaint":
+			reason = data.ReasonComplaint
+		case "unsubscribe":
+			reason = data.ReasonUnsubscribe
+		default:
+			return nil, fmt.Errorf("%w: unknown type %q", errUnknownBounceFormat, b.Type)
+		}
+
+		events = append(events, bounceEvent{Email: b.Email, Reason: reason, Source: "webhook", Detail: b.Detail})
+	}
+	return events, nil
+}
@@ -1,37 +1,46 @@
-//go:build grpc
-
 package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"os"
 	"strconv"
+	"time"
 
+	"mailer-service/data"
+	"ride-sharing/shared/clients"
 	mailpb "ride-sharing/shared/generated/mail"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
 )
 
 const grpcPort = 50002
 
 type mailGrpcServer struct {
 	mailpb.UnimplementedMailServiceServer
-	mailer Mail
+	queue *MailQueue
 }
 
-func startGRPCServer(m Mail) {
+func startGRPCServer(queue *MailQueue) {
 	addr := fmt.Sprintf(":%d", grpcPort)
 	lis, err := net.Listen("tcp", addr)
 	if err != nil {
 		log.Fatalf("mail-service failed to listen: %v", err)
 	}
 
-	s := grpc.NewServer()
-	mailpb.RegisterMailServiceServer(s, &mailGrpcServer{mailer: m})
+	serverOpts, err := clients.ServerCredentials()
+	if err != nil {
+		log.Fatalf("gRPC TLS: %v", err)
+	}
+
+	s := grpc.NewServer(serverOpts...)
+	mailpb.RegisterMailServiceServer(s, &mailGrpcServer{queue: queue})
 	reflection.Register(s)
 
 	log.Printf("mail-service gRPC listening on %s", addr)
@@ -43,20 +52,73 @@ func startGRPCServer(m Mail) {
 }
 
 func (s *mailGrpcServer) SendMail(ctx context.Context, req *mailpb.MailRequest) (*mailpb.MailResponse, error) {
+	if len(req.GetAttachments()) > 0 {
+		return nil, status.Error(codes.InvalidArgument, errPathAttachments.Error())
+	}
+
+	attachments := make([]Attachment, 0, len(req.GetFiles()))
+	for _, f := range req.GetFiles() {
+		attachments = append(attachments, Attachment{
+			Name:        f.GetName(),
+			ContentType: f.GetContentType(),
+			Content:     f.GetContent(),
+			ImageRef:    f.GetImageRef(),
+		})
+	}
+
 	msg := Message{
 		From:        req.GetFrom(),
 		FromName:    req.GetFromName(),
 		To:          req.GetTo(),
 		Subject:     req.GetSubject(),
 		Data:        req.GetMessage(),
-		Attachments: req.GetAttachments(),
+		Attachments: attachments,
+		Template:    req.GetTemplate(),
+		Locale:      req.GetLocale(),
+		Variables:   req.GetVariables(),
+		Category:    req.GetCategory(),
+		UserToken:   clients.UserToken(ctx),
 	}
-	if msg.From == "" { msg.From = s.mailer.FromAddress }
-	if msg.FromName == "" { msg.FromName = s.mailer.FromName }
-	if err := s.mailer.SendSMTPMessage(msg); err != nil {
+	queued, err := s.queue.Enqueue(ctx, msg)
+	if err != nil {
+		if isInvalidMail(err) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
 		return nil, err
 	}
-	return &mailpb.MailResponse{Message: "Email sent successfully to " + req.GetTo()}, nil
+	return &mailpb.MailResponse{
+		Message: "Email queued for " + req.GetTo(),
+		Id:      queued.ID,
+		Status:  queued.Status,
+	}, nil
+}
+
+func (s *mailGrpcServer) GetMailStatus(ctx context.Context, req *mailpb.MailStatusRequest) (*mailpb.MailStatusResponse, error) {
+	if req.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+
+	m, err := s.queue.Status(ctx, req.GetId())
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		return nil, err
+	}
+
+	resp := &mailpb.MailStatusResponse{
+		Id:        m.ID,
+		To:        m.To,
+		Status:    m.Status,
+		Attempts:  int32(m.Attempts),
+		LastError: m.LastError,
+		CreatedAt: m.CreatedAt.Format(time.RFC3339),
+		UpdatedAt: m.UpdatedAt.Format(time.RFC3339),
+	}
+	if m.SentAt != nil {
+		resp.SentAt = m.SentAt.Format(time.RFC3339)
+	}
+	return resp, nil
 }
 
 // helper to construct Mail from env (if needed elsewhere)
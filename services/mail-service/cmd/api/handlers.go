@@ -1,8 +1,16 @@
 package main
 
 import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+
+	"mailer-service/data"
+
+	"github.com/go-chi/chi/v5"
 )
 
 func (app *Config) SendMail(w http.ResponseWriter, r *http.Request) {
@@ -11,6 +19,11 @@ func (app *Config) SendMail(w http.ResponseWriter, r *http.Request) {
 		To      string `json:"to"`
 		Subject string `json:"subject"`
 		Message string `json:"message"`
+		Attachments []Attachment    `json:"attachments,omitempty"`
+		Template  string            `json:"template,omitempty"`
+		Locale    string            `json:"locale,omitempty"`
+		Variables map[string]string `json:"variables,omitempty"`
+		Category  string            `json:"category,omitempty"`
 	}
 
 	var requestPayload mailMessage
@@ -27,19 +40,243 @@ func (app *Config) SendMail(w http.ResponseWriter, r *http.Request) {
 		To: requestPayload.To,
 		Subject: requestPayload.Subject,
 		Data: requestPayload.Message,
+		Attachments: requestPayload.Attachments,
+		Template: requestPayload.Template,
+		Locale: requestPayload.Locale,
+		Variables: requestPayload.Variables,
+		Category: requestPayload.Category,
 	}
 
-	err = app.Mailer.SendSMTPMessage(msg)
+	queued, err := app.Queue.Enqueue(r.Context(), msg)
 	if err != nil {
 		log.Println(err)
-		app.errorJSON(w, err)
+		if isInvalidMail(err) {
+			app.errorJSON(w, err)
+		} else {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+		}
 		return
 	}
 
+	message := "queued for " + requestPayload.To
+	if queued.Status == data.StatusSuppressed {
+		message = requestPayload.To + " is suppressed, mail not sent"
+	}
+
 	payload := jsonResponse {
 		Error: false,
-		Message: "sent to " + requestPayload.To,
+		Message: message,
+		Data: map[string]string{
+			"id": queued.ID,
+			"status": queued.Status,
+		},
 	}
 
 	app.writeJSON(w, http.StatusAccepted, payload)
 }
+
+// MailStatus reports the delivery status of a queued mail
+func (app *Config) MailStatus(w http.ResponseWriter, r *http.Request) {
+	m, err := app.Queue.Status(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		if errors.Is(err, data.ErrNotFound) {
+			app.errorJSON(w, err, http.StatusNotFound)
+		} else {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error: false,
+		Message: m.Status,
+		Data: m,
+	})
+}
+
+// DeadLetters lists the latest mails that were given up on
+func (app *Config) DeadLetters(w http.ResponseWriter, r *http.Request) {
+	letters, err := app.Models.Mail.DeadLetters(r.Context(), 100)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error: false,
+		Message: "dead letters",
+		Data: letters,
+	})
+}
+
+// IngestBounces adds the hard bounces and complaints reported by the mail
+// provider to the suppression list. See parseBounces for the formats.
+func (app *Config) IngestBounces(w http.ResponseWriter, r *http.Request) {
+	// Only from the header: a secret in the URL ends up in access logs
+	secret := r.Header.Get("X-Bounce-Secret")
+	if app.BounceSecret == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(app.BounceSecret)) != 1 {
+		app.errorJSON(w, errors.New("invalid bounce secret"), http.StatusUnauthorized)
+		return
+	}
+
+	// Bounce mails can quote the original message, attachments included
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 20<<20))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	events, err := parseBounces(r.Header.Get("Content-Type"), body)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	for _, e := range events {
+		err := app.Models.Suppression.Add(r.Context(), data.Suppression{
+			Email:  e.Email,
+			Reason: e.Reason,
+			Source: e.Source,
+			Detail: e.Detail,
+		})
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Suppressed %s (%s via %s)", e.Email, e.Reason, e.Source)
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error: false,
+		Message: fmt.Sprintf("%d addresses suppressed", len(events)),
+	})
+}
+
+// Unsubscribe handles the links in marketing mail. GET shows a confirmation
+// page; POST, from that page or from a mail client's one-click unsubscribe,
+// suppresses the address for marketing mail.
+func (app *Config) Unsubscribe(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	page := struct {
+		Email string
+		Token string
+		Done  bool
+		Error string
+	}{Token: token}
+
+	status := http.StatusOK
+	email, err := app.Mailer.Unsubscribe.Verify(token)
+	switch {
+	case err != nil:
+		status = http.StatusBadRequest
+		page.Error = "This unsubscribe link is invalid."
+	case r.Method == http.MethodPost:
+		err = app.Models.Suppression.Add(r.Context(), data.Suppression{
+			Email:  email,
+			Reason: data.ReasonUnsubscribe,
+			Source: "link",
+		})
+		if err != nil {
+			log.Printf("Error unsubscribing %s: %v", email, err)
+			status = http.StatusInternalServerError
+			page.Error = "Something went wrong, please try again later."
+			break
+		}
+		page.Done = true
+	}
+	page.Email = email
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	unsubscribePage.Execute(w, page)
+}
+
+// Suppressions lists the latest suppressions, ?email= narrows them down to one address
+func (app *Config) Suppressions(w http.ResponseWriter, r *http.Request) {
+	list, err := app.Models.Suppression.List(r.Context(), r.URL.Query().Get("email"), 100)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error: false,
+		Message: "suppressions",
+		Data: list,
+	})
+}
+
+// AddSuppression suppresses an address by hand
+func (app *Config) AddSuppression(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Email  string `json:"email"`
+		Reason string `json:"reason"`
+		Detail string `json:"detail,omitempty"`
+	}
+
+	if err := app.readJSON(w, r, &requestPayload); err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if requestPayload.Email == "" || !data.ValidReason(requestPayload.Reason) {
+		app.errorJSON(w, errors.New("email and a reason of hard_bounce, complaint or unsubscribe are required"))
+		return
+	}
+
+	err := app.Models.Suppression.Add(r.Context(), data.Suppression{
+		Email:  requestPayload.Email,
+		Reason: requestPayload.Reason,
+		Source: "admin",
+		Detail: requestPayload.Detail,
+	})
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.writeJSON(w, http.StatusCreated, jsonResponse{
+		Error: false,
+		Message: requestPayload.Email + " suppressed",
+	})
+}
+
+// RemoveSuppression lifts the suppressions of an address, only the one with
+// ?reason= when it is given
+func (app *Config) RemoveSuppression(w http.ResponseWriter, r *http.Request) {
+	email := chi.URLParam(r, "email")
+	removed, err := app.Models.Suppression.Remove(r.Context(), email, r.URL.Query().Get("reason"))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	if removed == 0 {
+		app.errorJSON(w, errors.New(email+" is not suppressed"), http.StatusNotFound)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		Error: false,
+		Message: fmt.Sprintf("%d suppressions of %s removed", removed, email),
+	})
+}
+
+// CapturedMails lists the mails kept by the in-memory transport, newest first.
+// ?to= narrows them down to one recipient.
+func (app *Config) CapturedMails(t *memoryTransport) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		app.writeJSON(w, http.StatusOK, jsonResponse{
+			Error: false,
+			Message: "captured mails",
+			Data: t.Mails(r.URL.Query().Get("to")),
+		})
+	}
+}
+
+// ClearCapturedMails drops the mails kept by the in-memory transport
+func (app *Config) ClearCapturedMails(t *memoryTransport) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t.Clear()
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -2,16 +2,22 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"html/template"
-	"log"
 	"os"
 	"path/filepath"
 	"time"
 
+	"mailer-service/data"
+
 	"github.com/vanng822/go-premailer/premailer"
 	mail "github.com/xhit/go-simple-mail/v2"
 )
 
+var errNoRecipient = errors.New("recipient is required")
+
 type Mail struct {
 	Domain      string
 	Host        string
@@ -21,6 +27,11 @@ type Mail struct {
 	Encryption  string
 	FromAddress string
 	FromName    string
+	Templates   *TemplateRegistry
+	Attachments AttachmentPolicy
+	Unsubscribe Unsubscriber
+
+	transport Transport
 }
 
 type Message struct {
@@ -28,12 +39,29 @@ type Message struct {
 	FromName    string
 	To          string
 	Subject     string
-	Attachments []string
+	Attachments []Attachment
 	Data        any
 	DataMap     map[string]any
+	// Template names a registered template; Data is ignored when it is set
+	Template  string
+	Locale    string
+	Variables map[string]string
+	// Category is transactional or marketing; templates bring their own and
+	// other mail defaults to marketing
+	Category string
+	// UserToken is the access token of the user the mail is sent for, whose
+	// permissions the imageRefs are read with
+	UserToken string
 }
 
-func (m *Mail) SendSMTPMessage(msg Message) error {
+// Compose renders msg into a mail ready to be queued. It fails straight away on
+// an unknown template or invalid variables, so callers learn about them
+// before anything is queued.
+func (m *Mail) Compose(ctx context.Context, msg Message) (*data.Mail, error) {
+	if msg.To == "" {
+		return nil, errNoRecipient
+	}
+
 	if msg.From == "" {
 		msg.From = m.FromAddress
 	}
@@ -42,59 +70,113 @@ func (m *Mail) SendSMTPMessage(msg Message) error {
 		msg.FromName = m.FromName
 	}
 
-	data := map[string]any {
-		"message": msg.Data,
+	formattedMessage, plainMessage, err := m.buildMessage(&msg)
+	if err != nil {
+		return nil, err
 	}
 
-	msg.DataMap = data
-
-	formattedMessage, err := m.buildHTMLMessage(msg)
+	category, err := m.category(msg)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	plainMessage, err := m.buildPlainTextMessage(msg)
+	attachments, err := m.Attachments.Resolve(ctx, msg.Attachments, msg.UserToken, len(formattedMessage)+len(plainMessage))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	var unsubscribeURL string
+	if category == CategoryMarketing {
+		unsubscribeURL = m.Unsubscribe.URL(msg.To)
+	}
+
+	return &data.Mail{
+		From:        msg.From,
+		FromName:    msg.FromName,
+		To:          msg.To,
+		Subject:     msg.Subject,
+		HTML:        formattedMessage,
+		Plain:       plainMessage,
+		Attachments: attachments,
+		Template:    msg.Template,
+		Category:    category,
+
+		UnsubscribeURL: unsubscribeURL,
+	}, nil
+}
+
+// category returns the category of msg, defaulting to the one of its template
+func (m *Mail) category(msg Message) (string, error) {
+	switch msg.Category {
+	case CategoryTransactional, CategoryMarketing:
+		return msg.Category, nil
+	case "":
+		if msg.Template != "" && m.Templates != nil {
+			return m.Templates.Category(msg.Template), nil
+		}
+		return CategoryMarketing, nil
+	default:
+		return "", fmt.Errorf("%w, not %q", errUnknownCategory, msg.Category)
+	}
+}
+
+// Deliver hands a composed mail to the configured transport.
+func (m *Mail) Deliver(d *data.Mail) error {
+	return m.transport.Send(d)
+}
+
+// connectSMTP opens a connection that stays open between messages
+func (m *Mail) connectSMTP() (*mail.SMTPClient, error) {
 	server := mail.NewSMTPClient()
 	server.Host = m.Host
 	server.Port = m.Port
 	server.Username = m.Username
 	server.Password = m.Password
 	server.Encryption = m.getEncryption(m.Encryption)
-	server.KeepAlive = false
+	server.KeepAlive = true
 	server.ConnectTimeout = 10 * time.Second
 	server.SendTimeout = 10 * time.Second
 
-	smtpClient, err := server.Connect()
-	if err != nil {
-		log.Println(err)
-		return err
-	}
+	return server.Connect()
+}
 
-	email := mail.NewMSG()
-	email.SetFrom(msg.From).
-		AddTo(msg.To).
-		SetSubject(msg.Subject)
+// buildMessage renders the HTML and plain text bodies of msg, from its named
+// template when it has one and from the generic message template otherwise
+func (m *Mail) buildMessage(msg *Message) (string, string, error) {
+	if msg.Template == "" {
+		msg.DataMap = map[string]any{
+			"message": msg.Data,
+		}
 
-	email.SetBody(mail.TextPlain, plainMessage)
-	email.AddAlternative(mail.TextHTML, formattedMessage)
+		formattedMessage, err := m.buildHTMLMessage(*msg)
+		if err != nil {
+			return "", "", err
+		}
 
-	if len(msg.Attachments) > 0 {
-		for _, x := range msg.Attachments {
-			email.AddAttachment(x)
+		plainMessage, err := m.buildPlainTextMessage(*msg)
+		if err != nil {
+			return "", "", err
 		}
+		return formattedMessage, plainMessage, nil
+	}
+
+	if m.Templates == nil {
+		return "", "", fmt.Errorf("%w: %q", ErrUnknownTemplate, msg.Template)
+	}
+
+	rendered, err := m.Templates.Render(msg.Template, msg.Locale, msg.Variables)
+	if err != nil {
+		return "", "", err
+	}
+	if msg.Subject == "" {
+		msg.Subject = rendered.Subject
 	}
 
-	err = email.Send(smtpClient)
+	formattedMessage, err := m.inlineCSS(rendered.HTML)
 	if err != nil {
-		log.Println(err)
-		return err
+		return "", "", err
 	}
-	
-	return nil
+	return formattedMessage, rendered.Plain, nil
 }
 
 func (m *Mail) buildHTMLMessage(msg Message) (string, error) {
@@ -171,26 +253,31 @@ func (m *Mail) getEncryption(s string) mail.Encryption {
 }
 
 // getTemplatePath returns the full path to a template file
-// Tries /app/templates (for Docker) first, then ./templates (for local)
 func (m *Mail) getTemplatePath(filename string) string {
+	return filepath.Join(templateDir(), filename)
+}
+
+// templateDir returns the directory holding the mail templates
+// Tries /app/templates (for Docker) first, then ./templates (for local)
+func templateDir() string {
 	// Try Docker path first
-	dockerPath := filepath.Join("/app", "templates", filename)
+	dockerPath := filepath.Join("/app", "templates")
 	if _, err := os.Stat(dockerPath); err == nil {
 		return dockerPath
 	}
-	
+
 	// Try relative path (for local development)
-	relativePath := filepath.Join(".", "templates", filename)
+	relativePath := filepath.Join(".", "templates")
 	if _, err := os.Stat(relativePath); err == nil {
 		return relativePath
 	}
-	
+
 	// Try from service root
-	servicePath := filepath.Join("services", "mail-service", "templates", filename)
+	servicePath := filepath.Join("services", "mail-service", "templates")
 	if _, err := os.Stat(servicePath); err == nil {
 		return servicePath
 	}
-	
+
 	// Fallback to Docker path
 	return dockerPath
-}
\ No newline at end of file
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// newTestMail returns a Mail on the real templates that delivers to memory
+func newTestMail(t *testing.T) (*Mail, *memoryTransport) {
+	t.Helper()
+
+	templates, err := LoadTemplates("../../templates", "en", templateSpecs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := newMemoryTransport(10)
+	return &Mail{
+		FromAddress: "no-reply@example.com",
+		FromName:    "RideShare",
+		Templates:   templates,
+		transport:   transport,
+	}, transport
+}
+
+// send composes msg and delivers it the way a queue worker does, and returns
+// what reached the transport
+func send(t *testing.T, m *Mail, transport *memoryTransport, msg Message) CapturedMail {
+	t.Helper()
+
+	composed, err := m.Compose(context.Background(), msg)
+	if err != nil {
+		t.Fatalf("compose %s: %v", msg.Template, err)
+	}
+	if err := m.Deliver(composed); err != nil {
+		t.Fatalf("deliver %s: %v", msg.Template, err)
+	}
+
+	mails := transport.Mails(msg.To)
+	if len(mails) == 0 {
+		t.Fatalf("no %s mail delivered to %s", msg.Template, msg.To)
+	}
+	return mails[0]
+}
+
+func TestOTPVerificationMail(t *testing.T) {
+	m, transport := newTestMail(t)
+
+	tests := []struct {
+		locale   string
+		greeting string
+	}{
+		{"", "Hello Jane"},
+		{"vi-VN", "Xin chào Jane"},
+		{"fr", "Hello Jane"},
+	}
+	for _, tt := range tests {
+		t.Run("locale "+tt.locale, func(t *testing.T) {
+			// what auth-service sends from ResendOTP
+			got := send(t, m, transport, Message{
+				To:       "jane@example.com",
+				Template: "otp_verification",
+				Locale:   tt.locale,
+				Variables: map[string]string{
+					"name":            "Jane",
+					"otp":             "482913",
+					"expires_minutes": "5",
+				},
+			})
+
+			if got.From != "no-reply@example.com" || got.Template != "otp_verification" || got.Subject == "" {
+				t.Fatalf("delivered %+v", got)
+			}
+			for _, body := range []string{got.Plain, got.HTML} {
+				if !strings.Contains(body, "482913") || !strings.Contains(body, tt.greeting) {
+					t.Fatalf("body without the OTP or %q:\n%s", tt.greeting, body)
+				}
+			}
+			if !strings.Contains(got.Raw, "To: <jane@example.com>") {
+				t.Fatalf("raw message without its recipient:\n%s", got.Raw)
+			}
+		})
+	}
+}
+
+func TestPasswordResetMail(t *testing.T) {
+	m, transport := newTestMail(t)
+	resetURL := "https://app.example.com/reset-password?otp=739105&token=3fa85f64"
+
+	// what auth-service sends from ForgetPasswordSendEmail
+	got := send(t, m, transport, Message{
+		To:       "jane@example.com",
+		Template: "password_reset",
+		Variables: map[string]string{
+			"name":            "Jane",
+			"otp":             "739105",
+			"reset_url":       resetURL,
+			"expires_minutes": "60",
+		},
+	})
+
+	if !strings.Contains(got.Plain, resetURL) || !strings.Contains(got.Plain, "739105") {
+		t.Fatalf("plain body without the reset link or OTP:\n%s", got.Plain)
+	}
+	if !strings.Contains(got.HTML, "739105") || !strings.Contains(got.HTML, "https://app.example.com/reset-password") {
+		t.Fatalf("HTML body without the reset link or OTP:\n%s", got.HTML)
+	}
+}
+
+func TestComposeRejectsInvalidMail(t *testing.T) {
+	m, transport := newTestMail(t)
+
+	tests := []struct {
+		name string
+		msg  Message
+		want error
+	}{
+		{"no recipient", Message{Template: "otp_verification"}, errNoRecipient},
+		{"unknown template", Message{To: "jane@example.com", Template: "welcome"}, ErrUnknownTemplate},
+		{"missing OTP", Message{
+			To:        "jane@example.com",
+			Template:  "otp_verification",
+			Variables: map[string]string{"name": "Jane", "expires_minutes": "5"},
+		}, ErrInvalidVariables},
+		{"reset link not a URL", Message{
+			To:       "jane@example.com",
+			Template: "password_reset",
+			Variables: map[string]string{
+				"name": "Jane", "otp": "739105", "reset_url": "javascript:alert(1)", "expires_minutes": "60",
+			},
+		}, ErrInvalidVariables},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := m.Compose(context.Background(), tt.msg)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Compose = %v, want %v", err, tt.want)
+			}
+			if !isInvalidMail(err) {
+				t.Fatalf("%v is not reported as an invalid mail", err)
+			}
+		})
+	}
+
+	if mails := transport.Mails(""); len(mails) != 0 {
+		t.Fatalf("%d invalid mails delivered", len(mails))
+	}
+}
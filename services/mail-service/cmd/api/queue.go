@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"mailer-service/data"
+	"ride-sharing/shared/env"
+	"ride-sharing/shared/retry"
+)
+
+// MailQueue accepts mails into the Mongo backed queue and delivers them from a
+// pool of workers. Failed deliveries are retried with the shared/retry backoff;
+// a mail that keeps failing, or that the server rejects for good, ends up in
+// the dead-letter store.
+type MailQueue struct {
+	store        data.MailStore
+	suppressions data.SuppressionStore
+	mailer       *Mail
+
+	workers int
+	retry   retry.Config
+	lease   time.Duration // how long a worker owns a claimed mail
+	poll    time.Duration // how often idle workers look for due retries
+
+	wake   chan struct{}
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewMailQueue(models data.Models, mailer *Mail) *MailQueue {
+	return &MailQueue{
+		store:        models.Mail,
+		suppressions: models.Suppression,
+		mailer:       mailer,
+		workers:      env.GetInt("MAIL_WORKERS", 4),
+		retry: retry.Config{
+			MaxRetries:  env.GetInt("MAIL_MAX_RETRIES", 5),
+			InitialWait: env.GetDuration("MAIL_RETRY_INITIAL_WAIT", 30*time.Second),
+			MaxWait:     env.GetDuration("MAIL_RETRY_MAX_WAIT", 30*time.Minute),
+		},
+		lease: time.Minute,
+		poll:  5 * time.Second,
+		wake:  make(chan struct{}, 1),
+	}
+}
+
+// Enqueue renders msg, stores it and returns its ID without waiting for SMTP.
+// A mail to a suppressed address is stored as suppressed and never sent.
+func (q *MailQueue) Enqueue(ctx context.Context, msg Message) (*data.Mail, error) {
+	m, err := q.mailer.Compose(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	sup, err := q.suppressions.Find(ctx, m.To, blockingReasons(m.Category))
+	if err != nil {
+		return nil, err
+	}
+	if sup != nil {
+		log.Printf("Not sending %s mail to %s: address is suppressed (%s)", m.Category, m.To, sup.Reason)
+		if err := q.store.Skip(ctx, m, "suppressed: "+sup.Reason); err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+
+	if err := q.store.Enqueue(ctx, m); err != nil {
+		return nil, err
+	}
+
+	// Let an idle worker pick it up now rather than on its next poll
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+
+	return m, nil
+}
+
+// Status returns a mail with its delivery status.
+func (q *MailQueue) Status(ctx context.Context, id string) (*data.Mail, error) {
+	return q.store.Get(ctx, id)
+}
+
+// Start runs the delivery workers until Stop is called.
+func (q *MailQueue) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	q.cancel = cancel
+
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go q.work(ctx)
+	}
+	log.Printf("Mail queue running with %d workers", q.workers)
+}
+
+// Stop lets the workers finish the mail they are sending and waits for them.
+func (q *MailQueue) Stop() {
+	if q.cancel != nil {
+		q.cancel()
+	}
+	q.wg.Wait()
+	q.mailer.transport.Close()
+}
+
+func (q *MailQueue) work(ctx context.Context) {
+	defer q.wg.Done()
+
+	for {
+		m, err := q.store.Claim(ctx, q.lease)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Error claiming mail: %v", err)
+		}
+
+		if m == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-q.wake:
+			case <-time.After(q.poll):
+			}
+			continue
+		}
+
+		// Finish the bookkeeping even while shutting down
+		q.deliver(context.WithoutCancel(ctx), m)
+	}
+}
+
+func (q *MailQueue) deliver(ctx context.Context, m *data.Mail) {
+	// The address may have been suppressed while the mail waited
+	sup, err := q.suppressions.Find(ctx, m.To, blockingReasons(m.Category))
+	if err == nil && sup != nil {
+		log.Printf("Dropping mail %s to %s: address was suppressed (%s)", m.ID, m.To, sup.Reason)
+		if err := q.store.MarkSuppressed(ctx, m, "suppressed: "+sup.Reason); err != nil {
+			log.Printf("Error updating mail %s: %v", m.ID, err)
+		}
+		return
+	}
+
+	if err == nil {
+		err = q.mailer.Deliver(m)
+	}
+	if err == nil {
+		if err := q.store.MarkSent(ctx, m); err != nil {
+			log.Printf("Error marking mail %s as sent: %v", m.ID, err)
+		}
+		return
+	}
+
+	attempt := m.Attempts + 1
+	switch {
+	case permanentSMTPError(err):
+		log.Printf("Mail %s to %s bounced: %v", m.ID, m.To, err)
+		if rejectedRecipient(err) {
+			q.suppress(ctx, m.To, err)
+		}
+		err = q.store.GiveUp(ctx, m, data.StatusBounced, err)
+	case attempt > q.retry.MaxRetries:
+		log.Printf("Mail %s to %s failed after %d attempts: %v", m.ID, m.To, attempt, err)
+		err = q.store.GiveUp(ctx, m, data.StatusFailed, err)
+	default:
+		wait := q.retry.Delay(attempt)
+		log.Printf("Mail %s to %s failed (attempt %d/%d), retrying in %v: %v", m.ID, m.To, attempt, q.retry.MaxRetries+1, wait, err)
+		err = q.store.Retry(ctx, m, err, time.Now().Add(wait))
+	}
+	if err != nil {
+		log.Printf("Error updating mail %s: %v", m.ID, err)
+	}
+}
+
+// suppress stops all mail to an address the SMTP server said doesn't exist
+func (q *MailQueue) suppress(ctx context.Context, to string, cause error) {
+	err := q.suppressions.Add(ctx, data.Suppression{
+		Email:  to,
+		Reason: data.ReasonHardBounce,
+		Source: "smtp",
+		Detail: cause.Error(),
+	})
+	if err != nil {
+		log.Printf("Error suppressing %s: %v", to, err)
+	}
+}
+
+// isInvalidMail reports whether err comes from the content of a mail rather
+// than from the queue, so transports can answer with a client error
+func isInvalidMail(err error) bool {
+	return errors.Is(err, ErrUnknownTemplate) || errors.Is(err, ErrInvalidVariables) ||
+		errors.Is(err, ErrInvalidAttachment) || errors.Is(err, errNoRecipient) ||
+		errors.Is(err, errUnknownCategory)
+}
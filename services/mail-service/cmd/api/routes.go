@@ -1,8 +1,12 @@
 package main
 
 import (
+	"expvar"
 	"net/http"
 
+	"ride-sharing/shared/logging"
+	"ride-sharing/shared/servicetoken"
+
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
@@ -22,8 +26,35 @@ func (app *Config) routes() http.Handler {
 	}))
 
 	mux.Use(middleware.Heartbeat("/ping"))
+	mux.Use(logging.Middleware)
 
 	mux.Post("/send", app.SendMail)
 
+	// Suppression list: bounces and complaints from the provider, one-click
+	// unsubscribe links from the mails, and manual edits
+	mux.Post("/bounces", app.IngestBounces)
+	mux.Get("/unsubscribe", app.Unsubscribe)
+	mux.Post("/unsubscribe", app.Unsubscribe)
+	mux.Group(func(r chi.Router) {
+		r.Use(servicetoken.Middleware(app.AdminToken))
+		// the queue shows the recipients, subjects and errors of the mails
+		r.Get("/status/{id}", app.MailStatus)
+		r.Get("/dead-letters", app.DeadLetters)
+		r.Get("/suppressions", app.Suppressions)
+		r.Post("/suppressions", app.AddSuppression)
+		r.Delete("/suppressions/{email}", app.RemoveSuppression)
+	})
+
+	// counts of the deprecated net/rpc calls
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	// Mails captured by the in-memory transport; only exists in local setups
+	if captured, ok := app.Mailer.transport.(*memoryTransport); ok {
+		mux.Route("/dev/mails", func(r chi.Router) {
+			r.Get("/", app.CapturedMails(captured))
+			r.Delete("/", app.ClearCapturedMails(captured))
+		})
+	}
+
 	return mux
 }
\ No newline at end of file
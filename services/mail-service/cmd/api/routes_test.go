@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGuardedRoutes(t *testing.T) {
+	app := &Config{BounceSecret: "bounce-secret", AdminToken: "admin-token"}
+	routes := app.routes()
+
+	// None of these get past the guard, so no store is needed
+	tests := []struct {
+		name   string
+		method string
+		target string
+		header string
+		value  string
+	}{
+		{"bounces without secret", "POST", "/bounces", "", ""},
+		{"bounces with a wrong secret", "POST", "/bounces", "X-Bounce-Secret", "wrong"},
+		{"bounces with the secret in the query", "POST", "/bounces?secret=bounce-secret", "", ""},
+		{"bounces with the admin token", "POST", "/bounces", "Authorization", "Bearer admin-token"},
+		{"list suppressions without token", "GET", "/suppressions", "", ""},
+		{"add suppression without token", "POST", "/suppressions", "", ""},
+		{"remove suppression with a wrong token", "DELETE", "/suppressions/jane@example.com", "Authorization", "Bearer wrong"},
+		{"list suppressions with the bounce secret", "GET", "/suppressions", "Authorization", "Bearer bounce-secret"},
+		{"mail status without token", "GET", "/status/65f000000000000000000001", "", ""},
+		{"mail status with a wrong token", "GET", "/status/65f000000000000000000001", "Authorization", "Bearer wrong"},
+		{"dead letters without token", "GET", "/dead-letters", "", ""},
+		{"dead letters with the bounce secret", "GET", "/dead-letters", "Authorization", "Bearer bounce-secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(`[]`))
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			rec := httptest.NewRecorder()
+			routes.ServeHTTP(rec, req)
+			if rec.Code != http.StatusUnauthorized {
+				t.Fatalf("status %d, want %d", rec.Code, http.StatusUnauthorized)
+			}
+		})
+	}
+
+	t.Run("bounces while the secret is unset", func(t *testing.T) {
+		routes := (&Config{AdminToken: "admin-token"}).routes()
+		req := httptest.NewRequest("POST", "/bounces", strings.NewReader(`[]`))
+		rec := httptest.NewRecorder()
+		routes.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("status %d, want %d", rec.Code, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("bounces with the secret", func(t *testing.T) {
+		// an empty list is parsed and suppresses nobody
+		req := httptest.NewRequest("POST", "/bounces", strings.NewReader(`[]`))
+		req.Header.Set("X-Bounce-Secret", "bounce-secret")
+		rec := httptest.NewRecorder()
+		routes.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d with the secret, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+		}
+	})
+}
@@ -1,13 +1,34 @@
 package main
 
 import (
+	"context"
+	"expvar"
 	"log"
+	"sync"
+	"time"
 )
 
+var (
+	legacyCalls      = expvar.NewMap("rpc.legacy_calls")
+	legacyCallWarned sync.Once
+)
+
+// legacyCall counts a net/rpc call and warns about the first one
+func legacyCall(method string) {
+	legacyCalls.Add(method, 1)
+	legacyCallWarned.Do(func() {
+		log.Printf("Deprecated net/rpc call RPCServer.%s, move the caller to gRPC", method)
+	})
+}
+
 // RPCServer is the type for our RPC Server. Methods that take this as a receiver are available
 // over RPC, as long as they are exported.
+//
+// Deprecated: call the gRPC MailService instead, through shared/clients.
+// Calls are counted in rpc.legacy_calls on /debug/vars to tell when the last
+// caller has moved.
 type RPCServer struct {
-	Mailer Mail
+	Queue *MailQueue
 }
 
 // RPCPayload is the type for data we receive from RPC
@@ -17,28 +38,81 @@ type RPCPayload struct {
 	To          string
 	Subject     string
 	Message     string
+	// Attachments used to be file paths on the mail-service host; they are
+	// rejected, send Files instead
 	Attachments []string
+	Files       []Attachment
+	// Template, Locale and Variables render a registered template instead of Message
+	Template  string
+	Locale    string
+	Variables map[string]string
+	// Category is transactional or marketing, see Message
+	Category string
 }
 
-// SendMail sends email via RPC
+// RPCMailStatus is the delivery status of a queued mail
+type RPCMailStatus struct {
+	ID        string
+	To        string
+	Status    string // queued, sent, failed, bounced or suppressed
+	Attempts  int
+	LastError string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	SentAt    *time.Time
+}
+
+// SendMail queues an email via RPC; delivery happens in the background
 func (r *RPCServer) SendMail(payload RPCPayload, resp *string) error {
+	legacyCall("SendMail")
+
+	if len(payload.Attachments) > 0 {
+		return errPathAttachments
+	}
+
 	msg := Message{
 		From:        payload.From,
 		FromName:    payload.FromName,
 		To:          payload.To,
 		Subject:     payload.Subject,
 		Data:        payload.Message,
-		Attachments: payload.Attachments,
+		Attachments: payload.Files,
+		Template:    payload.Template,
+		Locale:      payload.Locale,
+		Variables:   payload.Variables,
+		Category:    payload.Category,
 	}
 
-	err := r.Mailer.SendSMTPMessage(msg)
+	queued, err := r.Queue.Enqueue(context.Background(), msg)
 	if err != nil {
-		log.Println("error sending email via RPC", err)
+		log.Println("error queueing email via RPC", err)
 		return err
 	}
 
 	// resp is the message sent back to the RPC caller
-	*resp = "Email sent successfully to " + payload.To
+	*resp = "Email queued for " + payload.To + " with id " + queued.ID
+	return nil
+}
+
+// GetMailStatus reports the delivery status of a mail queued by SendMail
+func (r *RPCServer) GetMailStatus(id string, resp *RPCMailStatus) error {
+	legacyCall("GetMailStatus")
+
+	m, err := r.Queue.Status(context.Background(), id)
+	if err != nil {
+		return err
+	}
+
+	*resp = RPCMailStatus{
+		ID:        m.ID,
+		To:        m.To,
+		Status:    m.Status,
+		Attempts:  m.Attempts,
+		LastError: m.LastError,
+		CreatedAt: m.CreatedAt,
+		UpdatedAt: m.UpdatedAt,
+		SentAt:    m.SentAt,
+	}
 	return nil
 }
 
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+
+	mail "github.com/xhit/go-simple-mail/v2"
+)
+
+// smtpPool keeps SMTP connections open between sends instead of dialing the
+// server for every message. Connections idle for longer than idleTimeout are
+// closed, as most servers drop them on their side anyway.
+type smtpPool struct {
+	connect     func() (*mail.SMTPClient, error)
+	idle        chan *pooledSMTP
+	idleTimeout time.Duration
+
+	mu     sync.Mutex
+	closed bool
+}
+
+type pooledSMTP struct {
+	client   *mail.SMTPClient
+	lastUsed time.Time
+}
+
+func newSMTPPool(connect func() (*mail.SMTPClient, error), size int, idleTimeout time.Duration) *smtpPool {
+	if size < 1 {
+		size = 1
+	}
+	return &smtpPool{
+		connect:     connect,
+		idle:        make(chan *pooledSMTP, size),
+		idleTimeout: idleTimeout,
+	}
+}
+
+// get returns an idle connection that still answers, or dials a new one
+func (p *smtpPool) get() (*pooledSMTP, error) {
+	for {
+		select {
+		case c := <-p.idle:
+			if time.Since(c.lastUsed) > p.idleTimeout || c.client.Noop() != nil {
+				c.client.Close()
+				continue
+			}
+			return c, nil
+		default:
+			client, err := p.connect()
+			if err != nil {
+				return nil, err
+			}
+			return &pooledSMTP{client: client}, nil
+		}
+	}
+}
+
+// put hands c back after a send that returned err. Connections are only reused
+// when the server answered, since any other error may have left them broken.
+func (p *smtpPool) put(c *pooledSMTP, err error) {
+	var reply *textproto.Error
+	if err != nil && !errors.As(err, &reply) {
+		c.client.Close()
+		return
+	}
+	if err != nil && c.client.Reset() != nil {
+		c.client.Close()
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		c.client.Quit()
+		return
+	}
+
+	c.lastUsed = time.Now()
+	select {
+	case p.idle <- c:
+	default:
+		c.client.Quit()
+	}
+}
+
+// close ends every idle connection; connections in use are closed when put back
+func (p *smtpPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return
+	}
+	p.closed = true
+
+	for {
+		select {
+		case c := <-p.idle:
+			c.client.Quit()
+		default:
+			return
+		}
+	}
+}
+
+// permanentSMTPError reports whether the server rejected a message for good
+// (a 5xx reply), in which case retrying can't help
+func permanentSMTPError(err error) bool {
+	var reply *textproto.Error
+	return errors.As(err, &reply) && reply.Code >= 500
+}
+
+// rejectedRecipient reports whether the server refused the address itself
+// rather than this message, judging by the enhanced status code when the reply
+// has one and by the 550, 551 and 553 replies otherwise
+func rejectedRecipient(err error) bool {
+	var reply *textproto.Error
+	if !errors.As(err, &reply) || reply.Code < 500 {
+		return false
+	}
+
+	if code := dsnStatusCode(reply.Msg); strings.Count(code, ".") == 2 {
+		return hardBounceStatus(code)
+	}
+	return reply.Code == 550 || reply.Code == 551 || reply.Code == 553
+}
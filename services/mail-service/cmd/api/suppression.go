@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+
+	"mailer-service/data"
+)
+
+// Mail categories. Transactional mail (OTPs, password resets, receipts) is
+// what users need to use the platform, so only a hard bounce stops it;
+// marketing mail honours every suppression and carries an unsubscribe link.
+const (
+	CategoryTransactional = "transactional"
+	CategoryMarketing     = "marketing"
+)
+
+var (
+	errUnknownCategory         = errors.New("category must be transactional or marketing")
+	errInvalidUnsubscribeToken = errors.New("invalid unsubscribe link")
+)
+
+// blockingReasons lists the suppression reasons that stop a mail of category.
+// A hard bounce stops everything since the address doesn't exist.
+func blockingReasons(category string) []string {
+	if category == CategoryMarketing {
+		return []string{data.ReasonHardBounce, data.ReasonComplaint, data.ReasonUnsubscribe}
+	}
+	return []string{data.ReasonHardBounce}
+}
+
+// Unsubscriber signs and checks the tokens of one-click unsubscribe links. A
+// token is the address and its HMAC, so links work without any stored state.
+type Unsubscriber struct {
+	BaseURL string
+	secret  []byte
+}
+
+func newUnsubscriber() Unsubscriber {
+	baseURL := os.Getenv("MAIL_PUBLIC_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost"
+	}
+
+	secret := []byte(os.Getenv("MAIL_UNSUBSCRIBE_SECRET"))
+	if len(secret) == 0 {
+		log.Println("Warning: MAIL_UNSUBSCRIBE_SECRET is not set, unsubscribe links stop working on restart")
+		secret = make([]byte, 32)
+		rand.Read(secret)
+	}
+
+	return Unsubscriber{BaseURL: strings.TrimRight(baseURL, "/"), secret: secret}
+}
+
+// URL returns the unsubscribe link of email
+func (u Unsubscriber) URL(email string) string {
+	return u.BaseURL + "/unsubscribe?token=" + url.QueryEscape(u.Token(email))
+}
+
+// Token returns the signed unsubscribe token of email
+func (u Unsubscriber) Token(email string) string {
+	email = data.NormalizeEmail(email)
+	return base64.RawURLEncoding.EncodeToString([]byte(email)) + "." +
+		base64.RawURLEncoding.EncodeToString(u.sign(email))
+}
+
+// Verify returns the address a token was issued for
+func (u Unsubscriber) Verify(token string) (string, error) {
+	encodedEmail, encodedMAC, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", errInvalidUnsubscribeToken
+	}
+
+	email, err := base64.RawURLEncoding.DecodeString(encodedEmail)
+	if err != nil {
+		return "", errInvalidUnsubscribeToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(encodedMAC)
+	if err != nil {
+		return "", errInvalidUnsubscribeToken
+	}
+
+	if !hmac.Equal(mac, u.sign(string(email))) {
+		return "", errInvalidUnsubscribeToken
+	}
+	return string(email), nil
+}
+
+func (u Unsubscriber) sign(email string) []byte {
+	h := hmac.New(sha256.New, u.secret)
+	h.Write([]byte("unsubscribe:" + email))
+	return h.Sum(nil)
+}
+
+// unsubscribePage asks for a confirmation on GET, so link scanners opening the
+// URL don't unsubscribe anyone, and confirms the POST.
+var unsubscribePage = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Unsubscribe</title></head>
+<body style="font-family: sans-serif; max-width: 480px; margin: 60px auto;">
+{{if .Done}}
+  <p>{{.Email}} won't receive marketing emails from RideShare anymore.</p>
+{{else if .Error}}
+  <p>{{.Error}}</p>
+{{else}}
+  <p>Stop sending marketing emails to {{.Email}}?</p>
+  <form method="post">
+    <input type="hidden" name="token" value="{{.Token}}">
+    <button type="submit">Unsubscribe</button>
+  </form>
+{{end}}
+</body>
+</html>
+`))
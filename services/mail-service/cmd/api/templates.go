@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+)
+
+// VarType is the type a template variable must have. Variables travel as
+// strings in every transport, the type only decides how they are validated.
+type VarType int
+
+const (
+	VarString VarType = iota
+	VarNumber
+	VarURL
+)
+
+func (t VarType) String() string {
+	switch t {
+	case VarNumber:
+		return "number"
+	case VarURL:
+		return "url"
+	default:
+		return "string"
+	}
+}
+
+// TemplateSpec declares a named template and the variables it needs. Every
+// declared variable is required. Category defaults to marketing.
+type TemplateSpec struct {
+	Name     string
+	Vars     map[string]VarType
+	Category string
+}
+
+// templateSpecs is the registry of the emails the platform sends. Each one needs
+// a <locale>/<name>.{subject,html,plain}.gohtml set in the templates directory,
+// at least for the default locale.
+var templateSpecs = []TemplateSpec{
+	{
+		Name:     "otp_verification",
+		Vars:     map[string]VarType{"name": VarString, "otp": VarString, "expires_minutes": VarNumber},
+		Category: CategoryTransactional,
+	},
+	{
+		Name:     "password_reset",
+		Vars:     map[string]VarType{"name": VarString, "otp": VarString, "reset_url": VarURL, "expires_minutes": VarNumber},
+		Category: CategoryTransactional,
+	},
+	{
+		Name: "trip_receipt",
+		Vars: map[string]VarType{
+			"name": VarString, "trip_id": VarString, "pickup": VarString, "dropoff": VarString,
+			"distance_km": VarNumber, "fare": VarNumber, "currency": VarString, "completed_at": VarString,
+		},
+		Category: CategoryTransactional,
+	},
+	{
+		Name:     "driver_approved",
+		Vars:     map[string]VarType{"name": VarString, "app_url": VarURL},
+		Category: CategoryTransactional,
+	},
+}
+
+var (
+	// ErrUnknownTemplate is returned for a template name that isn't registered
+	ErrUnknownTemplate = errors.New("unknown mail template")
+	// ErrInvalidVariables is returned when the variables don't match the template
+	ErrInvalidVariables = errors.New("invalid template variables")
+)
+
+// localizedTemplate is one template parsed for one locale
+type localizedTemplate struct {
+	subject *texttemplate.Template
+	html    *htmltemplate.Template
+	plain   *texttemplate.Template
+}
+
+// Rendered is the output of a template.
+type Rendered struct {
+	Subject string
+	HTML    string
+	Plain   string
+}
+
+// TemplateRegistry holds every registered template in every available locale.
+type TemplateRegistry struct {
+	defaultLocale string
+	specs         map[string]TemplateSpec
+	templates     map[string]map[string]*localizedTemplate // name -> locale -> template
+}
+
+// LoadTemplates parses the templates of every spec below dir. It fails when a
+// template is missing for the default locale, when a locale only has part of a
+// set or when a template refers to a variable its spec doesn't declare, so a
+// broken template stops the service at startup instead of on the first send.
+func LoadTemplates(dir, defaultLocale string, specs []TemplateSpec) (*TemplateRegistry, error) {
+	layout := filepath.Join(dir, "layout.html.gohtml")
+	if _, err := os.Stat(layout); err != nil {
+		return nil, fmt.Errorf("mail layout: %w", err)
+	}
+
+	locales, err := localeDirs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	reg := &TemplateRegistry{
+		defaultLocale: defaultLocale,
+		specs:         make(map[string]TemplateSpec, len(specs)),
+		templates:     make(map[string]map[string]*localizedTemplate, len(specs)),
+	}
+
+	for _, spec := range specs {
+		reg.specs[spec.Name] = spec
+		reg.templates[spec.Name] = map[string]*localizedTemplate{}
+
+		for _, locale := range locales {
+			t, err := parseLocalized(dir, layout, locale, spec.Name)
+			if err != nil {
+				return nil, fmt.Errorf("template %s (%s): %w", spec.Name, locale, err)
+			}
+			if t == nil {
+				continue
+			}
+			if _, err := t.render(sampleVariables(spec), locale); err != nil {
+				return nil, fmt.Errorf("template %s (%s): %w", spec.Name, locale, err)
+			}
+			reg.templates[spec.Name][locale] = t
+		}
+
+		if _, ok := reg.templates[spec.Name][defaultLocale]; !ok {
+			return nil, fmt.Errorf("template %s has no %s version", spec.Name, defaultLocale)
+		}
+	}
+
+	return reg, nil
+}
+
+// Render renders template name in locale, falling back from a regional locale
+// such as "vi-VN" to "vi" and then to the default locale.
+func (r *TemplateRegistry) Render(name, locale string, vars map[string]string) (*Rendered, error) {
+	spec, ok := r.specs[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownTemplate, name)
+	}
+	if err := validateVariables(spec, vars); err != nil {
+		return nil, err
+	}
+
+	locale = r.resolveLocale(name, locale)
+	return r.templates[name][locale].render(vars, locale)
+}
+
+// Category returns the category mails from template name belong to.
+func (r *TemplateRegistry) Category(name string) string {
+	if category := r.specs[name].Category; category != "" {
+		return category
+	}
+	return CategoryMarketing
+}
+
+// Templates lists the registered templates with the locales they exist in.
+func (r *TemplateRegistry) Templates() map[string][]string {
+	list := make(map[string][]string, len(r.templates))
+	for name, byLocale := range r.templates {
+		locales := make([]string, 0, len(byLocale))
+		for locale := range byLocale {
+			locales = append(locales, locale)
+		}
+		sort.Strings(locales)
+		list[name] = locales
+	}
+	return list
+}
+
+func (r *TemplateRegistry) resolveLocale(name, locale string) string {
+	byLocale := r.templates[name]
+
+	locale = strings.ToLower(strings.TrimSpace(locale))
+	if _, ok := byLocale[locale]; ok {
+		return locale
+	}
+	if i := strings.IndexAny(locale, "-_"); i > 0 {
+		if _, ok := byLocale[locale[:i]]; ok {
+			return locale[:i]
+		}
+	}
+	return r.defaultLocale
+}
+
+func (t *localizedTemplate) render(vars map[string]string, locale string) (*Rendered, error) {
+	var subject, html, plain bytes.Buffer
+
+	if err := t.subject.Execute(&subject, vars); err != nil {
+		return nil, err
+	}
+	if err := t.html.ExecuteTemplate(&html, "layout", vars); err != nil {
+		return nil, err
+	}
+	if err := t.plain.Execute(&plain, vars); err != nil {
+		return nil, err
+	}
+
+	return &Rendered{
+		Subject: strings.TrimSpace(subject.String()),
+		HTML:    html.String(),
+		Plain:   strings.TrimSpace(plain.String()) + "\n",
+	}, nil
+}
+
+// parseLocalized parses the template set of name in locale, nil when the
+// locale has none of its files
+func parseLocalized(dir, layout, locale, name string) (*localizedTemplate, error) {
+	base := filepath.Join(dir, locale, name)
+	files := []string{base + ".subject.gohtml", base + ".html.gohtml", base + ".plain.gohtml"}
+
+	var missing []string
+	for _, f := range files {
+		if _, err := os.Stat(f); err != nil {
+			missing = append(missing, filepath.Base(f))
+		}
+	}
+	if len(missing) == len(files) {
+		return nil, nil
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing %s", strings.Join(missing, ", "))
+	}
+
+	subject, err := texttemplate.ParseFiles(files[0])
+	if err != nil {
+		return nil, err
+	}
+
+	html, err := htmltemplate.New("layout").
+		Funcs(htmltemplate.FuncMap{"locale": func() string { return locale }}).
+		ParseFiles(layout, files[1])
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := texttemplate.ParseFiles(files[2])
+	if err != nil {
+		return nil, err
+	}
+
+	// A variable that isn't passed is a bug in the template or its spec
+	subject.Option("missingkey=error")
+	html.Option("missingkey=error")
+	plain.Option("missingkey=error")
+
+	return &localizedTemplate{subject: subject, html: html, plain: plain}, nil
+}
+
+// localeDirs returns the locale subdirectories of dir
+func localeDirs(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var locales []string
+	for _, e := range entries {
+		if e.IsDir() {
+			locales = append(locales, e.Name())
+		}
+	}
+	return locales, nil
+}
+
+func validateVariables(spec TemplateSpec, vars map[string]string) error {
+	var problems []string
+
+	for name, typ := range spec.Vars {
+		v, ok := vars[name]
+		if !ok || v == "" {
+			problems = append(problems, fmt.Sprintf("%s is required", name))
+			continue
+		}
+
+		switch typ {
+		case VarNumber:
+			if _, err := strconv.ParseFloat(v, 64); err != nil {
+				problems = append(problems, fmt.Sprintf("%s must be a number", name))
+			}
+		case VarURL:
+			u, err := url.Parse(v)
+			if err != nil || u.Scheme == "" || u.Host == "" {
+				problems = append(problems, fmt.Sprintf("%s must be an absolute URL", name))
+			}
+		}
+	}
+
+	for name := range vars {
+		if _, ok := spec.Vars[name]; !ok {
+			problems = append(problems, fmt.Sprintf("%s is not a variable of %s", name, spec.Name))
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("%w: %s", ErrInvalidVariables, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// sampleVariables returns valid values for every variable of spec, used to
+// check templates at load time
+func sampleVariables(spec TemplateSpec) map[string]string {
+	vars := make(map[string]string, len(spec.Vars))
+	for name, typ := range spec.Vars {
+		switch typ {
+		case VarNumber:
+			vars[name] = "1"
+		case VarURL:
+			vars[name] = "https://example.com"
+		default:
+			vars[name] = name
+		}
+	}
+	return vars
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"mailer-service/data"
+
+	mail "github.com/xhit/go-simple-mail/v2"
+)
+
+// Transport delivers composed mails. The queue workers call Send concurrently.
+type Transport interface {
+	Send(m *data.Mail) error
+	Close() error
+}
+
+// newTransport picks the transport named by MAIL_TRANSPORT: "smtp" (default),
+// "maildir" to write every mail to a local Maildir, or "memory" to keep them in
+// memory for the dev endpoint. The last two never touch the network.
+func newTransport(m *Mail, kind string, size int, idleTimeout time.Duration) (Transport, error) {
+	switch kind {
+	case "", "smtp":
+		return &smtpTransport{pool: newSMTPPool(m.connectSMTP, size, idleTimeout)}, nil
+	case "maildir":
+		dir := os.Getenv("MAIL_MAILDIR")
+		if dir == "" {
+			dir = "maildir"
+		}
+		return newMaildirTransport(dir)
+	case "memory":
+		return newMemoryTransport(100), nil
+	default:
+		return nil, fmt.Errorf("unknown mail transport %q", kind)
+	}
+}
+
+// buildEmail turns a composed mail into a go-simple-mail message
+func buildEmail(d *data.Mail) *mail.Email {
+	email := mail.NewMSG()
+	email.SetFrom(d.From).
+		AddTo(d.To).
+		SetSubject(d.Subject)
+
+	email.SetBody(mail.TextPlain, d.Plain)
+	email.AddAlternative(mail.TextHTML, d.HTML)
+
+	// One-click unsubscribe (RFC 8058) for marketing mail
+	if d.UnsubscribeURL != "" {
+		email.AddHeader("List-Unsubscribe", "<"+d.UnsubscribeURL+">")
+		email.AddHeader("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+	}
+
+	for _, a := range d.Attachments {
+		email.Attach(&mail.File{Name: a.Name, MimeType: a.ContentType, Data: a.Content})
+	}
+
+	return email
+}
+
+// smtpTransport sends mails over pooled SMTP connections
+type smtpTransport struct {
+	pool *smtpPool
+}
+
+func (t *smtpTransport) Send(d *data.Mail) error {
+	email := buildEmail(d)
+	if email.Error != nil {
+		return email.Error
+	}
+
+	conn, err := t.pool.get()
+	if err != nil {
+		return err
+	}
+
+	err = email.Send(conn.client)
+	t.pool.put(conn, err)
+	return err
+}
+
+func (t *smtpTransport) Close() error {
+	t.pool.close()
+	return nil
+}
+
+// maildirTransport writes every mail as a file into the new/ folder of a
+// Maildir, which any mail client can open
+type maildirTransport struct {
+	dir string
+}
+
+func newMaildirTransport(dir string) (*maildirTransport, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, err
+		}
+	}
+	return &maildirTransport{dir: dir}, nil
+}
+
+func (t *maildirTransport) Send(d *data.Mail) error {
+	email := buildEmail(d)
+	if email.Error != nil {
+		return email.Error
+	}
+
+	host, _ := os.Hostname()
+	name := fmt.Sprintf("%d.%s_%s.%s", time.Now().Unix(), d.ID, randomSuffix(), host)
+
+	// Maildir delivery: write to tmp/, then move into new/ so readers never
+	// see a half written message
+	tmp := filepath.Join(t.dir, "tmp", name)
+	if err := os.WriteFile(tmp, []byte(email.GetMessage()), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(t.dir, "new", name))
+}
+
+func (t *maildirTransport) Close() error {
+	return nil
+}
+
+// CapturedMail is a mail kept by the in-memory transport
+type CapturedMail struct {
+	ID       string    `json:"id"`
+	From     string    `json:"from"`
+	To       string    `json:"to"`
+	Subject  string    `json:"subject"`
+	Template string    `json:"template,omitempty"`
+	HTML     string    `json:"html"`
+	Plain    string    `json:"plain"`
+	Raw      string    `json:"raw"`
+	SentAt   time.Time `json:"sent_at"`
+}
+
+// memoryTransport keeps the last sent mails in memory instead of sending them
+type memoryTransport struct {
+	limit int
+
+	mu    sync.Mutex
+	mails []CapturedMail
+}
+
+func newMemoryTransport(limit int) *memoryTransport {
+	return &memoryTransport{limit: limit}
+}
+
+func (t *memoryTransport) Send(d *data.Mail) error {
+	email := buildEmail(d)
+	if email.Error != nil {
+		return email.Error
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.mails = append(t.mails, CapturedMail{
+		ID:       d.ID,
+		From:     d.From,
+		To:       d.To,
+		Subject:  d.Subject,
+		Template: d.Template,
+		HTML:     d.HTML,
+		Plain:    d.Plain,
+		Raw:      email.GetMessage(),
+		SentAt:   time.Now(),
+	})
+	if len(t.mails) > t.limit {
+		t.mails = t.mails[len(t.mails)-t.limit:]
+	}
+	return nil
+}
+
+// Mails returns the captured mails, newest first, optionally only those to a recipient
+func (t *memoryTransport) Mails(to string) []CapturedMail {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	mails := []CapturedMail{}
+	for i := len(t.mails) - 1; i >= 0; i-- {
+		if to == "" || t.mails[i].To == to {
+			mails = append(mails, t.mails[i])
+		}
+	}
+	return mails
+}
+
+// Clear drops every captured mail
+func (t *memoryTransport) Clear() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.mails = nil
+}
+
+func (t *memoryTransport) Close() error {
+	return nil
+}
+
+func randomSuffix() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,262 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	database = "mail"
+
+	StatusQueued  = "queued"
+	StatusSent    = "sent"
+	StatusFailed  = "failed"
+	StatusBounced = "bounced"
+	// StatusSuppressed marks a mail that wasn't sent because its recipient is
+	// on the suppression list
+	StatusSuppressed = "suppressed"
+)
+
+// ErrNotFound is returned when a mail doesn't exist
+var ErrNotFound = errors.New("mail not found")
+
+func New(client *mongo.Client) Models {
+	db := client.Database(database)
+
+	return Models{
+		Mail: MailStore{
+			mails:       db.Collection("mails"),
+			deadLetters: db.Collection("dead_letters"),
+		},
+		Suppression: SuppressionStore{
+			suppressions: db.Collection("suppressions"),
+		},
+	}
+}
+
+type Models struct {
+	Mail        MailStore
+	Suppression SuppressionStore
+}
+
+// Mail is a rendered email and its delivery state.
+type Mail struct {
+	ID          string       `bson:"_id" json:"id"`
+	From        string       `bson:"from" json:"from"`
+	FromName    string       `bson:"from_name" json:"from_name"`
+	To          string       `bson:"to" json:"to"`
+	Subject     string       `bson:"subject" json:"subject"`
+	HTML        string       `bson:"html" json:"-"`
+	Plain       string       `bson:"plain" json:"-"`
+	Attachments []Attachment `bson:"attachments,omitempty" json:"attachments,omitempty"`
+	Template    string       `bson:"template,omitempty" json:"template,omitempty"`
+	Category    string       `bson:"category" json:"category"`
+	// UnsubscribeURL goes into the List-Unsubscribe header of marketing mail
+	UnsubscribeURL string `bson:"unsubscribe_url,omitempty" json:"-"`
+
+	Status        string     `bson:"status" json:"status"`
+	Attempts      int        `bson:"attempts" json:"attempts"`
+	LastError     string     `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	NextAttemptAt time.Time  `bson:"next_attempt_at" json:"next_attempt_at"`
+	LockedUntil   time.Time  `bson:"locked_until" json:"-"`
+	CreatedAt     time.Time  `bson:"created_at" json:"created_at"`
+	UpdatedAt     time.Time  `bson:"updated_at" json:"updated_at"`
+	SentAt        *time.Time `bson:"sent_at,omitempty" json:"sent_at,omitempty"`
+}
+
+// Attachment is a file attached to a mail, already checked and loaded.
+type Attachment struct {
+	Name        string `bson:"name" json:"name"`
+	ContentType string `bson:"content_type" json:"content_type"`
+	Content     []byte `bson:"content" json:"-"`
+	ImageRef    string `bson:"image_ref,omitempty" json:"image_ref,omitempty"`
+}
+
+// DeadLetter is a mail that was given up on, kept for inspection and replay.
+type DeadLetter struct {
+	Mail           `bson:",inline"`
+	DeadLetteredAt time.Time `bson:"dead_lettered_at" json:"dead_lettered_at"`
+}
+
+// MailStore keeps queued mails in the mails collection and the ones that kept
+// failing in dead_letters. Workers claim a mail by leasing it for a while, so a
+// worker that dies mid-send only delays the mail until the lease runs out.
+type MailStore struct {
+	mails       *mongo.Collection
+	deadLetters *mongo.Collection
+}
+
+// EnsureIndexes creates the indexes the queue relies on.
+func (s MailStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.mails.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+		{Keys: bson.D{{Key: "to", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	return err
+}
+
+// Enqueue stores m as queued for immediate delivery and sets its ID.
+func (s MailStore) Enqueue(ctx context.Context, m *Mail) error {
+	now := time.Now()
+
+	m.ID = primitive.NewObjectID().Hex()
+	m.Status = StatusQueued
+	m.Attempts = 0
+	m.NextAttemptAt = now
+	m.LockedUntil = time.Time{}
+	m.CreatedAt = now
+	m.UpdatedAt = now
+
+	_, err := s.mails.InsertOne(ctx, m)
+	return err
+}
+
+// Skip stores m as suppressed, a mail that is kept for its status but never sent.
+func (s MailStore) Skip(ctx context.Context, m *Mail, reason string) error {
+	now := time.Now()
+
+	m.ID = primitive.NewObjectID().Hex()
+	m.Status = StatusSuppressed
+	m.LastError = reason
+	m.NextAttemptAt = now
+	m.CreatedAt = now
+	m.UpdatedAt = now
+
+	_, err := s.mails.InsertOne(ctx, m)
+	return err
+}
+
+// Claim leases the next due mail for lease and returns it, nil when nothing is due.
+func (s MailStore) Claim(ctx context.Context, lease time.Duration) (*Mail, error) {
+	now := time.Now()
+
+	filter := bson.M{
+		"status":          StatusQueued,
+		"next_attempt_at": bson.M{"$lte": now},
+		"locked_until":    bson.M{"$lte": now},
+	}
+	update := bson.M{"$set": bson.M{
+		"locked_until": now.Add(lease),
+		"updated_at":   now,
+	}}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var m Mail
+	err := s.mails.FindOneAndUpdate(ctx, filter, update, opts).Decode(&m)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// MarkSent records a successful delivery.
+func (s MailStore) MarkSent(ctx context.Context, m *Mail) error {
+	now := time.Now()
+
+	_, err := s.mails.UpdateByID(ctx, m.ID, bson.M{"$set": bson.M{
+		"status":       StatusSent,
+		"attempts":     m.Attempts + 1,
+		"last_error":   "",
+		"sent_at":      now,
+		"locked_until": time.Time{},
+		"updated_at":   now,
+	}})
+	return err
+}
+
+// MarkSuppressed records that a queued mail won't be sent because its
+// recipient was suppressed after it was queued.
+func (s MailStore) MarkSuppressed(ctx context.Context, m *Mail, reason string) error {
+	_, err := s.mails.UpdateByID(ctx, m.ID, bson.M{"$set": bson.M{
+		"status":       StatusSuppressed,
+		"last_error":   reason,
+		"locked_until": time.Time{},
+		"updated_at":   time.Now(),
+	}})
+	return err
+}
+
+// Retry records a failed attempt and queues m again at next.
+func (s MailStore) Retry(ctx context.Context, m *Mail, cause error, next time.Time) error {
+	_, err := s.mails.UpdateByID(ctx, m.ID, bson.M{"$set": bson.M{
+		"status":          StatusQueued,
+		"attempts":        m.Attempts + 1,
+		"last_error":      cause.Error(),
+		"next_attempt_at": next,
+		"locked_until":    time.Time{},
+		"updated_at":      time.Now(),
+	}})
+	return err
+}
+
+// GiveUp marks m as failed or bounced and copies it to the dead-letter store.
+func (s MailStore) GiveUp(ctx context.Context, m *Mail, status string, cause error) error {
+	now := time.Now()
+
+	m.Status = status
+	m.Attempts++
+	m.LastError = cause.Error()
+	m.LockedUntil = time.Time{}
+	m.UpdatedAt = now
+
+	_, err := s.mails.UpdateByID(ctx, m.ID, bson.M{"$set": bson.M{
+		"status":       m.Status,
+		"attempts":     m.Attempts,
+		"last_error":   m.LastError,
+		"locked_until": m.LockedUntil,
+		"updated_at":   now,
+	}})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.deadLetters.ReplaceOne(ctx,
+		bson.M{"_id": m.ID},
+		DeadLetter{Mail: *m, DeadLetteredAt: now},
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+// Get returns the mail with id.
+func (s MailStore) Get(ctx context.Context, id string) (*Mail, error) {
+	var m Mail
+	err := s.mails.FindOne(ctx, bson.M{"_id": id}).Decode(&m)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// DeadLetters returns the most recent dead letters, newest first.
+func (s MailStore) DeadLetters(ctx context.Context, limit int64) ([]*DeadLetter, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "dead_lettered_at", Value: -1}}).
+		SetLimit(limit)
+
+	cursor, err := s.deadLetters.Find(ctx, bson.D{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	letters := []*DeadLetter{}
+	if err := cursor.All(ctx, &letters); err != nil {
+		return nil, err
+	}
+	return letters, nil
+}
@@ -0,0 +1,143 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"net/mail"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Reasons an address is suppressed
+const (
+	ReasonHardBounce  = "hard_bounce"
+	ReasonComplaint   = "complaint"
+	ReasonUnsubscribe = "unsubscribe"
+)
+
+// Suppression stops mail to an address. An address has at most one
+// suppression per reason.
+type Suppression struct {
+	Email     string    `bson:"email" json:"email"`
+	Reason    string    `bson:"reason" json:"reason"`
+	Source    string    `bson:"source,omitempty" json:"source,omitempty"` // smtp, dsn, webhook, link or admin
+	Detail    string    `bson:"detail,omitempty" json:"detail,omitempty"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// ValidReason reports whether reason is one of the suppression reasons.
+func ValidReason(reason string) bool {
+	switch reason {
+	case ReasonHardBounce, ReasonComplaint, ReasonUnsubscribe:
+		return true
+	}
+	return false
+}
+
+// NormalizeEmail returns the bare, lower case address of email, so "Jane
+// <Jane@Example.com>" and "jane@example.com" share their suppressions.
+func NormalizeEmail(email string) string {
+	if addr, err := mail.ParseAddress(email); err == nil {
+		email = addr.Address
+	}
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// SuppressionStore keeps the suppressed addresses in the suppressions collection.
+type SuppressionStore struct {
+	suppressions *mongo.Collection
+}
+
+// EnsureIndexes creates the indexes suppression lookups rely on.
+func (s SuppressionStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.suppressions.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "email", Value: 1}, {Key: "reason", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "created_at", Value: -1}}},
+	})
+	return err
+}
+
+// Add suppresses an address, or refreshes the source and detail of an
+// existing suppression with the same reason.
+func (s SuppressionStore) Add(ctx context.Context, sup Suppression) error {
+	if !ValidReason(sup.Reason) {
+		return errors.New("unknown suppression reason " + sup.Reason)
+	}
+
+	now := time.Now()
+	_, err := s.suppressions.UpdateOne(ctx,
+		bson.M{"email": NormalizeEmail(sup.Email), "reason": sup.Reason},
+		bson.M{
+			"$set":         bson.M{"source": sup.Source, "detail": sup.Detail, "updated_at": now},
+			"$setOnInsert": bson.M{"created_at": now},
+		},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// Find returns the suppression of email for one of reasons, nil when there's none.
+func (s SuppressionStore) Find(ctx context.Context, email string, reasons []string) (*Suppression, error) {
+	filter := bson.M{
+		"email":  NormalizeEmail(email),
+		"reason": bson.M{"$in": reasons},
+	}
+
+	var sup Suppression
+	err := s.suppressions.FindOne(ctx, filter).Decode(&sup)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sup, nil
+}
+
+// List returns the most recent suppressions, newest first, only those of
+// email when it isn't empty.
+func (s SuppressionStore) List(ctx context.Context, email string, limit int64) ([]*Suppression, error) {
+	filter := bson.M{}
+	if email != "" {
+		filter["email"] = NormalizeEmail(email)
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetLimit(limit)
+
+	cursor, err := s.suppressions.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	list := []*Suppression{}
+	if err := cursor.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Remove lifts the suppression of email for reason, or for every reason when
+// reason is empty, and returns how many were removed.
+func (s SuppressionStore) Remove(ctx context.Context, email, reason string) (int64, error) {
+	filter := bson.M{"email": NormalizeEmail(email)}
+	if reason != "" {
+		filter["reason"] = reason
+	}
+
+	res, err := s.suppressions.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
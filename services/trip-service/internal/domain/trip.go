@@ -16,9 +16,13 @@ type TripModel struct {
 
 type TripRepository interface {
 	CreateTrip(ctx context.Context, trip *TripModel) (*TripModel, error)
+	GetTripsByUserID(ctx context.Context, userID string) ([]*TripModel, error)
+	DeleteTripsByUserID(ctx context.Context, userID string) (int, error)
 }
 
 type TripService interface {
 	CreateTrip(ctx context.Context, fare *RideFareModel) (*TripModel, error)
 	GetRoute(ctx context.Context, pickup, destination *types.Coordinate) (*types.OsrmApiResponse, error)
+	GetUserTrips(ctx context.Context, userID string) ([]*TripModel, error)
+	EraseUserTrips(ctx context.Context, userID string) (int, error)
 }
@@ -0,0 +1,71 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"ride-sharing/services/trip-service/internal/domain"
+	"ride-sharing/shared/contracts"
+	"ride-sharing/shared/messaging"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	serviceName      = "trip-service"
+	userErasureQueue = "trip_user_erasure"
+)
+
+// UserConsumer erases the trips of deleted users.
+type UserConsumer struct {
+	rabbitmq *messaging.RabbitMQ
+	service  domain.TripService
+}
+
+func NewUserConsumer(rabbitmq *messaging.RabbitMQ, service domain.TripService) *UserConsumer {
+	return &UserConsumer{
+		rabbitmq: rabbitmq,
+		service:  service,
+	}
+}
+
+func (c *UserConsumer) Listen() error {
+	return c.rabbitmq.ConsumeMessages(userErasureQueue, []string{contracts.UserEventDeleted}, c.handleUserDeleted)
+}
+
+func (c *UserConsumer) handleUserDeleted(ctx context.Context, msg amqp.Delivery) error {
+	var message contracts.AmqpMessage
+	if err := json.Unmarshal(msg.Body, &message); err != nil {
+		return fmt.Errorf("failed to unmarshal message: %v", err)
+	}
+
+	var payload contracts.UserDeletedEvent
+	if err := json.Unmarshal(message.Data, &payload); err != nil {
+		return fmt.Errorf("failed to unmarshal payload: %v", err)
+	}
+
+	erased, err := c.service.EraseUserTrips(ctx, payload.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to erase trips for user %s: %v", payload.UserID, err)
+	}
+	log.Printf("Erased %d trip records for deleted user %s", erased, payload.UserID)
+
+	confirmation, err := json.Marshal(contracts.UserDataErasedEvent{
+		RequestID: payload.RequestID,
+		UserID:    payload.UserID,
+		Service:   serviceName,
+		Erased:    int64(erased),
+		ErasedAt:  time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.rabbitmq.PublishMessage(ctx, contracts.UserEventDataErased, contracts.AmqpMessage{
+		OwnerID: payload.UserID,
+		Data:    confirmation,
+	})
+}
@@ -2,9 +2,11 @@ package http
 
 import (
 	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
 	"ride-sharing/services/trip-service/internal/domain"
+	"ride-sharing/shared/resilience"
 	"ride-sharing/shared/types"
 )
 
@@ -25,16 +27,48 @@ func (s *HttpHandler) HandleTripPreview(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if reqBody.UserID == "" {
+		http.Error(w, "user ID is required", http.StatusBadRequest)
+		return
+	}
+
 	ctx := r.Context()
 
 	t, err := s.Service.GetRoute(ctx, &reqBody.Pickup, &reqBody.Destination)
+	if errors.Is(err, resilience.ErrOpen) || errors.Is(err, resilience.ErrBulkheadFull) {
+		slog.WarnContext(ctx, "route lookup rejected", "error", err)
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "routing is unavailable, try again later", http.StatusServiceUnavailable)
+		return
+	}
 	if err != nil {
-		log.Println(err)
+		slog.ErrorContext(ctx, "route lookup failed", "error", err)
+		http.Error(w, "failed to get route", http.StatusBadGateway)
+		return
 	}
 
 	writeJSON(w, http.StatusOK, t)
 }
 
+// HandleUserTrips returns every trip stored for a user. It backs the account
+// data export in the auth service.
+func (s *HttpHandler) HandleUserTrips(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("userID")
+	if userID == "" {
+		http.Error(w, "user ID is required", http.StatusBadRequest)
+		return
+	}
+
+	trips, err := s.Service.GetUserTrips(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "listing user trips failed", "user_id", userID, "error", err)
+		http.Error(w, "failed to get trips", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, trips)
+}
+
 func writeJSON(w http.ResponseWriter, status int, data any) error {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
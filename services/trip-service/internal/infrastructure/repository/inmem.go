@@ -3,9 +3,11 @@ package repository
 import (
 	"context"
 	"ride-sharing/services/trip-service/internal/domain"
+	"sync"
 )
 
 type inmemRepository struct {
+	mu        sync.RWMutex
 	trips     map[string]*domain.TripModel
 	rideFares map[string]*domain.RideFareModel
 }
@@ -18,6 +20,44 @@ func NewInmemRepository() *inmemRepository {
 }
 
 func (r *inmemRepository) CreateTrip(ctx context.Context, trip *domain.TripModel) (*domain.TripModel, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	r.trips[trip.ID.Hex()] = trip
 	return trip, nil
 }
+
+func (r *inmemRepository) GetTripsByUserID(ctx context.Context, userID string) ([]*domain.TripModel, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	trips := []*domain.TripModel{}
+	for _, t := range r.trips {
+		if t.UserID == userID {
+			trips = append(trips, t)
+		}
+	}
+	return trips, nil
+}
+
+// DeleteTripsByUserID removes every trip and ride fare owned by the user and
+// returns how many records were removed.
+func (r *inmemRepository) DeleteTripsByUserID(ctx context.Context, userID string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deleted := 0
+	for id, t := range r.trips {
+		if t.UserID == userID {
+			delete(r.trips, id)
+			deleted++
+		}
+	}
+	for id, f := range r.rideFares {
+		if f.UserID == userID {
+			delete(r.rideFares, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,27 @@
+package service
+
+import (
+	"net/http"
+	"ride-sharing/shared/resilience"
+	"ride-sharing/shared/retry"
+	"time"
+)
+
+// newOSRMClient returns the client of the OSRM API behind the previews. A
+// slow OSRM ties up OSRM_MAX_CONCURRENT requests at most, each bounded by
+// OSRM_TIMEOUT, and one failing OSRM_BREAKER_FAILURES times in a row isn't
+// called for OSRM_BREAKER_OPEN: the previews fail fast with
+// resilience.ErrOpen meanwhile.
+func newOSRMClient() *http.Client {
+	cfg := resilience.ConfigFromEnv("OSRM", resilience.Config{
+		Timeout: 5 * time.Second,
+		Retry: retry.Config{
+			MaxRetries:  1,
+			InitialWait: 200 * time.Millisecond,
+			MaxWait:     time.Second,
+		},
+		Bulkhead: resilience.BulkheadConfig{MaxConcurrent: 32, MaxWait: 500 * time.Millisecond},
+		Breaker:  resilience.BreakerConfig{Failures: 5, OpenFor: 30 * time.Second},
+	})
+	return &http.Client{Transport: resilience.NewTransport("osrm", cfg)}
+}
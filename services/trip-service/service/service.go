@@ -7,18 +7,23 @@ import (
 	"io"
 	"net/http"
 	"ride-sharing/services/trip-service/internal/domain"
+	"ride-sharing/shared/env"
 	"ride-sharing/shared/types"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type service struct {
-	repo domain.TripRepository
+	repo    domain.TripRepository
+	osrm    *http.Client
+	osrmURL string
 }
 
 func NewService(repo domain.TripRepository) *service {
 	return &service{
-		repo: repo,
+		repo:    repo,
+		osrm:    newOSRMClient(),
+		osrmURL: env.GetString("OSRM_URL", "http://router.project-osrm.org"),
 	}
 }
 
@@ -33,19 +38,36 @@ func (s *service) CreateTrip(ctx context.Context, fare *domain.RideFareModel) (*
 	return s.repo.CreateTrip(ctx, t)
 }
 
+func (s *service) GetUserTrips(ctx context.Context, userID string) ([]*domain.TripModel, error) {
+	return s.repo.GetTripsByUserID(ctx, userID)
+}
+
+func (s *service) EraseUserTrips(ctx context.Context, userID string) (int, error) {
+	return s.repo.DeleteTripsByUserID(ctx, userID)
+}
+
 func (s *service) GetRoute(ctx context.Context, pickup, destination *types.Coordinate) (*types.OsrmApiResponse, error) {
 	url := fmt.Sprintf(
-		"http://router.project-osrm.org/route/v1/driving/%f,%f;%f,%f?overview=full&geometries=geojson",
+		"%s/route/v1/driving/%f,%f;%f,%f?overview=full&geometries=geojson",
+		s.osrmURL,
 		pickup.Longitude, pickup.Latitude,
 		destination.Longitude, destination.Latitude,
 	)
 
-	resp, err := http.Get(url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch route from OSRM API: %v", err)
+		return nil, fmt.Errorf("failed to build the OSRM request: %w", err)
+	}
+	resp, err := s.osrm.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch route from OSRM API: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OSRM API returned %s", resp.Status)
+	}
+
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read the response: %v", err)
@@ -0,0 +1,275 @@
+/*
+Package clients provides the gRPC clients of the services. A Pool keeps one
+connection per address, shared by every client of the process, and gives
+calls a deadline when their context has none, retries the idempotent calls
+that failed because the service was unavailable, and uses TLS when
+configured. The calls
+to each address go through a resilience.Policy: a bulkhead bounds the ones
+running at once, and a circuit breaker fails them fast, with Unavailable,
+while the service keeps failing.
+
+	mail, err := clients.NewMailClient(clients.Default())
+	...
+	resp, err := mail.SendMail(ctx, &mailpb.MailRequest{...})
+*/
+package clients
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"ride-sharing/shared/env"
+	"ride-sharing/shared/resilience"
+	"ride-sharing/shared/retry"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// Options configure the connections of a Pool.
+type Options struct {
+	// Timeout is the deadline of a call whose context has none
+	Timeout time.Duration
+	// Retry is how often and how long apart the idempotent calls failing
+	// with Unavailable are retried. Zero MaxRetries doesn't retry.
+	Retry retry.Config
+	// Bulkhead bounds the concurrent calls to an address
+	Bulkhead resilience.BulkheadConfig
+	// Breaker stops calling an address whose calls keep failing with
+	// Unavailable or DeadlineExceeded
+	Breaker resilience.BreakerConfig
+	// TLS secures the connections when not nil
+	TLS *tls.Config
+}
+
+// OptionsFromEnv reads GRPC_TIMEOUT, GRPC_RETRIES, GRPC_MAX_CONCURRENT,
+// GRPC_MAX_WAIT, GRPC_BREAKER_FAILURES, GRPC_BREAKER_OPEN and the TLS settings:
+// GRPC_TLS turns TLS on, GRPC_TLS_CA_FILE verifies the servers against a CA
+// other than the system ones, GRPC_TLS_CERT_FILE and GRPC_TLS_KEY_FILE are a
+// client certificate for mutual TLS, and GRPC_TLS_SERVER_NAME overrides the
+// name checked in the server certificates.
+func OptionsFromEnv() (Options, error) {
+	cfg := resilience.ConfigFromEnv("GRPC", resilience.Config{
+		Timeout: 5 * time.Second,
+		Retry: retry.Config{
+			MaxRetries:  3,
+			InitialWait: 100 * time.Millisecond,
+			MaxWait:     2 * time.Second,
+		},
+		Bulkhead: resilience.BulkheadConfig{MaxConcurrent: 100, MaxWait: time.Second},
+		Breaker:  resilience.BreakerConfig{Failures: 5, OpenFor: 30 * time.Second},
+	})
+	opts := Options{
+		Timeout:  cfg.Timeout,
+		Retry:    cfg.Retry,
+		Bulkhead: cfg.Bulkhead,
+		Breaker:  cfg.Breaker,
+	}
+
+	if !env.GetBool("GRPC_TLS", false) {
+		return opts, nil
+	}
+
+	tlsCfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: os.Getenv("GRPC_TLS_SERVER_NAME"),
+	}
+	if caFile := os.Getenv("GRPC_TLS_CA_FILE"); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return opts, fmt.Errorf("reading GRPC_TLS_CA_FILE: %w", err)
+		}
+		tlsCfg.RootCAs = x509.NewCertPool()
+		if !tlsCfg.RootCAs.AppendCertsFromPEM(pem) {
+			return opts, errors.New("GRPC_TLS_CA_FILE holds no PEM certificate")
+		}
+	}
+	certFile, keyFile := os.Getenv("GRPC_TLS_CERT_FILE"), os.Getenv("GRPC_TLS_KEY_FILE")
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return opts, fmt.Errorf("loading GRPC_TLS_CERT_FILE and GRPC_TLS_KEY_FILE: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	opts.TLS = tlsCfg
+
+	return opts, nil
+}
+
+// Pool shares one connection per address. Connections are opened on first
+// use and reconnect by themselves; they are never closed before Close.
+// Deadlines, retries, bulkheads and breakers apply to unary calls; streams
+// may rightly outlive a call, so they are bounded by the caller's context
+// only.
+type Pool struct {
+	opts Options
+
+	mu    sync.Mutex
+	conns map[string]*grpc.ClientConn
+}
+
+func NewPool(opts Options) *Pool {
+	return &Pool{opts: opts, conns: map[string]*grpc.ClientConn{}}
+}
+
+var (
+	defaultOnce sync.Once
+	defaultPool *Pool
+	defaultErr  error
+)
+
+// Default returns the pool of the process, configured by OptionsFromEnv the
+// first time it is called, so after main loaded the environment. It panics
+// when the TLS settings are invalid.
+func Default() *Pool {
+	defaultOnce.Do(func() {
+		var opts Options
+		opts, defaultErr = OptionsFromEnv()
+		defaultPool = NewPool(opts)
+	})
+	if defaultErr != nil {
+		panic("clients: " + defaultErr.Error())
+	}
+	return defaultPool
+}
+
+// Conn returns the connection to addr, opening it on first use.
+func (p *Pool) Conn(addr string) (*grpc.ClientConn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if conn, ok := p.conns[addr]; ok {
+		return conn, nil
+	}
+
+	creds := insecure.NewCredentials()
+	if p.opts.TLS != nil {
+		creds = credentials.NewTLS(p.opts.TLS)
+	}
+
+	conn, err := grpc.NewClient(addr,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithChainUnaryInterceptor(p.deadline, p.guard(addr)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s: %w", addr, err)
+	}
+	p.conns[addr] = conn
+	return conn, nil
+}
+
+// Close closes every connection of the pool.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var errs []error
+	for addr, conn := range p.conns {
+		errs = append(errs, conn.Close())
+		delete(p.conns, addr)
+	}
+	return errors.Join(errs...)
+}
+
+func (p *Pool) deadline(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if _, ok := ctx.Deadline(); !ok && p.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.opts.Timeout)
+		defer cancel()
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+// idempotent lists the methods that can safely run twice. A call that timed
+// out may have been carried out anyway, so only these are retried: retrying
+// SendMail or LogInfo could send a mail or store a log twice.
+var idempotent = map[string]bool{
+	"/auth.AuthService/VerifyAccessToken": true,
+	"/auth.AuthService/GetProvinces":      true,
+	"/auth.AuthService/GetWards":          true,
+	"/mail.MailService/GetMailStatus":     true,
+	"/image.ImageService/GetURL":          true,
+	"/image.ImageService/ReadImage":       true,
+	"/image.ImageService/ListImages":      true,
+	"/image.ImageService/GetImage":        true,
+	"/image.ImageService/DeleteImage":     true,
+	"/logger.LoggerService/QueryLogs":     true,
+}
+
+// guard runs the calls to addr through its policy, after deadline so every
+// attempt shares the deadline of the call. Unavailable and DeadlineExceeded
+// count against the breaker, and the idempotent calls failing with them are
+// retried while the call has time left; the other codes are answers of the
+// service.
+func (p *Pool) guard(addr string) grpc.UnaryClientInterceptor {
+	policy := resilience.New("grpc "+addr, resilience.Config{
+		Retry:    p.opts.Retry,
+		Bulkhead: p.opts.Bulkhead,
+		Breaker:  p.opts.Breaker,
+	})
+
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		call := func(ctx context.Context) error {
+			err := invoker(ctx, method, req, reply, cc, opts...)
+			switch status.Code(err) {
+			case codes.OK, codes.Unavailable, codes.DeadlineExceeded:
+				return err
+			}
+			return resilience.Permanent(err)
+		}
+
+		var err error
+		if idempotent[method] {
+			err = policy.Do(ctx, call)
+		} else {
+			err = policy.Attempt(ctx, call)
+		}
+		if errors.Is(err, resilience.ErrOpen) || errors.Is(err, resilience.ErrBulkheadFull) {
+			return status.Error(codes.Unavailable, err.Error())
+		}
+		return err
+	}
+}
+
+// ServerCredentials returns the options that make a gRPC server use TLS when
+// GRPC_TLS is on, with GRPC_TLS_CERT_FILE and GRPC_TLS_KEY_FILE as its
+// certificate and, when GRPC_TLS_CA_FILE is set, requiring client
+// certificates signed by that CA.
+func ServerCredentials() ([]grpc.ServerOption, error) {
+	if !env.GetBool("GRPC_TLS", false) {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(os.Getenv("GRPC_TLS_CERT_FILE"), os.Getenv("GRPC_TLS_KEY_FILE"))
+	if err != nil {
+		return nil, fmt.Errorf("loading GRPC_TLS_CERT_FILE and GRPC_TLS_KEY_FILE: %w", err)
+	}
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if caFile := os.Getenv("GRPC_TLS_CA_FILE"); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading GRPC_TLS_CA_FILE: %w", err)
+		}
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("GRPC_TLS_CA_FILE holds no PEM certificate")
+		}
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return []grpc.ServerOption{grpc.Creds(credentials.NewTLS(cfg))}, nil
+}
@@ -0,0 +1,114 @@
+package clients
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mailpb "ride-sharing/shared/generated/mail"
+	"ride-sharing/shared/resilience"
+	"ride-sharing/shared/retry"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// unavailableMail is a mail-service that is always unavailable and counts
+// the calls it gets
+type unavailableMail struct {
+	mailpb.UnimplementedMailServiceServer
+	sends, statuses atomic.Int32
+}
+
+func (s *unavailableMail) SendMail(ctx context.Context, req *mailpb.MailRequest) (*mailpb.MailResponse, error) {
+	s.sends.Add(1)
+	return nil, status.Error(codes.Unavailable, "shutting down")
+}
+
+func (s *unavailableMail) GetMailStatus(ctx context.Context, req *mailpb.MailStatusRequest) (*mailpb.MailStatusResponse, error) {
+	s.statuses.Add(1)
+	if req.GetId() == "missing" {
+		return nil, status.Error(codes.NotFound, "mail not found")
+	}
+	return nil, status.Error(codes.Unavailable, "shutting down")
+}
+
+// dialGuarded connects to server through the interceptors of a pool with opts
+func dialGuarded(t *testing.T, opts Options, server mailpb.MailServiceServer) mailpb.MailServiceClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	mailpb.RegisterMailServiceServer(s, server)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	p := NewPool(opts)
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithChainUnaryInterceptor(p.deadline, p.guard(t.Name())),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return mailpb.NewMailServiceClient(conn)
+}
+
+func TestGuardRetriesIdempotentCallsOnly(t *testing.T) {
+	server := &unavailableMail{}
+	client := dialGuarded(t, Options{
+		Timeout: 5 * time.Second,
+		Retry:   retry.Config{MaxRetries: 2, InitialWait: time.Millisecond, MaxWait: time.Millisecond},
+	}, server)
+	ctx := context.Background()
+
+	_, err := client.SendMail(ctx, &mailpb.MailRequest{To: "jane@example.com"})
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("SendMail = %v, want Unavailable", err)
+	}
+	if n := server.sends.Load(); n != 1 {
+		t.Errorf("SendMail was attempted %d times, want once: a retry could send the mail twice", n)
+	}
+
+	_, err = client.GetMailStatus(ctx, &mailpb.MailStatusRequest{Id: "1"})
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("GetMailStatus = %v, want Unavailable", err)
+	}
+	if n := server.statuses.Load(); n != 3 {
+		t.Errorf("GetMailStatus was attempted %d times, want 3", n)
+	}
+
+	// answers of the service aren't retried either
+	server.statuses.Store(0)
+	_, err = client.GetMailStatus(ctx, &mailpb.MailStatusRequest{Id: "missing"})
+	if status.Code(err) != codes.NotFound || server.statuses.Load() != 1 {
+		t.Errorf("GetMailStatus = %v after %d attempts, want NotFound after 1", err, server.statuses.Load())
+	}
+}
+
+func TestGuardBreaksNonIdempotentCalls(t *testing.T) {
+	server := &unavailableMail{}
+	client := dialGuarded(t, Options{
+		Timeout: 5 * time.Second,
+		Breaker: resilience.BreakerConfig{Failures: 2, OpenFor: time.Minute},
+	}, server)
+	ctx := context.Background()
+
+	for i := 0; i < 4; i++ {
+		_, err := client.SendMail(ctx, &mailpb.MailRequest{To: "jane@example.com"})
+		if status.Code(err) != codes.Unavailable {
+			t.Fatalf("SendMail %d = %v, want Unavailable", i+1, err)
+		}
+	}
+	// the breaker opened after two failures and failed the others fast
+	if n := server.sends.Load(); n != 2 {
+		t.Fatalf("mail-service got %d calls, want 2", n)
+	}
+}
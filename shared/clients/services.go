@@ -0,0 +1,61 @@
+package clients
+
+import (
+	"ride-sharing/shared/env"
+	authpb "ride-sharing/shared/generated/auth"
+	imagepb "ride-sharing/shared/generated/image"
+	loggerpb "ride-sharing/shared/generated/logger"
+	mailpb "ride-sharing/shared/generated/mail"
+)
+
+// AuthClient calls auth at AUTH_GRPC_ADDR.
+type AuthClient struct {
+	authpb.AuthServiceClient
+}
+
+func NewAuthClient(pool *Pool) (*AuthClient, error) {
+	conn, err := pool.Conn(env.GetString("AUTH_GRPC_ADDR", "auth:50000"))
+	if err != nil {
+		return nil, err
+	}
+	return &AuthClient{authpb.NewAuthServiceClient(conn)}, nil
+}
+
+// LoggerClient calls logger-service at LOGGER_GRPC_ADDR.
+type LoggerClient struct {
+	loggerpb.LoggerServiceClient
+}
+
+func NewLoggerClient(pool *Pool) (*LoggerClient, error) {
+	conn, err := pool.Conn(env.GetString("LOGGER_GRPC_ADDR", "logger-service:50001"))
+	if err != nil {
+		return nil, err
+	}
+	return &LoggerClient{loggerpb.NewLoggerServiceClient(conn)}, nil
+}
+
+// MailClient calls mail-service at MAIL_GRPC_ADDR.
+type MailClient struct {
+	mailpb.MailServiceClient
+}
+
+func NewMailClient(pool *Pool) (*MailClient, error) {
+	conn, err := pool.Conn(env.GetString("MAIL_GRPC_ADDR", "mail-service:50002"))
+	if err != nil {
+		return nil, err
+	}
+	return &MailClient{mailpb.NewMailServiceClient(conn)}, nil
+}
+
+// ImageClient calls image-service at IMAGE_GRPC_ADDR.
+type ImageClient struct {
+	imagepb.ImageServiceClient
+}
+
+func NewImageClient(pool *Pool) (*ImageClient, error) {
+	conn, err := pool.Conn(env.GetString("IMAGE_GRPC_ADDR", "image-service:50003"))
+	if err != nil {
+		return nil, err
+	}
+	return &ImageClient{imagepb.NewImageServiceClient(conn)}, nil
+}
@@ -0,0 +1,34 @@
+package clients
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// userTokenMetadata carries the access token of the user a call is made for,
+// apart from the authorization of the calling service itself
+const userTokenMetadata = "x-user-authorization"
+
+// WithUserToken returns ctx for the calls made on behalf of the user of an
+// access token of the auth service. mail-service reads the imageRefs of a
+// mail with it, so a user may attach only the images they may read.
+func WithUserToken(ctx context.Context, token string) context.Context {
+	if token == "" {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, userTokenMetadata, "Bearer "+token)
+}
+
+// UserToken returns the access token of the user an incoming call was made
+// for with WithUserToken, empty when none was.
+func UserToken(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get(userTokenMetadata) {
+		if token, ok := strings.CutPrefix(v, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+	}
+	return ""
+}
@@ -29,4 +29,8 @@ const (
 
 	// Payment commands (payment.cmd.*)
 	PaymentCmdCreateSession = "payment.cmd.create_session"
+
+	// User events (user.event.*)
+	UserEventDeleted    = "user.event.deleted"
+	UserEventDataErased = "user.event.data_erased"
 )
@@ -0,0 +1,22 @@
+package contracts
+
+import "time"
+
+// UserDeletedEvent is published by the auth service once an account deletion
+// request has passed its grace period. Every service holding personal data for
+// the user must erase or anonymise it and reply with a UserDataErasedEvent.
+type UserDeletedEvent struct {
+	RequestID string    `json:"requestId"`
+	UserID    string    `json:"userId"`
+	DeletedAt time.Time `json:"deletedAt"`
+}
+
+// UserDataErasedEvent confirms that a single service finished erasing the data
+// of a deleted user. The auth service records it in the deletion audit trail.
+type UserDataErasedEvent struct {
+	RequestID string    `json:"requestId"`
+	UserID    string    `json:"userId"`
+	Service   string    `json:"service"`
+	Erased    int64     `json:"erased"`
+	ErasedAt  time.Time `json:"erasedAt"`
+}
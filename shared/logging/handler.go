@@ -0,0 +1,172 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Entry is one record as logger-service stores it.
+type Entry struct {
+	Timestamp time.Time         `json:"time"`
+	Service   string            `json:"service"`
+	Level     string            `json:"level"`
+	Message   string            `json:"msg"`
+	TraceID   string            `json:"trace_id,omitempty"`
+	UserID    string            `json:"user_id,omitempty"`
+	Fields    map[string]string `json:"fields,omitempty"`
+}
+
+// Handler is a slog.Handler that ships records to logger-service. Attributes
+// become the fields of the entry, named "group.key" inside groups, except
+// trace_id and user_id which fill the entry's own. Values that aren't
+// strings are formatted, or JSON encoded.
+type Handler struct {
+	service string
+	level   slog.Leveler
+	shipper *shipper // shared by the handlers derived with WithAttrs and WithGroup
+
+	prefix string
+	fields map[string]string
+}
+
+// New starts a Handler shipping the records of service through sink.
+func New(service string, sink Sink, opts Options) *Handler {
+	level := opts.Level
+	if level == nil {
+		level = slog.LevelInfo
+	}
+
+	return &Handler{
+		service: service,
+		level:   level,
+		shipper: newShipper(service, sink, opts),
+	}
+}
+
+func (h *Handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+// Handle never blocks: when the buffer is full the record goes to the
+// fallback writer.
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	e := Entry{
+		Timestamp: r.Time,
+		Service:   h.service,
+		Level:     levelName(r.Level),
+		Message:   r.Message,
+		TraceID:   TraceID(ctx),
+	}
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now()
+	}
+
+	fields := make(map[string]string, len(h.fields)+r.NumAttrs())
+	for k, v := range h.fields {
+		fields[k] = v
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		appendAttr(fields, h.prefix, a)
+		return true
+	})
+
+	if id, ok := fields["trace_id"]; ok {
+		e.TraceID = id
+		delete(fields, "trace_id")
+	}
+	if id, ok := fields["user_id"]; ok {
+		e.UserID = id
+		delete(fields, "user_id")
+	}
+	if len(fields) > 0 {
+		e.Fields = fields
+	}
+
+	h.shipper.enqueue(e)
+	return nil
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+
+	h2 := *h
+	h2.fields = make(map[string]string, len(h.fields)+len(attrs))
+	for k, v := range h.fields {
+		h2.fields[k] = v
+	}
+	for _, a := range attrs {
+		appendAttr(h2.fields, h.prefix, a)
+	}
+	return &h2
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	h2 := *h
+	h2.prefix = join(h.prefix, name)
+	return &h2
+}
+
+// Close sends the buffered records and stops shipping, or gives up when ctx
+// is done. Records logged afterwards go to the fallback writer.
+func (h *Handler) Close(ctx context.Context) error {
+	return h.shipper.close(ctx)
+}
+
+func appendAttr(fields map[string]string, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+
+	if a.Value.Kind() == slog.KindGroup {
+		// An inline group, with no key, adds its attributes to the current one
+		if a.Key != "" {
+			prefix = join(prefix, a.Key)
+		}
+		for _, ga := range a.Value.Group() {
+			appendAttr(fields, prefix, ga)
+		}
+		return
+	}
+
+	fields[join(prefix, a.Key)] = valueString(a.Value)
+}
+
+func valueString(v slog.Value) string {
+	switch v.Kind() {
+	case slog.KindTime:
+		return v.Time().Format(time.RFC3339Nano)
+	case slog.KindAny:
+		switch x := v.Any().(type) {
+		case error:
+			return x.Error()
+		case fmt.Stringer:
+			return x.String()
+		case []byte:
+			return string(x)
+		default:
+			if out, err := json.Marshal(x); err == nil {
+				return string(out)
+			}
+			return fmt.Sprint(x)
+		}
+	default:
+		return v.String()
+	}
+}
+
+func join(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
@@ -0,0 +1,111 @@
+/*
+Package logging ships the logs of a service to logger-service. It provides a
+log/slog Handler that batches records in the background, sends them through
+a Sink, reconnecting when logger-service comes back, and writes them to
+stderr meanwhile so nothing is lost. Records carry the service name and the
+trace ID of their context.
+
+A service calls Setup first thing in main and defers the function it returns:
+
+	defer logging.Setup("trip-service")()
+
+Setup also routes the standard log package through the handler, so
+log.Printf keeps working and is shipped at info level.
+*/
+package logging
+
+import (
+	"context"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"ride-sharing/shared/env"
+)
+
+// Options tune a Handler.
+type Options struct {
+	// Level is the minimum level shipped, info when nil
+	Level slog.Leveler
+	// BufferSize is how many records wait to be sent before new ones go to
+	// Fallback instead
+	BufferSize int
+	// BatchSize and FlushInterval bound how long a record waits: a batch is
+	// sent once it is full or every FlushInterval, whichever comes first
+	BatchSize     int
+	FlushInterval time.Duration
+	// Echo also writes every record to Fallback, not only the undelivered
+	// ones. Records are written before they're queued, so the message of a
+	// log.Fatal makes it out even though the process exits right after.
+	Echo bool
+	// Fallback receives the records that could not be shipped, one JSON
+	// object per line; stderr when nil
+	Fallback io.Writer
+}
+
+// OptionsFromEnv reads LOG_LEVEL, LOG_SHIP_BUFFER, LOG_SHIP_BATCH,
+// LOG_SHIP_INTERVAL and LOG_STDERR.
+func OptionsFromEnv() Options {
+	return Options{
+		Level:         ParseLevel(env.GetString("LOG_LEVEL", "info")),
+		BufferSize:    env.GetInt("LOG_SHIP_BUFFER", 1000),
+		BatchSize:     env.GetInt("LOG_SHIP_BATCH", 100),
+		FlushInterval: env.GetDuration("LOG_SHIP_INTERVAL", time.Second),
+		Echo:          env.GetBool("LOG_STDERR", true),
+		Fallback:      os.Stderr,
+	}
+}
+
+// Setup makes a Handler shipping the logs of service to logger-service the
+// default slog logger, and the output of the log package. The returned
+// function sends what is still buffered; defer it in main.
+func Setup(service string) func() {
+	h := New(service, NewSink(), OptionsFromEnv())
+	return Install(h)
+}
+
+// Install makes h the default slog logger and the output of the log package,
+// and returns a function that flushes h.
+func Install(h *Handler) func() {
+	slog.SetDefault(slog.New(h))
+	// slog.SetDefault already points the log package at h; drop the
+	// timestamp log would prepend, records have their own
+	log.SetFlags(0)
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		h.Close(ctx)
+	}
+}
+
+// ParseLevel reads debug, info, warn or error, info when level is unknown.
+func ParseLevel(level string) slog.Level {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// levelName maps a slog level to the level names of logger-service
+func levelName(level slog.Level) string {
+	switch {
+	case level < slog.LevelInfo:
+		return "debug"
+	case level < slog.LevelWarn:
+		return "info"
+	case level < slog.LevelError:
+		return "warn"
+	default:
+		return "error"
+	}
+}
@@ -0,0 +1,212 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"ride-sharing/shared/retry"
+)
+
+// sendTimeout bounds one batch, so a hung logger-service counts as down
+const sendTimeout = 5 * time.Second
+
+// Sink delivers batches of entries to logger-service. It should connect
+// lazily and reconnect on the next Send after a failed one.
+type Sink interface {
+	Send(ctx context.Context, entries []Entry) error
+	Close() error
+}
+
+// shipper sends entries in batches from a goroutine. After a failed batch it
+// backs off before trying again, writing the entries to the fallback
+// meanwhile.
+type shipper struct {
+	service   string
+	sink      Sink
+	entries   chan Entry
+	batchSize int
+	interval  time.Duration
+	echo      bool
+	backoff   retry.Config
+
+	fallbackMu sync.Mutex
+	fallback   io.Writer
+
+	// enqueue holds the read lock while it sends, close the write lock to
+	// close entries
+	mu     sync.RWMutex
+	closed bool
+	done   chan struct{}
+
+	// only used by run
+	failures int
+	retryAt  time.Time
+}
+
+func newShipper(service string, sink Sink, opts Options) *shipper {
+	if opts.BufferSize < 1 {
+		opts.BufferSize = 1000
+	}
+	if opts.BatchSize < 1 {
+		opts.BatchSize = 100
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+	if opts.Fallback == nil {
+		opts.Fallback = os.Stderr
+	}
+
+	s := &shipper{
+		service:   service,
+		sink:      sink,
+		entries:   make(chan Entry, opts.BufferSize),
+		batchSize: opts.BatchSize,
+		interval:  opts.FlushInterval,
+		echo:      opts.Echo,
+		backoff: retry.Config{
+			InitialWait: time.Second,
+			MaxWait:     30 * time.Second,
+		},
+		fallback: opts.Fallback,
+		done:     make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *shipper) enqueue(e Entry) {
+	if s.echo {
+		s.write(e)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		if !s.echo {
+			s.write(e)
+		}
+		return
+	}
+
+	select {
+	case s.entries <- e:
+	default:
+		if !s.echo {
+			s.write(e)
+		}
+	}
+}
+
+func (s *shipper) close(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.entries)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-s.done:
+		return s.sink.Close()
+	case <-ctx.Done():
+		return fmt.Errorf("%d log records not shipped: %w", len(s.entries), ctx.Err())
+	}
+}
+
+func (s *shipper) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	batch := make([]Entry, 0, s.batchSize)
+	for {
+		select {
+		case e, ok := <-s.entries:
+			if !ok {
+				s.send(batch)
+				return
+			}
+			batch = append(batch, e)
+			if len(batch) >= s.batchSize {
+				s.send(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				s.send(batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+func (s *shipper) send(batch []Entry) {
+	if len(batch) == 0 {
+		return
+	}
+
+	// Still backing off: don't wait on a service that is known to be down
+	if time.Now().Before(s.retryAt) {
+		s.writeUnshipped(batch)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	err := s.sink.Send(ctx, batch)
+	cancel()
+
+	if err != nil {
+		s.failures++
+		s.retryAt = time.Now().Add(s.backoff.Delay(s.failures))
+		if s.failures == 1 {
+			s.notice("logger-service unreachable, writing logs to stderr until it is back: %v", err)
+		}
+		s.writeUnshipped(batch)
+		return
+	}
+
+	if s.failures > 0 {
+		s.notice("logger-service reachable again, shipping logs")
+		s.failures = 0
+		s.retryAt = time.Time{}
+	}
+}
+
+func (s *shipper) writeUnshipped(batch []Entry) {
+	if s.echo {
+		return
+	}
+	for _, e := range batch {
+		s.write(e)
+	}
+}
+
+// notice reports on shipping itself. It goes straight to the fallback, not
+// through the handler, which would loop.
+func (s *shipper) notice(format string, args ...any) {
+	s.write(Entry{
+		Timestamp: time.Now(),
+		Service:   s.service,
+		Level:     "warn",
+		Message:   fmt.Sprintf(format, args...),
+	})
+}
+
+func (s *shipper) write(e Entry) {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+
+	s.fallbackMu.Lock()
+	defer s.fallbackMu.Unlock()
+	s.fallback.Write(append(line, '\n'))
+}
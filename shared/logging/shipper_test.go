@@ -0,0 +1,257 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"ride-sharing/shared/clients"
+	loggerpb "ride-sharing/shared/generated/logger"
+	"ride-sharing/shared/retry"
+
+	"google.golang.org/grpc"
+)
+
+// fakeLogService is a logger-service handing over every StreamLogs call, one
+// batch, to batches
+type fakeLogService struct {
+	loggerpb.UnimplementedLoggerServiceServer
+	batches chan []*loggerpb.LogRequest
+}
+
+func (s *fakeLogService) StreamLogs(stream grpc.ClientStreamingServer[loggerpb.LogRequest, loggerpb.StreamLogsResponse]) error {
+	var batch []*loggerpb.LogRequest
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			s.batches <- batch
+			return stream.SendAndClose(&loggerpb.StreamLogsResponse{Accepted: int64(len(batch))})
+		}
+		if err != nil {
+			return err
+		}
+		batch = append(batch, req)
+	}
+}
+
+// serveLogs starts svc on addr, "127.0.0.1:0" for any port, and returns the
+// server and its address
+func serveLogs(t *testing.T, addr string, svc *fakeLogService) (*grpc.Server, string) {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	loggerpb.RegisterLoggerServiceServer(s, svc)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	return s, lis.Addr().String()
+}
+
+// syncBuffer is a fallback writer safe to read while the shipper writes
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// newTestHandler ships the records of "trip-service" to the logger-service at
+// addr; unshipped records go to the returned buffer
+func newTestHandler(t *testing.T, addr string, opts Options) (*Handler, *syncBuffer) {
+	t.Helper()
+	t.Setenv("LOGGER_GRPC_ADDR", addr)
+
+	pool := clients.NewPool(clients.Options{})
+	t.Cleanup(func() { pool.Close() })
+
+	fallback := &syncBuffer{}
+	opts.Fallback = fallback
+	h := New("trip-service", NewGRPCSink(pool), opts)
+	// Back off briefly after a failed batch; set before the first record, so
+	// before the shipper reads it
+	h.shipper.backoff = retry.Config{InitialWait: 10 * time.Millisecond, MaxWait: 10 * time.Millisecond}
+	return h, fallback
+}
+
+func closeHandler(t *testing.T, h *Handler) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := h.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func nextBatch(t *testing.T, svc *fakeLogService) []*loggerpb.LogRequest {
+	t.Helper()
+	select {
+	case batch := <-svc.batches:
+		return batch
+	case <-time.After(5 * time.Second):
+		t.Fatal("no batch shipped")
+		return nil
+	}
+}
+
+func names(batch []*loggerpb.LogRequest) string {
+	var out []string
+	for _, req := range batch {
+		out = append(out, req.GetName())
+	}
+	return strings.Join(out, ",")
+}
+
+func TestBatching(t *testing.T) {
+	svc := &fakeLogService{batches: make(chan []*loggerpb.LogRequest, 10)}
+	_, addr := serveLogs(t, "127.0.0.1:0", svc)
+	h, fallback := newTestHandler(t, addr, Options{BatchSize: 3, FlushInterval: time.Hour})
+
+	logger := slog.New(h)
+	ctx := WithTraceID(context.Background(), "trace-1")
+	for _, msg := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		logger.InfoContext(ctx, msg, "user_id", "user-1", slog.Group("trip", "id", 7))
+	}
+
+	// full batches go out right away
+	for _, want := range []string{"a,b,c", "d,e,f"} {
+		if got := names(nextBatch(t, svc)); got != want {
+			t.Fatalf("batch %s, want %s", got, want)
+		}
+	}
+	select {
+	case batch := <-svc.batches:
+		t.Fatalf("batch %s shipped before it was full or Close", names(batch))
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// and Close sends what is left
+	closeHandler(t, h)
+	last := nextBatch(t, svc)
+	if names(last) != "g" {
+		t.Fatalf("last batch %s, want g", names(last))
+	}
+	req := last[0]
+	if req.GetService() != "trip-service" || req.GetLevel() != "info" || req.GetTraceId() != "trace-1" ||
+		req.GetUserId() != "user-1" || req.GetFields()["trip.id"] != "7" {
+		t.Fatalf("shipped %v", req)
+	}
+	if fallback.String() != "" {
+		t.Fatalf("written to the fallback: %s", fallback)
+	}
+
+	// once closed, records go to the fallback
+	logger.Warn("late")
+	if !strings.Contains(fallback.String(), `"msg":"late"`) {
+		t.Fatalf("fallback %q", fallback)
+	}
+}
+
+func TestFlushInterval(t *testing.T) {
+	svc := &fakeLogService{batches: make(chan []*loggerpb.LogRequest, 10)}
+	_, addr := serveLogs(t, "127.0.0.1:0", svc)
+	h, _ := newTestHandler(t, addr, Options{BatchSize: 100, FlushInterval: 20 * time.Millisecond})
+	defer closeHandler(t, h)
+
+	logger := slog.New(h)
+	logger.Info("a")
+	logger.Error("b")
+	if got := names(nextBatch(t, svc)); got != "a,b" {
+		t.Fatalf("batch %s, want a,b", got)
+	}
+}
+
+func TestFallbackWhenUnreachable(t *testing.T) {
+	// an address nothing listens on
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+
+	h, fallback := newTestHandler(t, addr, Options{BatchSize: 1, FlushInterval: time.Hour})
+	logger := slog.New(h)
+	logger.Info("trip created", "trip_id", "trip-1")
+	logger.Info("trip accepted")
+	closeHandler(t, h)
+
+	out := fallback.String()
+	for _, want := range []string{"logger-service unreachable", `"msg":"trip created"`, `"trip_id":"trip-1"`, `"msg":"trip accepted"`} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("fallback %q lacks %s", out, want)
+		}
+	}
+	if n := strings.Count(out, "unreachable"); n != 1 {
+		t.Fatalf("outage reported %d times", n)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	svc := &fakeLogService{batches: make(chan []*loggerpb.LogRequest, 100)}
+	s, addr := serveLogs(t, "127.0.0.1:0", svc)
+	h, fallback := newTestHandler(t, addr, Options{BatchSize: 1, FlushInterval: time.Hour})
+	defer closeHandler(t, h)
+	logger := slog.New(h)
+
+	logger.Info("before")
+	if got := names(nextBatch(t, svc)); got != "before" {
+		t.Fatalf("batch %s", got)
+	}
+
+	// logger-service goes away: records go to the fallback
+	s.Stop()
+	logger.Info("during")
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(fallback.String(), `"msg":"during"`) {
+		if time.Now().After(deadline) {
+			t.Fatalf("fallback %q", fallback)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// and comes back on the same address: shipping resumes once the
+	// connection is re-established
+	serveLogs(t, addr, svc)
+	deadline = time.Now().Add(10 * time.Second)
+	for shipped := false; !shipped; {
+		if time.Now().After(deadline) {
+			t.Fatalf("never shipped again, fallback %q", fallback)
+		}
+		logger.Info("after")
+		select {
+		case batch := <-svc.batches:
+			shipped = names(batch) == "after"
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	// the recovery is reported right after the batch is sent
+	for !strings.Contains(fallback.String(), "logger-service reachable again") {
+		if time.Now().After(deadline) {
+			t.Fatalf("fallback %q", fallback)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !strings.Contains(fallback.String(), "logger-service unreachable") {
+		t.Fatalf("fallback %q", fallback)
+	}
+}
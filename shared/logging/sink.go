@@ -0,0 +1,79 @@
+package logging
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"ride-sharing/shared/clients"
+	loggerpb "ride-sharing/shared/generated/logger"
+)
+
+// NewSink returns the sink to logger-service at LOGGER_GRPC_ADDR, over the
+// connection of the default client pool.
+func NewSink() Sink {
+	return NewGRPCSink(clients.Default())
+}
+
+// grpcSink sends each batch over one StreamLogs call. The connection is
+// opened on the first batch and re-established by gRPC when it drops.
+type grpcSink struct {
+	pool *clients.Pool
+
+	mu     sync.Mutex
+	client *clients.LoggerClient
+}
+
+func NewGRPCSink(pool *clients.Pool) Sink {
+	return &grpcSink{pool: pool}
+}
+
+func (s *grpcSink) Send(ctx context.Context, entries []Entry) error {
+	client, err := s.connect()
+	if err != nil {
+		return err
+	}
+
+	stream, err := client.StreamLogs(ctx)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := stream.Send(toRequest(e)); err != nil {
+			return err
+		}
+	}
+	_, err = stream.CloseAndRecv()
+	return err
+}
+
+func (s *grpcSink) connect() (*clients.LoggerClient, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client == nil {
+		client, err := clients.NewLoggerClient(s.pool)
+		if err != nil {
+			return nil, err
+		}
+		s.client = client
+	}
+	return s.client, nil
+}
+
+// Close leaves the connection to the pool, which other clients may share
+func (s *grpcSink) Close() error {
+	return nil
+}
+
+func toRequest(e Entry) *loggerpb.LogRequest {
+	return &loggerpb.LogRequest{
+		Name:      e.Message,
+		UserId:    e.UserID,
+		Service:   e.Service,
+		Level:     e.Level,
+		TraceId:   e.TraceID,
+		Timestamp: e.Timestamp.Format(time.RFC3339Nano),
+		Fields:    e.Fields,
+	}
+}
@@ -0,0 +1,60 @@
+package logging
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// TraceHeader carries the trace ID between services
+const TraceHeader = "X-Trace-ID"
+
+type traceKey struct{}
+
+// WithTraceID returns a context whose records are tagged with traceID.
+func WithTraceID(ctx context.Context, traceID string) context.Context {
+	return context.WithValue(ctx, traceKey{}, traceID)
+}
+
+// TraceID returns the trace ID of ctx, empty when it has none.
+func TraceID(ctx context.Context) string {
+	id, _ := ctx.Value(traceKey{}).(string)
+	return id
+}
+
+// Middleware gives every request a trace ID: the one of the X-Trace-ID
+// header, else the trace ID of a W3C traceparent header, else a new one. It
+// is echoed in the response and tags the records logged with the request
+// context.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(TraceHeader)
+		if id == "" {
+			id = traceparentID(r.Header.Get("traceparent"))
+		}
+		if id == "" {
+			id = strings.ReplaceAll(uuid.NewString(), "-", "")
+		}
+
+		w.Header().Set(TraceHeader, id)
+		next.ServeHTTP(w, r.WithContext(WithTraceID(r.Context(), id)))
+	})
+}
+
+// Inject passes the trace ID of ctx on to an outgoing request.
+func Inject(ctx context.Context, header http.Header) {
+	if id := TraceID(ctx); id != "" {
+		header.Set(TraceHeader, id)
+	}
+}
+
+// traceparentID reads the trace ID of "version-traceid-parentid-flags"
+func traceparentID(traceparent string) string {
+	parts := strings.Split(traceparent, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 {
+		return ""
+	}
+	return parts[1]
+}
@@ -0,0 +1,157 @@
+/*
+Package messaging wraps the RabbitMQ connection used to exchange events between
+services. Messages are published as contracts.AmqpMessage on topic exchanges and
+routed by the keys defined in the contracts package.
+*/
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"ride-sharing/shared/contracts"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	// UserExchange carries user lifecycle events (user.event.*)
+	UserExchange = "user"
+)
+
+// MessageHandler processes a single delivery. Returning an error rejects the message.
+type MessageHandler func(ctx context.Context, msg amqp.Delivery) error
+
+type RabbitMQ struct {
+	conn    *amqp.Connection
+	Channel *amqp.Channel
+}
+
+// NewRabbitMQ dials the broker and declares the exchanges used by the services.
+func NewRabbitMQ(uri string) (*RabbitMQ, error) {
+	conn, err := amqp.Dial(uri)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to RabbitMQ: %v", err)
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to create channel: %v", err)
+	}
+
+	rmq := &RabbitMQ{
+		conn:    conn,
+		Channel: ch,
+	}
+
+	if err := rmq.setupExchanges(); err != nil {
+		rmq.Close()
+		return nil, fmt.Errorf("failed to setup exchanges: %v", err)
+	}
+
+	return rmq, nil
+}
+
+func (r *RabbitMQ) setupExchanges() error {
+	return r.Channel.ExchangeDeclare(
+		UserExchange, // name
+		"topic",      // type
+		true,         // durable
+		false,        // auto-deleted
+		false,        // internal
+		false,        // no-wait
+		nil,          // arguments
+	)
+}
+
+// PublishMessage publishes message on the exchange that owns the routing key.
+func (r *RabbitMQ) PublishMessage(ctx context.Context, routingKey string, message contracts.AmqpMessage) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %v", err)
+	}
+
+	return r.Channel.PublishWithContext(ctx,
+		exchangeFor(routingKey), // exchange
+		routingKey,              // routing key
+		false,                   // mandatory
+		false,                   // immediate
+		amqp.Publishing{
+			ContentType:  "application/json",
+			DeliveryMode: amqp.Persistent,
+			Body:         body,
+		},
+	)
+}
+
+// ConsumeMessages declares a durable queue bound to routingKeys and runs handler
+// for each delivery. It blocks until the channel is closed.
+func (r *RabbitMQ) ConsumeMessages(queueName string, routingKeys []string, handler MessageHandler) error {
+	q, err := r.Channel.QueueDeclare(
+		queueName, // name
+		true,      // durable
+		false,     // delete when unused
+		false,     // exclusive
+		false,     // no-wait
+		nil,       // arguments
+	)
+	if err != nil {
+		return fmt.Errorf("failed to declare queue %s: %v", queueName, err)
+	}
+
+	for _, key := range routingKeys {
+		if err := r.Channel.QueueBind(q.Name, key, exchangeFor(key), false, nil); err != nil {
+			return fmt.Errorf("failed to bind queue %s to %s: %v", queueName, key, err)
+		}
+	}
+
+	// Fair dispatch: don't hand a consumer a new message until it acked the last one
+	if err := r.Channel.Qos(1, 0, false); err != nil {
+		return fmt.Errorf("failed to set QoS: %v", err)
+	}
+
+	msgs, err := r.Channel.Consume(
+		q.Name, // queue
+		"",     // consumer
+		false,  // auto-ack
+		false,  // exclusive
+		false,  // no-local
+		false,  // no-wait
+		nil,    // args
+	)
+	if err != nil {
+		return fmt.Errorf("failed to consume from %s: %v", queueName, err)
+	}
+
+	for msg := range msgs {
+		if err := handler(context.Background(), msg); err != nil {
+			log.Printf("Failed to handle message %s on %s: %v", msg.RoutingKey, queueName, err)
+			// Requeue once, then drop to avoid poison messages looping forever
+			_ = msg.Nack(false, !msg.Redelivered)
+			continue
+		}
+		_ = msg.Ack(false)
+	}
+
+	return nil
+}
+
+// Close closes the channel and the underlying connection.
+func (r *RabbitMQ) Close() {
+	if r.Channel != nil {
+		r.Channel.Close()
+	}
+	if r.conn != nil {
+		r.conn.Close()
+	}
+}
+
+// exchangeFor maps a routing key such as "user.event.deleted" to its exchange.
+func exchangeFor(routingKey string) string {
+	prefix, _, _ := strings.Cut(routingKey, ".")
+	return prefix
+}
@@ -0,0 +1,171 @@
+package resilience
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// State is the state of a circuit breaker.
+type State int
+
+const (
+	// Closed lets the calls through
+	Closed State = iota
+	// Open rejects the calls until its time is up
+	Open
+	// HalfOpen lets probes through to tell whether the dependency is back
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// outcome is what a call let through the breaker tells it.
+type outcome int
+
+const (
+	success outcome = iota
+	failure
+	// ignored calls count neither way, like the ones the caller cancelled
+	ignored
+)
+
+// breaker is a consecutive failures circuit breaker. A nil breaker lets
+// every call through.
+type breaker struct {
+	name string
+	cfg  BreakerConfig
+
+	mu       sync.Mutex
+	state    State
+	failures int // consecutive ones, while closed
+	openedAt time.Time
+	probes   int // running while half-open
+	passed   int // probes that succeeded while half-open
+	changes  int64
+	// generation changes with the state, so that the calls let through
+	// before don't count in the new one
+	generation uint64
+}
+
+func newBreaker(name string, cfg BreakerConfig) *breaker {
+	if cfg.Failures <= 0 {
+		return nil
+	}
+	if cfg.OpenFor <= 0 {
+		cfg.OpenFor = 30 * time.Second
+	}
+	if cfg.Probes <= 0 {
+		cfg.Probes = 1
+	}
+	return &breaker{name: name, cfg: cfg}
+}
+
+// allow lets a call through, returning the function it reports its outcome
+// to, or rejects it with ErrOpen.
+func (b *breaker) allow() (func(outcome), error) {
+	if b == nil {
+		return func(outcome) {}, nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == Open {
+		if time.Since(b.openedAt) < b.cfg.OpenFor {
+			return nil, ErrOpen
+		}
+		b.setState(HalfOpen)
+	}
+	if b.state == HalfOpen {
+		if b.probes >= b.cfg.Probes {
+			return nil, ErrOpen
+		}
+		b.probes++
+	}
+
+	generation := b.generation
+	return func(o outcome) { b.done(generation, o) }, nil
+}
+
+func (b *breaker) done(generation uint64, o outcome) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation != b.generation {
+		return
+	}
+
+	switch b.state {
+	case Closed:
+		switch o {
+		case success:
+			b.failures = 0
+		case failure:
+			b.failures++
+			if b.failures >= b.cfg.Failures {
+				b.setState(Open)
+			}
+		}
+	case HalfOpen:
+		b.probes--
+		switch o {
+		case success:
+			b.passed++
+			if b.passed >= b.cfg.Probes {
+				b.setState(Closed)
+			}
+		case failure:
+			b.setState(Open)
+		}
+	}
+}
+
+// setState moves the breaker to state; b.mu is held
+func (b *breaker) setState(state State) {
+	log.Printf("resilience: %s breaker %s -> %s", b.name, b.state, state)
+
+	b.state = state
+	b.generation++
+	b.changes++
+	b.failures, b.probes, b.passed = 0, 0, 0
+	if state == Open {
+		b.openedAt = time.Now()
+	}
+}
+
+func (b *breaker) current() State {
+	state, _, _ := b.snapshot()
+	return state
+}
+
+// snapshot returns the state, how often it changed, and when the breaker
+// last opened when it isn't closed
+func (b *breaker) snapshot() (State, int64, time.Time) {
+	if b == nil {
+		return Closed, 0, time.Time{}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// an open breaker whose time is up is half-open for the next call
+	state := b.state
+	if state == Open && time.Since(b.openedAt) >= b.cfg.OpenFor {
+		state = HalfOpen
+	}
+	if state == Closed {
+		return state, b.changes, time.Time{}
+	}
+	return state, b.changes, b.openedAt
+}
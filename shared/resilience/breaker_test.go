@@ -0,0 +1,149 @@
+package resilience
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// expire ends the open time of b
+func expire(b *breaker) {
+	b.mu.Lock()
+	b.openedAt = b.openedAt.Add(-b.cfg.OpenFor)
+	b.mu.Unlock()
+}
+
+func TestBreakerTransitions(t *testing.T) {
+	tests := []struct {
+		name   string
+		probes int
+		// steps: a call that succeeds, fails or is ignored, "expire" for the
+		// open time running out, "reject" for a call rejected with ErrOpen
+		steps string
+		want  State
+	}{
+		{"closed", 1, "", Closed},
+		{"failures not consecutive", 1, "failure success failure", Closed},
+		{"ignored calls don't reset", 1, "failure ignored failure", Open},
+		{"opens", 1, "failure failure", Open},
+		{"rejects while open", 1, "failure failure reject reject", Open},
+		{"half-open once expired", 1, "failure failure expire", HalfOpen},
+		{"probe closes", 1, "failure failure expire success", Closed},
+		{"probe reopens", 1, "failure failure expire failure reject", Open},
+		{"ignored probe", 1, "failure failure expire ignored", HalfOpen},
+		{"closed again counts anew", 1, "failure failure expire success failure", Closed},
+		{"probes all succeed", 2, "failure failure expire success", HalfOpen},
+		{"probes closing", 2, "failure failure expire success success", Closed},
+		{"last probe failing", 2, "failure failure expire success failure", Open},
+	}
+	outcomes := map[string]outcome{"success": success, "failure": failure, "ignored": ignored}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBreaker("test", BreakerConfig{Failures: 2, OpenFor: time.Minute, Probes: tt.probes})
+			for _, step := range strings.Fields(tt.steps) {
+				switch step {
+				case "expire":
+					expire(b)
+				case "reject":
+					if _, err := b.allow(); !errors.Is(err, ErrOpen) {
+						t.Fatalf("call let through while %s", b.current())
+					}
+				default:
+					done, err := b.allow()
+					if err != nil {
+						t.Fatalf("%s call rejected while %s", step, b.current())
+					}
+					done(outcomes[step])
+				}
+			}
+			if got := b.current(); got != tt.want {
+				t.Fatalf("state %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBreakerProbeLimit(t *testing.T) {
+	b := newBreaker("test", BreakerConfig{Failures: 1, OpenFor: time.Minute, Probes: 2})
+	done, _ := b.allow()
+	done(failure)
+	expire(b)
+
+	// half-open lets Probes calls run at once
+	first, err := b.allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := b.allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.allow(); !errors.Is(err, ErrOpen) {
+		t.Fatal("third probe let through")
+	}
+
+	// a probe done makes room for another
+	first(ignored)
+	third, err := b.allow()
+	if err != nil {
+		t.Fatal("no room after a probe ended")
+	}
+	second(success)
+	third(success)
+	if b.current() != Closed {
+		t.Fatalf("state %s after the probes succeeded", b.current())
+	}
+}
+
+func TestBreakerGeneration(t *testing.T) {
+	tests := []struct {
+		name string
+		late outcome
+	}{
+		{"late success", success},
+		{"late failure", failure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBreaker("test", BreakerConfig{Failures: 2, OpenFor: time.Minute})
+
+			// let through while closed, done once the breaker moved on
+			slow, _ := b.allow()
+			for i := 0; i < 2; i++ {
+				done, _ := b.allow()
+				done(failure)
+			}
+
+			// the slow call says nothing of the open breaker
+			slow(tt.late)
+			if b.current() != Open {
+				t.Fatalf("state %s, want open", b.current())
+			}
+
+			// nor of the probe running
+			expire(b)
+			probe, err := b.allow()
+			if err != nil {
+				t.Fatal(err)
+			}
+			slow(tt.late)
+			if b.current() != HalfOpen {
+				t.Fatalf("state %s, want half-open", b.current())
+			}
+			if _, err := b.allow(); !errors.Is(err, ErrOpen) {
+				t.Fatal("late call freed the probe")
+			}
+
+			probe(success)
+			if b.current() != Closed {
+				t.Fatalf("state %s after the probe", b.current())
+			}
+			_, changes, _ := b.snapshot()
+			if changes != 3 {
+				t.Fatalf("%d state changes, want 3", changes)
+			}
+		})
+	}
+}
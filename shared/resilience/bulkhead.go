@@ -0,0 +1,53 @@
+package resilience
+
+import (
+	"context"
+	"time"
+)
+
+// bulkhead is a semaphore bounding the concurrent calls to a dependency. A
+// nil bulkhead has no bound.
+type bulkhead struct {
+	slots   chan struct{}
+	maxWait time.Duration
+}
+
+func newBulkhead(cfg BulkheadConfig) *bulkhead {
+	if cfg.MaxConcurrent <= 0 {
+		return nil
+	}
+	return &bulkhead{slots: make(chan struct{}, cfg.MaxConcurrent), maxWait: cfg.MaxWait}
+}
+
+// acquire takes a slot, waiting maxWait at most for one to be released
+func (b *bulkhead) acquire(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	if b.maxWait <= 0 {
+		return ErrBulkheadFull
+	}
+
+	timer := time.NewTimer(b.maxWait)
+	defer timer.Stop()
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return ErrBulkheadFull
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *bulkhead) release() {
+	if b != nil {
+		<-b.slots
+	}
+}
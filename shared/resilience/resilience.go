@@ -0,0 +1,351 @@
+/*
+Package resilience guards the calls a service makes to its dependencies.
+
+A Policy is made for each dependency, like a service or a third party API. It
+bounds every attempt with a timeout, retries the failed ones with the backoff
+of shared/retry, limits the calls running at once with a bulkhead so a slow
+dependency ties up a bounded number of goroutines, and stops calling a
+failing dependency with a circuit breaker: closed, it lets calls through and
+counts the consecutive failures; open, it rejects them for a while; half-open,
+it lets a few probes through and closes again when they succeed.
+
+	osrm := resilience.New("osrm", resilience.ConfigFromEnv("OSRM", defaults))
+	err := osrm.Do(ctx, func(ctx context.Context) error {
+		...
+	})
+
+Every policy is counted in Stats, published through expvar by Publish.
+*/
+package resilience
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ride-sharing/shared/env"
+	"ride-sharing/shared/retry"
+)
+
+var (
+	// ErrOpen rejects the calls to a dependency whose breaker is open
+	ErrOpen = errors.New("circuit breaker is open")
+	// ErrBulkheadFull rejects the calls to a dependency already running its
+	// maximum of concurrent calls
+	ErrBulkheadFull = errors.New("too many concurrent calls")
+)
+
+// Config configures the Policy of a dependency. The zero Config guards
+// nothing: no timeout, no retries, no bulkhead and no breaker.
+type Config struct {
+	// Timeout bounds every attempt; zero leaves the caller's deadline alone
+	Timeout time.Duration
+	// Retry is how often and how long apart failed attempts are retried;
+	// zero MaxRetries doesn't retry
+	Retry    retry.Config
+	Bulkhead BulkheadConfig
+	Breaker  BreakerConfig
+}
+
+// BulkheadConfig limits the concurrent calls to a dependency.
+type BulkheadConfig struct {
+	// MaxConcurrent calls run at once, zero for no limit
+	MaxConcurrent int
+	// MaxWait is how long a call waits for a slot before failing with
+	// ErrBulkheadFull; zero fails at once
+	MaxWait time.Duration
+}
+
+// BreakerConfig configures the circuit breaker of a dependency.
+type BreakerConfig struct {
+	// Failures is the number of consecutive failures opening the breaker,
+	// zero for no breaker
+	Failures int
+	// OpenFor is how long the breaker stays open before probing
+	OpenFor time.Duration
+	// Probes are the calls let through while half-open, 1 when zero; the
+	// breaker closes once they all succeeded
+	Probes int
+}
+
+// ConfigFromEnv reads the Config of a dependency from the variables named
+// after prefix, falling back to def: <prefix>_TIMEOUT, <prefix>_RETRIES,
+// <prefix>_MAX_CONCURRENT, <prefix>_MAX_WAIT, <prefix>_BREAKER_FAILURES and
+// <prefix>_BREAKER_OPEN.
+func ConfigFromEnv(prefix string, def Config) Config {
+	cfg := def
+	cfg.Timeout = env.GetDuration(prefix+"_TIMEOUT", def.Timeout)
+	cfg.Retry.MaxRetries = env.GetInt(prefix+"_RETRIES", def.Retry.MaxRetries)
+	cfg.Bulkhead.MaxConcurrent = env.GetInt(prefix+"_MAX_CONCURRENT", def.Bulkhead.MaxConcurrent)
+	cfg.Bulkhead.MaxWait = env.GetDuration(prefix+"_MAX_WAIT", def.Bulkhead.MaxWait)
+	cfg.Breaker.Failures = env.GetInt(prefix+"_BREAKER_FAILURES", def.Breaker.Failures)
+	cfg.Breaker.OpenFor = env.GetDuration(prefix+"_BREAKER_OPEN", def.Breaker.OpenFor)
+	return cfg
+}
+
+// Permanent marks the error of an attempt as the dependency's answer rather
+// than its failure, like a 404 or an invalid argument: it is not retried and
+// doesn't count against the breaker. Do and Attempt return err itself.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err}
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Policy guards the calls to a dependency. It is safe for concurrent use.
+type Policy struct {
+	name     string
+	cfg      Config
+	breaker  *breaker
+	bulkhead *bulkhead
+
+	calls        atomic.Int64
+	successes    atomic.Int64
+	failures     atomic.Int64
+	timeouts     atomic.Int64
+	retries      atomic.Int64
+	rejectedOpen atomic.Int64
+	rejectedFull atomic.Int64
+	inFlight     atomic.Int64
+
+	mu          sync.Mutex
+	lastFailure string
+}
+
+// Stats are the metrics of a Policy.
+type Stats struct {
+	Name          string     `json:"name"`
+	State         string     `json:"state"`
+	Calls         int64      `json:"calls"` // attempts let through
+	Successes     int64      `json:"successes"`
+	Failures      int64      `json:"failures"`
+	Timeouts      int64      `json:"timeouts"` // failures that ran out of time
+	Retries       int64      `json:"retries"`
+	RejectedOpen  int64      `json:"rejected_open"`
+	RejectedFull  int64      `json:"rejected_full"`
+	InFlight      int64      `json:"in_flight"`
+	MaxConcurrent int        `json:"max_concurrent,omitempty"`
+	StateChanges  int64      `json:"state_changes"`
+	OpenedAt      *time.Time `json:"opened_at,omitempty"` // while not closed
+	LastFailure   string     `json:"last_failure,omitempty"`
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = map[string]*Policy{}
+)
+
+// New returns the policy of the dependency name. It replaces in Stats a
+// policy made before under the same name.
+func New(name string, cfg Config) *Policy {
+	p := &Policy{
+		name:     name,
+		cfg:      cfg,
+		breaker:  newBreaker(name, cfg.Breaker),
+		bulkhead: newBulkhead(cfg.Bulkhead),
+	}
+
+	registryMu.Lock()
+	registry[name] = p
+	registryMu.Unlock()
+	return p
+}
+
+// Name returns the name of the dependency.
+func (p *Policy) Name() string {
+	return p.name
+}
+
+// Config returns the configuration of the policy.
+func (p *Policy) Config() Config {
+	return p.cfg
+}
+
+// State returns the state of the breaker: closed, open or half-open.
+func (p *Policy) State() State {
+	return p.breaker.current()
+}
+
+// Do calls fn through Attempt, retrying the failed attempts as configured.
+// Attempts rejected by the breaker or the bulkhead, permanent errors and
+// the ones of a context that is done are not retried.
+func (p *Policy) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	for attempt := 1; ; attempt++ {
+		err := p.attempt(ctx, fn)
+		if err == nil || attempt > p.cfg.Retry.MaxRetries || !retryable(ctx, err) {
+			return unwrapPermanent(err)
+		}
+
+		p.retries.Add(1)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(p.cfg.Retry.Delay(attempt)):
+		}
+	}
+}
+
+// Attempt calls fn once, when the breaker and the bulkhead let it, with the
+// context bounded by the timeout. The context ends with the attempt, so fn
+// must be done with it, like with a response body, when it returns.
+func (p *Policy) Attempt(ctx context.Context, fn func(ctx context.Context) error) error {
+	return unwrapPermanent(p.attempt(ctx, fn))
+}
+
+func (p *Policy) attempt(ctx context.Context, fn func(ctx context.Context) error) error {
+	done, err := p.breaker.allow()
+	if err != nil {
+		p.rejectedOpen.Add(1)
+		return fmt.Errorf("%s: %w", p.name, err)
+	}
+	if err := p.bulkhead.acquire(ctx); err != nil {
+		done(ignored)
+		if errors.Is(err, ErrBulkheadFull) {
+			p.rejectedFull.Add(1)
+			return fmt.Errorf("%s: %w", p.name, err)
+		}
+		return err
+	}
+	held := &slot{bulkhead: p.bulkhead}
+	defer func() {
+		if !held.kept {
+			p.bulkhead.release()
+		}
+	}()
+
+	p.calls.Add(1)
+	p.inFlight.Add(1)
+	defer p.inFlight.Add(-1)
+
+	callCtx := context.WithValue(ctx, slotKey{}, held)
+	if p.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		callCtx, cancel = context.WithTimeout(callCtx, p.cfg.Timeout)
+		defer cancel()
+	}
+
+	err = fn(callCtx)
+	var permanent *permanentError
+	switch {
+	case err == nil:
+		p.successes.Add(1)
+		done(success)
+	case errors.Is(ctx.Err(), context.Canceled):
+		// the caller gave up, which says nothing of the dependency
+		done(ignored)
+	case errors.As(err, &permanent):
+		p.successes.Add(1)
+		done(success)
+	default:
+		p.failures.Add(1)
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(callCtx.Err(), context.DeadlineExceeded) {
+			p.timeouts.Add(1)
+		}
+		p.mu.Lock()
+		p.lastFailure = err.Error()
+		p.mu.Unlock()
+		done(failure)
+	}
+	return err
+}
+
+// slot is the bulkhead slot of an attempt, which fn may keep past the
+// attempt with keepSlot
+type slot struct {
+	bulkhead *bulkhead
+	kept     bool
+}
+
+type slotKey struct{}
+
+// keepSlot keeps the bulkhead slot of the attempt running with ctx once the
+// attempt returns, and returns its release, which may be called more than
+// once. It is called by fn, before the attempt returns.
+func keepSlot(ctx context.Context) func() {
+	s, ok := ctx.Value(slotKey{}).(*slot)
+	if !ok || s.bulkhead == nil {
+		return func() {}
+	}
+	s.kept = true
+	return sync.OnceFunc(s.bulkhead.release)
+}
+
+func retryable(ctx context.Context, err error) bool {
+	var permanent *permanentError
+	return ctx.Err() == nil &&
+		!errors.As(err, &permanent) &&
+		!errors.Is(err, ErrOpen) &&
+		!errors.Is(err, ErrBulkheadFull)
+}
+
+func unwrapPermanent(err error) error {
+	var permanent *permanentError
+	if errors.As(err, &permanent) {
+		return permanent.err
+	}
+	return err
+}
+
+// Stats returns the metrics of the policy.
+func (p *Policy) Stats() Stats {
+	state, changes, openedAt := p.breaker.snapshot()
+	var opened *time.Time
+	if !openedAt.IsZero() {
+		opened = &openedAt
+	}
+	p.mu.Lock()
+	lastFailure := p.lastFailure
+	p.mu.Unlock()
+
+	return Stats{
+		Name:          p.name,
+		State:         state.String(),
+		Calls:         p.calls.Load(),
+		Successes:     p.successes.Load(),
+		Failures:      p.failures.Load(),
+		Timeouts:      p.timeouts.Load(),
+		Retries:       p.retries.Load(),
+		RejectedOpen:  p.rejectedOpen.Load(),
+		RejectedFull:  p.rejectedFull.Load(),
+		InFlight:      p.inFlight.Load(),
+		MaxConcurrent: p.cfg.Bulkhead.MaxConcurrent,
+		StateChanges:  changes,
+		OpenedAt:      opened,
+		LastFailure:   lastFailure,
+	}
+}
+
+// AllStats returns the metrics of every policy of the process, by name.
+func AllStats() []Stats {
+	registryMu.Lock()
+	policies := make([]*Policy, 0, len(registry))
+	for _, p := range registry {
+		policies = append(policies, p)
+	}
+	registryMu.Unlock()
+
+	stats := make([]Stats, 0, len(policies))
+	for _, p := range policies {
+		stats = append(stats, p.Stats())
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+// Publish exposes AllStats through expvar under name, e.g. on /debug/vars.
+func Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() any { return AllStats() }))
+}
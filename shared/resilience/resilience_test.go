@@ -0,0 +1,131 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"ride-sharing/shared/retry"
+)
+
+var (
+	errUnavailable = errors.New("unavailable")
+	errNotFound    = errors.New("not found")
+)
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		calls     int
+		want      error
+		successes int64
+		failures  int64
+		state     State
+	}{
+		{"success", nil, 1, nil, 1, 0, Closed},
+		{"failure retried", errUnavailable, 4, errUnavailable, 0, 4, Open},
+		{"permanent error", Permanent(errNotFound), 1, errNotFound, 1, 0, Closed},
+		{"wrapped permanent error", errors.Join(errors.New("get trip"), Permanent(errNotFound)), 1, errNotFound, 1, 0, Closed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New("test", Config{
+				Retry:   retry.Config{MaxRetries: 3, InitialWait: time.Millisecond, MaxWait: time.Millisecond},
+				Breaker: BreakerConfig{Failures: 4, OpenFor: time.Minute},
+			})
+
+			calls := 0
+			err := p.Do(context.Background(), func(context.Context) error {
+				calls++
+				return tt.err
+			})
+			if calls != tt.calls {
+				t.Fatalf("%d calls, want %d", calls, tt.calls)
+			}
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Do = %v, want %v", err, tt.want)
+			}
+			var permanent *permanentError
+			if errors.As(err, &permanent) && err == permanent {
+				t.Fatal("permanent error returned marked")
+			}
+
+			stats := p.Stats()
+			if stats.Successes != tt.successes || stats.Failures != tt.failures || p.State() != tt.state {
+				t.Fatalf("stats %+v", stats)
+			}
+
+			// an open breaker rejects at once, without retrying
+			if tt.state == Open {
+				calls = 0
+				if err := p.Do(context.Background(), func(context.Context) error { calls++; return nil }); !errors.Is(err, ErrOpen) || calls != 0 {
+					t.Fatalf("Do while open = %v after %d calls", err, calls)
+				}
+			}
+		})
+	}
+}
+
+func TestBulkhead(t *testing.T) {
+	tests := []struct {
+		name    string
+		maxWait time.Duration
+		// the slot is given back after this, never when zero
+		freedAfter time.Duration
+		cancel     bool
+		want       error
+	}{
+		{"full", 0, 0, false, ErrBulkheadFull},
+		{"full after waiting", 20 * time.Millisecond, 0, false, ErrBulkheadFull},
+		{"freed while waiting", time.Second, 10 * time.Millisecond, false, nil},
+		{"cancelled while waiting", time.Second, 0, true, context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New("test", Config{
+				Retry:    retry.Config{MaxRetries: 3, InitialWait: time.Millisecond},
+				Bulkhead: BulkheadConfig{MaxConcurrent: 1, MaxWait: tt.maxWait},
+			})
+
+			// a call holding the only slot
+			running, release := make(chan struct{}), make(chan struct{})
+			go p.Do(context.Background(), func(context.Context) error {
+				close(running)
+				<-release
+				return nil
+			})
+			<-running
+			if tt.freedAfter > 0 {
+				time.AfterFunc(tt.freedAfter, func() { close(release) })
+			} else {
+				defer close(release)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				time.AfterFunc(10*time.Millisecond, cancel)
+			}
+
+			start := time.Now()
+			calls := 0
+			err := p.Do(ctx, func(context.Context) error {
+				calls++
+				return nil
+			})
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Do = %v, want %v", err, tt.want)
+			}
+			if tt.want == ErrBulkheadFull {
+				if waited := time.Since(start); waited < tt.maxWait {
+					t.Fatalf("rejected after %s, want %s", waited, tt.maxWait)
+				}
+				if calls != 0 || p.Stats().RejectedFull != 1 || p.Stats().Retries != 0 {
+					t.Fatalf("%d calls, stats %+v", calls, p.Stats())
+				}
+			}
+		})
+	}
+}
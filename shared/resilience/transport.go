@@ -0,0 +1,130 @@
+package resilience
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// Transport is an http.RoundTripper guarding the requests through a Policy
+// per host, named "<name> <host>". Its answers 502, 503 and 504 fail like
+// the requests that couldn't be sent; the other ones are the dependency's
+// answers, whatever their status. Only the requests without a body, GET,
+// HEAD and OPTIONS ones, are retried, and the timeout bounds every attempt
+// up to the end of its response body. A response holds its bulkhead slot
+// until its body is closed.
+type Transport struct {
+	// Base sends the requests, http.DefaultTransport when nil
+	Base http.RoundTripper
+
+	name string
+	cfg  Config
+
+	mu       sync.Mutex
+	policies map[string]*Policy
+}
+
+// NewTransport returns a Transport guarding every host with the policy cfg.
+func NewTransport(name string, cfg Config) *Transport {
+	return &Transport{name: name, cfg: cfg, policies: map[string]*Policy{}}
+}
+
+// Policy returns the policy of host, making it on first use.
+func (t *Transport) Policy(host string) *Policy {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.policies[host]
+	if !ok {
+		// the timeout is applied here, since the response body outlives
+		// the attempt
+		cfg := t.cfg
+		cfg.Timeout = 0
+		p = New(t.name+" "+host, cfg)
+		t.policies[host] = p
+	}
+	return p
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	p := t.Policy(req.URL.Host)
+
+	call := p.Attempt
+	if replayable(req) {
+		call = p.Do
+	}
+
+	var resp *http.Response
+	err := call(req.Context(), func(ctx context.Context) error {
+		if resp != nil {
+			// the answer of the previous attempt
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+			resp = nil
+		}
+
+		out := req
+		cancel := context.CancelFunc(func() {})
+		if t.cfg.Timeout > 0 {
+			var ctx context.Context
+			ctx, cancel = context.WithTimeout(req.Context(), t.cfg.Timeout)
+			out = req.WithContext(ctx)
+		}
+
+		r, err := base.RoundTrip(out)
+		if err != nil {
+			cancel()
+			return err
+		}
+		body := &cancelBody{ReadCloser: r.Body, cancel: cancel, release: func() {}}
+		r.Body = body
+		resp = r
+
+		switch r.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			// the slot goes with the failed attempt, the retry needs it
+			return fmt.Errorf("%s answered %s", req.URL.Host, r.Status)
+		}
+		// a slow body ties up the dependency as much as a slow answer
+		body.release = keepSlot(ctx)
+		return nil
+	})
+
+	// a failed answer is still the answer of the last attempt
+	if resp != nil {
+		return resp, nil
+	}
+	return nil, err
+}
+
+func replayable(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody {
+		return false
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// cancelBody ends the context of an attempt with its response body, and
+// gives back the bulkhead slot the response holds.
+type cancelBody struct {
+	io.ReadCloser
+	cancel  context.CancelFunc
+	release func()
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	b.release()
+	return err
+}
@@ -0,0 +1,105 @@
+package resilience
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ride-sharing/shared/retry"
+)
+
+func TestTransportHoldsSlot(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(status.Load()))
+		io.WriteString(w, "route")
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: NewTransport("osrm", Config{
+		Timeout:  5 * time.Second,
+		Bulkhead: BulkheadConfig{MaxConcurrent: 1},
+	})}
+
+	first, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the body not read yet, the request still ties up the dependency
+	if _, err := client.Get(srv.URL); !errors.Is(err, ErrBulkheadFull) {
+		t.Fatalf("second request while the body is open: %v", err)
+	}
+
+	body, err := io.ReadAll(first.Body)
+	if err != nil || string(body) != "route" {
+		t.Fatalf("body %q, %v", body, err)
+	}
+	first.Body.Close()
+	first.Body.Close() // gives the slot back once
+
+	second, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("request once the body is closed: %v", err)
+	}
+	second.Body.Close()
+
+	// a failed answer gives its slot back with its attempt
+	status.Store(http.StatusServiceUnavailable)
+	failed, err := client.Get(srv.URL)
+	if err != nil || failed.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("failed answer %v, %v", failed, err)
+	}
+	defer failed.Body.Close()
+	status.Store(http.StatusOK)
+	third, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("request after a failed answer: %v", err)
+	}
+	third.Body.Close()
+}
+
+func TestTransportRetries(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// unavailable twice, then the route
+		if calls.Add(1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, "route")
+	}))
+	defer srv.Close()
+
+	// one slot, which each retry takes after the failed attempt gave it back
+	client := &http.Client{Transport: NewTransport("osrm", Config{
+		Retry:    retry.Config{MaxRetries: 2, InitialWait: time.Millisecond},
+		Bulkhead: BulkheadConfig{MaxConcurrent: 1},
+	})}
+
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "route" || calls.Load() != 3 {
+		t.Fatalf("answer %d %q after %d calls", resp.StatusCode, body, calls.Load())
+	}
+
+	// a request with a body is sent once
+	calls.Store(0)
+	resp, err = client.Post(srv.URL, "text/plain", http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || calls.Load() != 1 {
+		t.Fatalf("answer %d after %d calls", resp.StatusCode, calls.Load())
+	}
+}
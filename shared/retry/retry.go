@@ -25,6 +25,27 @@ func DefaultConfig() Config {
 	}
 }
 
+// Delay returns how long to wait before retry number attempt (starting at 1),
+// following the same exponential backoff as WithBackoff. It lets callers that
+// persist retries, such as a queue, schedule them instead of sleeping.
+func (c Config) Delay(attempt int) time.Duration {
+	if attempt < 1 {
+		return 0
+	}
+
+	wait := c.InitialWait
+	for i := 1; i < attempt; i++ {
+		wait *= 2
+		if wait >= c.MaxWait {
+			return c.MaxWait
+		}
+	}
+	if wait > c.MaxWait {
+		return c.MaxWait
+	}
+	return wait
+}
+
 // WithBackoff executes the given operation with exponential backoff retry logic
 func WithBackoff(ctx context.Context, cfg Config, operation func() error) error {
 	var err error
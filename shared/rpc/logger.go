@@ -1,14 +1,27 @@
+// Package rpc holds the net/rpc clients of logger-service and mail-service.
+//
+// Deprecated: the services are served over gRPC; use the clients of
+// ride-sharing/shared/clients. The net/rpc servers only stay up until every
+// caller has moved.
 package rpc
 
 import (
 	"log"
+	"net"
 	"net/rpc"
+	"time"
 )
 
 // RPCPayload matches the payload structure in logger-service
 type RPCPayload struct {
-	Name string
-	Data string
+	Name      string
+	Data      string
+	UserID    string
+	Service   string
+	Level     string // debug, info, warn or error
+	TraceID   string
+	Timestamp time.Time
+	Fields    map[string]string
 }
 
 // LoggerClient wraps RPC client for logger service
@@ -19,12 +32,12 @@ type LoggerClient struct {
 // NewLoggerClient creates a new RPC client connection to logger service
 // loggerServiceURL should be in format "host:port", e.g., "logger-service:5001"
 func NewLoggerClient(loggerServiceURL string) (*LoggerClient, error) {
-	client, err := rpc.Dial("tcp", loggerServiceURL)
+	conn, err := net.DialTimeout("tcp", loggerServiceURL, 5*time.Second)
 	if err != nil {
 		return nil, err
 	}
 
-	return &LoggerClient{client: client}, nil
+	return &LoggerClient{client: rpc.NewClient(conn)}, nil
 }
 
 // LogInfo sends log information via RPC
@@ -45,6 +58,12 @@ func (lc *LoggerClient) LogInfo(name, data string) error {
 	return nil
 }
 
+// Log sends a structured log entry via RPC
+func (lc *LoggerClient) Log(payload RPCPayload) error {
+	var result string
+	return lc.client.Call("RPCServer.LogInfo", payload, &result)
+}
+
 // Close closes the RPC connection
 func (lc *LoggerClient) Close() error {
 	if lc.client != nil {
@@ -7,12 +7,24 @@ import (
 
 // MailRPCPayload matches the payload structure in mail-service
 type MailRPCPayload struct {
-	From        string
-	FromName    string
-	To          string
-	Subject     string
-	Message     string
-	Attachments []string
+	From      string
+	FromName  string
+	To        string
+	Subject   string
+	Message   string
+	Files     []MailAttachment
+	Template  string
+	Locale    string
+	Variables map[string]string
+}
+
+// MailAttachment is a file attached to a mail: either its content or a
+// "folder/file" reference to an object stored through image-service
+type MailAttachment struct {
+	Name        string
+	ContentType string
+	Content     []byte
+	ImageRef    string
 }
 
 // MailClient wraps RPC client for mail service
@@ -32,14 +44,38 @@ func NewMailClient(mailServiceURL string) (*MailClient, error) {
 }
 
 // SendMail sends email via RPC
-func (mc *MailClient) SendMail(from, fromName, to, subject, message string, attachments []string) error {
+func (mc *MailClient) SendMail(from, fromName, to, subject, message string, attachments []MailAttachment) error {
 	payload := MailRPCPayload{
-		From:        from,
-		FromName:    fromName,
-		To:          to,
-		Subject:     subject,
-		Message:     message,
-		Attachments: attachments,
+		From:     from,
+		FromName: fromName,
+		To:       to,
+		Subject:  subject,
+		Message:  message,
+		Files:    attachments,
+	}
+
+	var result string
+	err := mc.client.Call("RPCServer.SendMail", payload, &result)
+	if err != nil {
+		log.Printf("RPC call error: %v", err)
+		return err
+	}
+
+	log.Printf("RPC Response: %s", result)
+	return nil
+}
+
+// SendTemplate sends an email rendered from a named mail-service template.
+// An empty subject uses the template's own subject.
+func (mc *MailClient) SendTemplate(from, fromName, to, subject, template, locale string, variables map[string]string) error {
+	payload := MailRPCPayload{
+		From:      from,
+		FromName:  fromName,
+		To:        to,
+		Subject:   subject,
+		Template:  template,
+		Locale:    locale,
+		Variables: variables,
 	}
 
 	var result string
@@ -60,4 +96,3 @@ func (mc *MailClient) Close() error {
 	}
 	return nil
 }
-
@@ -0,0 +1,96 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+)
+
+// Locker elects a single leader per job across replicas.
+type Locker interface {
+	// TryAcquire returns a lease when this replica became the leader for name,
+	// or nil when another replica holds it.
+	TryAcquire(ctx context.Context, name string) (Lease, error)
+}
+
+// Lease is held by the leader until it is released or lost.
+type Lease interface {
+	// Check reports an error once the lease is no longer held.
+	Check(ctx context.Context) error
+	Release()
+}
+
+// PostgresLocker elects leaders with session level Postgres advisory locks.
+// The lock lives on a dedicated connection, so a crashed leader loses it as
+// soon as Postgres notices the connection is gone.
+type PostgresLocker struct {
+	DB *sql.DB
+	// Namespace is mixed into the lock key so unrelated applications sharing a
+	// database don't collide. Defaults to "scheduler".
+	Namespace string
+}
+
+func NewPostgresLocker(db *sql.DB) *PostgresLocker {
+	return &PostgresLocker{DB: db, Namespace: "scheduler"}
+}
+
+func (l *PostgresLocker) TryAcquire(ctx context.Context, name string) (Lease, error) {
+	key := lockKey(l.Namespace, name)
+
+	conn, err := l.DB.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %v", err)
+	}
+
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&acquired); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to try advisory lock: %v", err)
+	}
+	if !acquired {
+		conn.Close()
+		return nil, nil
+	}
+
+	return &postgresLease{conn: conn, key: key}, nil
+}
+
+type postgresLease struct {
+	conn *sql.Conn
+	key  int64
+}
+
+func (l *postgresLease) Check(ctx context.Context) error {
+	// The lock belongs to the session, so it's held as long as the connection
+	// is alive and we never unlocked it
+	var held bool
+	query := `SELECT EXISTS (
+		SELECT 1 FROM pg_locks
+		WHERE locktype = 'advisory' AND objsubid = 1 AND pid = pg_backend_pid() AND granted
+		  AND ((classid::bigint << 32) | objid::bigint) = $1
+	)`
+	if err := l.conn.QueryRowContext(ctx, query, l.key).Scan(&held); err != nil {
+		return fmt.Errorf("lost connection holding the lock: %v", err)
+	}
+	if !held {
+		return fmt.Errorf("advisory lock %d is no longer held", l.key)
+	}
+	return nil
+}
+
+func (l *postgresLease) Release() {
+	// Closing the connection would release the lock too, but the pool may keep
+	// the session alive, so unlock explicitly first
+	_, _ = l.conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", l.key)
+	l.conn.Close()
+}
+
+// lockKey hashes namespace and job name into an advisory lock key
+func lockKey(namespace, name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(namespace))
+	h.Write([]byte{0})
+	h.Write([]byte(name))
+	return int64(h.Sum64())
+}
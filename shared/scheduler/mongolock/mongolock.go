@@ -0,0 +1,97 @@
+// Package mongolock elects the leaders of scheduler jobs with lease documents
+// in Mongo, for services that have no Postgres. It lives apart from the
+// scheduler so services on Postgres don't depend on the Mongo driver.
+package mongolock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"time"
+
+	"ride-sharing/shared/scheduler"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var errLeaseLost = errors.New("lease expired and was taken over")
+
+// Locker elects leaders with lease documents in a Mongo collection. A lease
+// lasts TTL and is renewed by every Check, so a crashed leader is replaced at
+// most TTL later; TTL should be longer than the interval between runs plus the
+// run timeout.
+type Locker struct {
+	Collection *mongo.Collection
+	TTL        time.Duration
+
+	owner string
+}
+
+func New(collection *mongo.Collection, ttl time.Duration) *Locker {
+	host, _ := os.Hostname()
+	b := make([]byte, 4)
+	rand.Read(b)
+
+	return &Locker{
+		Collection: collection,
+		TTL:        ttl,
+		owner:      host + "-" + hex.EncodeToString(b),
+	}
+}
+
+func (l *Locker) TryAcquire(ctx context.Context, name string) (scheduler.Lease, error) {
+	now := time.Now()
+
+	// Take the lease when it's free, expired or already ours. When another
+	// replica holds it the filter misses and the upsert hits the unique _id.
+	_, err := l.Collection.UpdateOne(ctx,
+		bson.M{
+			"_id": name,
+			"$or": bson.A{
+				bson.M{"expires_at": bson.M{"$lte": now}},
+				bson.M{"owner": l.owner},
+			},
+		},
+		bson.M{"$set": bson.M{"owner": l.owner, "expires_at": now.Add(l.TTL)}},
+		options.Update().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &lease{locker: l, name: name}, nil
+}
+
+type lease struct {
+	locker *Locker
+	name   string
+}
+
+// Check renews the lease, failing when another replica took it over
+func (l *lease) Check(ctx context.Context) error {
+	res, err := l.locker.Collection.UpdateOne(ctx,
+		bson.M{"_id": l.name, "owner": l.locker.owner},
+		bson.M{"$set": bson.M{"expires_at": time.Now().Add(l.locker.TTL)}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return errLeaseLost
+	}
+	return nil
+}
+
+func (l *lease) Release() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	l.locker.Collection.DeleteOne(ctx, bson.M{"_id": l.name, "owner": l.locker.owner})
+}
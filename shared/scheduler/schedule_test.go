@@ -0,0 +1,158 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+// bits returns the set of values
+func bits(values ...int) uint64 {
+	var set uint64
+	for _, v := range values {
+		set |= 1 << uint(v)
+	}
+	return set
+}
+
+func TestParseField(t *testing.T) {
+	minute, hour, dow := fields[0], fields[1], fields[4]
+
+	tests := []struct {
+		expr  string
+		field field
+		want  uint64
+	}{
+		{"5", minute, bits(5)},
+		{"1,2,5-7", minute, bits(1, 2, 5, 6, 7)},
+		{"*/20", minute, bits(0, 20, 40)},
+		{"10/20", minute, bits(10, 30, 50)},
+		{"9-17/4", hour, bits(9, 13, 17)},
+		{"22-23,0-1", hour, bits(22, 23, 0, 1)},
+		{"1-5", dow, bits(1, 2, 3, 4, 5)},
+		{"*", dow, bits(0, 1, 2, 3, 4, 5, 6, 7)},
+	}
+	for _, tt := range tests {
+		got, err := parseField(tt.expr, tt.field)
+		if err != nil {
+			t.Errorf("%s field %q: %v", tt.field.name, tt.expr, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s field %q = %b, want %b", tt.field.name, tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestCronInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+		"1-a * * * *",
+		"@fortnightly",
+		"@every -1s",
+		"@every soon",
+	} {
+		if _, err := Cron(expr); err == nil {
+			t.Errorf("Cron(%q) is valid", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	at := func(s string) time.Time {
+		t.Helper()
+		v, err := time.Parse("2006-01-02 15:04:05", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	tests := []struct {
+		name string
+		expr string
+		from string
+		want string // empty when there is no next run
+	}{
+		{"every minute", "* * * * *", "2026-10-19 10:07:30", "2026-10-19 10:08:00"},
+		{"strictly after", "*/15 * * * *", "2026-10-19 10:15:00", "2026-10-19 10:30:00"},
+		{"step", "*/15 * * * *", "2026-10-19 10:07:00", "2026-10-19 10:15:00"},
+		{"step over the hour", "*/15 * * * *", "2026-10-19 10:50:00", "2026-10-19 11:00:00"},
+		{"ranged step", "0 9-17/4 * * *", "2026-10-19 10:00:00", "2026-10-19 13:00:00"},
+		{"ranged step next day", "0 9-17/4 * * *", "2026-10-19 17:00:00", "2026-10-20 09:00:00"},
+		{"list", "0,30 8,20 * * *", "2026-10-19 08:30:00", "2026-10-19 20:00:00"},
+		{"weekdays from Saturday", "30 2 * * 1-5", "2026-10-17 03:00:00", "2026-10-19 02:30:00"},
+		{"Sunday as 7", "0 12 * * 7", "2026-10-19 00:00:00", "2026-10-25 12:00:00"},
+		{"Sunday as 0", "0 12 * * 0", "2026-10-19 00:00:00", "2026-10-25 12:00:00"},
+		{"day of month", "0 0 13 * *", "2026-10-19 00:00:00", "2026-11-13 00:00:00"},
+		{"day of month or of week", "0 0 13 * 5", "2026-10-01 00:00:00", "2026-10-02 00:00:00"},
+		{"day of month or of week, the 13th", "0 0 13 * 5", "2026-10-09 00:00:00", "2026-10-13 00:00:00"},
+		{"day of month and any day of week", "0 0 13 * *", "2026-10-02 00:00:00", "2026-10-13 00:00:00"},
+		{"31st skips short months", "0 0 31 * *", "2026-11-01 00:00:00", "2026-12-31 00:00:00"},
+		{"month", "0 0 1 3,9 *", "2026-10-19 00:00:00", "2027-03-01 00:00:00"},
+		{"leap day", "0 0 29 2 *", "2026-03-01 00:00:00", "2028-02-29 00:00:00"},
+		{"new year", "@yearly", "2026-10-19 10:00:00", "2027-01-01 00:00:00"},
+		{"weekly", "@weekly", "2026-10-19 10:00:00", "2026-10-25 00:00:00"},
+		{"hourly", "@hourly", "2026-10-19 10:00:00", "2026-10-19 11:00:00"},
+		{"never", "0 0 30 2 *", "2026-10-19 00:00:00", ""},
+		{"every", "@every 90s", "2026-10-19 10:00:00", "2026-10-19 10:01:30"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Cron(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := s.Next(at(tt.from))
+			if tt.want == "" {
+				if !got.IsZero() {
+					t.Fatalf("Next = %s, want none", got)
+				}
+				return
+			}
+			if want := at(tt.want); !got.Equal(want) {
+				t.Fatalf("Next(%s) = %s, want %s", tt.from, got, want)
+			}
+		})
+	}
+}
+
+func TestCronIn(t *testing.T) {
+	saigon := time.FixedZone("ICT", 7*60*60)
+	s, err := CronIn("0 9 * * *", saigon)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 07:00 in Saigon, 9:00 is two hours later
+	from := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	want := time.Date(2026, 10, 19, 2, 0, 0, 0, time.UTC)
+	if got := s.Next(from); !got.Equal(want) {
+		t.Fatalf("Next = %s, want %s", got, want)
+	}
+}
+
+func TestEvery(t *testing.T) {
+	from := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	if got := Every(time.Minute).Next(from); !got.Equal(from.Add(time.Minute)) {
+		t.Fatalf("Next = %s", got)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Every(0) doesn't panic")
+		}
+	}()
+	Every(0)
+}
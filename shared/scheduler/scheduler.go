@@ -0,0 +1,268 @@
+/*
+Package scheduler runs periodic background jobs inside a service.
+
+Jobs run on an interval (Every) or a cron expression (Cron). A job marked as
+Singleton only runs on the replica that holds its leader lease, which the
+PostgresLocker hands out through advisory locks, so scaling a service out
+doesn't run the same cleanup several times. Every job gets optional jitter, a
+timeout and run metrics that can be published through expvar.
+*/
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Job is a unit of periodic work.
+type Job struct {
+	Name     string
+	Schedule Schedule
+	Run      func(ctx context.Context) error
+	// Jitter delays every run by a random duration in [0, Jitter) so replicas
+	// and jobs sharing a schedule don't all fire at once.
+	Jitter time.Duration
+	// Timeout bounds a single run. Zero means no timeout.
+	Timeout time.Duration
+	// Singleton jobs only run on the replica elected through the Locker.
+	Singleton bool
+}
+
+// Stats are the run metrics of a job.
+type Stats struct {
+	Name         string        `json:"name"`
+	Runs         int64         `json:"runs"`
+	Failures     int64         `json:"failures"`
+	Skipped      int64         `json:"skipped"` // runs left to the leader on another replica
+	Leader       bool          `json:"leader"`
+	Running      bool          `json:"running"`
+	LastRun      time.Time     `json:"last_run"`
+	LastDuration time.Duration `json:"last_duration"`
+	LastError    string        `json:"last_error,omitempty"`
+	NextRun      time.Time     `json:"next_run"`
+}
+
+type Scheduler struct {
+	locker Locker
+
+	mu      sync.Mutex
+	jobs    []*jobState
+	started bool
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+type jobState struct {
+	job   Job
+	lease Lease
+
+	mu    sync.Mutex
+	stats Stats
+}
+
+// New creates a scheduler. locker may be nil when no job is a Singleton.
+func New(locker Locker) *Scheduler {
+	return &Scheduler{locker: locker}
+}
+
+// Add registers a job. Jobs must be added before Start.
+func (s *Scheduler) Add(job Job) error {
+	if job.Name == "" || job.Schedule == nil || job.Run == nil {
+		return errors.New("scheduler: job needs a name, a schedule and a run function")
+	}
+	if job.Singleton && s.locker == nil {
+		return fmt.Errorf("scheduler: singleton job %s needs a locker", job.Name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started {
+		return fmt.Errorf("scheduler: cannot add job %s after Start", job.Name)
+	}
+	for _, j := range s.jobs {
+		if j.job.Name == job.Name {
+			return fmt.Errorf("scheduler: job %s already registered", job.Name)
+		}
+	}
+
+	s.jobs = append(s.jobs, &jobState{job: job, stats: Stats{Name: job.Name}})
+	return nil
+}
+
+// Start runs every job in its own goroutine until ctx is cancelled or Stop is called.
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started {
+		return
+	}
+	s.started = true
+
+	ctx, s.cancel = context.WithCancel(ctx)
+	for _, j := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(ctx, j)
+	}
+	log.Printf("Scheduler started with %d job(s)", len(s.jobs))
+}
+
+// Stop cancels running jobs, waits for them to return and releases leases.
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	cancel := s.cancel
+	s.mu.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	cancel()
+	s.wg.Wait()
+}
+
+// Stats returns the metrics of every job.
+func (s *Scheduler) Stats() []Stats {
+	s.mu.Lock()
+	jobs := append([]*jobState(nil), s.jobs...)
+	s.mu.Unlock()
+
+	stats := make([]Stats, 0, len(jobs))
+	for _, j := range jobs {
+		j.mu.Lock()
+		stats = append(stats, j.stats)
+		j.mu.Unlock()
+	}
+	return stats
+}
+
+// Publish exposes Stats through expvar under name, e.g. on /debug/vars.
+func (s *Scheduler) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() any { return s.Stats() }))
+}
+
+func (s *Scheduler) loop(ctx context.Context, j *jobState) {
+	defer s.wg.Done()
+	defer j.releaseLease()
+
+	for {
+		next := j.job.Schedule.Next(time.Now())
+		if next.IsZero() {
+			log.Printf("Scheduler: job %s has no further runs", j.job.Name)
+			return
+		}
+		if j.job.Jitter > 0 {
+			next = next.Add(time.Duration(rand.Int63n(int64(j.job.Jitter))))
+		}
+
+		j.mu.Lock()
+		j.stats.NextRun = next
+		j.mu.Unlock()
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		s.runOnce(ctx, j)
+	}
+}
+
+func (s *Scheduler) runOnce(ctx context.Context, j *jobState) {
+	if j.job.Singleton && !s.lead(ctx, j) {
+		j.mu.Lock()
+		j.stats.Skipped++
+		j.mu.Unlock()
+		return
+	}
+
+	runCtx := ctx
+	if j.job.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, j.job.Timeout)
+		defer cancel()
+	}
+
+	j.mu.Lock()
+	j.stats.Running = true
+	j.mu.Unlock()
+
+	start := time.Now()
+	err := safeRun(runCtx, j.job.Run)
+	duration := time.Since(start)
+
+	j.mu.Lock()
+	j.stats.Running = false
+	j.stats.Runs++
+	j.stats.LastRun = start
+	j.stats.LastDuration = duration
+	j.stats.LastError = ""
+	if err != nil {
+		j.stats.Failures++
+		j.stats.LastError = err.Error()
+	}
+	j.mu.Unlock()
+
+	if err != nil {
+		log.Printf("Scheduler: job %s failed after %s: %v", j.job.Name, duration, err)
+	}
+}
+
+// lead makes sure this replica holds the job's lease, acquiring it if free
+func (s *Scheduler) lead(ctx context.Context, j *jobState) bool {
+	if j.lease != nil {
+		if err := j.lease.Check(ctx); err == nil {
+			return true
+		} else {
+			log.Printf("Scheduler: lost leadership of %s: %v", j.job.Name, err)
+			j.releaseLease()
+		}
+	}
+
+	lease, err := s.locker.TryAcquire(ctx, j.job.Name)
+	if err != nil {
+		log.Printf("Scheduler: leader election for %s failed: %v", j.job.Name, err)
+		return false
+	}
+	if lease == nil {
+		return false
+	}
+
+	log.Printf("Scheduler: this replica now leads %s", j.job.Name)
+	j.lease = lease
+	j.mu.Lock()
+	j.stats.Leader = true
+	j.mu.Unlock()
+	return true
+}
+
+func (j *jobState) releaseLease() {
+	if j.lease == nil {
+		return
+	}
+	j.lease.Release()
+	j.lease = nil
+
+	j.mu.Lock()
+	j.stats.Leader = false
+	j.mu.Unlock()
+}
+
+// safeRun turns a panicking job into a failed run instead of a crashed service
+func safeRun(ctx context.Context, run func(ctx context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return run(ctx)
+}
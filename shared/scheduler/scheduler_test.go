@@ -0,0 +1,206 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryLocker is a Locker shared by the replicas of a test, like the
+// database is in production.
+type memoryLocker struct {
+	mu      sync.Mutex
+	holders map[string]*memoryLease
+}
+
+func newMemoryLocker() *memoryLocker {
+	return &memoryLocker{holders: map[string]*memoryLease{}}
+}
+
+func (l *memoryLocker) TryAcquire(ctx context.Context, name string) (Lease, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.holders[name] != nil {
+		return nil, nil
+	}
+	lease := &memoryLease{locker: l, name: name}
+	l.holders[name] = lease
+	return lease, nil
+}
+
+// expire takes name from its holder, like a lock lost with its connection
+func (l *memoryLocker) expire(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.holders, name)
+}
+
+type memoryLease struct {
+	locker *memoryLocker
+	name   string
+}
+
+func (l *memoryLease) Check(ctx context.Context) error {
+	l.locker.mu.Lock()
+	defer l.locker.mu.Unlock()
+	if l.locker.holders[l.name] != l {
+		return errors.New("lease lost")
+	}
+	return nil
+}
+
+func (l *memoryLease) Release() {
+	l.locker.mu.Lock()
+	defer l.locker.mu.Unlock()
+	if l.locker.holders[l.name] == l {
+		delete(l.locker.holders, l.name)
+	}
+}
+
+// replica is a scheduler with one singleton job counting its runs
+type replica struct {
+	s    *Scheduler
+	job  *jobState
+	runs int
+}
+
+func newReplica(t *testing.T, locker Locker) *replica {
+	t.Helper()
+	r := &replica{s: New(locker)}
+	err := r.s.Add(Job{
+		Name:      "cleanup",
+		Schedule:  Every(time.Hour),
+		Run:       func(ctx context.Context) error { r.runs++; return nil },
+		Singleton: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.job = r.s.jobs[0]
+	return r
+}
+
+func (r *replica) tick() {
+	r.s.runOnce(context.Background(), r.job)
+}
+
+func TestSingletonHandoff(t *testing.T) {
+	locker := newMemoryLocker()
+	a, b := newReplica(t, locker), newReplica(t, locker)
+
+	steps := []struct {
+		name         string
+		before       func()
+		runsA, runsB int
+		leaderA      bool
+		leaderB      bool
+	}{
+		{name: "a leads first", runsA: 1, leaderA: true},
+		{name: "a keeps leading", runsA: 2, leaderA: true},
+		{
+			name:   "a releases its lease, b takes over",
+			before: func() { a.job.releaseLease() },
+			runsA:  2, runsB: 1, leaderB: true,
+		},
+		{
+			name:   "b loses its lease, a takes over",
+			before: func() { locker.expire("cleanup") },
+			runsA:  3, runsB: 1, leaderA: true,
+		},
+		{name: "b stays a follower", runsA: 4, runsB: 1, leaderA: true},
+	}
+	for _, step := range steps {
+		if step.before != nil {
+			step.before()
+		}
+		// b ticks first once a lost its lease, like a replica whose timer
+		// fires first
+		if step.leaderB {
+			b.tick()
+			a.tick()
+		} else {
+			a.tick()
+			b.tick()
+		}
+
+		if a.runs != step.runsA || b.runs != step.runsB {
+			t.Fatalf("%s: runs a=%d b=%d, want a=%d b=%d", step.name, a.runs, b.runs, step.runsA, step.runsB)
+		}
+		if got := a.s.Stats()[0].Leader; got != step.leaderA {
+			t.Fatalf("%s: a leader = %v, want %v", step.name, got, step.leaderA)
+		}
+		if got := b.s.Stats()[0].Leader; got != step.leaderB {
+			t.Fatalf("%s: b leader = %v, want %v", step.name, got, step.leaderB)
+		}
+	}
+
+	if skipped := a.s.Stats()[0].Skipped + b.s.Stats()[0].Skipped; skipped != int64(len(steps)) {
+		t.Errorf("skipped %d runs, want one per step", skipped)
+	}
+}
+
+func TestStopReleasesLease(t *testing.T) {
+	locker := newMemoryLocker()
+	a, b := newReplica(t, locker), newReplica(t, locker)
+
+	a.tick()
+	if a.runs != 1 {
+		t.Fatalf("a ran %d times, want 1", a.runs)
+	}
+
+	// a stopping hands the job to b at its next run
+	a.s.Start(context.Background())
+	a.s.Stop()
+	b.tick()
+	if b.runs != 1 {
+		t.Fatalf("b ran %d times once a stopped, want 1", b.runs)
+	}
+}
+
+func TestRunFailures(t *testing.T) {
+	s := New(nil)
+	for _, job := range []Job{
+		{Name: "fails", Schedule: Every(time.Hour), Run: func(ctx context.Context) error { return errors.New("boom") }},
+		{Name: "panics", Schedule: Every(time.Hour), Run: func(ctx context.Context) error { panic("boom") }},
+	} {
+		if err := s.Add(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, j := range s.jobs {
+		s.runOnce(context.Background(), j)
+	}
+
+	for _, stats := range s.Stats() {
+		if stats.Runs != 1 || stats.Failures != 1 || stats.LastError == "" {
+			t.Errorf("%s: %+v, want one failed run", stats.Name, stats)
+		}
+	}
+}
+
+func TestAdd(t *testing.T) {
+	s := New(nil)
+	run := func(ctx context.Context) error { return nil }
+
+	if err := s.Add(Job{Name: "a", Schedule: Every(time.Hour), Run: run}); err != nil {
+		t.Fatal(err)
+	}
+	for _, job := range []Job{
+		{Name: "a", Schedule: Every(time.Hour), Run: run},
+		{Name: "b", Run: run},
+		{Name: "c", Schedule: Every(time.Hour), Run: run, Singleton: true},
+	} {
+		if err := s.Add(job); err == nil {
+			t.Errorf("job %s is accepted", job.Name)
+		}
+	}
+
+	s.Start(context.Background())
+	defer s.Stop()
+	if err := s.Add(Job{Name: "d", Schedule: Every(time.Hour), Run: run}); err == nil {
+		t.Error("job added after Start")
+	}
+}
@@ -0,0 +1,66 @@
+package servicetoken
+
+import (
+	"context"
+	"crypto/subtle"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// errMissing is the answer to the gRPC calls without the token
+var errMissing = status.Error(codes.Unauthenticated, "a valid bearer token is required")
+
+// ValidContext reports whether an incoming gRPC call carries token as
+// "authorization: Bearer" metadata.
+func ValidContext(ctx context.Context, token string) bool {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		got, ok := strings.CutPrefix(v, "Bearer ")
+		if ok && token != "" && subtle.ConstantTimeCompare([]byte(strings.TrimSpace(got)), []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// Outgoing returns ctx sending token with the gRPC calls made with it.
+func Outgoing(ctx context.Context, token string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+}
+
+// UnaryServerInterceptor answers Unauthenticated to the calls of methods,
+// full names like "/logger.LoggerService/QueryLogs", made without token; the
+// other methods are left open.
+func UnaryServerInterceptor(token string, methods ...string) grpc.UnaryServerInterceptor {
+	guarded := methodSet(methods)
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if guarded[info.FullMethod] && !ValidContext(ctx, token) {
+			return nil, errMissing
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for the streaming
+// methods.
+func StreamServerInterceptor(token string, methods ...string) grpc.StreamServerInterceptor {
+	guarded := methodSet(methods)
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if guarded[info.FullMethod] && !ValidContext(ss.Context(), token) {
+			return errMissing
+		}
+		return handler(srv, ss)
+	}
+}
+
+func methodSet(methods []string) map[string]bool {
+	set := make(map[string]bool, len(methods))
+	for _, m := range methods {
+		set[m] = true
+	}
+	return set
+}
@@ -0,0 +1,97 @@
+package servicetoken
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	loggerpb "ride-sharing/shared/generated/logger"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// openLogger answers every call it gets
+type openLogger struct {
+	loggerpb.UnimplementedLoggerServiceServer
+}
+
+func (openLogger) LogInfo(ctx context.Context, req *loggerpb.LogRequest) (*loggerpb.LogResponse, error) {
+	return &loggerpb.LogResponse{}, nil
+}
+
+func (openLogger) QueryLogs(ctx context.Context, req *loggerpb.LogQueryRequest) (*loggerpb.LogQueryResponse, error) {
+	return &loggerpb.LogQueryResponse{}, nil
+}
+
+func (openLogger) TailLogs(req *loggerpb.TailLogsRequest, stream grpc.ServerStreamingServer[loggerpb.TailEvent]) error {
+	return stream.Send(&loggerpb.TailEvent{})
+}
+
+func TestInterceptors(t *testing.T) {
+	guarded := []string{loggerpb.LoggerService_QueryLogs_FullMethodName, loggerpb.LoggerService_TailLogs_FullMethodName}
+
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(UnaryServerInterceptor("service-token", guarded...)),
+		grpc.ChainStreamInterceptor(StreamServerInterceptor("service-token", guarded...)),
+	)
+	loggerpb.RegisterLoggerServiceServer(s, openLogger{})
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	client := loggerpb.NewLoggerServiceClient(conn)
+
+	tail := func(ctx context.Context) error {
+		stream, err := client.TailLogs(ctx, &loggerpb.TailLogsRequest{})
+		if err != nil {
+			return err
+		}
+		_, err = stream.Recv()
+		return err
+	}
+	query := func(ctx context.Context) error {
+		_, err := client.QueryLogs(ctx, &loggerpb.LogQueryRequest{})
+		return err
+	}
+	logInfo := func(ctx context.Context) error {
+		_, err := client.LogInfo(ctx, &loggerpb.LogRequest{})
+		return err
+	}
+
+	tests := []struct {
+		name  string
+		call  func(context.Context) error
+		token string
+		want  codes.Code
+	}{
+		{"query without token", query, "", codes.Unauthenticated},
+		{"query with a wrong token", query, "wrong", codes.Unauthenticated},
+		{"query", query, "service-token", codes.OK},
+		{"tail without token", tail, "", codes.Unauthenticated},
+		{"tail", tail, "service-token", codes.OK},
+		{"open method without token", logInfo, "", codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.token != "" {
+				ctx = Outgoing(ctx, tt.token)
+			}
+			if err := tt.call(ctx); status.Code(err) != tt.want {
+				t.Fatalf("call = %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+/*
+Package servicetoken guards the internal HTTP endpoints and gRPC methods of a
+service with a static bearer token, like the one the services present to each
+other to read the data of a user, or the token of an admin endpoint.
+
+	token, err := servicetoken.FromEnv("INTERNAL_SERVICE_TOKEN")
+	if err != nil {
+		log.Fatal(err)
+	}
+	mux.Handle("GET /users/{userID}/trips", servicetoken.Require(token, handler))
+
+Callers send it with Set. The gRPC methods are guarded by the interceptors,
+and callers send it with Outgoing.
+*/
+package servicetoken
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// FromEnv returns the token of the variable name, failing when it is unset:
+// an internal endpoint is never left open for want of a token.
+func FromEnv(name string) (string, error) {
+	token := strings.TrimSpace(os.Getenv(name))
+	if token == "" {
+		return "", fmt.Errorf("%s is not set", name)
+	}
+	return token, nil
+}
+
+// Valid reports whether r carries token as "Authorization: Bearer".
+func Valid(r *http.Request, token string) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && token != "" && subtle.ConstantTimeCompare([]byte(strings.TrimSpace(got)), []byte(token)) == 1
+}
+
+// Require answers 401 to the requests without token, and passes the others
+// to next.
+func Require(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !Valid(r, token) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "a valid bearer token is required", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Middleware is Require for routers taking middlewares, like chi.
+func Middleware(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return Require(token, next)
+	}
+}
+
+// Set sends token with r.
+func Set(r *http.Request, token string) {
+	r.Header.Set("Authorization", "Bearer "+token)
+}